@@ -0,0 +1,184 @@
+// anomaly.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Anomaly types reported in agent_anomaly events
+const (
+	AnomalyIdenticalToolCalls = "identical_tool_calls" // Same tool with identical input, turn after turn
+	AnomalyRetryStorm         = "retry_storm"          // Same tool retried after errors, turn after turn
+	AnomalyTurnDepth          = "turn_depth"           // Session ran more turns than expected
+)
+
+// AnomalyConfig holds thresholds for runaway-agent detection. A zero threshold
+// disables that detector.
+type AnomalyConfig struct {
+	IdenticalToolCalls int    `toml:"identical_tool_calls"` // Consecutive turns with an identical first tool call
+	RetryStorm         int    `toml:"retry_storm"`          // Consecutive retries of a failing tool
+	MaxTurnDepth       int    `toml:"max_turn_depth"`       // Turns in one session
+	CircuitBreaker     bool   `toml:"circuit_breaker"`      // Fail the session's requests after an anomaly
+	BreakerCooldown    string `toml:"breaker_cooldown"`     // How long a tripped breaker stays open (default: 10m)
+}
+
+// defaultBreakerCooldown is how long a tripped circuit breaker stays open
+// when breaker_cooldown isn't set.
+const defaultBreakerCooldown = 10 * time.Minute
+
+// Validate checks the breaker cooldown.
+func (c AnomalyConfig) Validate() error {
+	if c.BreakerCooldown != "" {
+		if d, err := time.ParseDuration(c.BreakerCooldown); err != nil || d <= 0 {
+			return fmt.Errorf("anomaly: invalid breaker_cooldown %q", c.BreakerCooldown)
+		}
+	}
+	return nil
+}
+
+func (c AnomalyConfig) breakerCooldown() time.Duration {
+	if d, err := time.ParseDuration(c.BreakerCooldown); err == nil && d > 0 {
+		return d
+	}
+	return defaultBreakerCooldown
+}
+
+// AnomalyData describes a detected anomaly for the agent_anomaly event body.
+type AnomalyData struct {
+	Type           string `json:"anomaly_type"`
+	ToolName       string `json:"tool_name,omitempty"`
+	Count          int    `json:"count"`
+	Threshold      int    `json:"threshold"`
+	BreakerTripped bool   `json:"breaker_tripped"`
+}
+
+func (a AnomalyData) String() string {
+	switch a.Type {
+	case AnomalyIdenticalToolCalls:
+		return fmt.Sprintf("%s called with identical input %d turns in a row", a.ToolName, a.Count)
+	case AnomalyRetryStorm:
+		return fmt.Sprintf("%s retried %d times after errors", a.ToolName, a.Count)
+	case AnomalyTurnDepth:
+		return fmt.Sprintf("session reached %d turns (threshold %d)", a.Count, a.Threshold)
+	}
+	return a.Type
+}
+
+// toolCallHash returns a stable hash of a tool call's name and input.
+// json.Marshal sorts map keys, so identical inputs hash identically.
+func toolCallHash(block ContentBlock) string {
+	input, _ := json.Marshal(block.ToolInput)
+	sum := sha256.Sum256(append([]byte(block.ToolName+"\x00"), input...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// UpdateToolInputStreak tracks consecutive turns whose first tool call has the
+// same name and input. firstTool is nil if the response had no tool calls.
+func UpdateToolInputStreak(state *PatternState, firstTool *ContentBlock) {
+	if firstTool == nil {
+		state.LastToolInputHash = ""
+		state.IdenticalCallStreak = 0
+		return
+	}
+
+	hash := toolCallHash(*firstTool)
+	if hash == state.LastToolInputHash {
+		state.IdenticalCallStreak++
+	} else {
+		state.IdenticalCallStreak = 1
+	}
+	state.LastToolInputHash = hash
+}
+
+// DetectAnomalies checks pattern state (after ComputePatterns and
+// UpdateToolInputStreak) against the configured thresholds. Each detector
+// fires when its count reaches a multiple of the threshold, so a loop that
+// keeps going is reported again rather than on every turn.
+func DetectAnomalies(state *PatternState, cfg AnomalyConfig) []AnomalyData {
+	var anomalies []AnomalyData
+
+	if n := cfg.IdenticalToolCalls; n > 0 && state.IdenticalCallStreak > 0 && state.IdenticalCallStreak%n == 0 {
+		anomalies = append(anomalies, AnomalyData{
+			Type:      AnomalyIdenticalToolCalls,
+			ToolName:  state.LastToolName,
+			Count:     state.IdenticalCallStreak,
+			Threshold: n,
+		})
+	}
+
+	if n := cfg.RetryStorm; n > 0 && state.RetryCount > 0 && state.RetryCount%n == 0 {
+		anomalies = append(anomalies, AnomalyData{
+			Type:      AnomalyRetryStorm,
+			ToolName:  state.LastToolName,
+			Count:     state.RetryCount,
+			Threshold: n,
+		})
+	}
+
+	if n := cfg.MaxTurnDepth; n > 0 && state.TurnCount > n && (state.TurnCount-1)%n == 0 {
+		anomalies = append(anomalies, AnomalyData{
+			Type:      AnomalyTurnDepth,
+			Count:     state.TurnCount,
+			Threshold: n,
+		})
+	}
+
+	return anomalies
+}
+
+// detectAndEmitAnomalies runs the detectors, emits an agent_anomaly event for
+// each hit and trips the session's circuit breaker if configured.
+func detectAndEmitAnomalies(emitter AgentEventEmitter, sessionID, provider, machineID string, state *PatternState, content []ContentBlock, cfg AnomalyConfig) {
+	var firstTool *ContentBlock
+	for i := range content {
+		if content[i].Type == "tool_use" {
+			firstTool = &content[i]
+			break
+		}
+	}
+	UpdateToolInputStreak(state, firstTool)
+
+	for _, anomaly := range DetectAnomalies(state, cfg) {
+		if cfg.CircuitBreaker {
+			anomaly.BreakerTripped = true
+			state.BreakerReason = anomaly.String()
+			state.BreakerTrippedAt = time.Now()
+		}
+		log.Printf("WARNING: agent anomaly: %s (session=%s, breaker=%v)", anomaly, sessionID, anomaly.BreakerTripped)
+		emitter.EmitAnomaly(sessionID, provider, machineID, anomaly)
+	}
+}
+
+// checkCircuitBreaker returns an error if the session's breaker is open. The
+// breaker stays open, so client retries fail too, until the cooldown has
+// passed since it tripped; then it closes and the request goes through.
+func checkCircuitBreaker(state *PatternState, cfg AnomalyConfig) error {
+	if state == nil || state.BreakerReason == "" {
+		return nil
+	}
+	if time.Since(state.BreakerTrippedAt) >= cfg.breakerCooldown() {
+		state.BreakerReason = ""
+		state.BreakerTrippedAt = time.Time{}
+		return nil
+	}
+	return fmt.Errorf("llm-proxy circuit breaker tripped: %s", state.BreakerReason)
+}
+
+// discardEventEmitter drops all events. Used when the circuit breaker is
+// enabled without Loki, since pattern tracking runs on the event path.
+type discardEventEmitter struct{}
+
+func (discardEventEmitter) EmitTurnStart(sessionID, provider, machine string, turnDepth int, errorRecovered bool) {
+}
+func (discardEventEmitter) EmitTurnEnd(sessionID, provider, machine, stopReason string, isRetry bool, errorType string, patterns PatternData, tokens TokenData) {
+}
+func (discardEventEmitter) EmitToolCall(sessionID, provider, machine, toolName string, toolIndex int, toolUseID string) {
+}
+func (discardEventEmitter) EmitToolResult(sessionID, provider, machine, toolName, toolUseID string, isError bool) {
+}
+func (discardEventEmitter) EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData) {}
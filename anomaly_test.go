@@ -0,0 +1,186 @@
+// anomaly_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateToolInputStreak(t *testing.T) {
+	state := &PatternState{PendingToolIDs: make(map[string]string)}
+	ls := &ContentBlock{Type: "tool_use", ToolName: "Bash", ToolInput: map[string]interface{}{"command": "ls", "timeout": 10}}
+	lsReordered := &ContentBlock{Type: "tool_use", ToolName: "Bash", ToolInput: map[string]interface{}{"timeout": 10, "command": "ls"}}
+	pwd := &ContentBlock{Type: "tool_use", ToolName: "Bash", ToolInput: map[string]interface{}{"command": "pwd"}}
+
+	UpdateToolInputStreak(state, ls)
+	UpdateToolInputStreak(state, lsReordered)
+	if state.IdenticalCallStreak != 2 {
+		t.Errorf("expected streak 2 for identical input, got %d", state.IdenticalCallStreak)
+	}
+
+	UpdateToolInputStreak(state, pwd)
+	if state.IdenticalCallStreak != 1 {
+		t.Errorf("expected streak reset to 1 for different input, got %d", state.IdenticalCallStreak)
+	}
+
+	UpdateToolInputStreak(state, nil)
+	if state.IdenticalCallStreak != 0 || state.LastToolInputHash != "" {
+		t.Errorf("expected streak cleared for response without tools, got %d", state.IdenticalCallStreak)
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	cfg := AnomalyConfig{IdenticalToolCalls: 3, RetryStorm: 4, MaxTurnDepth: 10}
+
+	tests := []struct {
+		name  string
+		state PatternState
+		want  []string
+	}{
+		{"quiet", PatternState{TurnCount: 5, IdenticalCallStreak: 2, RetryCount: 1}, nil},
+		{"identical calls", PatternState{TurnCount: 5, IdenticalCallStreak: 3}, []string{AnomalyIdenticalToolCalls}},
+		{"identical calls past threshold", PatternState{TurnCount: 5, IdenticalCallStreak: 4}, nil},
+		{"identical calls repeat", PatternState{TurnCount: 7, IdenticalCallStreak: 6}, []string{AnomalyIdenticalToolCalls}},
+		{"retry storm", PatternState{TurnCount: 5, RetryCount: 4}, []string{AnomalyRetryStorm}},
+		{"turn depth", PatternState{TurnCount: 11}, []string{AnomalyTurnDepth}},
+		{"turn depth at threshold", PatternState{TurnCount: 10}, nil},
+		{"turn depth repeat", PatternState{TurnCount: 21}, []string{AnomalyTurnDepth}},
+		{"both", PatternState{TurnCount: 5, IdenticalCallStreak: 3, RetryCount: 4}, []string{AnomalyIdenticalToolCalls, AnomalyRetryStorm}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, a := range DetectAnomalies(&tt.state, cfg) {
+				got = append(got, a.Type)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if got := DetectAnomalies(&PatternState{TurnCount: 1000, IdenticalCallStreak: 100, RetryCount: 100}, AnomalyConfig{}); len(got) != 0 {
+		t.Errorf("expected zero thresholds to disable detection, got %v", got)
+	}
+}
+
+func TestPatternStatePersistsAnomalyFields(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	sessionID, _, _, err := sm.GetOrCreateSession([]byte(`{}`), "anthropic", "api.anthropic.com", http.Header{}, "/v1/messages", "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession failed: %v", err)
+	}
+
+	state := &PatternState{
+		PendingToolIDs:      make(map[string]string),
+		LastToolInputHash:   "abc123",
+		IdenticalCallStreak: 4,
+		BreakerReason:       "Bash called with identical input 4 turns in a row",
+		BreakerTrippedAt:    time.Unix(1760000000, 0),
+	}
+	if err := sm.UpdatePatternState(sessionID, state); err != nil {
+		t.Fatalf("UpdatePatternState failed: %v", err)
+	}
+
+	loaded, err := sm.LoadPatternState(sessionID)
+	if err != nil {
+		t.Fatalf("LoadPatternState failed: %v", err)
+	}
+	if loaded.LastToolInputHash != "abc123" || loaded.IdenticalCallStreak != 4 || loaded.BreakerReason != state.BreakerReason || !loaded.BreakerTrippedAt.Equal(state.BreakerTrippedAt) {
+		t.Errorf("anomaly fields not persisted: %+v", loaded)
+	}
+}
+
+func TestCircuitBreakerFailsNextRequest(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	// Upstream keeps asking for the exact same tool call
+	upstreamCalls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content": []map[string]interface{}{
+				{"type": "tool_use", "id": "toolu_" + strings.Repeat("x", upstreamCalls), "name": "Bash", "input": map[string]interface{}{"command": "make test"}},
+			},
+			"usage":       map[string]interface{}{"input_tokens": 10, "output_tokens": 5},
+			"stop_reason": "tool_use",
+		})
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	proxy.anomaly = AnomalyConfig{IdenticalToolCalls: 2, CircuitBreaker: true}
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	send := func() *httptest.ResponseRecorder {
+		body := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"fix the tests"}],"metadata":{"user_id":"user_abc_account_def_session_loop-session"}}`
+		req := httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := send(); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, w.Code)
+		}
+	}
+
+	if len(emitter.AnomalyEvents) != 1 {
+		t.Fatalf("expected 1 anomaly event, got %d", len(emitter.AnomalyEvents))
+	}
+	anomaly := emitter.AnomalyEvents[0]
+	if anomaly.Type != AnomalyIdenticalToolCalls || anomaly.ToolName != "Bash" || !anomaly.BreakerTripped {
+		t.Errorf("unexpected anomaly: %+v", anomaly)
+	}
+
+	// Breaker is open: the next request fails without reaching upstream
+	w := send()
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected breaker to reject with 400, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "circuit breaker tripped") {
+		t.Errorf("expected circuit breaker message, got %s", w.Body.String())
+	}
+	if upstreamCalls != 2 {
+		t.Errorf("expected rejected request not to reach upstream, got %d calls", upstreamCalls)
+	}
+
+	// The breaker stays open, so the client's automatic retry fails too
+	if w := send(); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected retry to be rejected while the breaker is open, got %d", w.Code)
+	}
+
+	// Rejected requests don't advance the session
+	sessionID, _, _ := sm.db.FindByClientSessionID("loop-session")
+	if _, _, _, seq, _ := sm.db.GetSessionWithClientID(sessionID); seq != 2 {
+		t.Errorf("expected rejected requests to leave the session at seq 2, got %d", seq)
+	}
+
+	// Once the cooldown has passed, the breaker closes
+	proxy.anomaly.BreakerCooldown = "1ns"
+	if w := send(); w.Code != http.StatusOK {
+		t.Errorf("expected request after cooldown to succeed, got %d", w.Code)
+	}
+	if upstreamCalls != 3 {
+		t.Errorf("expected 3 upstream calls, got %d", upstreamCalls)
+	}
+}
@@ -0,0 +1,182 @@
+// auth.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxy auth lets one proxy be shared by a team. Each user has a token, sent
+// either as a Proxy-Authorization header (Bearer <token>, or Basic with the
+// token as password) or as a path prefix: /u/<token>/anthropic/api.anthropic.com/...
+// The token is stripped before the request is routed, so it never reaches an
+// upstream or a log, and the user's name replaces user@host as the machine
+// recorded in _meta and Loki labels.
+
+const authPathPrefix = "/u/"
+
+// ErrProxyAuth is returned for a missing or unknown proxy token.
+var ErrProxyAuth = errors.New("proxy authentication required")
+
+// ProxyUser is one [[auth.users]] entry. Exactly one of Token and
+// TokenSHA256 must be set.
+type ProxyUser struct {
+	Name        string `toml:"name"`         // Identity recorded in logs
+	Token       string `toml:"token"`        // Plaintext token
+	TokenSHA256 string `toml:"token_sha256"` // Hex sha256 of the token, to keep it out of the config file
+}
+
+// AuthConfig configures proxy authentication. No users disables it.
+type AuthConfig struct {
+	Users []ProxyUser `toml:"users"`
+	// PublicEndpoints lists status endpoints (/health, /health/loki,
+	// /health/bedrock, /metrics) served without a token on a non-loopback
+	// listener, e.g. "/health" for load balancer checks.
+	PublicEndpoints []string `toml:"public_endpoints"`
+}
+
+// Enabled reports whether any users are configured.
+func (c AuthConfig) Enabled() bool {
+	return len(c.Users) > 0
+}
+
+// ProxyAuth maps tokens to users. A nil *ProxyAuth lets every request through.
+type ProxyAuth struct {
+	users map[string]string // hex sha256(token) -> name
+}
+
+// NewProxyAuth validates cfg. Returns nil (and no error) when auth is disabled.
+func NewProxyAuth(cfg AuthConfig) (*ProxyAuth, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	for _, path := range cfg.PublicEndpoints {
+		if !isStatusEndpoint(path) {
+			return nil, fmt.Errorf("auth public_endpoints: %q is not a status endpoint (valid: %s)", path, strings.Join(statusEndpoints, ", "))
+		}
+	}
+
+	a := &ProxyAuth{users: make(map[string]string, len(cfg.Users))}
+	for i, u := range cfg.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("auth user %d: name is required", i+1)
+		}
+		if (u.Token == "") == (u.TokenSHA256 == "") {
+			return nil, fmt.Errorf("auth user %q: exactly one of token or token_sha256 must be set", u.Name)
+		}
+
+		hash := strings.ToLower(u.TokenSHA256)
+		if u.Token != "" {
+			if strings.Contains(u.Token, "/") {
+				return nil, fmt.Errorf("auth user %q: token must not contain '/'", u.Name)
+			}
+			hash = hashProxyToken(u.Token)
+		} else if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("auth user %q: token_sha256 must be 64 hex characters", u.Name)
+		}
+
+		if other, ok := a.users[hash]; ok {
+			return nil, fmt.Errorf("auth users %q and %q share a token", other, u.Name)
+		}
+		a.users[hash] = u.Name
+	}
+	return a, nil
+}
+
+func hashProxyToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate returns the user a request belongs to, stripping the token
+// from its Proxy-Authorization header and path. With auth disabled it
+// returns "" and leaves the request untouched.
+func (a *ProxyAuth) Authenticate(r *http.Request) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+
+	token := proxyAuthorizationToken(r.Header.Get("Proxy-Authorization"))
+	r.Header.Del("Proxy-Authorization")
+
+	if strings.HasPrefix(r.URL.Path, authPathPrefix) {
+		rest := r.URL.Path[len(authPathPrefix):]
+		pathToken, remainder, _ := strings.Cut(rest, "/")
+		r.URL.Path = "/" + remainder
+		r.URL.RawPath = ""
+		if token == "" {
+			token = pathToken
+		}
+	}
+
+	if token == "" {
+		return "", ErrProxyAuth
+	}
+	name, ok := a.users[hashProxyToken(token)]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown token", ErrProxyAuth)
+	}
+	return name, nil
+}
+
+// proxyAuthorizationToken extracts the token from a Bearer or Basic
+// Proxy-Authorization value. For Basic, the password is the token.
+func proxyAuthorizationToken(value string) string {
+	scheme, credentials, ok := strings.Cut(value, " ")
+	if !ok {
+		return ""
+	}
+	credentials = strings.TrimSpace(credentials)
+
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		return credentials
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return ""
+		}
+		_, password, _ := strings.Cut(string(decoded), ":")
+		return password
+	}
+	return ""
+}
+
+type proxyUserKey struct{}
+
+// withProxyUser attaches an authenticated user to a request context.
+func withProxyUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, proxyUserKey{}, user)
+}
+
+// proxyUserFromContext returns the authenticated user, or "".
+func proxyUserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(proxyUserKey{}).(string)
+	return user
+}
+
+// ValidateListenAddress refuses to bind a non-loopback address unless proxy
+// auth is configured, since the proxy would otherwise be open to anyone who
+// can reach it.
+func ValidateListenAddress(host string, auth AuthConfig) error {
+	if auth.Enabled() || isLoopbackHost(host) {
+		return nil
+	}
+	return fmt.Errorf("refusing to listen on %q without [[auth.users]]: the proxy would be unauthenticated", host)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
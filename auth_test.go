@@ -0,0 +1,258 @@
+// auth_test.go
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testProxyAuth(t *testing.T) *ProxyAuth {
+	t.Helper()
+	a, err := NewProxyAuth(AuthConfig{Users: []ProxyUser{
+		{Name: "alice", Token: "alice-token"},
+		{Name: "bob", TokenSHA256: hashProxyToken("bob-token")},
+	}})
+	if err != nil {
+		t.Fatalf("NewProxyAuth failed: %v", err)
+	}
+	return a
+}
+
+func TestProxyAuthAuthenticate(t *testing.T) {
+	a := testProxyAuth(t)
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:bob-token"))
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		wantUser string
+		wantPath string
+	}{
+		{"bearer", "/anthropic/api.anthropic.com/v1/messages", "Bearer alice-token", "alice", "/anthropic/api.anthropic.com/v1/messages"},
+		{"basic", "/anthropic/api.anthropic.com/v1/messages", basic, "bob", "/anthropic/api.anthropic.com/v1/messages"},
+		{"path prefix", "/u/bob-token/openai/api.openai.com/v1/responses", "", "bob", "/openai/api.openai.com/v1/responses"},
+		{"hashed token", "/u/bob-token/model/claude/invoke", "", "bob", "/model/claude/invoke"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Proxy-Authorization", tt.header)
+			}
+			user, err := a.Authenticate(req)
+			if err != nil || user != tt.wantUser {
+				t.Fatalf("Authenticate = %q, %v; want %q", user, err, tt.wantUser)
+			}
+			if req.URL.Path != tt.wantPath {
+				t.Errorf("Expected path %s, got %s", tt.wantPath, req.URL.Path)
+			}
+			if req.Header.Get("Proxy-Authorization") != "" {
+				t.Error("Expected Proxy-Authorization to be stripped")
+			}
+		})
+	}
+
+	for _, path := range []string{"/anthropic/api.anthropic.com/v1/messages", "/u/wrong-token/anthropic/api.anthropic.com/v1/messages"} {
+		if _, err := a.Authenticate(httptest.NewRequest("POST", path, nil)); err == nil {
+			t.Errorf("Expected %s to be rejected", path)
+		}
+	}
+
+	var disabled *ProxyAuth
+	req := httptest.NewRequest("POST", "/u/alice-token/x", nil)
+	if user, err := disabled.Authenticate(req); user != "" || err != nil || req.URL.Path != "/u/alice-token/x" {
+		t.Error("Expected disabled auth to leave requests untouched")
+	}
+}
+
+func TestNewProxyAuthRejectsBadUsers(t *testing.T) {
+	for _, users := range [][]ProxyUser{
+		{{Token: "t"}},
+		{{Name: "a"}},
+		{{Name: "a", Token: "t", TokenSHA256: hashProxyToken("t")}},
+		{{Name: "a", TokenSHA256: "abc"}},
+		{{Name: "a", Token: "has/slash"}},
+		{{Name: "a", Token: "same"}, {Name: "b", TokenSHA256: hashProxyToken("same")}},
+	} {
+		if _, err := NewProxyAuth(AuthConfig{Users: users}); err == nil {
+			t.Errorf("Expected %+v to be rejected", users)
+		}
+	}
+	if a, err := NewProxyAuth(AuthConfig{}); a != nil || err != nil {
+		t.Error("Expected no users to disable auth")
+	}
+	bad := AuthConfig{Users: []ProxyUser{{Name: "a", Token: "t"}}, PublicEndpoints: []string{"/anthropic"}}
+	if _, err := NewProxyAuth(bad); err == nil {
+		t.Error("Expected a non-status public endpoint to be rejected")
+	}
+}
+
+func TestValidateListenAddress(t *testing.T) {
+	withAuth := AuthConfig{Users: []ProxyUser{{Name: "alice", Token: "t"}}}
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		if err := ValidateListenAddress(host, AuthConfig{}); err != nil {
+			t.Errorf("Expected %s to be allowed without auth: %v", host, err)
+		}
+	}
+	for _, host := range []string{"0.0.0.0", "", "10.0.0.5"} {
+		if err := ValidateListenAddress(host, AuthConfig{}); err == nil {
+			t.Errorf("Expected %q to be refused without auth", host)
+		}
+		if err := ValidateListenAddress(host, withAuth); err != nil {
+			t.Errorf("Expected %q to be allowed with auth: %v", host, err)
+		}
+	}
+}
+
+func TestProxyAuthRecordsIdentity(t *testing.T) {
+	var received *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":3,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	closeOrder := []string{}
+	lokiExporter := newMockLokiExporter(&closeOrder)
+	mw := NewMultiWriter(logger, lokiExporter)
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+	proxy := NewProxyWithSessionManagerAndLogger(mw, sm)
+	proxy.auth = testProxyAuth(t)
+
+	body := `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/u/alice-token/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	mw.Close()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if received.URL.Path != "/v1/messages" || strings.Contains(received.URL.String(), "alice-token") {
+		t.Errorf("Expected token stripped before forwarding, got %s", received.URL)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, upstreamHost, "*", "*.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one session log, got %v", matches)
+	}
+	data, _ := os.ReadFile(matches[0])
+	if strings.Contains(string(data), "alice-token") {
+		t.Error("Expected token kept out of the log")
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]interface{}
+		json.Unmarshal([]byte(line), &entry)
+		if machine := entry["_meta"].(map[string]interface{})["machine"]; machine != "alice" {
+			t.Errorf("Expected machine alice in %s entry, got %v", entry["type"], machine)
+		}
+	}
+	for _, call := range lokiExporter.pushCalls {
+		if machine := call.entry["_meta"].(map[string]interface{})["machine"]; machine != "alice" {
+			t.Errorf("Expected machine alice in Loki %s entry, got %v", call.entry["type"], machine)
+		}
+	}
+
+	// Unauthenticated requests never reach the upstream
+	received = nil
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(body)))
+	if w.Code != http.StatusProxyAuthRequired || received != nil {
+		t.Errorf("Expected 407 without forwarding, got %d", w.Code)
+	}
+}
+
+func TestSessionsScopedToUser(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":3,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+	proxy := NewProxyWithSessionManagerAndLogger(logger, sm)
+	proxy.auth = testProxyAuth(t)
+
+	body := `{"model":"claude-sonnet-4","metadata":{"user_id":"user_abc_account_def_session_shared-session"},"messages":[{"role":"user","content":"hi"}]}`
+	send := func(token string) int {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("POST", "/u/"+token+"/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := send("alice-token"); code != http.StatusOK {
+		t.Fatalf("Expected alice's first request to succeed, got %d", code)
+	}
+	if code := send("alice-token"); code != http.StatusOK {
+		t.Fatalf("Expected alice to continue her session, got %d", code)
+	}
+	if code := send("bob-token"); code != http.StatusForbidden {
+		t.Errorf("Expected bob to be refused alice's session, got %d", code)
+	}
+
+	sessionID, owner, err := sm.db.FindByClientSessionID("shared-session")
+	if err != nil || owner != "alice" {
+		t.Fatalf("Expected session owned by alice, got %q (%v)", owner, err)
+	}
+	_, _, _, lastSeq, _ := sm.db.GetSessionWithClientID(sessionID)
+	if lastSeq != 2 {
+		t.Errorf("Expected bob's request kept out of alice's session, got last_seq %d", lastSeq)
+	}
+}
+
+func TestStatusEndpointsRequireAuthOffLoopback(t *testing.T) {
+	users := []ProxyUser{{Name: "alice", Token: "alice-token"}}
+	tests := []struct {
+		name   string
+		cfg    Config
+		path   string
+		header string
+		want   int
+	}{
+		{"metrics without token", Config{Listen: "0.0.0.0", Auth: AuthConfig{Users: users}}, "/metrics", "", http.StatusProxyAuthRequired},
+		{"health without token", Config{Listen: "0.0.0.0", Auth: AuthConfig{Users: users}}, "/health", "", http.StatusProxyAuthRequired},
+		{"metrics with token", Config{Listen: "0.0.0.0", Auth: AuthConfig{Users: users}}, "/metrics", "Bearer alice-token", http.StatusOK},
+		{"health with path token", Config{Listen: "0.0.0.0", Auth: AuthConfig{Users: users}}, "/u/alice-token/health", "", http.StatusOK},
+		{"public health", Config{Listen: "0.0.0.0", Auth: AuthConfig{Users: users, PublicEndpoints: []string{"/health"}}}, "/health", "", http.StatusOK},
+		{"public health keeps metrics closed", Config{Listen: "0.0.0.0", Auth: AuthConfig{Users: users, PublicEndpoints: []string{"/health"}}}, "/metrics", "", http.StatusProxyAuthRequired},
+		{"loopback listener", Config{Listen: "localhost", Auth: AuthConfig{Users: users}}, "/metrics", "", http.StatusOK},
+		{"auth disabled", Config{Listen: "localhost"}, "/health", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.LogDir = t.TempDir()
+			srv, err := NewServer(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewServer failed: %v", err)
+			}
+			defer srv.Close()
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Proxy-Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -148,7 +149,7 @@ type bedrockState struct {
 }
 
 // initBedrock initializes Bedrock resources. Returns nil if Bedrock is not configured.
-func initBedrock(region string) (*bedrockState, error) {
+func initBedrock(region string, transportCfg TransportConfig) (*bedrockState, error) {
 	if region == "" {
 		return nil, nil
 	}
@@ -165,17 +166,22 @@ func initBedrock(region string) (*bedrockState, error) {
 		return nil, fmt.Errorf("load AWS config: %w", err)
 	}
 
+	transport := &http.Transport{
+		DisableCompression:    true,
+		ResponseHeaderTimeout: 300 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+	if err := transportCfg.Apply(transport); err != nil {
+		return nil, err
+	}
+
 	return &bedrockState{
 		region:   region,
 		credProv: cfg.Credentials,
 		signer:   v4.NewSigner(),
 		client: &http.Client{
-			Transport: &http.Transport{
-				DisableCompression:    true,
-				ResponseHeaderTimeout: 300 * time.Second,
-				ForceAttemptHTTP2:     true,
-			},
-			Timeout: 0,
+			Transport: transport,
+			Timeout:   0,
 		},
 		semaphore: make(chan struct{}, bedrockMaxConcurrent),
 	}, nil
@@ -224,6 +230,11 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 	// Use provider=anthropic — Bedrock payloads use the Anthropic JSON format
 	provider := "anthropic"
 	upstream := fmt.Sprintf("bedrock-runtime.%s.amazonaws.com", p.bedrock.region)
+	if err := p.upstreams.Check("bedrock", upstream); err != nil {
+		log.Printf("Upstream rejected: %v (path=%s)", err, r.URL.Path)
+		http.Error(w, "upstream not allowed: "+err.Error(), http.StatusForbidden)
+		return
+	}
 
 	// Session tracking and logging setup
 	var sessionID string
@@ -245,15 +256,37 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if p.sessionManager != nil {
-			sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(reqBody, provider, upstream, r.Header, r.URL.Path)
+			owner := proxyUserFromContext(r.Context())
+			existing, err := p.sessionManager.FindSession(reqBody, provider, r.Header, r.URL.Path, owner)
+			if errors.Is(err, ErrSessionOwner) {
+				log.Printf("Session: rejecting Bedrock request from %q: %v", owner, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			admittedState, ok := p.admitRequest(w, r, existing, provider, "bedrock")
+			if !ok {
+				return
+			}
+
+			sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(reqBody, provider, upstream, r.Header, r.URL.Path, owner)
+			if errors.Is(err, ErrSessionOwner) {
+				log.Printf("Session: rejecting Bedrock request from %q: %v", owner, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 			if err != nil {
 				sessionID = p.generateSessionID()
 				seq = 1
 				isNewSession = true
 			}
+			p.registerIdentity(sessionID, r)
 
 			if p.eventEmitter != nil {
-				patternState, _ = p.sessionManager.LoadPatternState(sessionID)
+				if sessionID == existing {
+					patternState = admittedState
+				} else {
+					patternState, _ = p.sessionManager.LoadPatternState(sessionID)
+				}
 				if patternState == nil {
 					patternState = &PatternState{PendingToolIDs: make(map[string]string)}
 				}
@@ -261,12 +294,13 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 				hadError := p.processToolResultsAndEmitEvents(reqBody, sessionID, provider, patternState)
 				patternState.LastWasError = hadError
 				patternState.TurnCount++
-				p.eventEmitter.EmitTurnStart(sessionID, provider, p.machineID, patternState.TurnCount, errorRecovered)
+				p.eventEmitter.EmitTurnStart(sessionID, provider, p.machineFor(sessionID), patternState.TurnCount, errorRecovered)
 			}
 		} else {
 			sessionID = p.generateSessionID()
 			seq = 1
 			isNewSession = true
+			p.registerIdentity(sessionID, r)
 		}
 
 		if isNewSession {
@@ -308,6 +342,7 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 	// Send to Bedrock
 	resp, err := p.bedrock.client.Do(proxyReq)
 	if err != nil {
+		p.metrics.ObserveFailure(provider, modelID)
 		http.Error(w, "upstream request failed: "+err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -317,12 +352,13 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 	if resp.StatusCode != http.StatusOK {
 		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, bedrockMaxErrorBody))
 
+		timing := ResponseTiming{
+			TTFBMs:  time.Since(startTime).Milliseconds(),
+			TotalMs: time.Since(startTime).Milliseconds(),
+		}
+		p.metrics.ObserveResponse(provider, modelID, resp.StatusCode, timing, UsageInfo{})
 		if shouldLog {
-			timing := ResponseTiming{
-				TTFBMs:  time.Since(startTime).Milliseconds(),
-				TotalMs: time.Since(startTime).Milliseconds(),
-			}
-			p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, errBody, nil, timing, requestID)
+			p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, errBody, nil, timing, requestID, nil)
 		}
 
 		copyHeaders(w.Header(), resp.Header)
@@ -344,6 +380,8 @@ func (p *Proxy) serveBedrockStreaming(w http.ResponseWriter, resp *http.Response
 	observeBuf := bytes.NewBuffer(make([]byte, 0, bedrockMaxBuffer))
 	limitedW := &LimitedWriter{W: observeBuf, N: int64(bedrockMaxBuffer)}
 	tee := io.TeeReader(resp.Body, limitedW)
+	p.metrics.StreamStarted()
+	defer p.metrics.StreamEnded()
 
 	// Forward headers and status
 	copyHeaders(w.Header(), resp.Header)
@@ -382,12 +420,15 @@ func (p *Proxy) serveBedrockStreaming(w http.ResponseWriter, resp *http.Response
 			TTFBMs:  ttfb.Milliseconds(),
 			TotalMs: totalTime.Milliseconds(),
 		}
-		p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, nil, chunks, timing, requestID)
+		parsed := ParseStreamingResponse(chunks)
+		cost := priceResponse(p.pricing, p.sessionManager, sessionID, modelID, parsed.Usage)
+		p.budget.Record(sessionID, p.machineFor(sessionID), resp.StatusCode, parsed.Usage, cost)
+		p.metrics.ObserveResponse(provider, modelID, resp.StatusCode, timing, parsed.Usage)
+		p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, nil, chunks, timing, requestID, cost)
 
 		// Emit agent observability events
 		if p.eventEmitter != nil && patternState != nil && p.sessionManager != nil && len(chunks) > 0 {
-			parsed := ParseStreamingResponse(chunks)
-			emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineID, patternState, parsed.Content, parsed.Usage, parsed.StopReason, resp.StatusCode, "")
+			emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineFor(sessionID), patternState, parsed.Content, parsed.Usage, cost, parsed.StopReason, resp.StatusCode, "", p.anomaly)
 		}
 	}
 }
@@ -396,6 +437,7 @@ func (p *Proxy) serveBedrockStreaming(w http.ResponseWriter, resp *http.Response
 func (p *Proxy) serveBedrockNonStreaming(w http.ResponseWriter, resp *http.Response, startTime time.Time, modelID, upstream, provider, sessionID string, seq int, reqBody []byte, requestID string, patternState *PatternState, shouldLog bool) {
 	respBody, err := io.ReadAll(io.LimitReader(resp.Body, bedrockMaxRequestBody))
 	if err != nil {
+		p.metrics.ObserveFailure(provider, modelID)
 		http.Error(w, "failed to read response body", http.StatusBadGateway)
 		return
 	}
@@ -407,11 +449,14 @@ func (p *Proxy) serveBedrockNonStreaming(w http.ResponseWriter, resp *http.Respo
 			TTFBMs:  totalTime.Milliseconds(),
 			TotalMs: totalTime.Milliseconds(),
 		}
-		p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, respBody, nil, timing, requestID)
+		parsed := ParseResponseBody(string(respBody), upstream)
+		cost := priceResponse(p.pricing, p.sessionManager, sessionID, modelID, parsed.Usage)
+		p.budget.Record(sessionID, p.machineFor(sessionID), resp.StatusCode, parsed.Usage, cost)
+		p.metrics.ObserveResponse(provider, modelID, resp.StatusCode, timing, parsed.Usage)
+		p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, respBody, nil, timing, requestID, cost)
 
 		if p.eventEmitter != nil && patternState != nil {
-			parsed := ParseResponseBody(string(respBody), upstream)
-			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody))
+			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody), cost)
 		}
 	}
 
@@ -601,6 +601,9 @@ type providerCapture struct {
 func (pc *providerCapture) RegisterUpstream(sessionID, upstream string) {
 	pc.inner.RegisterUpstream(sessionID, upstream)
 }
+func (pc *providerCapture) RegisterIdentity(sessionID, identity string) {
+	pc.inner.RegisterIdentity(sessionID, identity)
+}
 func (pc *providerCapture) LogSessionStart(sessionID, provider, upstream string) error {
 	*pc.capturedProvider = provider
 	return pc.inner.LogSessionStart(sessionID, provider, upstream)
@@ -609,8 +612,8 @@ func (pc *providerCapture) LogRequest(sessionID, provider string, seq int, metho
 	*pc.capturedProvider = provider
 	return pc.inner.LogRequest(sessionID, provider, seq, method, path, headers, body, requestID)
 }
-func (pc *providerCapture) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string) error {
-	return pc.inner.LogResponse(sessionID, provider, seq, status, headers, body, chunks, timing, requestID)
+func (pc *providerCapture) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string, costUSD *float64) error {
+	return pc.inner.LogResponse(sessionID, provider, seq, status, headers, body, chunks, timing, requestID, costUSD)
 }
 func (pc *providerCapture) LogFork(sessionID, provider string, fromSeq int, parentSession string) error {
 	return pc.inner.LogFork(sessionID, provider, fromSeq, parentSession)
@@ -0,0 +1,213 @@
+// blobstore.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With deduplication enabled, large repeated parts of a request body (each
+// message, the system prompt, each tool definition) are written once to
+// <upstream>/<date>/blobs/<hash>.json next to the session logs. The hash is
+// the sha256 of the content, or an HMAC of it with encryption at rest (see
+// LogCipher.BlobName). The request entry then carries body_segments instead
+// of body: literal text interleaved with blob references, which concatenate
+// back to the exact original bytes.
+
+const blobDirName = "blobs"
+
+// StorageConfig controls how session logs are written.
+type StorageConfig struct {
+	Dedup bool `toml:"dedup"` // Write repeated request body parts once to the blob store
+}
+
+// minBlobSize keeps small values inline, where a reference would cost more
+// than it saves.
+const minBlobSize = 128
+
+// dedupBodyKeys are the top-level request fields whose contents are resent
+// every turn. Array values are split per element.
+var dedupBodyKeys = map[string]bool{
+	"messages":           true, // Anthropic, OpenAI Chat Completions
+	"input":              true, // OpenAI Responses
+	"contents":           true, // Gemini
+	"system":             true,
+	"instructions":       true,
+	"systemInstruction":  true,
+	"system_instruction": true,
+	"tools":              true,
+}
+
+// bodySegment is one piece of a deduplicated body: either literal text or
+// the hash of a blob.
+type bodySegment struct {
+	Text string `json:"text,omitempty"`
+	Blob string `json:"blob,omitempty"`
+}
+
+// splitBody cuts a JSON request body into segments, returning the blobs to
+// store by hash, named with c.BlobName. Returns nil segments if there is
+// nothing worth storing separately or the body isn't a JSON object.
+func splitBody(body []byte, c *LogCipher) ([]bodySegment, map[string][]byte) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil
+	}
+
+	var segments []bodySegment
+	blobs := make(map[string][]byte)
+	last := 0
+
+	cut := func(start, end int) {
+		data := body[start:end]
+		if len(data) < minBlobSize {
+			return
+		}
+		key := c.BlobName(data)
+		if start > last {
+			segments = append(segments, bodySegment{Text: string(body[last:start])})
+		}
+		segments = append(segments, bodySegment{Blob: key})
+		blobs[key] = data
+		last = end
+	}
+
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, nil
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil
+		}
+		key, _ := keyTok.(string)
+		if !dedupBodyKeys[key] {
+			continue
+		}
+
+		end := int(dec.InputOffset())
+		start := end - len(value)
+		if value[0] != '[' {
+			cut(start, end)
+			continue
+		}
+
+		elems := json.NewDecoder(bytes.NewReader(value))
+		elems.Token()
+		for elems.More() {
+			var elem json.RawMessage
+			if err := elems.Decode(&elem); err != nil {
+				return nil, nil
+			}
+			elemEnd := start + int(elems.InputOffset())
+			cut(elemEnd-len(elem), elemEnd)
+		}
+	}
+
+	if len(blobs) == 0 {
+		return nil, nil
+	}
+	if last < len(body) {
+		segments = append(segments, bodySegment{Text: string(body[last:])})
+	}
+	return segments, blobs
+}
+
+// blobPath returns where a blob lives for logs in dir.
+func blobPath(dir, hash string) string {
+	return filepath.Join(dir, blobDirName, hash+".json")
+}
+
+// writeBlob stores data under its hash in dir. Blobs are immutable, so an
+// existing file is left as is; the rename makes concurrent writers safe.
+func writeBlob(dir, hash string, data []byte) error {
+	path := blobPath(dir, hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// readBlob loads a blob referenced from logs in dir. A session that crossed
+// midnight may reference blobs stored under a sibling date directory.
+func readBlob(dir, hash string, c *LogCipher) ([]byte, error) {
+	paths := []string{blobPath(dir, hash)}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(dir), "*", blobDirName, hash+".json"))
+	paths = append(paths, matches...)
+
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err == nil {
+			return c.Open(data)
+		}
+	}
+	return nil, fmt.Errorf("blob %s not found", hash)
+}
+
+// expandBody reassembles a deduplicated body.
+func expandBody(segments []bodySegment, dir string, c *LogCipher) (string, error) {
+	var body strings.Builder
+	for _, seg := range segments {
+		if seg.Blob == "" {
+			body.WriteString(seg.Text)
+			continue
+		}
+		data, err := readBlob(dir, seg.Blob, c)
+		if err != nil {
+			return "", err
+		}
+		body.Write(data)
+	}
+	return body.String(), nil
+}
+
+// resolveLogLine returns a log line with body_segments replaced by the full
+// body. Lines without segments, or whose blobs are missing, come back as is.
+func resolveLogLine(line, dir string, c *LogCipher) string {
+	if !strings.Contains(line, `"body_segments"`) {
+		return line
+	}
+
+	var ref struct {
+		Segments []bodySegment `json:"body_segments"`
+	}
+	var entry map[string]interface{}
+	if json.Unmarshal([]byte(line), &ref) != nil || json.Unmarshal([]byte(line), &entry) != nil || ref.Segments == nil {
+		return line
+	}
+
+	body, err := expandBody(ref.Segments, dir, c)
+	if err != nil {
+		return line
+	}
+	delete(entry, "body_segments")
+	entry["body"] = body
+
+	resolved, err := json.Marshal(entry)
+	if err != nil {
+		return line
+	}
+	return string(resolved)
+}
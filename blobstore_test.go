@@ -0,0 +1,123 @@
+// blobstore_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dedupTestBody(turns int) string {
+	system := strings.Repeat("You are a careful coding agent. ", 10)
+	var messages []string
+	for i := 0; i < turns; i++ {
+		messages = append(messages, `{"role": "user", "content": "`+strings.Repeat("please look at file "+string(rune('a'+i))+" ", 10)+`"}`)
+	}
+	// Irregular whitespace must survive the round trip
+	return `{"model":"claude-sonnet-4", "max_tokens": 1024,` + "\n" + `  "system": "` + system + `",  "messages": [ ` + strings.Join(messages, " ,\n") + ` ], "stream": true}`
+}
+
+func TestSplitBodyRoundTrip(t *testing.T) {
+	body := dedupTestBody(3)
+
+	segments, blobs := splitBody([]byte(body), nil)
+	if segments == nil {
+		t.Fatal("Expected body to be split")
+	}
+	// System prompt plus three messages
+	if len(blobs) != 4 {
+		t.Errorf("Expected 4 blobs, got %d", len(blobs))
+	}
+
+	dir := t.TempDir()
+	for hash, data := range blobs {
+		if err := writeBlob(dir, hash, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expanded, err := expandBody(segments, dir, nil)
+	if err != nil {
+		t.Fatalf("expandBody failed: %v", err)
+	}
+	if expanded != body {
+		t.Errorf("Round trip changed the body:\n got: %s\nwant: %s", expanded, body)
+	}
+}
+
+func TestSplitBodyLeavesSmallOrInvalidBodiesInline(t *testing.T) {
+	for _, body := range []string{
+		`{"messages":[{"role":"user","content":"hi"}]}`,
+		`not json`,
+		`[{"role":"user"}]`,
+		`{"messages":[` + strings.Repeat(`"x",`, 100),
+	} {
+		if segments, _ := splitBody([]byte(body), nil); segments != nil {
+			t.Errorf("Expected %q to stay inline, got %+v", body, segments)
+		}
+	}
+}
+
+func TestLoggerDedupStoresEachBlockOnce(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	logger.SetDedup(true)
+
+	sessionID := "20260320-100000-dedup"
+	logger.LogSessionStart(sessionID, "anthropic", "api.anthropic.com")
+	first := dedupTestBody(2)
+	second := dedupTestBody(3)
+	logger.LogRequest(sessionID, "anthropic", 1, "POST", "/v1/messages", nil, []byte(first), "req-1")
+	logger.LogRequest(sessionID, "anthropic", 2, "POST", "/v1/messages", nil, []byte(second), "req-2")
+	logger.Close()
+
+	today := time.Now().Format("2006-01-02")
+	logPath := filepath.Join(tmpDir, "api.anthropic.com", today, sessionID+".jsonl")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if strings.Contains(string(data), "careful coding agent") {
+		t.Error("Expected system prompt to be stored as a blob, not inline")
+	}
+	if !strings.Contains(string(data), `"body_segments"`) {
+		t.Error("Expected request entries to reference blobs")
+	}
+
+	// System prompt and three distinct messages, shared between the turns
+	blobs, _ := filepath.Glob(filepath.Join(tmpDir, "api.anthropic.com", today, blobDirName, "*.json"))
+	if len(blobs) != 4 {
+		t.Errorf("Expected 4 blobs, got %d", len(blobs))
+	}
+
+	entries, err := NewExplorer(tmpDir).parseSessionFile(logPath)
+	if err != nil {
+		t.Fatalf("parseSessionFile failed: %v", err)
+	}
+	if len(entries) != 3 || entries[1].Body != first || entries[2].Body != second {
+		t.Errorf("Expected explorer to reconstitute the original bodies, got %+v", entries)
+	}
+}
+
+func TestRetentionRemovesBlobsWithLastLog(t *testing.T) {
+	logDir := t.TempDir()
+	writeTestLog(t, logDir, "api.anthropic.com", "2026-01-01", "old.jsonl", "x")
+	writeBlob(filepath.Join(logDir, "api.anthropic.com", "2026-01-01"), "abc", []byte(`"blob"`))
+	writeTestLog(t, logDir, "api.anthropic.com", "2026-03-19", "new.jsonl", "x")
+	keptBlob := filepath.Join(logDir, "api.anthropic.com", "2026-03-19")
+	writeBlob(keptBlob, "def", []byte(`"blob"`))
+
+	r := newTestRetention(RetentionConfig{MaxAgeDays: 30}, logDir, nil)
+	if _, err := r.Sweep(); err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(logDir, "api.anthropic.com", "2026-01-01")); !os.IsNotExist(err) {
+		t.Error("Expected expired date directory and its blobs to be removed")
+	}
+	if _, err := os.Stat(blobPath(keptBlob, "def")); err != nil {
+		t.Error("Expected blobs of kept logs to stay")
+	}
+}
@@ -0,0 +1,243 @@
+// budget.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BudgetLimits caps usage within one scope. Zero disables a limit.
+type BudgetLimits struct {
+	MaxTokens  int     `toml:"max_tokens"`   // Input (including cache reads and writes) + output tokens
+	MaxTurns   int     `toml:"max_turns"`    // Completed request/response cycles
+	MaxCostUSD float64 `toml:"max_cost_usd"` // Requires a known price for the model
+}
+
+func (l BudgetLimits) enabled() bool {
+	return l.MaxTokens > 0 || l.MaxTurns > 0 || l.MaxCostUSD > 0
+}
+
+// BudgetConfig holds budgets per session, per UTC day and per machine. The
+// machine is the authenticated user when proxy auth is on (the same identity
+// logs record as _meta.machine), so each user of a shared proxy has their own
+// machine budget.
+type BudgetConfig struct {
+	SoftLimitRatio float64      `toml:"soft_limit_ratio"` // Warn when usage reaches this fraction of a limit
+	MachineWindow  string       `toml:"machine_window"`   // Machine budget period: "day" (default), "week" or "month", in UTC
+	Session        BudgetLimits `toml:"session"`
+	Day            BudgetLimits `toml:"day"`
+	Machine        BudgetLimits `toml:"machine"`
+}
+
+// Enabled reports whether any budget limit is configured.
+func (c BudgetConfig) Enabled() bool {
+	return c.Session.enabled() || c.Day.enabled() || c.Machine.enabled()
+}
+
+// Validate checks the machine budget window.
+func (c BudgetConfig) Validate() error {
+	switch c.MachineWindow {
+	case "", "day", "week", "month":
+		return nil
+	}
+	return fmt.Errorf("budgets: unknown machine_window %q (valid: day, week, month)", c.MachineWindow)
+}
+
+// budgetWindowKey names the UTC period containing t, so usage recorded under
+// the key resets when the period ends.
+func budgetWindowKey(window string, t time.Time) string {
+	t = t.UTC()
+	switch window {
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// BudgetExceededError is returned when a request would exceed a budget.
+type BudgetExceededError struct {
+	Scope  string // session, day or machine
+	Metric string // tokens, turns or cost_usd
+	Used   float64
+	Limit  float64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("llm-proxy %s budget exceeded: %s", e.Scope, formatBudgetUsage(e.Metric, e.Used, e.Limit))
+}
+
+// formatBudgetUsage renders usage like "1200 of 1000 tokens used" or "$5.10 of $5.00 used".
+func formatBudgetUsage(metric string, used, limit float64) string {
+	if metric == "cost_usd" {
+		return fmt.Sprintf("$%.2f of $%.2f used", used, limit)
+	}
+	return fmt.Sprintf("%d of %d %s used", int64(used), int64(limit), metric)
+}
+
+// BudgetEnforcer tracks usage against configured budgets in sessions.db and
+// rejects conversation requests once a budget is exhausted.
+type BudgetEnforcer struct {
+	config BudgetConfig
+	sm     *SessionManager
+	now    func() time.Time
+	warned sync.Map // "scope:key:metric" -> struct{}, so soft-limit warnings log once
+}
+
+// NewBudgetEnforcer creates an enforcer for the given budgets.
+func NewBudgetEnforcer(config BudgetConfig, sm *SessionManager) *BudgetEnforcer {
+	if config.SoftLimitRatio <= 0 || config.SoftLimitRatio > 1 {
+		config.SoftLimitRatio = 0.8
+	}
+	return &BudgetEnforcer{
+		config: config,
+		sm:     sm,
+		now:    time.Now,
+	}
+}
+
+type budgetScope struct {
+	name   string
+	key    string
+	limits BudgetLimits
+}
+
+// scopes returns the enabled budget scopes that apply to a session of the
+// given machine (or authenticated user).
+func (b *BudgetEnforcer) scopes(sessionID, machine string) []budgetScope {
+	now := b.now()
+	all := []budgetScope{
+		{"session", sessionID, b.config.Session},
+		{"day", budgetWindowKey("day", now), b.config.Day},
+		{"machine", machine + "/" + budgetWindowKey(b.config.MachineWindow, now), b.config.Machine},
+	}
+	var enabled []budgetScope
+	for _, s := range all {
+		// A request that hasn't been given a session yet has no session usage
+		if s.name == "session" && sessionID == "" {
+			continue
+		}
+		if s.limits.enabled() {
+			enabled = append(enabled, s)
+		}
+	}
+	return enabled
+}
+
+type budgetUsage struct {
+	metric string
+	used   float64
+	limit  float64
+}
+
+// usageAgainstLimits pairs each configured limit with the usage so far.
+func usageAgainstLimits(totals UsageTotals, limits BudgetLimits) []budgetUsage {
+	var result []budgetUsage
+	if limits.MaxTokens > 0 {
+		result = append(result, budgetUsage{"tokens", float64(totals.Tokens), float64(limits.MaxTokens)})
+	}
+	if limits.MaxTurns > 0 {
+		result = append(result, budgetUsage{"turns", float64(totals.Turns), float64(limits.MaxTurns)})
+	}
+	if limits.MaxCostUSD > 0 {
+		result = append(result, budgetUsage{"cost_usd", totals.CostUSD, limits.MaxCostUSD})
+	}
+	return result
+}
+
+// Check returns a *BudgetExceededError if any budget for the session or its
+// machine is exhausted. Safe to call on a nil enforcer.
+func (b *BudgetEnforcer) Check(sessionID, machine string) error {
+	if b == nil {
+		return nil
+	}
+
+	for _, scope := range b.scopes(sessionID, machine) {
+		totals, err := b.sm.GetUsage(scope.name, scope.key)
+		if err != nil {
+			// Fail open - a broken ledger shouldn't take down the proxy
+			log.Printf("WARNING: budget lookup failed: %v (scope=%s)", err, scope.name)
+			continue
+		}
+		for _, u := range usageAgainstLimits(totals, scope.limits) {
+			if u.used >= u.limit {
+				return &BudgetExceededError{Scope: scope.name, Metric: u.metric, Used: u.used, Limit: u.limit}
+			}
+		}
+	}
+	return nil
+}
+
+// Record adds a completed turn's usage to every enabled budget scope and logs
+// a warning the first time a soft limit is crossed. Only successful (2xx)
+// responses count as turns. costUSD may be nil for models with no known
+// price. Cached input counts toward token limits: parsers report it apart
+// from InputTokens, and an agent loop re-sending a cached context is still
+// burning through tokens. Safe to call on a nil enforcer.
+func (b *BudgetEnforcer) Record(sessionID, machine string, status int, usage UsageInfo, costUSD *float64) {
+	if b == nil || status < 200 || status > 299 {
+		return
+	}
+
+	tokens := usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens + usage.OutputTokens
+	var cost float64
+	if costUSD != nil {
+		cost = *costUSD
+	}
+
+	for _, scope := range b.scopes(sessionID, machine) {
+		if err := b.sm.AddUsage(scope.name, scope.key, tokens, cost); err != nil {
+			log.Printf("WARNING: budget update failed: %v (scope=%s)", err, scope.name)
+			continue
+		}
+		totals, err := b.sm.GetUsage(scope.name, scope.key)
+		if err != nil {
+			continue
+		}
+		for _, u := range usageAgainstLimits(totals, scope.limits) {
+			if u.used < u.limit*b.config.SoftLimitRatio {
+				continue
+			}
+			if _, already := b.warned.LoadOrStore(scope.name+":"+scope.key+":"+u.metric, struct{}{}); already {
+				continue
+			}
+			log.Printf("WARNING: %s budget soft limit reached: %s (session=%s)",
+				scope.name, formatBudgetUsage(u.metric, u.used, u.limit), sessionID)
+		}
+	}
+}
+
+// writeRejection writes an error in the shape the provider's clients expect,
+// using statuses those clients treat as non-retryable so an agent stops
+// instead of backing off and trying again. Used for budget and circuit
+// breaker rejections.
+func writeRejection(w http.ResponseWriter, provider string, err error) {
+	var status int
+	var body map[string]interface{}
+
+	if provider == "bedrock" {
+		status = http.StatusBadRequest
+		w.Header().Set("X-Amzn-Errortype", "ServiceQuotaExceededException")
+		body = map[string]interface{}{
+			"message": err.Error(),
+		}
+	} else {
+		p := LookupProvider(provider)
+		if p == nil {
+			p = anthropicProvider{}
+		}
+		status, body = p.ErrorResponse(err)
+	}
+
+	// The OpenAI and Anthropic SDKs check this before the status code
+	w.Header().Set("X-Should-Retry", "false")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
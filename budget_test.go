@@ -0,0 +1,324 @@
+// budget_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBudgetEnforcer(t *testing.T, config BudgetConfig) (*BudgetEnforcer, *SessionManager) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	t.Cleanup(func() { logger.Close() })
+
+	sm, err := NewSessionManager(tmpDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+
+	return NewBudgetEnforcer(config, sm), sm
+}
+
+func TestBudgetSessionTokens(t *testing.T) {
+	budget, _ := newTestBudgetEnforcer(t, BudgetConfig{
+		Session: BudgetLimits{MaxTokens: 1000},
+	})
+
+	if err := budget.Check("session-1", "test-machine"); err != nil {
+		t.Fatalf("expected no error before any usage, got %v", err)
+	}
+
+	budget.Record("session-1", "test-machine", 200, UsageInfo{InputTokens: 600, OutputTokens: 300}, nil)
+	if err := budget.Check("session-1", "test-machine"); err != nil {
+		t.Fatalf("expected no error under limit, got %v", err)
+	}
+
+	budget.Record("session-1", "test-machine", 200, UsageInfo{InputTokens: 50, OutputTokens: 50}, nil)
+	err := budget.Check("session-1", "test-machine")
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected BudgetExceededError, got %v", err)
+	}
+	if exceeded.Scope != "session" || exceeded.Metric != "tokens" || exceeded.Used != 1000 {
+		t.Errorf("unexpected error details: %+v", exceeded)
+	}
+
+	// Other sessions have their own budget
+	if err := budget.Check("session-2", "test-machine"); err != nil {
+		t.Errorf("expected session-2 to be unaffected, got %v", err)
+	}
+}
+
+func TestBudgetDayAndMachineScopes(t *testing.T) {
+	budget, _ := newTestBudgetEnforcer(t, BudgetConfig{
+		Day:     BudgetLimits{MaxTurns: 2},
+		Machine: BudgetLimits{MaxCostUSD: 1.0},
+	})
+	day := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	budget.now = func() time.Time { return day }
+
+	budget.Record("session-1", "test-machine", 200, UsageInfo{}, nil)
+	budget.Record("session-2", "test-machine", 200, UsageInfo{}, nil)
+
+	err := budget.Check("session-3", "test-machine")
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Scope != "day" || exceeded.Metric != "turns" {
+		t.Fatalf("expected day turns budget exceeded, got %v", err)
+	}
+
+	// The day budget resets on the next UTC day
+	budget.now = func() time.Time { return day.AddDate(0, 0, 1) }
+	if err := budget.Check("session-3", "test-machine"); err != nil {
+		t.Fatalf("expected new day to reset day budget, got %v", err)
+	}
+
+	cost := 1.25
+	budget.Record("session-3", "test-machine", 200, UsageInfo{}, &cost)
+	err = budget.Check("session-4", "test-machine")
+	if !errors.As(err, &exceeded) || exceeded.Scope != "machine" || exceeded.Metric != "cost_usd" {
+		t.Fatalf("expected machine cost budget exceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "$1.25 of $1.00 used") {
+		t.Errorf("expected dollar amounts in message, got %q", err.Error())
+	}
+
+	// So does the machine budget, by default
+	budget.now = func() time.Time { return day.AddDate(0, 0, 2) }
+	if err := budget.Check("session-4", "test-machine"); err != nil {
+		t.Errorf("expected new day to reset machine budget, got %v", err)
+	}
+}
+
+func TestBudgetMachineWindow(t *testing.T) {
+	budget, _ := newTestBudgetEnforcer(t, BudgetConfig{
+		MachineWindow: "month",
+		Machine:       BudgetLimits{MaxTurns: 1},
+	})
+	day := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	budget.now = func() time.Time { return day }
+
+	budget.Record("session-1", "test-machine", 200, UsageInfo{}, nil)
+	budget.now = func() time.Time { return day.AddDate(0, 0, 10) }
+	if err := budget.Check("session-2", "test-machine"); err == nil {
+		t.Error("expected the monthly machine budget to hold later in the month")
+	}
+	budget.now = func() time.Time { return day.AddDate(0, 1, 0) }
+	if err := budget.Check("session-2", "test-machine"); err != nil {
+		t.Errorf("expected the monthly machine budget to reset next month, got %v", err)
+	}
+
+	if err := (BudgetConfig{MachineWindow: "year"}).Validate(); err == nil {
+		t.Error("expected an unknown machine_window to be rejected")
+	}
+}
+
+func TestBudgetMachinePerIdentity(t *testing.T) {
+	budget, _ := newTestBudgetEnforcer(t, BudgetConfig{
+		Machine: BudgetLimits{MaxTurns: 1},
+	})
+
+	budget.Record("session-1", "alice", 200, UsageInfo{}, nil)
+	if err := budget.Check("session-2", "alice"); err == nil {
+		t.Error("expected alice's machine budget to be exhausted")
+	}
+	if err := budget.Check("session-3", "bob"); err != nil {
+		t.Errorf("expected bob to have a separate machine budget, got %v", err)
+	}
+}
+
+func TestBudgetCountsCachedTokens(t *testing.T) {
+	budget, _ := newTestBudgetEnforcer(t, BudgetConfig{
+		Session: BudgetLimits{MaxTokens: 100000},
+	})
+
+	// A cached agent loop re-sends a large context for a handful of new tokens
+	usage := UsageInfo{InputTokens: 10, OutputTokens: 20, CacheReadInputTokens: 60000, CacheCreationInputTokens: 500}
+	budget.Record("session-1", "test-machine", 200, usage, nil)
+	if err := budget.Check("session-1", "test-machine"); err != nil {
+		t.Fatalf("expected the first turn to fit, got %v", err)
+	}
+	budget.Record("session-1", "test-machine", 200, usage, nil)
+	err := budget.Check("session-1", "test-machine")
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Metric != "tokens" || exceeded.Used != 121060 {
+		t.Errorf("expected cached tokens to count toward max_tokens, got %v", err)
+	}
+}
+
+func TestBudgetIgnoresErrorResponses(t *testing.T) {
+	budget, _ := newTestBudgetEnforcer(t, BudgetConfig{
+		Session: BudgetLimits{MaxTurns: 1},
+	})
+
+	budget.Record("session-1", "test-machine", 500, UsageInfo{}, nil)
+	budget.Record("session-1", "test-machine", 429, UsageInfo{}, nil)
+	if err := budget.Check("session-1", "test-machine"); err != nil {
+		t.Fatalf("expected error responses not to count as turns, got %v", err)
+	}
+	budget.Record("session-1", "test-machine", 200, UsageInfo{}, nil)
+	if err := budget.Check("session-1", "test-machine"); err == nil {
+		t.Error("expected a successful turn to count")
+	}
+}
+
+func TestBudgetSoftLimitWarning(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	budget, _ := newTestBudgetEnforcer(t, BudgetConfig{
+		SoftLimitRatio: 0.5,
+		Session:        BudgetLimits{MaxTurns: 4},
+	})
+
+	budget.Record("session-1", "test-machine", 200, UsageInfo{}, nil)
+	if strings.Contains(logBuf.String(), "soft limit") {
+		t.Fatal("expected no warning below soft limit")
+	}
+
+	budget.Record("session-1", "test-machine", 200, UsageInfo{}, nil)
+	budget.Record("session-1", "test-machine", 200, UsageInfo{}, nil)
+	if n := strings.Count(logBuf.String(), "session budget soft limit reached"); n != 1 {
+		t.Errorf("expected exactly one soft limit warning, got %d:\n%s", n, logBuf.String())
+	}
+}
+
+func TestBudgetNilEnforcer(t *testing.T) {
+	var budget *BudgetEnforcer
+	budget.Record("session-1", "test-machine", 200, UsageInfo{InputTokens: 100}, nil)
+	if err := budget.Check("session-1", "test-machine"); err != nil {
+		t.Errorf("expected nil enforcer to allow requests, got %v", err)
+	}
+}
+
+func TestProxyRejectsOverBudget(t *testing.T) {
+	upstreamCalls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			w.Write([]byte(`{"object":"chat.completion","choices":[{"message":{"role":"assistant","content":"ok"}}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`))
+			return
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	proxy := NewProxyWithSessionManagerAndLogger(logger, sm)
+	proxy.budget = NewBudgetEnforcer(BudgetConfig{Day: BudgetLimits{MaxTurns: 1}}, sm)
+
+	send := func(provider, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/"+provider+"/"+upstreamHost+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	anthropicBody := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"hi"}]}`
+	if w := send("anthropic", "/v1/messages", anthropicBody); w.Code != http.StatusOK {
+		t.Fatalf("expected first request to succeed, got %d", w.Code)
+	}
+
+	// Anthropic-shaped rejection
+	w := send("anthropic", "/v1/messages", anthropicBody)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	var anthropicErr struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &anthropicErr)
+	if anthropicErr.Type != "error" || anthropicErr.Error.Type != "invalid_request_error" || !strings.Contains(anthropicErr.Error.Message, "day budget exceeded") {
+		t.Errorf("unexpected Anthropic error body: %s", w.Body.String())
+	}
+
+	// OpenAI-shaped rejection
+	w = send("openai", "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	var openaiErr struct {
+		Error struct {
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &openaiErr)
+	if openaiErr.Error.Code != "insufficient_quota" || !strings.Contains(openaiErr.Error.Message, "1 of 1 turns used") {
+		t.Errorf("unexpected OpenAI error body: %s", w.Body.String())
+	}
+
+	if upstreamCalls != 1 {
+		t.Errorf("expected rejected requests not to reach upstream, got %d upstream calls", upstreamCalls)
+	}
+}
+
+func TestProxyBudgetRejectionLeavesSessionsAlone(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":10,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	proxy := NewProxyWithSessionManagerAndLogger(logger, sm)
+	proxy.budget = NewBudgetEnforcer(BudgetConfig{Day: BudgetLimits{MaxTurns: 1}}, sm)
+
+	send := func(clientSession string) int {
+		body := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"hi"}],"metadata":{"user_id":"user_abc_account_def_session_` + clientSession + `"}}`
+		req := httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send("first"); code != http.StatusOK {
+		t.Fatalf("expected first request to succeed, got %d", code)
+	}
+	if code := send("first"); code != http.StatusBadRequest {
+		t.Fatalf("expected over-budget request to be rejected, got %d", code)
+	}
+	if code := send("second"); code != http.StatusBadRequest {
+		t.Fatalf("expected over-budget request to be rejected, got %d", code)
+	}
+
+	// The rejected continuation didn't advance the existing session...
+	sessionID, _, _ := sm.db.FindByClientSessionID("first")
+	if _, _, _, seq, _ := sm.db.GetSessionWithClientID(sessionID); seq != 1 {
+		t.Errorf("expected rejected request to leave the session at seq 1, got %d", seq)
+	}
+	// ...and the rejected new conversation didn't start one
+	if sessionID, _, _ := sm.db.FindByClientSessionID("second"); sessionID != "" {
+		t.Errorf("expected rejected request not to create a session, got %s", sessionID)
+	}
+}
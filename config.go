@@ -26,10 +26,14 @@ type LokiConfig struct {
 	RetryMax     int    `toml:"retry_max"`    // Maximum retry attempts
 	UseGzip      bool   `toml:"use_gzip"`     // Enable gzip compression
 	Environment  string `toml:"environment"`  // Environment label (development, staging, production)
+	Spool        bool   `toml:"spool"`        // Keep batches Loki can't take on disk and replay them
+	SpoolDir     string `toml:"spool_dir"`    // Spool location (default: <log_dir>/.loki-spool)
+	SpoolMaxMB   int    `toml:"spool_max_mb"` // Spool size cap in MiB
 }
 
 type Config struct {
 	Port          int    `toml:"port"`
+	Listen        string `toml:"listen"` // Host or IP to bind; non-loopback requires [[auth.users]]
 	LogDir        string `toml:"log_dir"`
 	BedrockRegion string `toml:"bedrock_region"` // AWS region for Bedrock (empty = disabled)
 	ServiceMode   bool   `toml:"-"`              // CLI-only, not persisted in config file
@@ -41,11 +45,29 @@ type Config struct {
 	Explore       bool   `toml:"-"`              // CLI-only, not persisted in config file
 	ExplorePort   int    `toml:"explore_port"`
 	Loki          LokiConfig `toml:"loki"`
+	Pricing       map[string]ModelPrice `toml:"pricing"` // Per-model price overrides keyed by model glob
+	Budgets       BudgetConfig `toml:"budgets"`
+	Anomaly       AnomalyConfig `toml:"anomaly"`
+	Upstreams     map[string][]string `toml:"upstreams"` // Allowed upstream hosts/patterns per provider (plus "bedrock")
+	Retention     RetentionConfig `toml:"retention"`
+	Storage       StorageConfig `toml:"storage"`
+	Redaction     RedactionConfig `toml:"redaction"`
+	Encryption    EncryptionConfig `toml:"encryption"`
+	SensitiveHeaders []string `toml:"sensitive_headers"` // Extra headers to obfuscate in logs (added to the built-in list)
+	Auth          AuthConfig `toml:"auth"`
+	TLS           TLSConfig `toml:"tls"`             // Serve HTTPS on the listener
+	Transport     TransportConfig `toml:"transport"` // CA bundle, client cert and egress proxy for upstream connections
+	ForwardProxy  ForwardProxyConfig `toml:"forward_proxy"` // CONNECT mode with TLS interception
+	Playback      PlaybackConfig `toml:"playback"`          // Answer requests from recorded logs (hermetic tests)
+	OTLP          OTLPConfig `toml:"otlp"`                  // OpenTelemetry GenAI trace export
+	Sinks         []SinkConfig `toml:"sinks"`              // Extra log entry destinations ([[sinks]])
+	Webhook       WebhookConfig `toml:"webhook"`           // POST session, error, anomaly and budget events
 }
 
 func DefaultConfig() Config {
 	return Config{
 		Port:   0,
+		Listen: "localhost",
 		LogDir: "./logs",
 		Loki: LokiConfig{
 			Enabled:      false,
@@ -54,6 +76,19 @@ func DefaultConfig() Config {
 			RetryMax:     5,
 			UseGzip:      true,
 			Environment:  "development",
+			Spool:        true,
+			SpoolMaxMB:   512,
+		},
+		Budgets: BudgetConfig{
+			SoftLimitRatio: 0.8,
+		},
+		Anomaly: AnomalyConfig{
+			IdenticalToolCalls: 5,
+			RetryStorm:         5,
+		},
+		Upstreams: DefaultAllowedUpstreams(),
+		Retention: RetentionConfig{
+			Compression: "gzip",
 		},
 	}
 }
@@ -84,6 +119,9 @@ func LoadConfigFromEnv(cfg Config) Config {
 			cfg.Port = p
 		}
 	}
+	if listen := os.Getenv("LLM_PROXY_LISTEN"); listen != "" {
+		cfg.Listen = listen
+	}
 	if logDir := os.Getenv("LLM_PROXY_LOG_DIR"); logDir != "" {
 		cfg.LogDir = logDir
 	}
@@ -120,6 +158,17 @@ func LoadConfigFromEnv(cfg Config) Config {
 	if env := os.Getenv("LLM_PROXY_LOKI_ENVIRONMENT"); env != "" {
 		cfg.Loki.Environment = env
 	}
+	if spoolDir := os.Getenv("LLM_PROXY_LOKI_SPOOL_DIR"); spoolDir != "" {
+		cfg.Loki.SpoolDir = spoolDir
+	}
+
+	// OTLP trace export
+	if enabled := os.Getenv("LLM_PROXY_OTLP_ENABLED"); enabled != "" {
+		cfg.OTLP.Enabled = enabled == "true" || enabled == "1"
+	}
+	if endpoint := os.Getenv("LLM_PROXY_OTLP_ENDPOINT"); endpoint != "" {
+		cfg.OTLP.Endpoint = endpoint
+	}
 
 	return cfg
 }
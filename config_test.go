@@ -203,3 +203,331 @@ environment = "production"
 		t.Errorf("expected Loki.Environment 'production', got %q", cfg.Loki.Environment)
 	}
 }
+
+func TestLoadConfigFromTOML_PricingSection(t *testing.T) {
+	tomlContent := `
+[pricing]
+"claude-sonnet-4*" = { input = 2.5, output = 12.0, cache_read = 0.25 }
+"my-finetune-*" = { input = 1.0, output = 4.0 }
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Pricing) != 2 {
+		t.Fatalf("expected 2 pricing entries, got %d", len(cfg.Pricing))
+	}
+	sonnet := cfg.Pricing["claude-sonnet-4*"]
+	if sonnet.Input != 2.5 || sonnet.Output != 12.0 || sonnet.CacheRead != 0.25 {
+		t.Errorf("unexpected sonnet price: %+v", sonnet)
+	}
+	if cfg.Pricing["my-finetune-*"].Output != 4.0 {
+		t.Errorf("expected my-finetune-* output 4.0, got %v", cfg.Pricing["my-finetune-*"].Output)
+	}
+}
+
+func TestLoadConfigFromTOML_BudgetsSection(t *testing.T) {
+	tomlContent := `
+[budgets]
+soft_limit_ratio = 0.9
+
+[budgets.session]
+max_tokens = 2000000
+max_turns = 200
+
+[budgets.day]
+max_cost_usd = 50.0
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.Budgets.SoftLimitRatio != 0.9 {
+		t.Errorf("expected SoftLimitRatio 0.9, got %v", cfg.Budgets.SoftLimitRatio)
+	}
+	if cfg.Budgets.Session.MaxTokens != 2000000 || cfg.Budgets.Session.MaxTurns != 200 {
+		t.Errorf("unexpected session budget: %+v", cfg.Budgets.Session)
+	}
+	if cfg.Budgets.Day.MaxCostUSD != 50.0 {
+		t.Errorf("expected day MaxCostUSD 50, got %v", cfg.Budgets.Day.MaxCostUSD)
+	}
+	if cfg.Budgets.Machine.enabled() {
+		t.Error("expected machine budget to be disabled")
+	}
+	if !cfg.Budgets.Enabled() {
+		t.Error("expected budgets to be enabled")
+	}
+	if DefaultConfig().Budgets.Enabled() {
+		t.Error("expected budgets to be disabled by default")
+	}
+}
+
+func TestLoadConfigFromTOML_RetentionSection(t *testing.T) {
+	tomlContent := `
+[retention]
+compress_after_days = 7
+max_age_days = 90
+max_total_mb = 10240
+max_session_file_mb = 64
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := cfg.Retention
+	if r.CompressAfterDays != 7 || r.MaxAgeDays != 90 || r.MaxTotalMB != 10240 || r.MaxSessionFileMB != 64 {
+		t.Errorf("unexpected retention config: %+v", r)
+	}
+	if r.Compression != "gzip" {
+		t.Errorf("expected default compression gzip, got %q", r.Compression)
+	}
+	if DefaultConfig().Retention.Enabled() {
+		t.Error("expected retention to be disabled by default")
+	}
+}
+
+func TestLoadConfigFromTOML_StorageSection(t *testing.T) {
+	cfg, err := LoadConfigFromTOML([]byte("[storage]\ndedup = true\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Storage.Dedup {
+		t.Error("expected dedup to be enabled")
+	}
+	if DefaultConfig().Storage.Dedup {
+		t.Error("expected dedup to be disabled by default")
+	}
+}
+
+func TestLoadConfigFromTOML_RedactionSection(t *testing.T) {
+	tomlContent := `
+[redaction]
+enabled = true
+detectors = ["aws_key", "private_key"]
+
+[[redaction.rules]]
+detector = "email"
+strategy = "hash"
+
+[[redaction.rules]]
+name = "image"
+path = "messages[*].content[*].source.data"
+strategy = "remove"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !cfg.Redaction.Enabled || len(cfg.Redaction.Detectors) != 2 || len(cfg.Redaction.Rules) != 2 {
+		t.Fatalf("unexpected redaction config: %+v", cfg.Redaction)
+	}
+	if cfg.Redaction.Rules[1].Path != "messages[*].content[*].source.data" || cfg.Redaction.Rules[1].Strategy != "remove" {
+		t.Errorf("unexpected path rule: %+v", cfg.Redaction.Rules[1])
+	}
+	if _, err := NewRedactor(cfg.Redaction); err != nil {
+		t.Errorf("expected example rules to compile: %v", err)
+	}
+}
+
+func TestLoadConfigFromTOML_EncryptionSection(t *testing.T) {
+	cfg, err := LoadConfigFromTOML([]byte("[encryption]\nkey_file = \"~/.llm-proxy/log.key\"\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Encryption.KeyFile != "~/.llm-proxy/log.key" {
+		t.Errorf("expected key_file to be set, got %q", cfg.Encryption.KeyFile)
+	}
+	if DefaultConfig().Encryption.KeyFile != "" {
+		t.Error("expected encryption to be disabled by default")
+	}
+}
+
+func TestLoadConfigFromTOML_AuthSection(t *testing.T) {
+	tomlContent := `
+listen = "0.0.0.0"
+
+[[auth.users]]
+name = "alice"
+token = "alice-token"
+
+[[auth.users]]
+name = "bob"
+token_sha256 = "7c9e2b1f0e5fd55e3f0e0ab3f6a1f2a8c0d4c3b2a1908f7e6d5c4b3a29180716"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Listen != "0.0.0.0" {
+		t.Errorf("expected listen 0.0.0.0, got %q", cfg.Listen)
+	}
+	if len(cfg.Auth.Users) != 2 || cfg.Auth.Users[0].Name != "alice" || cfg.Auth.Users[1].TokenSHA256 == "" {
+		t.Errorf("unexpected auth config: %+v", cfg.Auth)
+	}
+	if DefaultConfig().Listen != "localhost" || DefaultConfig().Auth.Enabled() {
+		t.Error("expected localhost without auth by default")
+	}
+}
+
+func TestLoadConfigFromTOML_TLSAndTransportSections(t *testing.T) {
+	tomlContent := `
+[tls]
+cert_file = "/etc/llm-proxy/tls.crt"
+key_file = "/etc/llm-proxy/tls.key"
+
+[transport]
+ca_file = "/etc/ssl/corp-ca.pem"
+client_cert_file = "/etc/llm-proxy/client.crt"
+client_key_file = "/etc/llm-proxy/client.key"
+proxy = "http://egress.internal:3128"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.TLS.Enabled() || cfg.TLS.KeyFile != "/etc/llm-proxy/tls.key" {
+		t.Errorf("unexpected tls config: %+v", cfg.TLS)
+	}
+	if cfg.Transport.CAFile != "/etc/ssl/corp-ca.pem" || cfg.Transport.Proxy != "http://egress.internal:3128" || cfg.Transport.ClientKeyFile == "" {
+		t.Errorf("unexpected transport config: %+v", cfg.Transport)
+	}
+	if DefaultConfig().TLS.Enabled() || DefaultConfig().Transport != (TransportConfig{}) {
+		t.Error("expected plain HTTP and the default transport by default")
+	}
+}
+
+func TestLoadConfigFromTOML_ForwardProxySection(t *testing.T) {
+	cfg, err := LoadConfigFromTOML([]byte("[forward_proxy]\nenabled = true\nca_cert_file = \"/etc/llm-proxy/ca.crt\"\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.ForwardProxy.Enabled {
+		t.Error("expected forward proxy to be enabled")
+	}
+	certPath, keyPath := cfg.ForwardProxy.CAPaths()
+	_, defaultKey := DefaultCAPaths()
+	if certPath != "/etc/llm-proxy/ca.crt" || keyPath != defaultKey {
+		t.Errorf("unexpected CA paths %s, %s", certPath, keyPath)
+	}
+	if DefaultConfig().ForwardProxy.Enabled {
+		t.Error("expected forward proxy to be disabled by default")
+	}
+}
+
+func TestLoadConfigFromTOML_PlaybackSection(t *testing.T) {
+	cfg, err := LoadConfigFromTOML([]byte("[playback]\nenabled = true\ndir = \"./testdata/recordings\"\non_miss = \"record\"\nspeed = 1.0\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Playback.Enabled || cfg.Playback.Dir != "./testdata/recordings" || cfg.Playback.OnMiss != "record" || cfg.Playback.Speed != 1 {
+		t.Errorf("unexpected playback config %+v", cfg.Playback)
+	}
+	if DefaultConfig().Playback.Enabled {
+		t.Error("expected playback to be disabled by default")
+	}
+}
+
+func TestLoadConfigFromTOML_OTLPSection(t *testing.T) {
+	tomlContent := `
+[otlp]
+enabled = true
+endpoint = "http://localhost:4318/v1/traces"
+service_name = "agents"
+batch_wait = "2s"
+
+[otlp.headers]
+Authorization = "Bearer abc"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.OTLP.Enabled || cfg.OTLP.Endpoint != "http://localhost:4318/v1/traces" || cfg.OTLP.ServiceName != "agents" || cfg.OTLP.BatchWaitStr != "2s" {
+		t.Errorf("unexpected otlp config %+v", cfg.OTLP)
+	}
+	if cfg.OTLP.Headers["Authorization"] != "Bearer abc" {
+		t.Errorf("expected otlp headers, got %v", cfg.OTLP.Headers)
+	}
+}
+
+func TestLoadConfigFromTOML_SinksSection(t *testing.T) {
+	tomlContent := `
+[[sinks]]
+name = "console"
+type = "stdout"
+types = ["session_start", "response"]
+
+[[sinks]]
+name = "audit"
+type = "jsonl"
+path = "/var/log/llm-proxy/audit.jsonl"
+policy = "primary"
+providers = ["anthropic"]
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Sinks) != 2 {
+		t.Fatalf("expected 2 sinks, got %d", len(cfg.Sinks))
+	}
+	if cfg.Sinks[0].Name != "console" || len(cfg.Sinks[0].Types) != 2 {
+		t.Errorf("unexpected first sink %+v", cfg.Sinks[0])
+	}
+	if cfg.Sinks[1].Policy != "primary" || cfg.Sinks[1].Path != "/var/log/llm-proxy/audit.jsonl" || cfg.Sinks[1].Providers[0] != "anthropic" {
+		t.Errorf("unexpected second sink %+v", cfg.Sinks[1])
+	}
+	if err := ValidateSinks(cfg.Sinks, builtinSinkTypes, false); err != nil {
+		t.Errorf("expected valid sinks, got %v", err)
+	}
+}
+
+func TestLoadConfigFromTOML_WebhookSection(t *testing.T) {
+	tomlContent := `
+[webhook]
+enabled = true
+urls = ["https://hooks.example.com/llm-proxy"]
+secret = "s3cret"
+events = ["agent_anomaly", "budget_exceeded"]
+batch_size = 10
+batch_wait = "2s"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := cfg.Webhook
+	if !w.Enabled || len(w.URLs) != 1 || w.Secret != "s3cret" || len(w.Events) != 2 || w.BatchSize != 10 || w.BatchWaitStr != "2s" {
+		t.Errorf("unexpected webhook config %+v", w)
+	}
+	if err := w.Validate(); err != nil {
+		t.Errorf("expected a valid webhook config, got %v", err)
+	}
+}
+
+func TestLoadConfigFromTOML_LokiSpool(t *testing.T) {
+	cfg := DefaultConfig()
+	if !cfg.Loki.Spool || cfg.Loki.SpoolMaxMB != 512 {
+		t.Errorf("expected the spool on with 512 MiB by default, got %v/%d", cfg.Loki.Spool, cfg.Loki.SpoolMaxMB)
+	}
+
+	tomlContent := `
+[loki]
+enabled = true
+url = "http://loki:3100/loki/api/v1/push"
+spool = false
+spool_dir = "/var/spool/llm-proxy"
+spool_max_mb = 64
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Loki.Spool || cfg.Loki.SpoolDir != "/var/spool/llm-proxy" || cfg.Loki.SpoolMaxMB != 64 {
+		t.Errorf("unexpected spool config %+v", cfg.Loki)
+	}
+}
@@ -20,6 +20,12 @@ type PatternState struct {
 	SessionToolCount int               // Total tool calls in session so far
 	LastWasError     bool              // Previous turn's tool resulted in error
 	PendingToolIDs   map[string]string // tool_use_id -> tool_name for result matching
+
+	// Anomaly detection (see anomaly.go)
+	LastToolInputHash   string    // Hash of first tool name + input from previous turn
+	IdenticalCallStreak int       // Consecutive turns whose first tool call was identical
+	BreakerReason       string    // Anomaly that tripped the circuit breaker ("" = closed)
+	BreakerTrippedAt    time.Time // When the breaker tripped, for its cooldown
 }
 
 type SessionDB struct {
@@ -53,6 +59,15 @@ func NewSessionDB(path string) (*SessionDB, error) {
 		FOREIGN KEY (session_id) REFERENCES sessions(id)
 	);
 
+	CREATE TABLE IF NOT EXISTS usage_totals (
+		scope TEXT NOT NULL,
+		scope_key TEXT NOT NULL,
+		tokens INTEGER NOT NULL DEFAULT 0,
+		turns INTEGER NOT NULL DEFAULT 0,
+		cost_usd REAL NOT NULL DEFAULT 0,
+		PRIMARY KEY (scope, scope_key)
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_fingerprints_session ON fingerprints(session_id);
 	CREATE INDEX IF NOT EXISTS idx_sessions_provider ON sessions(provider);
 	CREATE INDEX IF NOT EXISTS idx_sessions_client_id ON sessions(client_session_id);
@@ -73,6 +88,13 @@ func NewSessionDB(path string) (*SessionDB, error) {
 		"ALTER TABLE sessions ADD COLUMN session_tool_count INTEGER NOT NULL DEFAULT 0",
 		"ALTER TABLE sessions ADD COLUMN last_was_error INTEGER NOT NULL DEFAULT 0",
 		"ALTER TABLE sessions ADD COLUMN pending_tool_ids TEXT NOT NULL DEFAULT '{}'",
+		"ALTER TABLE sessions ADD COLUMN total_cost_usd REAL NOT NULL DEFAULT 0",
+		"ALTER TABLE sessions ADD COLUMN last_tool_input_hash TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions ADD COLUMN identical_call_streak INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE sessions ADD COLUMN breaker_reason TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions ADD COLUMN breaker_tripped_at INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE sessions ADD COLUMN replay_of TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions ADD COLUMN owner TEXT NOT NULL DEFAULT ''",
 	}
 
 	for _, migration := range migrations {
@@ -167,29 +189,54 @@ func (s *SessionDB) GetSession(id string) (provider, upstream, filePath string,
 	return
 }
 
-// CreateSessionWithClientID creates a new session with a client-provided session ID
-func (s *SessionDB) CreateSessionWithClientID(id, clientSessionID, provider, upstream, filePath string) error {
+// CreateSessionWithClientID creates a new session with a client-provided session ID,
+// owned by the authenticated user owner ("" with proxy auth off)
+func (s *SessionDB) CreateSessionWithClientID(id, clientSessionID, owner, provider, upstream, filePath string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (id, client_session_id, owner, provider, upstream, created_at, last_activity, file_path, last_seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)
+	`, id, clientSessionID, owner, provider, upstream, now, now, filePath)
+
+	return err
+}
+
+// CreateReplaySession creates a session recording a replay of a request
+// from the session replayOf
+func (s *SessionDB) CreateReplaySession(id, replayOf, provider, upstream, filePath string) error {
 	now := time.Now().UTC().Format(time.RFC3339)
 
 	_, err := s.db.Exec(`
-		INSERT INTO sessions (id, client_session_id, provider, upstream, created_at, last_activity, file_path, last_seq)
+		INSERT INTO sessions (id, replay_of, provider, upstream, created_at, last_activity, file_path, last_seq)
 		VALUES (?, ?, ?, ?, ?, ?, ?, 1)
-	`, id, clientSessionID, provider, upstream, now, now, filePath)
+	`, id, replayOf, provider, upstream, now, now, filePath)
 
 	return err
 }
 
-// FindByClientSessionID finds a session by its client-provided session ID
-func (s *SessionDB) FindByClientSessionID(clientSessionID string) (sessionID string, err error) {
+// GetReplayOf returns the session a replay session was replayed from, or ""
+// for a session that is not a replay
+func (s *SessionDB) GetReplayOf(id string) (replayOf string, err error) {
 	row := s.db.QueryRow(`
-		SELECT id FROM sessions WHERE client_session_id = ?
+		SELECT replay_of FROM sessions WHERE id = ?
+	`, id)
+
+	err = row.Scan(&replayOf)
+	return
+}
+
+// FindByClientSessionID finds a session and its owner by the client-provided session ID
+func (s *SessionDB) FindByClientSessionID(clientSessionID string) (sessionID, owner string, err error) {
+	row := s.db.QueryRow(`
+		SELECT id, owner FROM sessions WHERE client_session_id = ?
 	`, clientSessionID)
 
-	err = row.Scan(&sessionID)
+	err = row.Scan(&sessionID, &owner)
 	if err == sql.ErrNoRows {
-		return "", nil
+		return "", "", nil
 	}
-	return sessionID, err
+	return sessionID, owner, err
 }
 
 // GetSessionWithClientID gets a session including its client session ID and last sequence
@@ -207,16 +254,19 @@ func (s *SessionDB) GetSessionWithClientID(id string) (provider, upstream, fileP
 func (s *SessionDB) LoadPatternState(sessionID string) (*PatternState, error) {
 	row := s.db.QueryRow(`
 		SELECT turn_count, last_tool_name, tool_streak, retry_count,
-		       session_tool_count, last_was_error, pending_tool_ids
+		       session_tool_count, last_was_error, pending_tool_ids,
+		       last_tool_input_hash, identical_call_streak, breaker_reason, breaker_tripped_at
 		FROM sessions WHERE id = ?
 	`, sessionID)
 
-	var turnCount, toolStreak, retryCount, sessionToolCount int
-	var lastToolName, pendingToolIDsJSON string
+	var turnCount, toolStreak, retryCount, sessionToolCount, identicalCallStreak int
+	var lastToolName, pendingToolIDsJSON, lastToolInputHash, breakerReason string
 	var lastWasError int
+	var breakerTrippedAt int64
 
 	err := row.Scan(&turnCount, &lastToolName, &toolStreak, &retryCount,
-		&sessionToolCount, &lastWasError, &pendingToolIDsJSON)
+		&sessionToolCount, &lastWasError, &pendingToolIDsJSON,
+		&lastToolInputHash, &identicalCallStreak, &breakerReason, &breakerTrippedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -239,6 +289,11 @@ func (s *SessionDB) LoadPatternState(sessionID string) (*PatternState, error) {
 		SessionToolCount: sessionToolCount,
 		LastWasError:     lastWasError != 0,
 		PendingToolIDs:   pendingToolIDs,
+
+		LastToolInputHash:   lastToolInputHash,
+		IdenticalCallStreak: identicalCallStreak,
+		BreakerReason:       breakerReason,
+		BreakerTrippedAt:    time.Unix(0, breakerTrippedAt),
 	}, nil
 }
 
@@ -253,18 +308,79 @@ func (s *SessionDB) UpdatePatternState(sessionID string, state *PatternState) er
 	if state.LastWasError {
 		lastWasError = 1
 	}
+	var breakerTrippedAt int64
+	if !state.BreakerTrippedAt.IsZero() {
+		breakerTrippedAt = state.BreakerTrippedAt.UnixNano()
+	}
 
 	_, err = s.db.Exec(`
 		UPDATE sessions
 		SET turn_count = ?, last_tool_name = ?, tool_streak = ?, retry_count = ?,
-		    session_tool_count = ?, last_was_error = ?, pending_tool_ids = ?
+		    session_tool_count = ?, last_was_error = ?, pending_tool_ids = ?,
+		    last_tool_input_hash = ?, identical_call_streak = ?, breaker_reason = ?,
+		    breaker_tripped_at = ?
 		WHERE id = ?
 	`, state.TurnCount, state.LastToolName, state.ToolStreak, state.RetryCount,
-		state.SessionToolCount, lastWasError, string(pendingToolIDsJSON), sessionID)
+		state.SessionToolCount, lastWasError, string(pendingToolIDsJSON),
+		state.LastToolInputHash, state.IdenticalCallStreak, state.BreakerReason,
+		breakerTrippedAt, sessionID)
 
 	return err
 }
 
+// AddSessionCost adds cost (USD) to a session's running total.
+func (s *SessionDB) AddSessionCost(sessionID string, cost float64) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET total_cost_usd = total_cost_usd + ? WHERE id = ?
+	`, cost, sessionID)
+	return err
+}
+
+// GetSessionCost returns a session's running cost total in USD.
+// Returns 0 with no error if session doesn't exist.
+func (s *SessionDB) GetSessionCost(sessionID string) (float64, error) {
+	var total float64
+	err := s.db.QueryRow(`SELECT total_cost_usd FROM sessions WHERE id = ?`, sessionID).Scan(&total)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return total, err
+}
+
+// UsageTotals holds accumulated usage for one budget scope key.
+type UsageTotals struct {
+	Tokens  int
+	Turns   int
+	CostUSD float64
+}
+
+// AddUsage adds one turn's usage to the running totals for a budget scope
+// (e.g. scope "day", key "2026-01-15").
+func (s *SessionDB) AddUsage(scope, key string, tokens int, cost float64) error {
+	_, err := s.db.Exec(`
+		INSERT INTO usage_totals (scope, scope_key, tokens, turns, cost_usd)
+		VALUES (?, ?, ?, 1, ?)
+		ON CONFLICT(scope, scope_key) DO UPDATE SET
+			tokens = tokens + excluded.tokens,
+			turns = turns + 1,
+			cost_usd = cost_usd + excluded.cost_usd
+	`, scope, key, tokens, cost)
+	return err
+}
+
+// GetUsage returns the running totals for a budget scope key.
+// Returns zero totals with no error if nothing has been recorded.
+func (s *SessionDB) GetUsage(scope, key string) (UsageTotals, error) {
+	var totals UsageTotals
+	err := s.db.QueryRow(`
+		SELECT tokens, turns, cost_usd FROM usage_totals WHERE scope = ? AND scope_key = ?
+	`, scope, key).Scan(&totals.Tokens, &totals.Turns, &totals.CostUSD)
+	if err == sql.ErrNoRows {
+		return UsageTotals{}, nil
+	}
+	return totals, err
+}
+
 // ClearMatchedToolID removes a tool ID from pending_tool_ids and returns the tool name.
 // Returns empty string if the tool ID was not found.
 func (s *SessionDB) ClearMatchedToolID(sessionID, toolUseID string) (string, error) {
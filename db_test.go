@@ -424,3 +424,36 @@ func TestDBMigrationOnExistingDB(t *testing.T) {
 		}
 	}
 }
+
+func TestDBSessionCostAccumulates(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "sessions.db")
+
+	db, err := NewSessionDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+
+	db.CreateSession("session-1", "anthropic", "api.anthropic.com", "session-1.jsonl")
+
+	if err := db.AddSessionCost("session-1", 0.25); err != nil {
+		t.Fatalf("AddSessionCost failed: %v", err)
+	}
+	if err := db.AddSessionCost("session-1", 0.5); err != nil {
+		t.Fatalf("AddSessionCost failed: %v", err)
+	}
+
+	total, err := db.GetSessionCost("session-1")
+	if err != nil {
+		t.Fatalf("GetSessionCost failed: %v", err)
+	}
+	if total != 0.75 {
+		t.Errorf("expected total 0.75, got %v", total)
+	}
+
+	total, err = db.GetSessionCost("nonexistent")
+	if err != nil || total != 0 {
+		t.Errorf("expected 0 for nonexistent session, got %v (err=%v)", total, err)
+	}
+}
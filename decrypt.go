@@ -0,0 +1,77 @@
+// decrypt.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// DecryptOptions configures `llm-proxy decrypt`.
+type DecryptOptions struct {
+	ConfigPath string
+	KeyFile    string
+	Expand     bool // Reassemble deduplicated request bodies
+	Paths      []string
+}
+
+// ParseDecryptFlags parses arguments for the decrypt subcommand.
+func ParseDecryptFlags(args []string) (DecryptOptions, error) {
+	fs := flag.NewFlagSet("llm-proxy decrypt", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: llm-proxy decrypt [flags] <session.jsonl>...\n\nPrints session logs as plaintext JSONL.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var opts DecryptOptions
+	fs.StringVar(&opts.ConfigPath, "config", "", "Path to config file (for [encryption] key_file)")
+	fs.StringVar(&opts.KeyFile, "key-file", "", "File holding the encryption key (overrides the config; $"+EncryptionKeyEnv+" wins over both)")
+	fs.BoolVar(&opts.Expand, "expand", false, "Reassemble deduplicated request bodies from the blob store")
+
+	if err := fs.Parse(args); err != nil {
+		return DecryptOptions{}, err
+	}
+	opts.Paths = fs.Args()
+	if len(opts.Paths) == 0 {
+		fs.Usage()
+		return DecryptOptions{}, fmt.Errorf("no log files given")
+	}
+	return opts, nil
+}
+
+// RunDecrypt implements `llm-proxy decrypt`.
+func RunDecrypt(args []string, out io.Writer) error {
+	opts, err := ParseDecryptFlags(args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if opts.KeyFile != "" {
+		cfg.Encryption.KeyFile = opts.KeyFile
+	}
+	c, err := LoadLogCipher(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range opts.Paths {
+		read := readLogLines
+		if opts.Expand {
+			read = readSessionLines
+		}
+		lines, err := read(path, c)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(out, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// encrypt.go
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// With encryption at rest, every JSONL line (and every dedup blob) is sealed
+// on its own with AES-256-GCM under a random nonce, so the logger can keep
+// appending without re-encrypting anything. A sealed line is
+// "enc1:" + base64(nonce || ciphertext). Readers pass plaintext lines through,
+// so logs written before encryption was turned on stay readable.
+
+const sealedLinePrefix = "enc1:"
+
+// EncryptionKeyEnv holds a base64 (or hex) encoded 32-byte key. It takes
+// precedence over [encryption] key_file.
+const EncryptionKeyEnv = "LLM_PROXY_ENCRYPTION_KEY"
+
+// ErrEncryptedLog is returned when reading a sealed line without a key.
+var ErrEncryptedLog = errors.New("log is encrypted; configure an encryption key")
+
+// EncryptionConfig configures encryption at rest.
+type EncryptionConfig struct {
+	KeyFile string `toml:"key_file"` // File holding a base64 (or hex) encoded 32-byte key
+}
+
+// LogCipher seals and opens log lines. A nil *LogCipher leaves data in plaintext.
+type LogCipher struct {
+	aead    cipher.AEAD
+	nameKey []byte // HMAC key for blob names, derived from the log key
+}
+
+// NewLogCipher creates a cipher from a 32-byte key.
+func NewLogCipher(key []byte) (*LogCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("llm-proxy blob names"))
+	return &LogCipher{aead: aead, nameKey: mac.Sum(nil)}, nil
+}
+
+// LoadLogCipher returns the cipher for the key in $LLM_PROXY_ENCRYPTION_KEY or
+// cfg.KeyFile, or nil if neither is set.
+func LoadLogCipher(cfg EncryptionConfig) (*LogCipher, error) {
+	encoded := os.Getenv(EncryptionKeyEnv)
+	if encoded == "" && cfg.KeyFile != "" {
+		data, err := os.ReadFile(expandHome(cfg.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("reading encryption key: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := decodeEncryptionKey(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return NewLogCipher(key)
+}
+
+// decodeEncryptionKey accepts base64 (as from `openssl rand -base64 32`) or hex.
+func decodeEncryptionKey(s string) ([]byte, error) {
+	if len(s) == 64 {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be base64 or hex encoded")
+	}
+	return key, nil
+}
+
+// expandHome replaces a leading ~/ with the user's home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
+
+// Seal encrypts one line (without its trailing newline).
+func (c *LogCipher) Seal(plaintext []byte) []byte {
+	if c == nil {
+		return plaintext
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic("encrypt: reading random nonce: " + err.Error())
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, nil)
+	return []byte(sealedLinePrefix + base64.StdEncoding.EncodeToString(sealed))
+}
+
+// BlobName names a dedup blob by its content: the hex sha256 in plaintext,
+// or an HMAC keyed from the log key when encrypted, so file names don't
+// reveal whether a known prompt was sent.
+func (c *LogCipher) BlobName(data []byte) string {
+	if c == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, c.nameKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Open decrypts a sealed line. Plaintext lines are returned as is.
+func (c *LogCipher) Open(line []byte) ([]byte, error) {
+	if !isSealed(line) {
+		return line, nil
+	}
+	if c == nil {
+		return nil, ErrEncryptedLog
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(line[len(sealedLinePrefix):]))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting log line: %w", err)
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("decrypting log line: too short")
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting log line: wrong key or corrupted data")
+	}
+	return plaintext, nil
+}
+
+func isSealed(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(sealedLinePrefix))
+}
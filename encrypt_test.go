@@ -0,0 +1,200 @@
+// encrypt_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLogCipher(t *testing.T, seed byte) *LogCipher {
+	t.Helper()
+	c, err := NewLogCipher(bytes.Repeat([]byte{seed}, 32))
+	if err != nil {
+		t.Fatalf("NewLogCipher failed: %v", err)
+	}
+	return c
+}
+
+func TestLogCipherRoundTrip(t *testing.T) {
+	c := testLogCipher(t, 1)
+	line := []byte(`{"type":"request","body":"func main() {}"}`)
+
+	sealed := c.Seal(line)
+	if !strings.HasPrefix(string(sealed), sealedLinePrefix) || bytes.Contains(sealed, []byte("func main")) {
+		t.Fatalf("Expected sealed line, got %s", sealed)
+	}
+	if bytes.Equal(sealed, c.Seal(line)) {
+		t.Error("Expected a fresh nonce per line")
+	}
+
+	opened, err := c.Open(sealed)
+	if err != nil || !bytes.Equal(opened, line) {
+		t.Errorf("Open = %s, %v; want %s", opened, err, line)
+	}
+
+	if _, err := testLogCipher(t, 2).Open(sealed); err == nil {
+		t.Error("Expected the wrong key to fail")
+	}
+
+	var noKey *LogCipher
+	if _, err := noKey.Open(sealed); !errors.Is(err, ErrEncryptedLog) {
+		t.Errorf("Expected ErrEncryptedLog without a key, got %v", err)
+	}
+	if out, err := c.Open(line); err != nil || !bytes.Equal(out, line) {
+		t.Error("Expected plaintext lines to pass through")
+	}
+	if out := noKey.Seal(line); !bytes.Equal(out, line) {
+		t.Error("Expected nil cipher to leave data in plaintext")
+	}
+}
+
+func TestLogCipherBlobName(t *testing.T) {
+	data := []byte("You are a careful coding agent.")
+	a, b := testLogCipher(t, 1), testLogCipher(t, 2)
+
+	if a.BlobName(data) != a.BlobName(data) {
+		t.Error("Expected blob names to be stable for one key")
+	}
+	if a.BlobName(data) == b.BlobName(data) {
+		t.Error("Expected blob names to depend on the key")
+	}
+	var plain *LogCipher
+	if got := plain.BlobName(data); got != sha256Hex(data) || got == a.BlobName(data) {
+		t.Errorf("Expected the plaintext sha256 without a key, got %s", got)
+	}
+}
+
+func TestLoadLogCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	keyFile := filepath.Join(t.TempDir(), "log.key")
+	os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+
+	t.Setenv(EncryptionKeyEnv, "")
+	if c, err := LoadLogCipher(EncryptionConfig{}); c != nil || err != nil {
+		t.Errorf("Expected no cipher without a key, got %v, %v", c, err)
+	}
+
+	fromFile, err := LoadLogCipher(EncryptionConfig{KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Loading key file failed: %v", err)
+	}
+
+	// The env var wins over the key file; hex is accepted too
+	t.Setenv(EncryptionKeyEnv, strings.Repeat("07", 32))
+	fromEnv, err := LoadLogCipher(EncryptionConfig{KeyFile: "/nonexistent"})
+	if err != nil {
+		t.Fatalf("Loading env key failed: %v", err)
+	}
+	if _, err := fromEnv.Open(fromFile.Seal([]byte("x"))); err != nil {
+		t.Error("Expected env and file keys to match")
+	}
+
+	t.Setenv(EncryptionKeyEnv, base64.StdEncoding.EncodeToString([]byte("too short")))
+	if _, err := LoadLogCipher(EncryptionConfig{}); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+}
+
+func TestLoggerEncryptsAtRest(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := testLogCipher(t, 3)
+	logger, _ := NewLogger(tmpDir)
+	logger.SetCipher(c)
+	logger.SetDedup(true)
+
+	sessionID := "20260320-100000-sealed"
+	body := dedupTestBody(2)
+	logger.LogSessionStart(sessionID, "anthropic", "api.anthropic.com")
+	logger.LogRequest(sessionID, "anthropic", 1, "POST", "/v1/messages", nil, []byte(body), "req-1")
+	logger.Close()
+
+	dayDir := filepath.Join(tmpDir, "api.anthropic.com", time.Now().Format("2006-01-02"))
+	logPath := filepath.Join(dayDir, sessionID+".jsonl")
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected 0600 log file, got %v", info.Mode().Perm())
+	}
+
+	data, _ := os.ReadFile(logPath)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, sealedLinePrefix) {
+			t.Errorf("Expected every line sealed, got %q", line)
+		}
+	}
+	blobs, _ := filepath.Glob(filepath.Join(dayDir, blobDirName, "*.json"))
+	if len(blobs) == 0 {
+		t.Fatal("Expected dedup blobs")
+	}
+	// Blob names must not be the plaintext sha256 of a known prompt
+	_, plainBlobs := splitBody([]byte(body), nil)
+	for hash := range plainBlobs {
+		if _, err := os.Stat(blobPath(dayDir, hash)); err == nil {
+			t.Errorf("Expected encrypted blobs not to be named by their sha256, found %s", hash)
+		}
+	}
+	for _, path := range append(blobs, logPath) {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), "careful coding agent") || strings.Contains(string(data), sessionID) {
+			t.Errorf("Expected no plaintext in %s", path)
+		}
+	}
+
+	explorer := NewExplorer(tmpDir)
+	if _, err := explorer.parseSessionFile(logPath); !errors.Is(err, ErrEncryptedLog) {
+		t.Errorf("Expected ErrEncryptedLog without a key, got %v", err)
+	}
+	explorer.SetCipher(c)
+	entries, err := explorer.parseSessionFile(logPath)
+	if err != nil {
+		t.Fatalf("parseSessionFile failed: %v", err)
+	}
+	if len(entries) != 2 || entries[1].Body != body {
+		t.Errorf("Expected explorer to decrypt the session, got %+v", entries)
+	}
+
+	sessions := explorer.listSessions()
+	if len(sessions) != 1 || sessions[0].MessageCount != 1 {
+		t.Errorf("Expected session metadata to be decrypted, got %+v", sessions)
+	}
+}
+
+func TestRunDecrypt(t *testing.T) {
+	tmpDir := t.TempDir()
+	key := bytes.Repeat([]byte{4}, 32)
+	keyFile := filepath.Join(tmpDir, "log.key")
+	os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)), 0600)
+	c, _ := NewLogCipher(key)
+
+	logPath := filepath.Join(tmpDir, "session.jsonl")
+	lines := []string{`{"type":"session_start"}`, `{"type":"request","body":"secret source"}`}
+	var data []byte
+	for _, line := range lines {
+		data = append(append(data, c.Seal([]byte(line))...), '\n')
+	}
+	os.WriteFile(logPath, data, 0600)
+
+	t.Setenv(EncryptionKeyEnv, "")
+	var out bytes.Buffer
+	if err := RunDecrypt([]string{"--key-file", keyFile, logPath}, &out); err != nil {
+		t.Fatalf("RunDecrypt failed: %v", err)
+	}
+	if out.String() != strings.Join(lines, "\n")+"\n" {
+		t.Errorf("Unexpected plaintext:\n%s", out.String())
+	}
+
+	if err := RunDecrypt([]string{logPath}, &out); !errors.Is(err, ErrEncryptedLog) {
+		t.Errorf("Expected ErrEncryptedLog without a key, got %v", err)
+	}
+	if err := RunDecrypt([]string{"--key-file", keyFile}, &out); err == nil {
+		t.Error("Expected an error without log files")
+	}
+}
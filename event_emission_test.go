@@ -16,6 +16,7 @@ type MockEventEmitter struct {
 	TurnEndEvents    []MockTurnEndEvent
 	ToolCallEvents   []MockToolCallEvent
 	ToolResultEvents []MockToolResultEvent
+	AnomalyEvents    []AnomalyData
 }
 
 type MockTurnStartEvent struct {
@@ -89,6 +90,10 @@ func (m *MockEventEmitter) EmitToolCall(sessionID, provider, machine, toolName s
 	})
 }
 
+func (m *MockEventEmitter) EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData) {
+	m.AnomalyEvents = append(m.AnomalyEvents, anomaly)
+}
+
 func (m *MockEventEmitter) EmitToolResult(sessionID, provider, machine, toolName, toolUseID string, isError bool) {
 	m.ToolResultEvents = append(m.ToolResultEvents, MockToolResultEvent{
 		SessionID: sessionID,
@@ -885,6 +890,165 @@ func TestStreamingEventEmission(t *testing.T) {
 	}
 }
 
+// TestStreamingEventEmission_OpenAIChatCompletions tests that tool calls and usage
+// from an OpenAI Chat Completions stream reach tool_call and turn_end events
+func TestStreamingEventEmission_OpenAIChatCompletions(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+
+		chunks := []string{
+			`data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read_file","arguments":"{\"path\":\"/tmp/x\"}"}}]}}]}` + "\n\n",
+			`data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}` + "\n\n",
+			`data: {"object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":40,"completion_tokens":12}}` + "\n\n",
+			"data: [DONE]\n\n",
+		}
+
+		flusher, _ := w.(http.Flusher)
+		for _, chunk := range chunks {
+			w.Write([]byte(chunk))
+			flusher.Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	body := `{
+		"model": "gpt-4o",
+		"messages": [{"role": "user", "content": "Read a file"}],
+		"stream": true,
+		"stream_options": {"include_usage": true},
+		"metadata": {"session_id": "openai-streaming-session"}
+	}`
+
+	req := httptest.NewRequest("POST", "/openai/"+upstreamHost+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if len(emitter.ToolCallEvents) != 1 {
+		t.Fatalf("Expected 1 tool_call event, got %d", len(emitter.ToolCallEvents))
+	}
+	if emitter.ToolCallEvents[0].ToolName != "read_file" || emitter.ToolCallEvents[0].ToolUseID != "call_1" {
+		t.Errorf("Unexpected tool_call event: %+v", emitter.ToolCallEvents[0])
+	}
+
+	if len(emitter.TurnEndEvents) != 1 {
+		t.Fatalf("Expected 1 turn_end event, got %d", len(emitter.TurnEndEvents))
+	}
+	turnEnd := emitter.TurnEndEvents[0]
+	if turnEnd.StopReason != "tool_calls" {
+		t.Errorf("Expected stop_reason='tool_calls', got %q", turnEnd.StopReason)
+	}
+	if turnEnd.Tokens.InputTokens != 40 || turnEnd.Tokens.OutputTokens != 12 {
+		t.Errorf("Unexpected tokens: %+v", turnEnd.Tokens)
+	}
+}
+
+// TestEventEmission_OpenAIResponsesNonStreaming tests that function calls in a
+// non-streaming Responses API body produce tool_call and turn_end events
+func TestEventEmission_OpenAIResponsesNonStreaming(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"object": "response",
+			"status": "completed",
+			"output": [{"type": "function_call", "call_id": "call_42", "name": "apply_patch", "arguments": "{}"}],
+			"usage": {"input_tokens": 70, "output_tokens": 9, "input_tokens_details": {"cached_tokens": 60}}
+		}`))
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	body := `{"model": "gpt-5", "input": [{"role": "user", "content": "patch it"}]}`
+	req := httptest.NewRequest("POST", "/openai/"+upstreamHost+"/v1/responses", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "apply_patch" {
+		t.Fatalf("Expected 1 apply_patch tool_call event, got %+v", emitter.ToolCallEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 {
+		t.Fatalf("Expected 1 turn_end event, got %d", len(emitter.TurnEndEvents))
+	}
+	tokens := emitter.TurnEndEvents[0].Tokens
+	if tokens.InputTokens != 10 || tokens.CacheReadInputTokens != 60 || tokens.OutputTokens != 9 {
+		t.Errorf("Unexpected tokens: %+v", tokens)
+	}
+}
+
+// TestEventEmission_OpenAIToolResults tests that OpenAI tool messages are matched
+// to the tool calls from the previous turn and emitted as tool_result events
+func TestEventEmission_OpenAIToolResults(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"object": "chat.completion",
+			"choices": [{"message": {"role": "assistant", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "read_file", "arguments": "{}"}}
+			]}, "finish_reason": "tool_calls"}]
+		}`))
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	body1 := `{"model": "gpt-4o", "metadata": {"session_id": "openai-tools"}, "messages": [{"role": "user", "content": "read"}]}`
+	req1 := httptest.NewRequest("POST", "/openai/"+upstreamHost+"/v1/chat/completions", strings.NewReader(body1))
+	proxy.ServeHTTP(httptest.NewRecorder(), req1)
+
+	body2 := `{"model": "gpt-4o", "metadata": {"session_id": "openai-tools"}, "messages": [
+		{"role": "user", "content": "read"},
+		{"role": "assistant", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "read_file", "arguments": "{}"}}]},
+		{"role": "tool", "tool_call_id": "call_1", "content": "contents"}
+	]}`
+	req2 := httptest.NewRequest("POST", "/openai/"+upstreamHost+"/v1/chat/completions", strings.NewReader(body2))
+	proxy.ServeHTTP(httptest.NewRecorder(), req2)
+
+	if len(emitter.ToolResultEvents) != 1 {
+		t.Fatalf("Expected 1 tool_result event, got %d", len(emitter.ToolResultEvents))
+	}
+	result := emitter.ToolResultEvents[0]
+	if result.ToolUseID != "call_1" || result.ToolName != "read_file" {
+		t.Errorf("Expected tool_result for read_file/call_1, got %+v", result)
+	}
+}
+
 // TestCacheTokensInEvents tests that cache tokens are passed through to events
 func TestCacheTokensInEvents(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -1433,3 +1597,65 @@ func TestNoToolsResetsStreak(t *testing.T) {
 		}
 	}
 }
+
+func TestEventEmissionTurnEndCost(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content": []map[string]interface{}{
+				{"type": "text", "text": "Hello!"},
+			},
+			"usage": map[string]interface{}{
+				"input_tokens":  1000,
+				"output_tokens": 100,
+			},
+			"stop_reason": "end_turn",
+		})
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	proxy.pricing = NewPriceTable(map[string]ModelPrice{
+		"test-model": {Input: 2, Output: 10},
+	})
+
+	body := `{
+		"model": "test-model",
+		"messages": [{"role": "user", "content": "Hello"}],
+		"metadata": {"user_id": "user_abc_account_def_session_cost-session-1"}
+	}`
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(emitter.TurnEndEvents) != 2 {
+		t.Fatalf("Expected 2 turn_end events, got %d", len(emitter.TurnEndEvents))
+	}
+
+	// 1000*2 + 100*10 = 3000 per million
+	cost := emitter.TurnEndEvents[0].Tokens.CostUSD
+	if cost == nil || *cost != 0.003 {
+		t.Fatalf("Expected cost_usd=0.003, got %v", cost)
+	}
+
+	total, err := sm.GetSessionCost(emitter.TurnEndEvents[1].SessionID)
+	if err != nil {
+		t.Fatalf("GetSessionCost failed: %v", err)
+	}
+	if total != 0.006 {
+		t.Errorf("Expected session total 0.006, got %v", total)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
-	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -23,6 +22,7 @@ type Explorer struct {
 	logDir    string
 	templates *template.Template
 	mux       *http.ServeMux
+	cipher    *LogCipher // Decrypts encrypted logs; nil for plaintext only
 }
 
 type SessionInfo struct {
@@ -30,6 +30,7 @@ type SessionInfo struct {
 	Host         string
 	Date         string
 	Path         string
+	Paths        []string // All parts, in order (Path is the first)
 	ModTime      time.Time
 	MessageCount int
 	TimeRange    string
@@ -45,7 +46,9 @@ type LogEntry struct {
 	Status  int
 	Meta    EntryMeta
 	Chunks  []StreamChunk
-	Raw     string // Original JSON line
+	Path    string   // Request path (request entries only)
+	CostUSD *float64 // Logged cost (response entries only; nil if unpriced)
+	Raw     string   // Original JSON line
 }
 
 type EntryMeta struct {
@@ -100,6 +103,11 @@ func NewExplorer(logDir string) *Explorer {
 	return e
 }
 
+// SetCipher lets the explorer read logs written with encryption at rest.
+func (e *Explorer) SetCipher(c *LogCipher) {
+	e.cipher = c
+}
+
 func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	e.mux.ServeHTTP(w, r)
 }
@@ -150,24 +158,15 @@ func (e *Explorer) handleHome(w http.ResponseWriter, r *http.Request) {
 func (e *Explorer) listSessions() []SessionInfo {
 	var sessions []SessionInfo
 
-	// Walk: logDir/<host>/<date>/<session>.jsonl
-	filepath.Walk(e.logDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".jsonl") {
-			return nil
-		}
-
-		rel, _ := filepath.Rel(e.logDir, path)
-		parts := strings.Split(rel, string(filepath.Separator))
-		if len(parts) != 3 {
-			return nil
-		}
-
+	// Walk: logDir/<host>/<date>/<session>[.partN].jsonl[.gz]
+	walkSessionLogs(e.logDir, func(l sessionLog) error {
 		session := SessionInfo{
-			ID:      strings.TrimSuffix(parts[2], ".jsonl"),
-			Host:    parts[0],
-			Date:    parts[1],
-			Path:    path,
-			ModTime: info.ModTime(),
+			ID:      l.SessionID,
+			Host:    l.Host,
+			Date:    l.Date,
+			Path:    l.Paths[0],
+			Paths:   l.Paths,
+			ModTime: l.ModTime,
 		}
 		e.parseSessionMetadata(&session)
 		sessions = append(sessions, session)
@@ -186,12 +185,23 @@ func (e *Explorer) listSessions() []SessionInfo {
 }
 
 func (e *Explorer) parseSessionMetadata(session *SessionInfo) {
-	data, err := os.ReadFile(session.Path)
-	if err != nil {
+	paths := session.Paths
+	if len(paths) == 0 {
+		paths = []string{session.Path}
+	}
+
+	var lines []string
+	for _, path := range paths {
+		partLines, err := readLogLines(path, e.cipher)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, partLines...)
+	}
+	if len(lines) == 0 {
 		return
 	}
 
-	lines := strings.Split(string(data), "\n")
 	var firstTs, lastTs time.Time
 	msgCount := 0
 
@@ -243,14 +253,14 @@ func (e *Explorer) handleSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find the session file
-	sessionPath := e.findSessionFile(sessionID)
-	if sessionPath == "" {
+	// Find the session's files
+	sessionPaths := e.findSessionFiles(sessionID)
+	if len(sessionPaths) == 0 {
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
 
-	entries, err := e.parseSessionFile(sessionPath)
+	entries, err := e.parseSessionFiles(sessionPaths)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -275,29 +285,49 @@ func (e *Explorer) handleSession(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (e *Explorer) findSessionFile(sessionID string) string {
-	var found string
-	filepath.Walk(e.logDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil
-		}
-		if strings.HasSuffix(info.Name(), sessionID+".jsonl") {
-			found = path
-			return filepath.SkipAll
+// findSessionFiles returns every file of a session: split parts, compressed
+// or not, across all the date directories it was written to, oldest first.
+func (e *Explorer) findSessionFiles(sessionID string) []string {
+	var found []sessionLog
+	walkSessionLogs(e.logDir, func(l sessionLog) error {
+		if l.SessionID == sessionID {
+			found = append(found, l)
 		}
 		return nil
 	})
-	return found
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Date < found[j].Date
+	})
+
+	var paths []string
+	for _, l := range found {
+		paths = append(paths, l.Paths...)
+	}
+	return paths
+}
+
+// parseSessionFiles parses a session's files in order as one entry list.
+func (e *Explorer) parseSessionFiles(paths []string) ([]LogEntry, error) {
+	var entries []LogEntry
+	for _, path := range paths {
+		partEntries, err := e.parseSessionFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, partEntries...)
+	}
+	return entries, nil
 }
 
 func (e *Explorer) parseSessionFile(path string) ([]LogEntry, error) {
-	data, err := os.ReadFile(path)
+	lines, err := readSessionLines(path, e.cipher)
 	if err != nil {
 		return nil, err
 	}
 
 	var entries []LogEntry
-	for _, line := range strings.Split(string(data), "\n") {
+	for _, line := range lines {
 		if line == "" {
 			continue
 		}
@@ -323,6 +353,12 @@ func (e *Explorer) parseSessionFile(path string) ([]LogEntry, error) {
 		if s, ok := raw["status"].(float64); ok {
 			entry.Status = int(s)
 		}
+		if p, ok := raw["path"].(string); ok {
+			entry.Path = p
+		}
+		if c, ok := raw["cost_usd"].(float64); ok {
+			entry.CostUSD = &c
+		}
 
 		// Parse streaming chunks
 		if chunks, ok := raw["chunks"].([]interface{}); ok {
@@ -404,10 +440,11 @@ func (e *Explorer) groupAndParseTurns(entries []LogEntry, host string) []ParsedT
 		if entry.Type == "request" {
 			reqParsed := ParseRequestBody(entry.Body, host)
 
-			// Extract the last user message (the new content for this turn)
+			// Extract the last user message (the new content for this turn).
+			// OpenAI sends tool results as separate "tool" role messages.
 			var lastUserMsg *ParsedMessage
 			for j := len(reqParsed.Messages) - 1; j >= 0; j-- {
-				if reqParsed.Messages[j].Role == "user" {
+				if role := reqParsed.Messages[j].Role; role == "user" || role == "tool" {
 					lastUserMsg = &reqParsed.Messages[j]
 					break
 				}
@@ -449,7 +486,11 @@ func (e *Explorer) groupAndParseTurns(entries []LogEntry, host string) []ParsedT
 				matchedTurn.Response = entry
 				// Use streaming parser if we have chunks, otherwise parse body
 				if len(entry.Chunks) > 0 {
-					matchedTurn.RespParsed = ParseStreamingResponse(entry.Chunks)
+					if p := providerForStream(entry.Chunks, host); p != nil {
+						matchedTurn.RespParsed = p.ParseStream(entry.Chunks)
+					} else {
+						matchedTurn.RespParsed = ParseStreamingResponse(entry.Chunks)
+					}
 				} else {
 					matchedTurn.RespParsed = ParseResponseBody(entry.Body, host)
 				}
@@ -510,31 +551,25 @@ func (e *Explorer) search(query string, limit int) []SearchResult {
 	var results []SearchResult
 	queryLower := strings.ToLower(query)
 
-	filepath.Walk(e.logDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".jsonl") {
-			return nil
-		}
-
+	walkSessionLogs(e.logDir, func(l sessionLog) error {
 		if len(results) >= limit {
 			return filepath.SkipAll
 		}
 
-		rel, _ := filepath.Rel(e.logDir, path)
-		parts := strings.Split(rel, string(filepath.Separator))
-		if len(parts) != 3 {
-			return nil
-		}
-
-		host := parts[0]
-		date := parts[1]
-		sessionID := strings.TrimSuffix(parts[2], ".jsonl")
+		host := l.Host
+		date := l.Date
+		sessionID := l.SessionID
 
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil
+		// Line numbers run on across a split session's parts
+		var lines []string
+		for _, path := range l.Paths {
+			partLines, err := readSessionLines(path, e.cipher)
+			if err != nil {
+				continue
+			}
+			lines = append(lines, partLines...)
 		}
 
-		lines := strings.Split(string(data), "\n")
 		for i, line := range lines {
 			if strings.Contains(strings.ToLower(line), queryLower) {
 				matchStart := strings.Index(strings.ToLower(line), queryLower)
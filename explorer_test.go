@@ -435,3 +435,51 @@ func TestHomeFiltersByHost(t *testing.T) {
 		t.Error("Expected filtered results to exclude openai session")
 	}
 }
+
+func TestExplorerReadsCompressedAndSplitSessions(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sessionDir := filepath.Join(tmpDir, "api.anthropic.com", "2026-01-14")
+	os.MkdirAll(sessionDir, 0755)
+
+	part1 := `{"type":"session_start","_meta":{"ts":"2026-01-14T10:00:00Z","host":"api.anthropic.com","session":"split-session"}}
+{"type":"request","seq":1,"body":"{\"messages\":[{\"role\":\"user\",\"content\":\"First question about zebras\"}]}","_meta":{"ts":"2026-01-14T10:00:01Z"}}
+`
+	part2 := `{"type":"request","seq":2,"body":"{\"messages\":[{\"role\":\"user\",\"content\":\"Second question about giraffes\"}]}","_meta":{"ts":"2026-01-14T10:05:00Z"}}
+`
+	os.WriteFile(filepath.Join(sessionDir, "split-session.jsonl"), []byte(part1), 0644)
+	os.WriteFile(filepath.Join(sessionDir, "split-session.part2.jsonl"), []byte(part2), 0644)
+	if _, err := gzipFile(filepath.Join(sessionDir, "split-session.jsonl"), filepath.Join(sessionDir, "split-session.jsonl.gz")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(sessionDir, "split-session.jsonl"))
+
+	explorer := NewExplorer(tmpDir)
+
+	sessions := explorer.listSessions()
+	if len(sessions) != 1 {
+		t.Fatalf("Expected parts listed as one session, got %d", len(sessions))
+	}
+	if sessions[0].ID != "split-session" || sessions[0].MessageCount != 2 {
+		t.Errorf("Expected split-session with 2 messages, got %+v", sessions[0])
+	}
+
+	req := httptest.NewRequest("GET", "/session/split-session", nil)
+	w := httptest.NewRecorder()
+	explorer.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "zebras") || !strings.Contains(body, "giraffes") {
+		t.Error("Expected session detail to include both parts")
+	}
+
+	results := explorer.search("zebras", 10)
+	if len(results) != 1 || results[0].SessionID != "split-session" || results[0].LineNumber != 2 {
+		t.Errorf("Expected search to match inside the compressed part, got %+v", results)
+	}
+	results = explorer.search("giraffes", 10)
+	if len(results) != 1 || results[0].LineNumber != 3 {
+		t.Errorf("Expected line numbers to continue across parts, got %+v", results)
+	}
+}
@@ -166,29 +166,14 @@ func ComputePriorFingerprint(body []byte, provider string) (string, error) {
 //  6. X-Client-Request-Id header
 //  7. user field
 //
+// OpenAI-compatible providers use the same order without the thread ID.
 // Returns empty string if no session ID is found.
 func ExtractClientSessionID(body []byte, provider string, headers http.Header, path string) string {
-	if provider == "openai" {
-		// Check URL path first for thread ID (highest priority)
-		if threadID := ExtractThreadIDFromPath(path); threadID != "" {
-			return threadID
-		}
-	}
-
-	var request map[string]interface{}
-	if err := json.Unmarshal(body, &request); err != nil {
+	p := LookupProvider(provider)
+	if p == nil {
 		return ""
 	}
-
-	if provider == "anthropic" {
-		return extractAnthropicSessionID(request)
-	}
-
-	if provider == "openai" {
-		return extractOpenAISessionID(request, headers)
-	}
-
-	return ""
+	return p.ClientSessionID(body, headers, path)
 }
 
 // extractAnthropicSessionID extracts session ID from Anthropic's metadata.user_id
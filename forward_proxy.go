@@ -0,0 +1,268 @@
+// forward_proxy.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Forward-proxy mode serves clients that only honour HTTPS_PROXY. CONNECT
+// tunnels to hosts in the upstream allowlist are intercepted: the proxy
+// terminates TLS with a certificate minted by its local CA and feeds each
+// request through the same routing and logging path as /{provider}/{upstream}
+// requests. Tunnels to any other host (and to Bedrock hosts) are relayed
+// opaquely, but only to port 443 on public addresses: the proxy must not
+// become a way into loopback or private networks.
+
+const forwardProxyDialTimeout = 10 * time.Second
+
+// ForwardProxyConfig configures CONNECT forward-proxy mode.
+type ForwardProxyConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	CACertFile string `toml:"ca_cert_file"` // Default ~/.config/llm-proxy/ca.crt (created if missing)
+	CAKeyFile  string `toml:"ca_key_file"`  // Default ~/.config/llm-proxy/ca.key
+}
+
+// CAPaths returns the configured CA files, or the defaults.
+func (c ForwardProxyConfig) CAPaths() (certPath, keyPath string) {
+	certPath, keyPath = DefaultCAPaths()
+	if c.CACertFile != "" {
+		certPath = c.CACertFile
+	}
+	if c.CAKeyFile != "" {
+		keyPath = c.CAKeyFile
+	}
+	return certPath, keyPath
+}
+
+// LoadCA loads the local CA, creating it on first use.
+func (c ForwardProxyConfig) LoadCA() (*LocalCA, error) {
+	return LoadOrCreateLocalCA(c.CAPaths())
+}
+
+// ForwardProxy handles CONNECT requests.
+type ForwardProxy struct {
+	proxy       *Proxy
+	ca          *LocalCA
+	allowTunnel func(ip net.IP, port string) bool // Opaque tunnel policy
+}
+
+// NewForwardProxy creates a forward proxy that intercepts allowlisted hosts
+// with certificates from ca and serves them through proxy.
+func NewForwardProxy(proxy *Proxy, ca *LocalCA) *ForwardProxy {
+	return &ForwardProxy{proxy: proxy, ca: ca, allowTunnel: publicTLSTarget}
+}
+
+// publicTLSTarget allows opaque tunnels to port 443 on public unicast
+// addresses only, never loopback, private, link-local or unspecified ones.
+func publicTLSTarget(ip net.IP, port string) bool {
+	return port == "443" && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// intercepts returns the provider and upstream to route an intercepted
+// tunnel to, or "" if the tunnel should be relayed opaquely.
+func (f *ForwardProxy) intercepts(hostname, port string) (provider, upstream string) {
+	if isLoopbackHost(hostname) {
+		return "", ""
+	}
+	upstream = hostname
+	if port != "443" {
+		upstream = net.JoinHostPort(hostname, port)
+	}
+	return f.proxy.upstreams.ProviderFor(upstream), upstream
+}
+
+// ServeHTTP handles one CONNECT request.
+func (f *ForwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, ok := f.proxy.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	hostname, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "CONNECT target must be host:port", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "CONNECT not supported on this connection", http.StatusInternalServerError)
+		return
+	}
+
+	provider, upstream := f.intercepts(hostname, port)
+	if provider == "" {
+		f.tunnel(w, r, hijacker, hostname, port)
+		return
+	}
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Forward proxy: hijack failed for %s: %v", r.Host, err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	tlsConn := tls.Server(bufferedConn{Conn: conn, r: buf.Reader}, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return f.ca.CertificateFor(hostname)
+		},
+	})
+	tlsConn.SetDeadline(time.Now().Add(forwardProxyDialTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("Forward proxy: TLS handshake with client for %s failed (is the local CA trusted?): %v", hostname, err)
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	user := proxyUserFromContext(r.Context())
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, inner *http.Request) {
+			inner.URL.Path = "/" + provider + "/" + upstream + inner.URL.Path
+			inner.URL.RawPath = ""
+			if user != "" {
+				inner = inner.WithContext(withProxyUser(inner.Context(), user))
+			}
+			f.proxy.serveProxy(w, inner)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	srv.Serve(newSingleConnListener(tlsConn))
+}
+
+// errTunnelNotAllowed is returned for tunnel targets outside allowTunnel.
+var errTunnelNotAllowed = errors.New("tunnel target not allowed")
+
+// tunnel relays a CONNECT tunnel to hostname:port without looking inside it.
+func (f *ForwardProxy) tunnel(w http.ResponseWriter, r *http.Request, hijacker http.Hijacker, hostname, port string) {
+	host := net.JoinHostPort(hostname, port)
+	upstreamConn, err := f.dialTunnel(r.Context(), hostname, port)
+	if errors.Is(err, errTunnelNotAllowed) {
+		log.Printf("Forward proxy: refusing tunnel to %s: %v", host, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "tunnel failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstreamConn.Close()
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Forward proxy: hijack failed for %s: %v", host, err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstreamConn, bufferedConn{Conn: conn, r: buf.Reader})
+		if tcp, ok := upstreamConn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(conn, upstreamConn)
+	<-done
+}
+
+// dialTunnel resolves hostname and dials the first reachable address. Every
+// resolved address must pass allowTunnel, and the checked address is the one
+// dialled, so a name can't be re-resolved to a forbidden one in between.
+func (f *ForwardProxy) dialTunnel(ctx context.Context, hostname, port string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, forwardProxyDialTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", hostname)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !f.allowTunnel(ip, port) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", errTunnelNotAllowed, net.JoinHostPort(hostname, port), ip)
+		}
+	}
+
+	var dialer net.Dialer
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// bufferedConn reads through the bufio.Reader left over from Hijack, which
+// may already hold bytes the client sent after its CONNECT request.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// singleConnListener hands one connection to an http.Server, then blocks
+// until that connection is closed so Serve returns when it ends.
+type singleConnListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	return &singleConnListener{conn: conn, done: make(chan struct{})}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() {
+		conn = &closeNotifyConn{Conn: l.conn, done: l.done}
+	})
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *singleConnListener) Close() error {
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// closeNotifyConn closes done when the connection is closed.
+type closeNotifyConn struct {
+	net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
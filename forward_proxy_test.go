@@ -0,0 +1,233 @@
+// forward_proxy_test.go
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalCA(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca", "ca.crt")
+	keyPath := filepath.Join(dir, "ca", "ca.key")
+
+	ca, err := LoadOrCreateLocalCA(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("LoadOrCreateLocalCA failed: %v", err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a 0600 CA key, got %v", info)
+	}
+
+	// Loading again reuses the saved CA
+	again, err := LoadOrCreateLocalCA(certPath, keyPath)
+	if err != nil || !again.Certificate().Equal(ca.Certificate()) {
+		t.Fatalf("Expected the saved CA to be reused: %v", err)
+	}
+
+	cert, err := ca.CertificateFor("api.anthropic.com")
+	if err != nil {
+		t.Fatalf("CertificateFor failed: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "api.anthropic.com", Roots: roots}); err != nil {
+		t.Errorf("Expected minted certificate to verify against the CA: %v", err)
+	}
+	if cached, _ := ca.CertificateFor("api.anthropic.com"); cached != cert {
+		t.Error("Expected minted certificates to be cached")
+	}
+
+	os.Remove(certPath)
+	if _, err := LoadOrCreateLocalCA(certPath, keyPath); err == nil {
+		t.Error("Expected a lone key file to be an error, not silently replaced")
+	}
+}
+
+// newForwardProxyTest starts a forward proxy that intercepts example.com and
+// sends its traffic to upstream.
+func newForwardProxyTest(t *testing.T, upstream *httptest.Server) (*httptest.Server, *LocalCA, string) {
+	t.Helper()
+	logDir := t.TempDir()
+	logger, _ := NewLogger(logDir)
+	sm, _ := NewSessionManager(logDir, logger)
+	t.Cleanup(func() { sm.Close(); logger.Close() })
+
+	proxy := NewProxyWithSessionManagerAndLogger(logger, sm)
+	proxy.upstreams = NewUpstreamAllowlist(map[string][]string{"anthropic": {"example.com"}})
+	client := upstream.Client()
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, upstream.Listener.Addr().String())
+	}
+	proxy.client = client
+
+	ca, err := LoadOrCreateLocalCA(filepath.Join(logDir, "ca.crt"), filepath.Join(logDir, "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := NewForwardProxy(proxy, ca)
+	srv := httptest.NewServer(forward)
+	t.Cleanup(srv.Close)
+	return srv, ca, logDir
+}
+
+func forwardProxyClient(proxyURL string, roots *x509.CertPool) *http.Client {
+	u, _ := url.Parse(proxyURL)
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(u),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+}
+
+func TestForwardProxyInterceptsAllowlistedHosts(t *testing.T) {
+	var gotPath string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"intercepted"}],"usage":{"input_tokens":3,"output_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	srv, ca, logDir := newForwardProxyTest(t, upstream)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+	client := forwardProxyClient(srv.URL, roots)
+
+	resp, err := client.Post("https://example.com/v1/messages", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("Request through forward proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "intercepted") {
+		t.Fatalf("Unexpected response: %d %s", resp.StatusCode, body)
+	}
+	if gotPath != "/v1/messages" {
+		t.Errorf("Expected upstream path /v1/messages, got %s", gotPath)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(logDir, "example.com", "*", "*.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected intercepted traffic to be logged, got %v", matches)
+	}
+	data, _ := os.ReadFile(matches[0])
+	if !strings.Contains(string(data), `"type":"response"`) || !strings.Contains(string(data), "intercepted") {
+		t.Errorf("Expected request and response in the session log, got:\n%s", data)
+	}
+}
+
+func TestForwardProxyTunnelsOtherHosts(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tunnelled"))
+	}))
+	defer other.Close()
+
+	srv, _, logDir := newForwardProxyTest(t, upstream)
+	// The test server listens on loopback, which the default policy refuses
+	srv.Config.Handler.(*ForwardProxy).allowTunnel = func(net.IP, string) bool { return true }
+
+	// The client sees the real server's certificate, not one from the local CA
+	client := other.Client()
+	u, _ := url.Parse(srv.URL)
+	client.Transport.(*http.Transport).Proxy = http.ProxyURL(u)
+	resp, err := client.Get(other.URL)
+	if err != nil {
+		t.Fatalf("Tunnelled request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "tunnelled" {
+		t.Errorf("Unexpected tunnelled response %q", body)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(logDir, "*", "*", "*.jsonl")); len(matches) != 0 {
+		t.Errorf("Expected tunnelled traffic not to be logged, got %v", matches)
+	}
+}
+
+func TestForwardProxyRefusesInternalTunnels(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	srv, _, _ := newForwardProxyTest(t, upstream)
+
+	for _, target := range []string{
+		upstream.Listener.Addr().String(), // loopback
+		"127.0.0.1:443",
+		"localhost:443",
+		"10.1.2.3:443",
+		"192.168.0.10:443",
+		"169.254.169.254:443",
+		"[::1]:443",
+		"0.0.0.0:443",
+	} {
+		req, _ := http.NewRequest(http.MethodConnect, srv.URL, nil)
+		req.Host = target
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("CONNECT %s failed: %v", target, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected CONNECT %s to be refused with 403, got %d", target, resp.StatusCode)
+		}
+	}
+}
+
+func TestPublicTLSTarget(t *testing.T) {
+	tests := []struct {
+		ip   string
+		port string
+		want bool
+	}{
+		{"93.184.216.34", "443", true},
+		{"2606:2800:220:1::1", "443", true},
+		{"93.184.216.34", "22", false},
+		{"127.0.0.1", "443", false},
+		{"10.0.0.1", "443", false},
+		{"172.16.5.4", "443", false},
+		{"fd00::1", "443", false},
+		{"fe80::1", "443", false},
+		{"::", "443", false},
+	}
+	for _, tt := range tests {
+		if got := publicTLSTarget(net.ParseIP(tt.ip), tt.port); got != tt.want {
+			t.Errorf("publicTLSTarget(%s, %s) = %v, want %v", tt.ip, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestForwardProxyRequiresAuth(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	srv, ca, _ := newForwardProxyTest(t, upstream)
+	srv.Config.Handler.(*ForwardProxy).proxy.auth = testProxyAuth(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+
+	if resp, err := forwardProxyClient(srv.URL, roots).Get("https://example.com/v1/models"); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected CONNECT without a token to be rejected")
+	}
+
+	u, _ := url.Parse(srv.URL)
+	u.User = url.UserPassword("alice", "alice-token")
+	resp, err := forwardProxyClient(u.String(), roots).Get("https://example.com/v1/models")
+	if err != nil {
+		t.Fatalf("Expected CONNECT with a token to succeed: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,177 @@
+// localca.go
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	localCAValidity   = 10 * 365 * 24 * time.Hour
+	leafCertValidity  = 90 * 24 * time.Hour
+	leafCertRenewBy   = 7 * 24 * time.Hour // Re-mint cached certs this close to expiry
+	localCACommonName = "llm-proxy local CA"
+)
+
+// DefaultCAPaths returns where --setup keeps the local CA:
+// ~/.config/llm-proxy/ca.crt and ca.key.
+func DefaultCAPaths() (certPath, keyPath string) {
+	home, _ := os.UserHomeDir()
+	dir := filepath.Join(home, ".config", "llm-proxy")
+	return filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+}
+
+// LocalCA mints per-host certificates for intercepted CONNECT tunnels.
+type LocalCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate // hostname -> leaf
+}
+
+// LoadOrCreateLocalCA loads the CA from certPath and keyPath, generating and
+// saving a new one if neither file exists.
+func LoadOrCreateLocalCA(certPath, keyPath string) (*LocalCA, error) {
+	certPath, keyPath = expandHome(certPath), expandHome(keyPath)
+
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		if err := createLocalCA(certPath, keyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading local CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing local CA: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("local CA %s is not a CA certificate", certPath)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("local CA key %s cannot sign", keyPath)
+	}
+	return &LocalCA{cert: cert, key: key, certs: make(map[string]*tls.Certificate)}, nil
+}
+
+// createLocalCA writes a new self-signed CA. The key is readable only by the user.
+func createLocalCA(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: localCACommonName, Organization: []string{"llm-proxy"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("creating local CA: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// Certificate returns the CA certificate.
+func (ca *LocalCA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// CertificateFor returns a leaf certificate for host, minting and caching it
+// on first use.
+func (ca *LocalCA) CertificateFor(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.certs[host]; ok && time.Until(cert.Leaf.NotAfter) > leafCertRenewBy {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(leafCertValidity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("minting certificate for %s: %w", host, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	ca.certs[host] = cert
+	return cert, nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
@@ -0,0 +1,208 @@
+// logfiles.go
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Session logs live at <logDir>/<upstream>/<YYYY-MM-DD>/<session>.jsonl.
+// A session that outgrows the size cap continues in <session>.part2.jsonl,
+// <session>.part3.jsonl and so on, and retention may gzip any of them to
+// <name>.jsonl.gz. Everything that reads logs goes through these helpers so
+// split and compressed files are read transparently.
+
+const (
+	logFileExt        = ".jsonl"
+	compressedLogExt  = ".gz"
+	sessionPartMarker = ".part"
+)
+
+// sessionLogFileName returns the file name for part n (1-based) of a session.
+func sessionLogFileName(sessionID string, part int) string {
+	if part <= 1 {
+		return sessionID + logFileExt
+	}
+	return sessionID + sessionPartMarker + strconv.Itoa(part) + logFileExt
+}
+
+// parseLogFileName splits a log file name into session ID and part number.
+// ok is false for files that aren't session logs.
+func parseLogFileName(name string) (sessionID string, part int, ok bool) {
+	name = strings.TrimSuffix(name, compressedLogExt)
+	if !strings.HasSuffix(name, logFileExt) {
+		return "", 0, false
+	}
+	name = strings.TrimSuffix(name, logFileExt)
+
+	part = 1
+	if idx := strings.LastIndex(name, sessionPartMarker); idx != -1 {
+		if n, err := strconv.Atoi(name[idx+len(sessionPartMarker):]); err == nil && n > 1 {
+			name, part = name[:idx], n
+		}
+	}
+	if name == "" {
+		return "", 0, false
+	}
+	return name, part, true
+}
+
+// readLogFile reads a session log file, decompressing it if needed.
+func readLogFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if !strings.HasSuffix(path, compressedLogExt) {
+		return io.ReadAll(f)
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// readLogLines reads a session log file as lines, decrypting sealed lines.
+func readLogLines(path string, c *LogCipher) ([]string, error) {
+	data, err := readLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		plain, err := c.Open([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, i+1, err)
+		}
+		lines[i] = string(plain)
+	}
+	return lines, nil
+}
+
+// readSessionLines is readLogLines with deduplicated request bodies expanded
+// from the blob store.
+func readSessionLines(path string, c *LogCipher) ([]string, error) {
+	lines, err := readLogLines(path, c)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	for i, line := range lines {
+		lines[i] = resolveLogLine(line, dir, c)
+	}
+	return lines, nil
+}
+
+// sessionLog is one session's files within a <upstream>/<date> directory.
+type sessionLog struct {
+	Host      string
+	Date      string
+	SessionID string
+	Paths     []string  // In part order
+	ModTime   time.Time // Latest modification across parts
+}
+
+// walkSessionLogs calls fn for every session under logDir, with its parts
+// grouped and ordered. Sessions are visited in directory order.
+func walkSessionLogs(logDir string, fn func(sessionLog) error) error {
+	hosts, err := os.ReadDir(logDir)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		if !host.IsDir() {
+			continue
+		}
+		dates, err := os.ReadDir(filepath.Join(logDir, host.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, date := range dates {
+			if !date.IsDir() {
+				continue
+			}
+			dir := filepath.Join(logDir, host.Name(), date.Name())
+			for _, session := range sessionLogsInDir(dir) {
+				session.Host = host.Name()
+				session.Date = date.Name()
+				if err := fn(session); err != nil {
+					if err == filepath.SkipAll {
+						return nil
+					}
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sessionLogsInDir groups the log files in one date directory by session.
+func sessionLogsInDir(dir string) []sessionLog {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	type partFile struct {
+		part int
+		path string
+	}
+	bySession := make(map[string][]partFile)
+	modTimes := make(map[string]time.Time)
+	var order []string
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		sessionID, part, ok := parseLogFileName(file.Name())
+		if !ok {
+			continue
+		}
+		if _, seen := bySession[sessionID]; !seen {
+			order = append(order, sessionID)
+		}
+		bySession[sessionID] = append(bySession[sessionID], partFile{part, filepath.Join(dir, file.Name())})
+		if info, err := file.Info(); err == nil && info.ModTime().After(modTimes[sessionID]) {
+			modTimes[sessionID] = info.ModTime()
+		}
+	}
+
+	sessions := make([]sessionLog, 0, len(order))
+	for _, sessionID := range order {
+		parts := bySession[sessionID]
+		sort.Slice(parts, func(i, j int) bool {
+			if parts[i].part != parts[j].part {
+				return parts[i].part < parts[j].part
+			}
+			// Mid-compression both copies exist briefly; prefer the original
+			return !strings.HasSuffix(parts[i].path, compressedLogExt)
+		})
+		session := sessionLog{SessionID: sessionID, ModTime: modTimes[sessionID]}
+		for i, p := range parts {
+			if i > 0 && parts[i-1].part == p.part {
+				continue
+			}
+			session.Paths = append(session.Paths, p.path)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
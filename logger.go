@@ -4,10 +4,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -24,11 +27,22 @@ type StreamChunk struct {
 }
 
 type Logger struct {
-	baseDir   string
-	machineID string // user@hostname for log aggregation
-	mu        sync.Mutex
-	files     map[string]*os.File
-	upstreams map[string]string // sessionID -> upstream
+	baseDir     string
+	machineID   string // user@hostname for log aggregation
+	mu          sync.Mutex
+	files       map[string]*os.File
+	upstreams   map[string]string // sessionID -> upstream
+	identities  map[string]string // sessionID -> authenticated user (replaces machineID)
+	maxFileSize int64             // Start a new session part past this many bytes (0 = unlimited)
+	fileSizes   map[string]int64  // sessionID -> bytes in the open part
+	lastWrite   map[string]time.Time
+	sessionDirs map[string]string // sessionID -> date directory of its first part
+	parts       map[string]int    // sessionID -> part number last opened
+	dedup       bool              // Store repeated request body parts in the blob store
+	knownBlobs  map[string]bool   // Blob paths already on disk
+	claimed     map[string]bool   // Paths retention is compressing or deleting
+	headers     *HeaderObfuscator
+	cipher      *LogCipher // nil = plaintext
 }
 
 func getMachineID() string {
@@ -51,13 +65,51 @@ func NewLogger(baseDir string) (*Logger, error) {
 	}
 
 	return &Logger{
-		baseDir:   baseDir,
-		machineID: getMachineID(),
-		files:     make(map[string]*os.File),
-		upstreams: make(map[string]string),
+		baseDir:     baseDir,
+		machineID:   getMachineID(),
+		files:       make(map[string]*os.File),
+		upstreams:   make(map[string]string),
+		identities:  make(map[string]string),
+		fileSizes:   make(map[string]int64),
+		lastWrite:   make(map[string]time.Time),
+		sessionDirs: make(map[string]string),
+		parts:       make(map[string]int),
+		knownBlobs:  make(map[string]bool),
+		claimed:     make(map[string]bool),
 	}, nil
 }
 
+// SetMaxFileSize caps the size of one session log file. Once a write takes a
+// file past the cap, the session continues in its next part. Zero disables.
+func (l *Logger) SetMaxFileSize(bytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxFileSize = bytes
+}
+
+// SetHeaderObfuscator sets which headers are masked in request and response
+// entries. The default covers the built-in sensitive headers.
+func (l *Logger) SetHeaderObfuscator(o *HeaderObfuscator) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.headers = o
+}
+
+// SetCipher turns on encryption at rest: each line (and blob) is sealed
+// individually, and new files and directories are private to the user.
+func (l *Logger) SetCipher(c *LogCipher) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cipher = c
+}
+
+// SetDedup turns on content-addressed storage of request bodies (see blobstore.go).
+func (l *Logger) SetDedup(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dedup = enabled
+}
+
 func (l *Logger) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -67,13 +119,17 @@ func (l *Logger) Close() error {
 	}
 	l.files = nil
 	l.upstreams = nil
+	l.identities = nil
+	l.sessionDirs = nil
+	l.parts = nil
 	return nil
 }
 
+// getFile returns the session's open log file, opening it if needed. A
+// session keeps the date directory it started in and continues its part
+// numbering when a file is reopened after a split or an idle release. The
+// caller must hold l.mu.
 func (l *Logger) getFile(sessionID string) (*os.File, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
 	if l.files == nil {
 		return nil, fmt.Errorf("logger is closed")
 	}
@@ -82,45 +138,174 @@ func (l *Logger) getFile(sessionID string) (*os.File, error) {
 		return f, nil
 	}
 
-	// Look up the upstream for this session
-	upstream, ok := l.upstreams[sessionID]
+	// Create directory: <baseDir>/<upstream>/<YYYY-MM-DD>/. A session whose
+	// state was pruned after an idle release finds its directory on disk.
+	logDir, ok := l.sessionDirs[sessionID]
 	if !ok {
-		return nil, fmt.Errorf("no upstream registered for session %s", sessionID)
+		if upstream, registered := l.upstreams[sessionID]; registered {
+			logDir = filepath.Join(l.baseDir, upstream, time.Now().Format("2006-01-02"))
+		} else if logDir, ok = l.findSessionDir(sessionID); !ok {
+			return nil, fmt.Errorf("no upstream registered for session %s", sessionID)
+		}
 	}
-
-	// Create directory: <baseDir>/<upstream>/<YYYY-MM-DD>/
-	dateStr := time.Now().Format("2006-01-02")
-	logDir := filepath.Join(l.baseDir, upstream, dateStr)
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	dirPerm, filePerm := os.FileMode(0755), os.FileMode(0644)
+	if l.cipher != nil {
+		dirPerm, filePerm = 0700, 0600
+	}
+	if err := os.MkdirAll(logDir, dirPerm); err != nil {
 		return nil, err
 	}
 
-	// Open file for append
-	path := filepath.Join(logDir, sessionID+".jsonl")
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	// Open the session's latest part for append, moving on if it's full or
+	// retention has claimed or compressed it
+	part, ok := l.parts[sessionID]
+	if !ok {
+		part = latestSessionPart(logDir, sessionID)
+	}
+	path := filepath.Join(logDir, sessionLogFileName(sessionID, part))
+	if _, err := os.Stat(path + compressedLogExt); err == nil || l.claimed[path] {
+		part++
+	} else if info, err := os.Stat(path); err == nil && l.maxFileSize > 0 && info.Size() >= l.maxFileSize {
+		part++
+	}
+	path = filepath.Join(logDir, sessionLogFileName(sessionID, part))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, filePerm)
 	if err != nil {
 		return nil, err
 	}
 
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+
 	l.files[sessionID] = f
+	l.fileSizes[sessionID] = size
+	l.sessionDirs[sessionID] = logDir
+	l.parts[sessionID] = part
 	return f, nil
 }
 
-func (l *Logger) writeEntry(sessionID string, entry interface{}) error {
-	f, err := l.getFile(sessionID)
-	if err != nil {
-		return err
+// latestSessionPart returns the highest part number of a session's log in
+// dir, compressed or not, or 1 if there is none yet.
+func latestSessionPart(dir, sessionID string) int {
+	latest := 1
+	matches, _ := filepath.Glob(filepath.Join(dir, sessionID+sessionPartMarker+"*"+logFileExt+"*"))
+	for _, match := range matches {
+		if id, part, ok := parseLogFileName(filepath.Base(match)); ok && id == sessionID && part > latest {
+			latest = part
+		}
+	}
+	return latest
+}
+
+// findSessionDir returns the earliest date directory holding a log of the
+// session. The caller must hold l.mu.
+func (l *Logger) findSessionDir(sessionID string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(l.baseDir, "*", "*", sessionID+"*"))
+	var dirs []string
+	for _, match := range matches {
+		if id, _, ok := parseLogFileName(filepath.Base(match)); ok && id == sessionID {
+			dirs = append(dirs, filepath.Dir(match))
+		}
+	}
+	if len(dirs) == 0 {
+		return "", false
+	}
+	slices.SortFunc(dirs, func(a, b string) int { return strings.Compare(filepath.Base(a), filepath.Base(b)) })
+	return dirs[0], true
+}
+
+// claimIdleFile closes path if it is open and hasn't been written for at
+// least idle, and keeps the logger off it until unclaimFile, so retention can
+// compress or delete it. A write for the session in the meantime opens its
+// next part. Once the session has no open file and has been idle that long,
+// its per-session state is dropped; a later write finds its directory and
+// part on disk again. Returns false if the file is still in use.
+func (l *Logger) claimIdleFile(path string, idle time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for sessionID, f := range l.files {
+		if f.Name() != path {
+			continue
+		}
+		if time.Since(l.lastWrite[sessionID]) < idle {
+			return false
+		}
+		f.Close()
+		delete(l.files, sessionID)
+		delete(l.fileSizes, sessionID)
+		break
+	}
+	if sessionID, _, ok := parseLogFileName(filepath.Base(path)); ok {
+		if _, open := l.files[sessionID]; !open && time.Since(l.lastWrite[sessionID]) >= idle {
+			l.forgetSession(sessionID)
+		}
+	}
+	if l.claimed != nil {
+		l.claimed[path] = true
 	}
+	return true
+}
+
+// forgetSession drops the state kept for a session between writes. The
+// caller must hold l.mu.
+func (l *Logger) forgetSession(sessionID string) {
+	delete(l.lastWrite, sessionID)
+	delete(l.upstreams, sessionID)
+	delete(l.identities, sessionID)
+	delete(l.sessionDirs, sessionID)
+	delete(l.parts, sessionID)
+}
 
+// forgetBlobs drops the blobs under dir from the known set, after retention
+// removed them.
+func (l *Logger) forgetBlobs(dir string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prefix := dir + string(filepath.Separator)
+	for path := range l.knownBlobs {
+		if strings.HasPrefix(path, prefix) {
+			delete(l.knownBlobs, path)
+		}
+	}
+}
+
+// unclaimFile ends a claim taken by claimIdleFile.
+func (l *Logger) unclaimFile(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.claimed, path)
+}
+
+func (l *Logger) writeEntry(sessionID string, entry interface{}) error {
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return err
 	}
 
+	// Hold the lock from lookup to write, so a split or release can't close
+	// the file in between
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	_, err = f.Write(append(data, '\n'))
+	f, err := l.getFile(sessionID)
+	if err != nil {
+		return err
+	}
+
+	data = l.cipher.Seal(data)
+
+	n, err := f.Write(append(data, '\n'))
+	l.lastWrite[sessionID] = time.Now()
+	l.fileSizes[sessionID] += int64(n)
+	if l.maxFileSize > 0 && l.fileSizes[sessionID] >= l.maxFileSize {
+		// The next write opens the following part
+		f.Close()
+		delete(l.files, sessionID)
+		delete(l.fileSizes, sessionID)
+	}
 	return err
 }
 
@@ -134,6 +319,26 @@ func (l *Logger) RegisterUpstream(sessionID, upstream string) {
 	l.mu.Unlock()
 }
 
+// RegisterIdentity records the authenticated user a session belongs to. It
+// is logged as the session's machine instead of user@host.
+func (l *Logger) RegisterIdentity(sessionID, identity string) {
+	l.mu.Lock()
+	if l.identities != nil {
+		l.identities[sessionID] = identity
+	}
+	l.mu.Unlock()
+}
+
+// machineFor returns the machine recorded in a session's _meta.
+func (l *Logger) machineFor(sessionID string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if identity, ok := l.identities[sessionID]; ok {
+		return identity
+	}
+	return l.machineID
+}
+
 func (l *Logger) LogSessionStart(sessionID, provider, upstream string) error {
 	// Register the upstream for this session
 	l.RegisterUpstream(sessionID, upstream)
@@ -144,7 +349,7 @@ func (l *Logger) LogSessionStart(sessionID, provider, upstream string) error {
 		"upstream": upstream,
 		"_meta": map[string]interface{}{
 			"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-			"machine": l.machineID,
+			"machine": l.machineFor(sessionID),
 			"host":    upstream,
 			"session": sessionID,
 		},
@@ -153,6 +358,11 @@ func (l *Logger) LogSessionStart(sessionID, provider, upstream string) error {
 }
 
 func (l *Logger) LogRequest(sessionID, provider string, seq int, method, path string, headers http.Header, body []byte, requestID string) error {
+	return l.LogRequestWithMeta(sessionID, provider, seq, method, path, headers, body, requestID, nil)
+}
+
+// LogRequestWithMeta logs a request with extra fields merged into _meta.
+func (l *Logger) LogRequestWithMeta(sessionID, provider string, seq int, method, path string, headers http.Header, body []byte, requestID string, extraMeta map[string]interface{}) error {
 	upstream := l.upstreams[sessionID]
 
 	entry := map[string]interface{}{
@@ -160,38 +370,99 @@ func (l *Logger) LogRequest(sessionID, provider string, seq int, method, path st
 		"seq":     seq,
 		"method":  method,
 		"path":    path,
-		"headers": ObfuscateHeaders(headers),
+		"headers": l.headers.Obfuscate(headers),
 		"body":    string(body),
 		"size":    len(body),
 		"_meta": map[string]interface{}{
 			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    l.machineID,
+			"machine":    l.machineFor(sessionID),
 			"host":       upstream,
 			"session":    sessionID,
 			"request_id": requestID,
 		},
 	}
+	addExtraMeta(entry, extraMeta)
+	if err := l.dedupBody(sessionID, body, entry); err != nil {
+		// Fall back to storing the body inline
+		log.Printf("WARNING: failed to store request blobs for session %s: %v", sessionID, err)
+	}
 	return l.writeEntry(sessionID, entry)
 }
 
-func (l *Logger) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string) error {
+// dedupBody moves the repeated parts of a request body into the blob store
+// next to the session's log file and swaps entry's body for segment references.
+func (l *Logger) dedupBody(sessionID string, body []byte, entry map[string]interface{}) error {
+	l.mu.Lock()
+	enabled, c := l.dedup, l.cipher
+	l.mu.Unlock()
+	if !enabled {
+		return nil
+	}
+
+	segments, blobs := splitBody(body, c)
+	if segments == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	f, err := l.getFile(sessionID)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(f.Name())
+
+	for hash, data := range blobs {
+		path := blobPath(dir, hash)
+		l.mu.Lock()
+		known := l.knownBlobs[path]
+		l.mu.Unlock()
+		if known {
+			continue
+		}
+		if err := writeBlob(dir, hash, c.Seal(data)); err != nil {
+			return err
+		}
+		l.mu.Lock()
+		if l.knownBlobs != nil {
+			l.knownBlobs[path] = true
+		}
+		l.mu.Unlock()
+	}
+
+	delete(entry, "body")
+	entry["body_segments"] = segments
+	return nil
+}
+
+func (l *Logger) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string, costUSD *float64) error {
+	return l.LogResponseWithMeta(sessionID, provider, seq, status, headers, body, chunks, timing, requestID, costUSD, nil)
+}
+
+// LogResponseWithMeta logs a response with extra fields merged into _meta.
+func (l *Logger) LogResponseWithMeta(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string, costUSD *float64, extraMeta map[string]interface{}) error {
 	upstream := l.upstreams[sessionID]
 
 	entry := map[string]interface{}{
 		"type":    "response",
 		"seq":     seq,
 		"status":  status,
-		"headers": headers,
+		"headers": l.headers.Obfuscate(headers),
 		"timing":  timing,
 		"size":    len(body),
 		"_meta": map[string]interface{}{
 			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    l.machineID,
+			"machine":    l.machineFor(sessionID),
 			"host":       upstream,
 			"session":    sessionID,
 			"request_id": requestID,
 		},
 	}
+	addExtraMeta(entry, extraMeta)
+
+	if costUSD != nil {
+		entry["cost_usd"] = *costUSD
+	}
 
 	if chunks != nil {
 		entry["chunks"] = chunks
@@ -202,6 +473,17 @@ func (l *Logger) LogResponse(sessionID, provider string, seq int, status int, he
 	return l.writeEntry(sessionID, entry)
 }
 
+// addExtraMeta merges extra into an entry's _meta map.
+func addExtraMeta(entry map[string]interface{}, extra map[string]interface{}) {
+	meta, ok := entry["_meta"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, v := range extra {
+		meta[k] = v
+	}
+}
+
 // LogFork records a fork event when conversation history diverges
 func (l *Logger) LogFork(sessionID, provider string, fromSeq int, parentSession string) error {
 	upstream := l.upstreams[sessionID]
@@ -213,7 +495,7 @@ func (l *Logger) LogFork(sessionID, provider string, fromSeq int, parentSession
 		"reason":         "message_history_diverged",
 		"_meta": map[string]interface{}{
 			"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-			"machine": l.machineID,
+			"machine": l.machineFor(sessionID),
 			"host":    upstream,
 			"session": sessionID,
 		},
@@ -118,7 +118,7 @@ func TestLoggerResponseWithTiming(t *testing.T) {
 		TotalMs: 1200,
 	}
 
-	err = logger.LogResponse(sessionID, provider, 1, 200, http.Header{}, []byte(`{"response":"ok"}`), nil, timing, "test-request-id")
+	err = logger.LogResponse(sessionID, provider, 1, 200, http.Header{}, []byte(`{"response":"ok"}`), nil, timing, "test-request-id", nil)
 	if err != nil {
 		t.Fatalf("Failed to log response: %v", err)
 	}
@@ -190,3 +190,39 @@ func TestLogEntryHasMeta(t *testing.T) {
 		t.Errorf("Expected machine format user@host, got %s", machine)
 	}
 }
+
+func TestLoggerResponseCost(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	logger, err := NewLogger(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	sessionID := "20260113-102345-cost"
+	upstream := "api.anthropic.com"
+	logger.LogSessionStart(sessionID, "anthropic", upstream)
+
+	cost := 0.0123
+	logger.LogResponse(sessionID, "anthropic", 1, 200, http.Header{}, []byte(`{}`), nil, ResponseTiming{}, "req-priced", &cost)
+	logger.LogResponse(sessionID, "anthropic", 2, 200, http.Header{}, []byte(`{}`), nil, ResponseTiming{}, "req-unpriced", nil)
+
+	today := time.Now().Format("2006-01-02")
+	data, _ := os.ReadFile(filepath.Join(tmpDir, upstream, today, sessionID+".jsonl"))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(lines))
+	}
+
+	var priced, unpriced map[string]interface{}
+	json.Unmarshal([]byte(lines[1]), &priced)
+	json.Unmarshal([]byte(lines[2]), &unpriced)
+
+	if priced["cost_usd"] != 0.0123 {
+		t.Errorf("expected cost_usd 0.0123, got %v", priced["cost_usd"])
+	}
+	if _, ok := unpriced["cost_usd"]; ok {
+		t.Error("expected no cost_usd for unpriced response")
+	}
+}
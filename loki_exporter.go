@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -20,6 +22,7 @@ const (
 	LogTypeTurnEnd    = "turn_end"
 	LogTypeToolCall   = "tool_call"
 	LogTypeToolResult = "tool_result"
+	LogTypeAnomaly    = "agent_anomaly"
 )
 
 // PatternData holds agent behavior pattern metrics for JSON body (not labels)
@@ -32,10 +35,11 @@ type PatternData struct {
 
 // TokenData holds token usage metrics for JSON body (not labels)
 type TokenData struct {
-	InputTokens              int `json:"input_tokens"`
-	OutputTokens             int `json:"output_tokens"`
-	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
-	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	InputTokens              int      `json:"input_tokens"`
+	OutputTokens             int      `json:"output_tokens"`
+	CacheReadInputTokens     int      `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int      `json:"cache_creation_input_tokens"`
+	CostUSD                  *float64 `json:"cost_usd,omitempty"` // nil when the model has no known price
 }
 
 // LokiExporterConfig holds configuration for the Loki exporter
@@ -50,6 +54,10 @@ type LokiExporterConfig struct {
 	Environment     string        // Environment label
 	BufferSize      int           // Channel buffer size
 	ShutdownTimeout time.Duration // Timeout for graceful shutdown
+	SpoolDir        string        // Spool failed and overflowing batches here (empty = drop them)
+	SpoolMaxBytes   int64         // Spool size cap; batches beyond it are dropped
+	SpoolCipher     *LogCipher    // Seals spool files (nil = plaintext)
+	ReplayWait      time.Duration // How often the spool is replayed
 }
 
 // LokiStream represents a single stream in the Loki push request
@@ -69,6 +77,9 @@ type LokiExporterStats struct {
 	EntriesFailed  int64
 	EntriesDropped int64
 	BatchesSent    int64
+	SpoolBatches   int64 // Batches waiting in the spool
+	SpoolEntries   int64 // Entries waiting in the spool
+	SpoolBytes     int64
 }
 
 // lokiEntry is an internal struct for queued entries
@@ -92,6 +103,9 @@ type lokiEntry struct {
 	isRetry   string // "true" or "false" for retry detection
 	errorType string // rate_limit, context_length, invalid_request, server_error
 
+	// Anomaly type label for agent_anomaly events
+	anomalyType string
+
 	// Request replay support
 	requestSHA string // SHA256 of raw request body for deterministic replay
 
@@ -109,11 +123,24 @@ type LokiExporter struct {
 	closedChan chan struct{}
 	closeOnce  sync.Once
 
+	// Entries that didn't fit in entryChan, spooled by Push once a batch is
+	// full and by run on each tick (spool only)
+	overflowMu sync.Mutex
+	overflow   []lokiEntry
+
+	// unhealthy is set when a batch fails, so later batches go straight to
+	// the spool until a replay reaches Loki. Owned by run.
+	unhealthy bool
+	spoolSeq  int64 // atomic
+
 	// Stats counters (accessed atomically)
 	entriesSent    int64
 	entriesFailed  int64
 	entriesDropped int64
 	batchesSent    int64
+	spoolBatches   int64
+	spoolEntries   int64
+	spoolBytes     int64
 }
 
 // NewLokiExporter creates a new LokiExporter with the given configuration
@@ -142,6 +169,12 @@ func NewLokiExporter(cfg LokiExporterConfig) (*LokiExporter, error) {
 	if cfg.ShutdownTimeout <= 0 {
 		cfg.ShutdownTimeout = 30 * time.Second
 	}
+	if cfg.SpoolMaxBytes <= 0 {
+		cfg.SpoolMaxBytes = 512 << 20
+	}
+	if cfg.ReplayWait <= 0 {
+		cfg.ReplayWait = 30 * time.Second
+	}
 	// UseGzip is a boolean - its zero value is false.
 	// Application-level default of true is set in config.go's DefaultConfig().
 
@@ -152,6 +185,11 @@ func NewLokiExporter(cfg LokiExporterConfig) (*LokiExporter, error) {
 		closeChan:  make(chan struct{}),
 		closedChan: make(chan struct{}),
 	}
+	if cfg.SpoolDir != "" {
+		if err := exporter.openSpool(); err != nil {
+			return nil, fmt.Errorf("LokiExporter: %w", err)
+		}
+	}
 
 	// Start background worker
 	go exporter.run()
@@ -406,7 +444,21 @@ func (e *LokiExporter) Push(entry map[string]interface{}, provider string) {
 	case e.entryChan <- le:
 		// Entry queued successfully
 	default:
-		// Channel full, drop entry
+		// Channel full: hold the entry for the spool, or drop it. run may be
+		// blocked in sendBatch retries, so full batches are spooled here.
+		if e.config.SpoolDir != "" {
+			e.overflowMu.Lock()
+			e.overflow = append(e.overflow, le)
+			var full []lokiEntry
+			if len(e.overflow) >= e.config.BatchSize {
+				full, e.overflow = e.overflow, nil
+			}
+			e.overflowMu.Unlock()
+			if full != nil {
+				e.spool(e.buildPushRequest(full), len(full))
+			}
+			return
+		}
 		atomic.AddInt64(&e.entriesDropped, 1)
 	}
 }
@@ -418,6 +470,11 @@ func (e *LokiExporter) run() {
 	batch := make([]lokiEntry, 0, e.config.BatchSize)
 	ticker := time.NewTicker(e.config.BatchWait)
 	defer ticker.Stop()
+	replayTicker := time.NewTicker(e.config.ReplayWait)
+	defer replayTicker.Stop()
+
+	// Deliver anything spooled by a previous run
+	e.replaySpool()
 
 	for {
 		select {
@@ -435,6 +492,10 @@ func (e *LokiExporter) run() {
 				e.sendBatch(batch)
 				batch = make([]lokiEntry, 0, e.config.BatchSize)
 			}
+			e.spoolOverflow()
+
+		case <-replayTicker.C:
+			e.replaySpool()
 
 		case <-e.closeChan:
 			// Drain remaining entries from channel
@@ -451,17 +512,70 @@ func (e *LokiExporter) run() {
 			if len(batch) > 0 {
 				e.sendBatch(batch)
 			}
+			e.spoolOverflow()
 			return
 		}
 	}
 }
 
-// sendBatch groups entries by labels and sends them to Loki with retries
+// sendBatch groups entries by labels and sends them to Loki with retries.
+// With a spool, a batch that can't be sent is spooled instead of failed.
 func (e *LokiExporter) sendBatch(entries []lokiEntry) {
 	if len(entries) == 0 {
 		return
 	}
 
+	request := e.buildPushRequest(entries)
+	entriesInBatch := len(entries)
+
+	// While Loki is down, don't wait on retries: spool straight away
+	if e.config.SpoolDir != "" && e.unhealthy {
+		e.spool(request, entriesInBatch)
+		return
+	}
+
+	// Send with retries
+	var lastErr error
+
+	for attempt := 0; attempt <= e.config.RetryMax; attempt++ {
+		if attempt > 0 {
+			// Exponential backoff with jitter
+			delay := e.config.RetryWait * time.Duration(1<<(attempt-1))
+			if delay > 10*time.Second {
+				delay = 10 * time.Second
+			}
+			// Add 25% jitter
+			jitter := time.Duration(float64(delay) * 0.25 * rand.Float64())
+			time.Sleep(delay + jitter)
+		}
+
+		lastErr = e.doSend(request)
+		if lastErr == nil {
+			// Success
+			atomic.AddInt64(&e.entriesSent, int64(entriesInBatch))
+			atomic.AddInt64(&e.batchesSent, 1)
+			return
+		}
+		var rejected *lokiRejectedError
+		if errors.As(lastErr, &rejected) {
+			// Loki won't take this batch however often it's sent
+			log.Printf("WARNING: %v, dropping %d entries", lastErr, entriesInBatch)
+			atomic.AddInt64(&e.entriesFailed, int64(entriesInBatch))
+			return
+		}
+	}
+
+	// All retries failed
+	if e.config.SpoolDir != "" {
+		e.unhealthy = true
+		e.spool(request, entriesInBatch)
+		return
+	}
+	atomic.AddInt64(&e.entriesFailed, int64(entriesInBatch))
+}
+
+// buildPushRequest groups entries into streams by label set.
+func (e *LokiExporter) buildPushRequest(entries []lokiEntry) LokiPushRequest {
 	// Group entries by labels
 	streams := make(map[string]*LokiStream)
 
@@ -505,6 +619,9 @@ func (e *LokiExporter) sendBatch(entries []lokiEntry) {
 		if entry.errorType != "" {
 			labels["error_type"] = entry.errorType
 		}
+		if entry.anomalyType != "" {
+			labels["anomaly_type"] = entry.anomalyType
+		}
 
 		// Transport label distinguishes Bedrock vs direct API traffic
 		if entry.transport != "" {
@@ -512,7 +629,7 @@ func (e *LokiExporter) sendBatch(entries []lokiEntry) {
 		}
 
 		// Create label key for grouping (include all labels for proper stream separation)
-		labelKey := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s",
+		labelKey := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s",
 			labels["app"],
 			labels["provider"],
 			labels["environment"],
@@ -527,6 +644,7 @@ func (e *LokiExporter) sendBatch(entries []lokiEntry) {
 			entry.toolName,
 			entry.isRetry,
 			entry.errorType,
+			entry.anomalyType,
 			entry.transport,
 		)
 
@@ -560,34 +678,7 @@ func (e *LokiExporter) sendBatch(entries []lokiEntry) {
 	for _, stream := range streams {
 		request.Streams = append(request.Streams, *stream)
 	}
-
-	// Send with retries
-	var lastErr error
-	entriesInBatch := len(entries)
-
-	for attempt := 0; attempt <= e.config.RetryMax; attempt++ {
-		if attempt > 0 {
-			// Exponential backoff with jitter
-			delay := e.config.RetryWait * time.Duration(1<<(attempt-1))
-			if delay > 10*time.Second {
-				delay = 10 * time.Second
-			}
-			// Add 25% jitter
-			jitter := time.Duration(float64(delay) * 0.25 * rand.Float64())
-			time.Sleep(delay + jitter)
-		}
-
-		lastErr = e.doSend(request)
-		if lastErr == nil {
-			// Success
-			atomic.AddInt64(&e.entriesSent, int64(entriesInBatch))
-			atomic.AddInt64(&e.batchesSent, 1)
-			return
-		}
-	}
-
-	// All retries failed
-	atomic.AddInt64(&e.entriesFailed, int64(entriesInBatch))
+	return request
 }
 
 // doSend performs the HTTP POST to Loki
@@ -639,10 +730,24 @@ func (e *LokiExporter) doSend(payload LokiPushRequest) error {
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		return nil
 	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return &lokiRejectedError{status: resp.StatusCode}
+	}
 
 	return fmt.Errorf("Loki returned status %d", resp.StatusCode)
 }
 
+// lokiRejectedError is a response that retrying won't change: a 4xx other
+// than 429, such as a malformed or out-of-order push. Only 5xx, 429 and
+// network errors are retried.
+type lokiRejectedError struct {
+	status int
+}
+
+func (e *lokiRejectedError) Error() string {
+	return fmt.Sprintf("Loki rejected the push with status %d", e.status)
+}
+
 // Stats returns the current statistics for the exporter
 func (e *LokiExporter) Stats() LokiExporterStats {
 	return LokiExporterStats{
@@ -650,6 +755,9 @@ func (e *LokiExporter) Stats() LokiExporterStats {
 		EntriesFailed:  atomic.LoadInt64(&e.entriesFailed),
 		EntriesDropped: atomic.LoadInt64(&e.entriesDropped),
 		BatchesSent:    atomic.LoadInt64(&e.batchesSent),
+		SpoolBatches:   atomic.LoadInt64(&e.spoolBatches),
+		SpoolEntries:   atomic.LoadInt64(&e.spoolEntries),
+		SpoolBytes:     atomic.LoadInt64(&e.spoolBytes),
 	}
 }
 
@@ -752,6 +860,9 @@ func (e *LokiExporter) emitEvent(sessionID, provider, machine, logType string, l
 	if et, ok := labels["error_type"]; ok {
 		entry.errorType = et
 	}
+	if at, ok := labels["anomaly_type"]; ok {
+		entry.anomalyType = at
+	}
 
 	// Build a complete entry map with type for JSON serialization
 	body["type"] = logType
@@ -802,6 +913,9 @@ func (e *LokiExporter) EmitTurnEnd(sessionID, provider, machine, stopReason stri
 		"cache_read_input_tokens":      tokens.CacheReadInputTokens,
 		"cache_creation_input_tokens":  tokens.CacheCreationInputTokens,
 	}
+	if tokens.CostUSD != nil {
+		body["cost_usd"] = *tokens.CostUSD
+	}
 
 	e.emitEvent(sessionID, provider, machine, LogTypeTurnEnd, labels, body)
 }
@@ -833,3 +947,21 @@ func (e *LokiExporter) EmitToolResult(sessionID, provider, machine, toolName, to
 
 	e.emitEvent(sessionID, provider, machine, LogTypeToolResult, labels, body)
 }
+
+// EmitAnomaly emits an agent_anomaly event when a runaway pattern is detected.
+func (e *LokiExporter) EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData) {
+	labels := map[string]string{
+		"anomaly_type": anomaly.Type,
+		"tool_name":    anomaly.ToolName,
+	}
+
+	body := map[string]interface{}{
+		"anomaly_type":    anomaly.Type,
+		"count":           anomaly.Count,
+		"threshold":       anomaly.Threshold,
+		"breaker_tripped": anomaly.BreakerTripped,
+		"message":         anomaly.String(),
+	}
+
+	e.emitEvent(sessionID, provider, machine, LogTypeAnomaly, labels, body)
+}
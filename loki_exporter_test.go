@@ -2099,3 +2099,64 @@ func TestLokiExporter_DifferentTransportsSeparateStreams(t *testing.T) {
 		t.Errorf("expected 2 streams (different transports), got %d", len(receivedPayload.Streams))
 	}
 }
+
+func TestEmitAnomaly(t *testing.T) {
+	var receivedPayload LokiPushRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := LokiExporterConfig{
+		URL:         server.URL,
+		BatchSize:   1,
+		BatchWait:   time.Hour,
+		UseGzip:     false,
+		Environment: "test",
+	}
+
+	exporter, err := NewLokiExporter(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exporter.EmitAnomaly("test-session", "anthropic", "test@host", AnomalyData{
+		Type:           AnomalyIdenticalToolCalls,
+		ToolName:       "Bash",
+		Count:          5,
+		Threshold:      5,
+		BreakerTripped: true,
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	exporter.Close()
+
+	if len(receivedPayload.Streams) == 0 {
+		t.Fatal("expected at least one stream")
+	}
+
+	stream := receivedPayload.Streams[0]
+	if stream.Stream["log_type"] != "agent_anomaly" {
+		t.Errorf("expected log_type 'agent_anomaly', got %q", stream.Stream["log_type"])
+	}
+	if stream.Stream["anomaly_type"] != "identical_tool_calls" {
+		t.Errorf("expected anomaly_type 'identical_tool_calls', got %q", stream.Stream["anomaly_type"])
+	}
+	if stream.Stream["tool_name"] != "Bash" {
+		t.Errorf("expected tool_name 'Bash', got %q", stream.Stream["tool_name"])
+	}
+
+	var logBody map[string]interface{}
+	if err := json.Unmarshal([]byte(stream.Values[0][1]), &logBody); err != nil {
+		t.Fatalf("failed to parse log body: %v", err)
+	}
+	if v, ok := logBody["count"].(float64); !ok || int(v) != 5 {
+		t.Errorf("expected count=5, got %v", logBody["count"])
+	}
+	if v, ok := logBody["breaker_tripped"].(bool); !ok || !v {
+		t.Errorf("expected breaker_tripped=true, got %v", logBody["breaker_tripped"])
+	}
+}
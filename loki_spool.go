@@ -0,0 +1,148 @@
+// loki_spool.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The Loki spool is a write-ahead directory for batches Loki didn't accept:
+// batches that failed after all retries, batches built while Loki is down,
+// and entries that overflowed the in-memory buffer. Each batch is one file
+// holding its push request, named <unix nanos>-<seq>-<entries>.json so the
+// spool replays oldest first and its depth is known without reading it.
+// With encryption at rest, the file is sealed like a log line. Replays
+// resume after a restart, since the files are all the state there is.
+
+// openSpool creates the spool directory and counts what a previous run left.
+func (e *LokiExporter) openSpool() error {
+	if err := os.MkdirAll(e.config.SpoolDir, 0700); err != nil {
+		return fmt.Errorf("creating spool directory: %w", err)
+	}
+	for _, path := range e.spoolFiles() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		atomic.AddInt64(&e.spoolBatches, 1)
+		atomic.AddInt64(&e.spoolEntries, int64(spoolFileEntries(path)))
+		atomic.AddInt64(&e.spoolBytes, info.Size())
+	}
+	if n := atomic.LoadInt64(&e.spoolEntries); n > 0 {
+		log.Printf("Loki: %d spooled entries to replay", n)
+	}
+	return nil
+}
+
+// spoolFiles returns the spooled batches, oldest first.
+func (e *LokiExporter) spoolFiles() []string {
+	paths, _ := filepath.Glob(filepath.Join(e.config.SpoolDir, "*.json"))
+	sort.Strings(paths)
+	return paths
+}
+
+// spoolFileEntries reads the entry count from a spool file name.
+func spoolFileEntries(path string) int {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	n, _ := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	return n
+}
+
+// spool writes a batch to the spool, dropping it if the spool is full.
+// Called from run and from Push.
+func (e *LokiExporter) spool(request LokiPushRequest, entries int) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		atomic.AddInt64(&e.entriesFailed, int64(entries))
+		return
+	}
+	data = e.config.SpoolCipher.Seal(data)
+	if atomic.LoadInt64(&e.spoolBytes)+int64(len(data)) > e.config.SpoolMaxBytes {
+		log.Printf("WARNING: Loki spool full (%d bytes), dropping %d entries", atomic.LoadInt64(&e.spoolBytes), entries)
+		atomic.AddInt64(&e.entriesDropped, int64(entries))
+		return
+	}
+
+	seq := atomic.AddInt64(&e.spoolSeq, 1)
+	name := fmt.Sprintf("%020d-%06d-%d.json", time.Now().UnixNano(), seq%1000000, entries)
+	if err := writeFileAtomic(filepath.Join(e.config.SpoolDir, name), data); err != nil {
+		log.Printf("WARNING: Loki spool write failed: %v", err)
+		atomic.AddInt64(&e.entriesFailed, int64(entries))
+		return
+	}
+	atomic.AddInt64(&e.spoolBatches, 1)
+	atomic.AddInt64(&e.spoolEntries, int64(entries))
+	atomic.AddInt64(&e.spoolBytes, int64(len(data)))
+}
+
+// spoolOverflow writes entries that overflowed the buffer to the spool.
+func (e *LokiExporter) spoolOverflow() {
+	e.overflowMu.Lock()
+	overflow := e.overflow
+	e.overflow = nil
+	e.overflowMu.Unlock()
+
+	for len(overflow) > 0 {
+		size := min(len(overflow), e.config.BatchSize)
+		e.spool(e.buildPushRequest(overflow[:size]), size)
+		overflow = overflow[size:]
+	}
+}
+
+// replaySpool sends spooled batches oldest first, one attempt each, and
+// stops at the first failure until the next replay. Batches Loki rejects
+// outright are dropped. Loki counts as healthy again only once a replayed
+// batch reached it; an empty spool proves nothing.
+func (e *LokiExporter) replaySpool() {
+	if e.config.SpoolDir == "" {
+		return
+	}
+	for _, path := range e.spoolFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		entries := spoolFileEntries(path)
+
+		plain, err := e.config.SpoolCipher.Open(data)
+		if err != nil {
+			// Sealed under another key, or no key configured: keep it
+			log.Printf("WARNING: can't open Loki spool file %s: %v", filepath.Base(path), err)
+			continue
+		}
+		var request LokiPushRequest
+		if err := json.Unmarshal(plain, &request); err == nil {
+			err := e.doSend(request)
+			var rejected *lokiRejectedError
+			switch {
+			case err == nil:
+				atomic.AddInt64(&e.entriesSent, int64(entries))
+				atomic.AddInt64(&e.batchesSent, 1)
+			case errors.As(err, &rejected):
+				log.Printf("WARNING: %v, discarding Loki spool file %s", err, filepath.Base(path))
+				atomic.AddInt64(&e.entriesFailed, int64(entries))
+			default:
+				e.unhealthy = true
+				return
+			}
+			e.unhealthy = false
+		} else {
+			log.Printf("WARNING: discarding unreadable Loki spool file %s: %v", filepath.Base(path), err)
+			atomic.AddInt64(&e.entriesFailed, int64(entries))
+		}
+
+		os.Remove(path)
+		atomic.AddInt64(&e.spoolBatches, -1)
+		atomic.AddInt64(&e.spoolEntries, -int64(entries))
+		atomic.AddInt64(&e.spoolBytes, -int64(len(data)))
+	}
+}
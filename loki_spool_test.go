@@ -0,0 +1,259 @@
+// loki_spool_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSpoolTestLoki returns a Loki stand-in that counts accepted entries and
+// responds with the status in down while it is set.
+func newSpoolTestLoki(t *testing.T, down *int32, received *int64) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := atomic.LoadInt32(down); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		var req LokiPushRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, s := range req.Streams {
+			atomic.AddInt64(received, int64(len(s.Values)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func spoolTestEntry(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"type":  "request",
+		"seq":   i,
+		"_meta": map[string]interface{}{"ts": time.Now().UTC().Format(time.RFC3339Nano), "machine": "test"},
+	}
+}
+
+func TestLokiSpool_SurvivesOutageAndRestart(t *testing.T) {
+	var down int32 = http.StatusServiceUnavailable
+	var received int64
+	srv := newSpoolTestLoki(t, &down, &received)
+	spoolDir := t.TempDir()
+	cfg := LokiExporterConfig{
+		URL:       srv.URL,
+		BatchSize: 2,
+		RetryMax:  1,
+		RetryWait: time.Millisecond,
+		SpoolDir:  spoolDir,
+	}
+
+	exporter, err := NewLokiExporter(cfg)
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		exporter.Push(spoolTestEntry(i), "anthropic")
+	}
+	exporter.Close()
+
+	stats := exporter.Stats()
+	if stats.SpoolEntries != 5 || stats.EntriesFailed != 0 || stats.EntriesDropped != 0 {
+		t.Fatalf("expected all 5 entries spooled, got %+v", stats)
+	}
+	if files, _ := filepath.Glob(filepath.Join(spoolDir, "*.json")); int64(len(files)) != stats.SpoolBatches {
+		t.Fatalf("expected %d spool files, got %d", stats.SpoolBatches, len(files))
+	}
+
+	// After a restart with Loki back up, the spool is replayed
+	atomic.StoreInt32(&down, 0)
+	exporter, err = NewLokiExporter(cfg)
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	exporter.Close()
+
+	if got := atomic.LoadInt64(&received); got != 5 {
+		t.Errorf("expected Loki to receive 5 replayed entries, got %d", got)
+	}
+	stats = exporter.Stats()
+	if stats.SpoolEntries != 0 || stats.SpoolBytes != 0 || stats.EntriesSent != 5 {
+		t.Errorf("expected an empty spool after replay, got %+v", stats)
+	}
+}
+
+func TestLokiSpool_OverflowIsSpooled(t *testing.T) {
+	var down int32
+	var received int64
+	srv := newSpoolTestLoki(t, &down, &received)
+
+	exporter, err := NewLokiExporter(LokiExporterConfig{
+		URL:        srv.URL,
+		BatchSize:  100,
+		BatchWait:  time.Hour,
+		BufferSize: 1,
+		SpoolDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	// Hold the worker so the channel stays full
+	exporter.entryChan <- lokiEntry{entry: spoolTestEntry(0), provider: "anthropic", timestamp: time.Now(), logType: "request"}
+	exporter.Push(spoolTestEntry(1), "anthropic")
+	exporter.Close()
+
+	stats := exporter.Stats()
+	if stats.EntriesDropped != 0 {
+		t.Errorf("expected no dropped entries with a spool, got %d", stats.EntriesDropped)
+	}
+	if stats.EntriesSent+stats.SpoolEntries != 2 {
+		t.Errorf("expected every entry sent or spooled, got %+v", stats)
+	}
+}
+
+func TestLokiSpool_SizeCap(t *testing.T) {
+	var down int32 = http.StatusServiceUnavailable
+	var received int64
+	srv := newSpoolTestLoki(t, &down, &received)
+	spoolDir := t.TempDir()
+
+	exporter, err := NewLokiExporter(LokiExporterConfig{
+		URL:           srv.URL,
+		RetryMax:      1,
+		RetryWait:     time.Millisecond,
+		SpoolDir:      spoolDir,
+		SpoolMaxBytes: 10,
+	})
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	exporter.Push(spoolTestEntry(1), "anthropic")
+	exporter.Close()
+
+	stats := exporter.Stats()
+	if stats.SpoolEntries != 0 || stats.EntriesDropped != 1 {
+		t.Errorf("expected the batch to be dropped at the size cap, got %+v", stats)
+	}
+	if entries, _ := os.ReadDir(spoolDir); len(entries) != 0 {
+		t.Errorf("expected an empty spool directory, got %d files", len(entries))
+	}
+}
+
+func TestLokiSpool_DropsRejectedBatches(t *testing.T) {
+	var down int32 = http.StatusServiceUnavailable
+	var received int64
+	srv := newSpoolTestLoki(t, &down, &received)
+	spoolDir := t.TempDir()
+	cfg := LokiExporterConfig{
+		URL:       srv.URL,
+		RetryMax:  1,
+		RetryWait: time.Millisecond,
+		SpoolDir:  spoolDir,
+	}
+
+	exporter, err := NewLokiExporter(cfg)
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	exporter.Push(spoolTestEntry(1), "anthropic")
+	exporter.Close()
+	if stats := exporter.Stats(); stats.SpoolEntries != 1 {
+		t.Fatalf("expected the entry spooled during the outage, got %+v", stats)
+	}
+
+	// A spooled batch Loki rejects is discarded on replay, not retried
+	atomic.StoreInt32(&down, http.StatusBadRequest)
+	exporter, err = NewLokiExporter(cfg)
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	exporter.Push(spoolTestEntry(2), "anthropic")
+	exporter.Close()
+
+	stats := exporter.Stats()
+	if stats.EntriesFailed != 2 || stats.SpoolEntries != 0 || stats.EntriesSent != 0 {
+		t.Errorf("expected both entries failed and an empty spool, got %+v", stats)
+	}
+	if files, _ := filepath.Glob(filepath.Join(spoolDir, "*.json")); len(files) != 0 {
+		t.Errorf("expected an empty spool, got %v", files)
+	}
+}
+
+func TestLokiSpool_EmptyReplayKeepsUnhealthy(t *testing.T) {
+	e := &LokiExporter{config: LokiExporterConfig{SpoolDir: t.TempDir()}, unhealthy: true}
+	e.replaySpool()
+	if !e.unhealthy {
+		t.Error("expected an empty replay to leave Loki marked unhealthy")
+	}
+}
+
+func TestLokiSpool_PushSpoolsFullOverflowBatches(t *testing.T) {
+	spoolDir := t.TempDir()
+	// No worker reads entryChan, as when run is blocked in sendBatch
+	e := &LokiExporter{
+		config:    LokiExporterConfig{BatchSize: 2, SpoolDir: spoolDir, SpoolMaxBytes: 1 << 20},
+		entryChan: make(chan lokiEntry),
+	}
+	for i := 1; i <= 5; i++ {
+		e.Push(spoolTestEntry(i), "anthropic")
+	}
+
+	stats := e.Stats()
+	if stats.SpoolEntries != 4 || stats.EntriesDropped != 0 || len(e.overflow) != 1 {
+		t.Errorf("expected 2 full batches spooled and 1 entry held, got %+v with %d held", stats, len(e.overflow))
+	}
+	if files, _ := filepath.Glob(filepath.Join(spoolDir, "*.json")); len(files) != 2 {
+		t.Errorf("expected 2 spool files, got %v", files)
+	}
+}
+
+func TestLokiSpool_SealedWithCipher(t *testing.T) {
+	var down int32 = http.StatusServiceUnavailable
+	var received int64
+	srv := newSpoolTestLoki(t, &down, &received)
+	spoolDir := t.TempDir()
+	cfg := LokiExporterConfig{
+		URL:         srv.URL,
+		RetryMax:    1,
+		RetryWait:   time.Millisecond,
+		SpoolDir:    spoolDir,
+		SpoolCipher: testLogCipher(t, 3),
+	}
+
+	exporter, err := NewLokiExporter(cfg)
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	exporter.Push(spoolTestEntry(1), "anthropic")
+	exporter.Close()
+
+	files, _ := filepath.Glob(filepath.Join(spoolDir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 spool file, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !isSealed(data) || strings.Contains(string(data), "llm-proxy") {
+		t.Errorf("expected a sealed spool file, got %s", data)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	exporter, err = NewLokiExporter(cfg)
+	if err != nil {
+		t.Fatalf("NewLokiExporter failed: %v", err)
+	}
+	exporter.Close()
+	if got := atomic.LoadInt64(&received); got != 1 {
+		t.Errorf("expected the sealed batch to be replayed, got %d entries", got)
+	}
+}
+
+func TestSpoolFileEntries(t *testing.T) {
+	if n := spoolFileEntries("/spool/00000000001736000000-000001-42.json"); n != 42 {
+		t.Errorf("expected 42, got %d", n)
+	}
+}
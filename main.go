@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,6 +14,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -102,6 +104,39 @@ func openBrowser(url string) {
 }
 
 func main() {
+	// Handle subcommands before flag parsing
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := RunReport(os.Args[2:], os.Stdout); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(0)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		if err := RunDecrypt(os.Args[2:], os.Stdout); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(0)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := RunReplay(os.Args[2:], os.Stdout); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(0)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	flags, err := ParseCLIFlags(os.Args[1:])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
@@ -153,6 +188,7 @@ func main() {
 		// Output exports
 		fmt.Printf("export ANTHROPIC_BASE_URL=\"http://localhost:%d/anthropic/api.anthropic.com\"\n", port)
 		fmt.Printf("export OPENAI_BASE_URL=\"http://localhost:%d/openai/api.openai.com\"\n", port)
+		fmt.Printf("export GOOGLE_GEMINI_BASE_URL=\"http://localhost:%d/gemini/generativelanguage.googleapis.com\"\n", port)
 		os.Exit(0)
 	}
 
@@ -167,6 +203,11 @@ func main() {
 
 	// Handle --setup: full Linux installation
 	if cfg.Setup {
+		if cfg.ForwardProxy.Enabled {
+			if err := SetupLocalCA(cfg.ForwardProxy); err != nil {
+				log.Fatalf("Setup failed: %v", err)
+			}
+		}
 		if err := FullSetup(); err != nil {
 			log.Fatalf("Setup failed: %v", err)
 		}
@@ -190,6 +231,11 @@ func main() {
 		}
 
 		explorer := NewExplorer(logDir)
+		logCipher, err := LoadLogCipher(cfg.Encryption)
+		if err != nil {
+			log.Fatalf("Loading encryption key: %v", err)
+		}
+		explorer.SetCipher(logCipher)
 
 		url := fmt.Sprintf("http://localhost:%d", port)
 		log.Printf("Starting LLM Proxy Explorer on %s", url)
@@ -227,11 +273,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Bind to localhost only — the proxy has no authentication, so binding to
-	// all interfaces would allow unauthenticated access if security groups are
-	// misconfigured. In ECS awsvpc mode, localhost is shared between containers
-	// in the same task, so the PA container can still reach the proxy.
-	addr := fmt.Sprintf("localhost:%d", cfg.Port)
+	// Bind to localhost unless proxy auth is configured — without it, binding
+	// to all interfaces would allow unauthenticated access if security groups
+	// are misconfigured. In ECS awsvpc mode, localhost is shared between
+	// containers in the same task, so the PA container can still reach the proxy.
+	if err := ValidateListenAddress(cfg.Listen, cfg.Auth); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	tlsConfig, certs, err := ListenerTLSConfig(cfg.TLS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	addr := net.JoinHostPort(cfg.Listen, strconv.Itoa(cfg.Port))
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error binding to %s: %v\n", addr, err)
@@ -254,6 +309,22 @@ func main() {
 	httpSrv := &http.Server{
 		Handler:           srv,
 		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+
+	// Reload the listener certificate on SIGHUP
+	if certs != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := certs.Reload(); err != nil {
+					log.Printf("WARNING: TLS reload failed, keeping the current certificate: %v", err)
+					continue
+				}
+				log.Printf("TLS: reloaded %s", cfg.TLS.CertFile)
+			}
+		}()
 	}
 
 	// Setup graceful shutdown
@@ -281,7 +352,13 @@ func main() {
 		log.Printf("Loki export: disabled")
 	}
 
-	if err := httpSrv.Serve(listener); err != nil && err != http.ErrServerClosed {
+	if tlsConfig != nil {
+		log.Printf("TLS: enabled (%s)", cfg.TLS.CertFile)
+		err = httpSrv.ServeTLS(listener, "", "")
+	} else {
+		err = httpSrv.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 }
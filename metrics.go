@@ -0,0 +1,229 @@
+// metrics.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics collects proxy counters for the Prometheus /metrics endpoint. The
+// text exposition format is written directly, so no client library is
+// needed. All methods are safe to call on a nil *Metrics.
+//
+// The model label is bounded by the price table (see PriceTable.MetricLabel):
+// models it doesn't know are counted as "other".
+type Metrics struct {
+	models   *PriceTable
+	mu       sync.Mutex
+	requests map[requestMetricKey]int64
+	tokens   map[tokenMetricKey]int64
+	ttfb     map[modelMetricKey]*histogram
+	duration map[modelMetricKey]*histogram
+
+	streamsInFlight int64 // atomic
+}
+
+type modelMetricKey struct {
+	provider string
+	model    string
+}
+
+type requestMetricKey struct {
+	modelMetricKey
+	status string
+}
+
+type tokenMetricKey struct {
+	modelMetricKey
+	tokenType string
+}
+
+// latencyBuckets are histogram upper bounds in seconds. LLM responses run
+// from sub-second to several minutes for long generations.
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type histogram struct {
+	counts []int64 // Per bucket, non-cumulative
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// NewMetrics creates an empty metrics registry that labels models by their
+// entry in models.
+func NewMetrics(models *PriceTable) *Metrics {
+	return &Metrics{
+		models:   models,
+		requests: make(map[requestMetricKey]int64),
+		tokens:   make(map[tokenMetricKey]int64),
+		ttfb:     make(map[modelMetricKey]*histogram),
+		duration: make(map[modelMetricKey]*histogram),
+	}
+}
+
+// ObserveResponse records a completed upstream response.
+func (m *Metrics) ObserveResponse(provider, model string, status int, timing ResponseTiming, usage UsageInfo) {
+	if m == nil {
+		return
+	}
+	key := modelMetricKey{provider: provider, model: m.models.MetricLabel(model)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestMetricKey{key, strconv.Itoa(status)}]++
+	for tokenType, n := range map[string]int{
+		"input":          usage.InputTokens,
+		"output":         usage.OutputTokens,
+		"cache_read":     usage.CacheReadInputTokens,
+		"cache_creation": usage.CacheCreationInputTokens,
+	} {
+		if n > 0 {
+			m.tokens[tokenMetricKey{key, tokenType}] += int64(n)
+		}
+	}
+	m.histogramFor(m.ttfb, key).observe(float64(timing.TTFBMs) / 1000)
+	m.histogramFor(m.duration, key).observe(float64(timing.TotalMs) / 1000)
+}
+
+// ObserveFailure records a request that got no upstream response (a
+// transport error, answered with a 502) without timing it.
+func (m *Metrics) ObserveFailure(provider, model string) {
+	if m == nil {
+		return
+	}
+	key := modelMetricKey{provider: provider, model: m.models.MetricLabel(model)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestMetricKey{key, strconv.Itoa(http.StatusBadGateway)}]++
+}
+
+func (m *Metrics) histogramFor(hs map[modelMetricKey]*histogram, key modelMetricKey) *histogram {
+	h, ok := hs[key]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(latencyBuckets))}
+		hs[key] = h
+	}
+	return h
+}
+
+// StreamStarted and StreamEnded track streaming responses in flight.
+func (m *Metrics) StreamStarted() {
+	if m != nil {
+		atomic.AddInt64(&m.streamsInFlight, 1)
+	}
+}
+
+func (m *Metrics) StreamEnded() {
+	if m != nil {
+		atomic.AddInt64(&m.streamsInFlight, -1)
+	}
+}
+
+// WritePrometheus writes the collected metrics in the text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, "llm_proxy_requests_total", "counter", "Upstream responses by provider, model and status code.")
+	requestKeys := make([]requestMetricKey, 0, len(m.requests))
+	for k := range m.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		return metricKeyLess(a.modelMetricKey, b.modelMetricKey, a.status, b.status)
+	})
+	for _, k := range requestKeys {
+		fmt.Fprintf(w, "llm_proxy_requests_total{%s,status=%s} %d\n", k.labels(), promQuote(k.status), m.requests[k])
+	}
+
+	writeMetricHeader(w, "llm_proxy_tokens_total", "counter", "Tokens by provider, model and type.")
+	tokenKeys := make([]tokenMetricKey, 0, len(m.tokens))
+	for k := range m.tokens {
+		tokenKeys = append(tokenKeys, k)
+	}
+	sort.Slice(tokenKeys, func(i, j int) bool {
+		a, b := tokenKeys[i], tokenKeys[j]
+		return metricKeyLess(a.modelMetricKey, b.modelMetricKey, a.tokenType, b.tokenType)
+	})
+	for _, k := range tokenKeys {
+		fmt.Fprintf(w, "llm_proxy_tokens_total{%s,type=%s} %d\n", k.labels(), promQuote(k.tokenType), m.tokens[k])
+	}
+
+	writeHistograms(w, "llm_proxy_response_ttfb_seconds", "Time to first byte of upstream responses.", m.ttfb)
+	writeHistograms(w, "llm_proxy_response_duration_seconds", "Total duration of upstream responses.", m.duration)
+
+	writeMetric(w, "llm_proxy_streams_in_flight", "gauge", "Streaming responses currently being relayed.", atomic.LoadInt64(&m.streamsInFlight))
+}
+
+func writeHistograms(w io.Writer, name, help string, hs map[modelMetricKey]*histogram) {
+	writeMetricHeader(w, name, "histogram", help)
+	keys := make([]modelMetricKey, 0, len(hs))
+	for k := range hs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return metricKeyLess(keys[i], keys[j], "", "") })
+	for _, k := range keys {
+		h := hs[k]
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, k.labels(), strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, k.labels(), h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, k.labels(), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, k.labels(), h.count)
+	}
+}
+
+func (k modelMetricKey) labels() string {
+	return "provider=" + promQuote(k.provider) + ",model=" + promQuote(k.model)
+}
+
+func metricKeyLess(a, b modelMetricKey, extraA, extraB string) bool {
+	if a.provider != b.provider {
+		return a.provider < b.provider
+	}
+	if a.model != b.model {
+		return a.model < b.model
+	}
+	return extraA < extraB
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeMetric writes a single unlabelled sample.
+func writeMetric(w io.Writer, name, typ, help string, value int64) {
+	writeMetricHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promQuote quotes a label value for the exposition format.
+func promQuote(s string) string {
+	return `"` + promLabelEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,91 @@
+// metrics_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetrics_WritePrometheus(t *testing.T) {
+	m := NewMetrics(NewPriceTable(nil))
+	m.ObserveResponse("anthropic", "claude-sonnet-4", 200, ResponseTiming{TTFBMs: 300, TotalMs: 4000}, UsageInfo{InputTokens: 10, OutputTokens: 5, CacheReadInputTokens: 100})
+	m.ObserveResponse("anthropic", "claude-sonnet-4", 200, ResponseTiming{TTFBMs: 700, TotalMs: 700}, UsageInfo{InputTokens: 1})
+	m.ObserveResponse("openai", "gpt-4o", 429, ResponseTiming{}, UsageInfo{})
+	m.StreamStarted()
+
+	var out strings.Builder
+	m.WritePrometheus(&out)
+	text := out.String()
+
+	for _, want := range []string{
+		"# TYPE llm_proxy_requests_total counter",
+		`llm_proxy_requests_total{provider="anthropic",model="claude-sonnet-4",status="200"} 2`,
+		`llm_proxy_requests_total{provider="openai",model="gpt-4o",status="429"} 1`,
+		`llm_proxy_tokens_total{provider="anthropic",model="claude-sonnet-4",type="input"} 11`,
+		`llm_proxy_tokens_total{provider="anthropic",model="claude-sonnet-4",type="cache_read"} 100`,
+		"# TYPE llm_proxy_response_ttfb_seconds histogram",
+		`llm_proxy_response_ttfb_seconds_bucket{provider="anthropic",model="claude-sonnet-4",le="0.5"} 1`,
+		`llm_proxy_response_ttfb_seconds_bucket{provider="anthropic",model="claude-sonnet-4",le="1"} 2`,
+		`llm_proxy_response_duration_seconds_bucket{provider="anthropic",model="claude-sonnet-4",le="2.5"} 1`,
+		`llm_proxy_response_duration_seconds_bucket{provider="anthropic",model="claude-sonnet-4",le="+Inf"} 2`,
+		`llm_proxy_response_duration_seconds_sum{provider="anthropic",model="claude-sonnet-4"} 4.7`,
+		"llm_proxy_streams_in_flight 1",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in output:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, `type="output"} 0`) {
+		t.Error("expected zero token counts to be omitted")
+	}
+}
+
+func TestMetrics_BoundsModelLabel(t *testing.T) {
+	m := NewMetrics(NewPriceTable(map[string]ModelPrice{"my-local-model": {Input: 1}}))
+	m.ObserveResponse("anthropic", "claude-sonnet-4-5-20250929", 200, ResponseTiming{}, UsageInfo{})
+	m.ObserveResponse("anthropic", "us.anthropic.claude-sonnet-4-20250514-v1:0", 200, ResponseTiming{}, UsageInfo{})
+	m.ObserveResponse("openai", "My-Local-Model", 200, ResponseTiming{}, UsageInfo{})
+	m.ObserveResponse("openai", "client-chosen-name-1", 200, ResponseTiming{}, UsageInfo{})
+	m.ObserveResponse("openai", "client-chosen-name-2", 200, ResponseTiming{}, UsageInfo{})
+	m.ObserveFailure("openai", "")
+
+	var out strings.Builder
+	m.WritePrometheus(&out)
+	text := out.String()
+
+	for _, want := range []string{
+		`llm_proxy_requests_total{provider="anthropic",model="claude-sonnet-4",status="200"} 2`,
+		`llm_proxy_requests_total{provider="openai",model="my-local-model",status="200"} 1`,
+		`llm_proxy_requests_total{provider="openai",model="other",status="200"} 2`,
+		`llm_proxy_requests_total{provider="openai",model="other",status="502"} 1`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in output:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "client-chosen-name") {
+		t.Error("expected unknown models to be labelled other")
+	}
+	if strings.Contains(text, `llm_proxy_response_ttfb_seconds_count{provider="openai",model="other"} 3`) {
+		t.Error("expected failures to be counted without timing")
+	}
+}
+
+func TestMetrics_NilSafe(t *testing.T) {
+	var m *Metrics
+	m.ObserveResponse("anthropic", "x", 200, ResponseTiming{}, UsageInfo{})
+	m.StreamStarted()
+	m.StreamEnded()
+
+	var out strings.Builder
+	m.WritePrometheus(&out)
+	if out.Len() != 0 {
+		t.Errorf("expected no output from a nil registry, got %q", out.String())
+	}
+}
+
+func TestPromQuote(t *testing.T) {
+	if got := promQuote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("unexpected escaping: %s", got)
+	}
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -27,6 +28,14 @@ type AgentEventEmitter interface {
 	EmitTurnEnd(sessionID, provider, machine, stopReason string, isRetry bool, errorType string, patterns PatternData, tokens TokenData)
 	EmitToolCall(sessionID, provider, machine, toolName string, toolIndex int, toolUseID string)
 	EmitToolResult(sessionID, provider, machine, toolName, toolUseID string, isError bool)
+	EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData)
+}
+
+// metaLogger is implemented by file loggers that accept extra _meta fields.
+// *Logger implements this interface.
+type metaLogger interface {
+	LogRequestWithMeta(sessionID, provider string, seq int, method, path string, headers http.Header, body []byte, requestID string, extraMeta map[string]interface{}) error
+	LogResponseWithMeta(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string, costUSD *float64, extraMeta map[string]interface{}) error
 }
 
 // bedrockContext holds per-request Bedrock metadata for Loki labels.
@@ -35,13 +44,20 @@ type bedrockContext struct {
 	modelID   string
 }
 
-// MultiWriter fans out log entries to both a file logger (primary) and a Loki
-// exporter (secondary). File errors are returned to the caller, while Loki
-// errors are logged but don't fail the operation (graceful degradation).
+// MultiWriter fans out log entries to a file logger and a list of sinks (see
+// sinks.go), Loki among them. The file logger has a filter and policy like
+// any sink, by default primary for every entry. Primary errors are returned
+// to the caller, while secondary sink errors are logged but don't fail the
+// operation (graceful degradation).
 type MultiWriter struct {
-	file      ProxyLogger
-	loki      LokiPusher
-	machineID string
+	file       ProxyLogger
+	fileSink   *ConfiguredSink // filter and policy for file; nil = every entry, primary
+	loki       LokiPusher      // Also in sinks; kept for EventEmitter
+	sinks      []*ConfiguredSink
+	machineID  string
+	identities sync.Map  // sessionID -> authenticated user (replaces machineID)
+	redactor   *Redactor // nil = persist bodies verbatim
+	headers    *HeaderObfuscator
 
 	// bedrockContexts stores per-request Bedrock metadata keyed by requestID.
 	// Set by serveBedrock before logging; consumed by LogRequest/LogResponse.
@@ -51,21 +67,94 @@ type MultiWriter struct {
 // NewMultiWriter creates a new MultiWriter that writes to both the file logger
 // and the Loki exporter. The loki parameter may be nil for file-only logging.
 func NewMultiWriter(file ProxyLogger, loki LokiPusher) *MultiWriter {
-	return &MultiWriter{
+	m := &MultiWriter{
 		file:      file,
 		loki:      loki,
 		machineID: getMachineIDForMultiWriter(),
 	}
+	if loki != nil {
+		m.AddSink(pusherSink("loki", loki))
+	}
+	return m
 }
 
 // NewMultiWriterWithCloseOrder is used for testing to track close order.
 // In production, use NewMultiWriter instead.
 func NewMultiWriterWithCloseOrder(file ProxyLogger, loki LokiPusher, closeOrder *[]string) *MultiWriter {
-	return &MultiWriter{
-		file:      file,
-		loki:      loki,
-		machineID: getMachineIDForMultiWriter(),
+	return NewMultiWriter(file, loki)
+}
+
+// AddSink adds a sink. Sinks receive entries in the order they were added.
+func (m *MultiWriter) AddSink(sink *ConfiguredSink) {
+	m.sinks = append(m.sinks, sink)
+}
+
+// SetFileSink sets the filter and policy of the file logger.
+func (m *MultiWriter) SetFileSink(sink *ConfiguredSink) {
+	m.fileSink = sink
+}
+
+// logFile runs write if the file filter accepts the entry. Under the
+// secondary policy its error is only logged.
+func (m *MultiWriter) logFile(entryType, provider string, write func() error) error {
+	if m.fileSink == nil {
+		return write()
+	}
+	if !m.fileSink.accepts(entryType, provider) {
+		return nil
+	}
+	err := write()
+	if err != nil && !m.fileSink.Primary {
+		log.Printf("WARNING: sink %s write failed: %v", m.fileSink.Name, err)
+		return nil
+	}
+	return err
+}
+
+// remote reports whether any sink is configured, so entries are only built
+// when something will receive them.
+func (m *MultiWriter) remote() bool {
+	return len(m.sinks) > 0
+}
+
+// push sends an entry to every sink whose filter accepts it. The first
+// primary sink error is returned; secondary sink errors are only logged.
+func (m *MultiWriter) push(entry map[string]interface{}, provider string) error {
+	entryType, _ := entry["type"].(string)
+	var primaryErr error
+	for _, s := range m.sinks {
+		if !s.accepts(entryType, provider) {
+			continue
+		}
+		if err := s.Sink.Write(entry, provider); err != nil {
+			if !s.Primary {
+				log.Printf("WARNING: sink %s write failed: %v", s.Name, err)
+			} else if primaryErr == nil {
+				primaryErr = fmt.Errorf("sink %s: %w", s.Name, err)
+			}
+		}
+	}
+	return primaryErr
+}
+
+// SetRedactor sets the redaction pipeline applied to bodies and stream
+// chunks before they reach either destination.
+func (m *MultiWriter) SetRedactor(r *Redactor) {
+	m.redactor = r
+}
+
+// SetHeaderObfuscator sets which headers are masked in Loki entries. The
+// file logger is configured separately since it obfuscates its own entries.
+func (m *MultiWriter) SetHeaderObfuscator(o *HeaderObfuscator) {
+	m.headers = o
+}
+
+// redactionMeta returns the _meta fields recording redaction counts, or nil.
+func redactionMeta(counts map[string]int) map[string]interface{} {
+	if len(counts) == 0 {
+		return nil
 	}
+	return map[string]interface{}{"redactions": counts}
 }
 
 // SetBedrockContext stores Bedrock metadata for a request, so LogRequest
@@ -128,107 +217,163 @@ func (m *MultiWriter) RegisterUpstream(sessionID, upstream string) {
 	m.file.RegisterUpstream(sessionID, upstream)
 }
 
-// LogSessionStart logs a session start to both destinations.
-// File errors are returned; Loki errors are logged but don't fail.
+// RegisterIdentity records the authenticated user a session belongs to, for
+// the file and the sinks.
+func (m *MultiWriter) RegisterIdentity(sessionID, identity string) {
+	m.identities.Store(sessionID, identity)
+	m.file.RegisterIdentity(sessionID, identity)
+}
+
+// machineFor returns the machine recorded in a session's _meta.
+func (m *MultiWriter) machineFor(sessionID string) string {
+	if identity, ok := m.identities.Load(sessionID); ok {
+		return identity.(string)
+	}
+	return m.machineID
+}
+
+// LogSessionStart logs a session start to the file and the sinks.
+// File and primary sink errors are returned; secondary sink errors are logged.
 func (m *MultiWriter) LogSessionStart(sessionID, provider, upstream string) error {
-	err := m.file.LogSessionStart(sessionID, provider, upstream)
+	err := m.logFile("session_start", provider, func() error {
+		return m.file.LogSessionStart(sessionID, provider, upstream)
+	})
 
-	if m.loki != nil {
+	if m.remote() {
 		entry := map[string]interface{}{
 			"type":     "session_start",
 			"provider": provider,
 			"upstream": upstream,
 			"_meta": map[string]interface{}{
 				"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-				"machine": m.machineID,
+				"machine": m.machineFor(sessionID),
 				"host":    upstream,
 				"session": sessionID,
 			},
 		}
-		m.loki.Push(entry, provider)
+		if pushErr := m.push(entry, provider); err == nil {
+			err = pushErr
+		}
 	}
 
 	return err
 }
 
-// LogRequest logs a request to both destinations.
-// File errors are returned; Loki errors are logged but don't fail.
+// LogRequest logs a request to the file and the sinks.
+// File and primary sink errors are returned; secondary sink errors are logged.
 func (m *MultiWriter) LogRequest(sessionID, provider string, seq int, method, path string, headers http.Header, body []byte, requestID string) error {
-	err := m.file.LogRequest(sessionID, provider, seq, method, path, headers, body, requestID)
+	// Compute SHA256 of raw request body for deterministic replay verification
+	bodyHash := sha256.Sum256(body)
+	bodySHA := hex.EncodeToString(bodyHash[:])
+
+	body, counts := m.redactor.RedactBody(body)
+	extraMeta := redactionMeta(counts)
 
-	if m.loki != nil {
-		// Compute SHA256 of raw request body for deterministic replay verification
-		bodyHash := sha256.Sum256(body)
-		bodySHA := hex.EncodeToString(bodyHash[:])
+	err := m.logFile("request", provider, func() error {
+		if ml, ok := m.file.(metaLogger); ok && extraMeta != nil {
+			return ml.LogRequestWithMeta(sessionID, provider, seq, method, path, headers, body, requestID, extraMeta)
+		}
+		return m.file.LogRequest(sessionID, provider, seq, method, path, headers, body, requestID)
+	})
 
+	if m.remote() {
 		meta := map[string]interface{}{
 			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    m.machineID,
+			"machine":    m.machineFor(sessionID),
 			"session":    sessionID,
 			"request_id": requestID,
 		}
 		addBedrockMeta(meta, path)
+		for k, v := range extraMeta {
+			meta[k] = v
+		}
 
 		entry := map[string]interface{}{
 			"type":        "request",
 			"seq":         seq,
 			"method":      method,
 			"path":        path,
-			"headers":     ObfuscateHeaders(headers),
+			"headers":     m.headers.Obfuscate(headers),
 			"body":        string(body),
 			"size":        len(body),
 			"request_sha": bodySHA,
 			"_meta":       meta,
 		}
-		m.loki.Push(entry, provider)
+		if pushErr := m.push(entry, provider); err == nil {
+			err = pushErr
+		}
 	}
 
 	return err
 }
 
-// LogResponse logs a response to both destinations.
-// File errors are returned; Loki errors are logged but don't fail.
-func (m *MultiWriter) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string) error {
-	err := m.file.LogResponse(sessionID, provider, seq, status, headers, body, chunks, timing, requestID)
+// LogResponse logs a response to the file and the sinks.
+// File and primary sink errors are returned; secondary sink errors are logged.
+func (m *MultiWriter) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string, costUSD *float64) error {
+	var counts map[string]int
+	if chunks != nil {
+		chunks, counts = m.redactor.RedactChunks(chunks)
+	} else {
+		body, counts = m.redactor.RedactBody(body)
+	}
+	extraMeta := redactionMeta(counts)
+
+	err := m.logFile("response", provider, func() error {
+		if ml, ok := m.file.(metaLogger); ok && extraMeta != nil {
+			return ml.LogResponseWithMeta(sessionID, provider, seq, status, headers, body, chunks, timing, requestID, costUSD, extraMeta)
+		}
+		return m.file.LogResponse(sessionID, provider, seq, status, headers, body, chunks, timing, requestID, costUSD)
+	})
 
-	if m.loki != nil {
+	if m.remote() {
 		meta := map[string]interface{}{
 			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    m.machineID,
+			"machine":    m.machineFor(sessionID),
 			"session":    sessionID,
 			"request_id": requestID,
 		}
 		// Add Bedrock metadata if this request was a Bedrock pass-through
 		m.addBedrockMetaByRequestID(meta, requestID)
+		for k, v := range extraMeta {
+			meta[k] = v
+		}
 
 		entry := map[string]interface{}{
 			"type":    "response",
 			"seq":     seq,
 			"status":  status,
-			"headers": headers,
+			"headers": m.headers.Obfuscate(headers),
 			"timing":  timing,
 			"size":    len(body),
 			"_meta":   meta,
 		}
 
+		if costUSD != nil {
+			entry["cost_usd"] = *costUSD
+		}
+
 		if chunks != nil {
 			entry["chunks"] = chunks
 		} else {
 			entry["body"] = string(body)
 		}
 
-		m.loki.Push(entry, provider)
+		if pushErr := m.push(entry, provider); err == nil {
+			err = pushErr
+		}
 	}
 
 	return err
 }
 
-// LogFork logs a fork event to both destinations.
-// File errors are returned; Loki errors are logged but don't fail.
+// LogFork logs a fork event to the file and the sinks.
+// File and primary sink errors are returned; secondary sink errors are logged.
 func (m *MultiWriter) LogFork(sessionID, provider string, fromSeq int, parentSession string) error {
-	err := m.file.LogFork(sessionID, provider, fromSeq, parentSession)
+	err := m.logFile("fork", provider, func() error {
+		return m.file.LogFork(sessionID, provider, fromSeq, parentSession)
+	})
 
-	if m.loki != nil {
+	if m.remote() {
 		entry := map[string]interface{}{
 			"type":           "fork",
 			"from_seq":       fromSeq,
@@ -236,24 +381,26 @@ func (m *MultiWriter) LogFork(sessionID, provider string, fromSeq int, parentSes
 			"reason":         "message_history_diverged",
 			"_meta": map[string]interface{}{
 				"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-				"machine": m.machineID,
+				"machine": m.machineFor(sessionID),
 				"session": sessionID,
 			},
 		}
-		m.loki.Push(entry, provider)
+		if pushErr := m.push(entry, provider); err == nil {
+			err = pushErr
+		}
 	}
 
 	return err
 }
 
-// Close flushes Loki first (to ensure all buffered entries are sent),
+// Close flushes the sinks first (to ensure all buffered entries are sent),
 // then closes the file logger. This order ensures no log entries are lost.
 func (m *MultiWriter) Close() error {
-	// Close Loki first to flush buffered entries
-	if m.loki != nil {
-		if err := m.loki.Close(); err != nil {
+	// Close sinks first to flush buffered entries
+	for _, s := range m.sinks {
+		if err := s.Sink.Close(); err != nil {
 			// Log but don't fail - graceful degradation
-			log.Printf("WARNING: Loki close failed: %v", err)
+			log.Printf("WARNING: sink %s close failed: %v", s.Name, err)
 		}
 	}
 
@@ -261,16 +408,122 @@ func (m *MultiWriter) Close() error {
 	return m.file.Close()
 }
 
-// EventEmitter returns the AgentEventEmitter for agent observability events.
-// Returns nil if Loki is not configured.
+// EventEmitter returns the AgentEventEmitter for agent observability events:
+// every sink that emits events (Loki, webhooks). A sink's providers filter
+// applies to its events too; its types filter only covers log entries.
+// Returns nil if none is configured.
 func (m *MultiWriter) EventEmitter() AgentEventEmitter {
-	if emitter, ok := m.loki.(AgentEventEmitter); ok {
-		return emitter
+	var emitters eventEmitters
+	for _, s := range m.sinks {
+		if a, ok := s.Sink.(pusherAdapter); ok {
+			if emitter, ok := a.LokiPusher.(AgentEventEmitter); ok {
+				if s.providers != nil {
+					emitter = providerFilteredEmitter{emitter, s.providers}
+				}
+				emitters = append(emitters, emitter)
+			}
+		}
+	}
+	switch len(emitters) {
+	case 0:
+		return nil
+	case 1:
+		return emitters[0]
+	}
+	return emitters
+}
+
+// budgetEventEmitter is implemented by emitters that report requests rejected
+// by a budget. *WebhookNotifier implements this interface.
+type budgetEventEmitter interface {
+	EmitBudgetExceeded(sessionID, provider, machine string, exceeded *BudgetExceededError)
+}
+
+// eventEmitters fans agent events out to several emitters.
+type eventEmitters []AgentEventEmitter
+
+func (es eventEmitters) EmitTurnStart(sessionID, provider, machine string, turnDepth int, errorRecovered bool) {
+	for _, e := range es {
+		e.EmitTurnStart(sessionID, provider, machine, turnDepth, errorRecovered)
+	}
+}
+
+func (es eventEmitters) EmitTurnEnd(sessionID, provider, machine, stopReason string, isRetry bool, errorType string, patterns PatternData, tokens TokenData) {
+	for _, e := range es {
+		e.EmitTurnEnd(sessionID, provider, machine, stopReason, isRetry, errorType, patterns, tokens)
+	}
+}
+
+func (es eventEmitters) EmitToolCall(sessionID, provider, machine, toolName string, toolIndex int, toolUseID string) {
+	for _, e := range es {
+		e.EmitToolCall(sessionID, provider, machine, toolName, toolIndex, toolUseID)
+	}
+}
+
+func (es eventEmitters) EmitToolResult(sessionID, provider, machine, toolName, toolUseID string, isError bool) {
+	for _, e := range es {
+		e.EmitToolResult(sessionID, provider, machine, toolName, toolUseID, isError)
+	}
+}
+
+func (es eventEmitters) EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData) {
+	for _, e := range es {
+		e.EmitAnomaly(sessionID, provider, machine, anomaly)
+	}
+}
+
+func (es eventEmitters) EmitBudgetExceeded(sessionID, provider, machine string, exceeded *BudgetExceededError) {
+	for _, e := range es {
+		if be, ok := e.(budgetEventEmitter); ok {
+			be.EmitBudgetExceeded(sessionID, provider, machine, exceeded)
+		}
 	}
-	return nil
 }
 
 // MachineID returns the machine identifier used in log metadata.
 func (m *MultiWriter) MachineID() string {
 	return m.machineID
 }
+
+// providerFilteredEmitter drops events for providers outside a sink's
+// providers filter.
+type providerFilteredEmitter struct {
+	emitter   AgentEventEmitter
+	providers map[string]bool
+}
+
+func (f providerFilteredEmitter) EmitTurnStart(sessionID, provider, machine string, turnDepth int, errorRecovered bool) {
+	if f.providers[provider] {
+		f.emitter.EmitTurnStart(sessionID, provider, machine, turnDepth, errorRecovered)
+	}
+}
+
+func (f providerFilteredEmitter) EmitTurnEnd(sessionID, provider, machine, stopReason string, isRetry bool, errorType string, patterns PatternData, tokens TokenData) {
+	if f.providers[provider] {
+		f.emitter.EmitTurnEnd(sessionID, provider, machine, stopReason, isRetry, errorType, patterns, tokens)
+	}
+}
+
+func (f providerFilteredEmitter) EmitToolCall(sessionID, provider, machine, toolName string, toolIndex int, toolUseID string) {
+	if f.providers[provider] {
+		f.emitter.EmitToolCall(sessionID, provider, machine, toolName, toolIndex, toolUseID)
+	}
+}
+
+func (f providerFilteredEmitter) EmitToolResult(sessionID, provider, machine, toolName, toolUseID string, isError bool) {
+	if f.providers[provider] {
+		f.emitter.EmitToolResult(sessionID, provider, machine, toolName, toolUseID, isError)
+	}
+}
+
+func (f providerFilteredEmitter) EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData) {
+	if f.providers[provider] {
+		f.emitter.EmitAnomaly(sessionID, provider, machine, anomaly)
+	}
+}
+
+func (f providerFilteredEmitter) EmitBudgetExceeded(sessionID, provider, machine string, exceeded *BudgetExceededError) {
+	if be, ok := f.emitter.(budgetEventEmitter); ok && f.providers[provider] {
+		be.EmitBudgetExceeded(sessionID, provider, machine, exceeded)
+	}
+}
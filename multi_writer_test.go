@@ -80,6 +80,8 @@ func (m *mockFileLogger) RegisterUpstream(sessionID, upstream string) {
 	m.registerUpstreamCalls = append(m.registerUpstreamCalls, registerUpstreamCall{sessionID, upstream})
 }
 
+func (m *mockFileLogger) RegisterIdentity(sessionID, identity string) {}
+
 func (m *mockFileLogger) LogSessionStart(sessionID, provider, upstream string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -94,7 +96,7 @@ func (m *mockFileLogger) LogRequest(sessionID, provider string, seq int, method,
 	return m.requestError
 }
 
-func (m *mockFileLogger) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string) error {
+func (m *mockFileLogger) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string, costUSD *float64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.responseCalls = append(m.responseCalls, responseCall{sessionID, provider, seq, status, headers, body, chunks, timing, requestID})
@@ -270,7 +272,7 @@ func TestMultiWriter_LogResponse_BothCalled(t *testing.T) {
 	timing := ResponseTiming{TTFBMs: 100, TotalMs: 200}
 	requestID := "req-123"
 
-	err := mw.LogResponse(sessionID, provider, seq, status, headers, body, nil, timing, requestID)
+	err := mw.LogResponse(sessionID, provider, seq, status, headers, body, nil, timing, requestID, nil)
 	if err != nil {
 		t.Fatalf("LogResponse returned error: %v", err)
 	}
@@ -365,7 +367,7 @@ func TestMultiWriter_NilLoki_NoError(t *testing.T) {
 		t.Errorf("LogRequest with nil Loki returned error: %v", err)
 	}
 
-	err = mw.LogResponse(sessionID, provider, 1, 200, nil, []byte(`{}`), nil, ResponseTiming{}, "req-1")
+	err = mw.LogResponse(sessionID, provider, 1, 200, nil, []byte(`{}`), nil, ResponseTiming{}, "req-1", nil)
 	if err != nil {
 		t.Errorf("LogResponse with nil Loki returned error: %v", err)
 	}
@@ -427,7 +429,7 @@ func TestMultiWriter_FileError_Returned(t *testing.T) {
 
 	// Test LogResponse error propagation
 	fileLogger.responseError = expectedErr
-	err = mw.LogResponse(sessionID, provider, 1, 200, nil, []byte(`{}`), nil, ResponseTiming{}, "req-1")
+	err = mw.LogResponse(sessionID, provider, 1, 200, nil, []byte(`{}`), nil, ResponseTiming{}, "req-1", nil)
 	if err != expectedErr {
 		t.Errorf("LogResponse: expected error %v, got %v", expectedErr, err)
 	}
@@ -542,7 +544,7 @@ func TestMultiWriter_LogResponse_WithChunks(t *testing.T) {
 	timing := ResponseTiming{TTFBMs: 50, TotalMs: 150}
 	requestID := "req-123"
 
-	err := mw.LogResponse(sessionID, provider, seq, status, headers, nil, chunks, timing, requestID)
+	err := mw.LogResponse(sessionID, provider, seq, status, headers, nil, chunks, timing, requestID, nil)
 	if err != nil {
 		t.Fatalf("LogResponse returned error: %v", err)
 	}
@@ -619,3 +621,30 @@ func computeSHA256(data []byte) string {
 	h := sha256.Sum256(data)
 	return hex.EncodeToString(h[:])
 }
+
+func TestMultiWriter_AddSink_ReceivesEntries(t *testing.T) {
+	closeOrder := []string{}
+	sink := newMockLokiExporter(&closeOrder)
+
+	// A sink alone (no Loki) still receives every entry
+	mw := NewMultiWriter(newMockFileLogger(), nil)
+	mw.AddSink(pusherSink("otlp", sink))
+
+	mw.LogSessionStart("test-session-123", "anthropic", "api.anthropic.com")
+	mw.LogRequest("test-session-123", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{}`), "req-1")
+	mw.LogResponse("test-session-123", "anthropic", 1, 200, nil, []byte(`{}`), nil, ResponseTiming{}, "req-1", nil)
+
+	if len(sink.pushCalls) != 3 {
+		t.Fatalf("Expected 3 pushes to the sink, got %d", len(sink.pushCalls))
+	}
+	if sink.pushCalls[1].entry["type"] != "request" {
+		t.Errorf("Expected second entry to be a request, got %v", sink.pushCalls[1].entry["type"])
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if sink.closeCalls != 1 {
+		t.Errorf("Expected sink to be closed once, got %d", sink.closeCalls)
+	}
+}
@@ -52,15 +52,61 @@ func extractPrefix(key string) string {
 	return ""
 }
 
-// ObfuscateHeaders returns a copy of headers with API keys obfuscated
+// defaultSensitiveHeaders are always obfuscated, in request and response
+// headers alike. Names are lowercase.
+var defaultSensitiveHeaders = []string{
+	"authorization",
+	"proxy-authorization",
+	"x-api-key",
+	"api-key",        // Azure OpenAI
+	"x-goog-api-key", // Gemini
+	"openai-organization",
+	"openai-project",
+	"chatgpt-account-id",
+	"cookie",
+	"set-cookie",
+	"x-amz-security-token",
+}
+
+// HeaderObfuscator masks the values of sensitive headers before logging.
+type HeaderObfuscator struct {
+	names map[string]bool
+}
+
+// NewHeaderObfuscator covers the default sensitive headers plus extra
+// (e.g. custom gateway auth headers). Names are case-insensitive.
+func NewHeaderObfuscator(extra []string) *HeaderObfuscator {
+	o := &HeaderObfuscator{names: make(map[string]bool)}
+	for _, name := range defaultSensitiveHeaders {
+		o.names[name] = true
+	}
+	for _, name := range extra {
+		o.names[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return o
+}
+
+var defaultHeaderObfuscator = NewHeaderObfuscator(nil)
+
+// ObfuscateHeaders returns a copy of headers with the default sensitive
+// headers obfuscated
 func ObfuscateHeaders(headers http.Header) http.Header {
+	return defaultHeaderObfuscator.Obfuscate(headers)
+}
+
+// Obfuscate returns a copy of headers with sensitive values obfuscated. A nil
+// *HeaderObfuscator uses the defaults.
+func (o *HeaderObfuscator) Obfuscate(headers http.Header) http.Header {
+	if o == nil {
+		o = defaultHeaderObfuscator
+	}
 	result := make(http.Header)
 
 	for key, values := range headers {
 		newValues := make([]string, len(values))
 		for i, v := range values {
-			if isAPIKeyHeader(key) {
-				newValues[i] = obfuscateHeaderValue(v)
+			if o.names[strings.ToLower(key)] {
+				newValues[i] = obfuscateHeaderValue(key, v)
 			} else {
 				newValues[i] = v
 			}
@@ -71,12 +117,41 @@ func ObfuscateHeaders(headers http.Header) http.Header {
 	return result
 }
 
+// Sensitive reports whether the named header is obfuscated. A nil
+// *HeaderObfuscator uses the defaults.
+func (o *HeaderObfuscator) Sensitive(name string) bool {
+	if o == nil {
+		o = defaultHeaderObfuscator
+	}
+	return o.names[strings.ToLower(name)]
+}
+
 func isAPIKeyHeader(name string) bool {
 	lower := strings.ToLower(name)
 	return lower == "x-api-key" || lower == "authorization"
 }
 
-func obfuscateHeaderValue(value string) string {
+func obfuscateHeaderValue(name, value string) string {
+	switch strings.ToLower(name) {
+	case "cookie":
+		return obfuscateCookies(value)
+	case "set-cookie":
+		// Only the first pair is the cookie; attributes like Path aren't secret
+		cookie, attrs, _ := strings.Cut(value, ";")
+		if attrs != "" {
+			return obfuscateCookies(cookie) + ";" + attrs
+		}
+		return obfuscateCookies(cookie)
+	}
+
+	if !isAPIKeyHeader(name) {
+		// Other headers don't carry recognizable key prefixes worth keeping
+		if scheme, token, ok := strings.Cut(value, " "); ok && isAuthScheme(scheme) {
+			return scheme + " " + maskSecret(token)
+		}
+		return maskSecret(value)
+	}
+
 	// Handle "Bearer <token>" format
 	if strings.HasPrefix(value, "Bearer ") {
 		token := strings.TrimPrefix(value, "Bearer ")
@@ -84,3 +159,34 @@ func obfuscateHeaderValue(value string) string {
 	}
 	return ObfuscateAPIKey(value)
 }
+
+func isAuthScheme(scheme string) bool {
+	switch strings.ToLower(scheme) {
+	case "bearer", "basic", "token":
+		return true
+	}
+	return false
+}
+
+// maskSecret keeps only the last 4 characters of long values.
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) > 12 {
+		return "..." + value[len(value)-4:]
+	}
+	return "..."
+}
+
+// obfuscateCookies masks every value in a "name=value; name2=value2" list.
+func obfuscateCookies(value string) string {
+	pairs := strings.Split(value, ";")
+	for i, pair := range pairs {
+		name, _, ok := strings.Cut(pair, "=")
+		if ok {
+			pairs[i] = name + "=..."
+		}
+	}
+	return strings.Join(pairs, ";")
+}
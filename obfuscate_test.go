@@ -3,6 +3,7 @@ package main
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -64,3 +65,57 @@ func TestObfuscateHeaders(t *testing.T) {
 		t.Error("Content-Type should not be modified")
 	}
 }
+
+func TestObfuscateHeadersSensitiveDefaults(t *testing.T) {
+	headers := http.Header{
+		"Openai-Organization":  []string{"org-AbCdEfGhIjKlMnOp"},
+		"Chatgpt-Account-Id":   []string{"5f1c2d3e-aaaa-bbbb-cccc-1234567890ab"},
+		"X-Amz-Security-Token": []string{"IQoJb3JpZ2luX2VjEHQaCXVzLWVhc3QtMSJH"},
+		"X-Goog-Api-Key":       []string{"AIzaSyA-1234567890abcdefghijklmnop"},
+		"Cookie":               []string{"__Secure-next-auth.session-token=eyJhbGciOi; _cfuvid=abc123"},
+		"Set-Cookie":           []string{"__cf_bm=secretvalue123; path=/; HttpOnly"},
+		"Content-Type":         []string{"application/json"},
+	}
+
+	result := ObfuscateHeaders(headers)
+
+	for name, raw := range map[string]string{
+		"Openai-Organization":  "AbCdEfGhIjKl",
+		"Chatgpt-Account-Id":   "5f1c2d3e",
+		"X-Amz-Security-Token": "IQoJb3JpZ2lu",
+		"X-Goog-Api-Key":       "AIzaSyA-1234",
+	} {
+		if got := result.Get(name); got == headers.Get(name) || strings.Contains(got, raw) {
+			t.Errorf("%s not obfuscated: %q", name, got)
+		}
+	}
+	if got := result.Get("Cookie"); got != "__Secure-next-auth.session-token=...; _cfuvid=..." {
+		t.Errorf("Cookie values not masked: %q", got)
+	}
+	if got := result.Get("Set-Cookie"); got != "__cf_bm=...; path=/; HttpOnly" {
+		t.Errorf("Set-Cookie value not masked: %q", got)
+	}
+	if result.Get("Content-Type") != "application/json" {
+		t.Error("Content-Type should not be modified")
+	}
+}
+
+func TestHeaderObfuscatorCustomHeaders(t *testing.T) {
+	o := NewHeaderObfuscator([]string{"X-Portkey-Api-Key", "helicone-auth"})
+	headers := http.Header{
+		"X-Portkey-Api-Key": []string{"pk-live-0123456789abcdef"},
+		"Helicone-Auth":     []string{"Bearer sk-helicone-0123456789abcdef"},
+	}
+
+	result := o.Obfuscate(headers)
+
+	if got := result.Get("X-Portkey-Api-Key"); got != "...cdef" {
+		t.Errorf("custom header not obfuscated: %q", got)
+	}
+	if got := result.Get("Helicone-Auth"); got != "Bearer ...cdef" {
+		t.Errorf("custom bearer header not obfuscated: %q", got)
+	}
+	if got := ObfuscateHeaders(headers).Get("X-Portkey-Api-Key"); got != "pk-live-0123456789abcdef" {
+		t.Errorf("custom headers should not change the defaults, got %q", got)
+	}
+}
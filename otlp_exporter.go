@@ -0,0 +1,668 @@
+// otlp_exporter.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The OTLP exporter turns log entries into OpenTelemetry traces using the
+// GenAI semantic conventions: each session is a trace, each request/response
+// turn a "chat" span, and each tool call an "execute_tool" child span that
+// runs from the response requesting it to the request carrying its result.
+// Spans are sent as OTLP/HTTP JSON, so no collector SDK is needed.
+
+// OTLP span kinds and status codes (opentelemetry-proto trace.proto).
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindClient   = 3
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+// otlpPendingTTL bounds how long an unanswered request or tool call is kept
+// waiting for its response or result.
+const otlpPendingTTL = time.Hour
+
+// OTLPConfig configures the OpenTelemetry trace exporter.
+type OTLPConfig struct {
+	Enabled      bool              `toml:"enabled"`
+	Endpoint     string            `toml:"endpoint"`     // OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces
+	Headers      map[string]string `toml:"headers"`      // Extra request headers, e.g. for auth
+	ServiceName  string            `toml:"service_name"` // Resource service.name (default "llm-proxy")
+	BatchSize    int               `toml:"batch_size"`   // Spans per export request
+	BatchWaitStr string            `toml:"batch_wait"`   // Duration string for batch timeout
+	RetryMax     int               `toml:"retry_max"`    // Maximum retry attempts
+}
+
+// OTLPExporterConfig holds configuration for the OTLP exporter
+type OTLPExporterConfig struct {
+	Endpoint        string
+	Headers         map[string]string
+	ServiceName     string
+	BatchSize       int           // Number of spans per batch
+	BatchWait       time.Duration // Duration to wait before flushing batch
+	RetryMax        int           // Maximum retry attempts
+	RetryWait       time.Duration // Base delay between retries
+	BufferSize      int           // Channel buffer size
+	ShutdownTimeout time.Duration // Timeout for graceful shutdown
+}
+
+// OTLPExporterStats holds statistics about the exporter's operation
+type OTLPExporterStats struct {
+	SpansSent      int64
+	SpansFailed    int64
+	EntriesDropped int64
+	BatchesSent    int64
+}
+
+// OTLP/HTTP JSON payload (opentelemetry-proto, JSON mapping). IDs are hex
+// and 64-bit integers are decimal strings.
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) otlpKeyValue {
+	s := strconv.FormatInt(value, 10)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+func otlpDouble(key string, value float64) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{DoubleValue: &value}}
+}
+
+func otlpStrings(key string, values []string) otlpKeyValue {
+	arr := &otlpArrayValue{Values: make([]otlpAnyValue, len(values))}
+	for i := range values {
+		arr.Values[i] = otlpAnyValue{StringValue: &values[i]}
+	}
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{ArrayValue: arr}}
+}
+
+// otlpID derives a stable hex ID of n bytes, so a session keeps its trace
+// across restarts and retried exports don't duplicate spans.
+func otlpID(n int, parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:n])
+}
+
+func otlpTraceID(sessionID string) string {
+	return otlpID(16, "session", sessionID)
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpEntry is an internal struct for queued entries
+type otlpEntry struct {
+	entry    map[string]interface{}
+	provider string
+}
+
+// otlpTurn is a request waiting for its response.
+type otlpTurn struct {
+	sessionID string
+	provider  string
+	seq       int
+	start     time.Time
+	model     string
+	attrs     []otlpKeyValue // Request attributes
+}
+
+// otlpToolCall is a tool call waiting for its result.
+type otlpToolCall struct {
+	traceID      string
+	spanID       string
+	parentSpanID string
+	name         string
+	start        time.Time
+}
+
+// OTLPExporter handles async batching and exporting spans over OTLP/HTTP
+type OTLPExporter struct {
+	config     OTLPExporterConfig
+	client     *http.Client
+	entryChan  chan otlpEntry
+	closeChan  chan struct{}
+	closedChan chan struct{}
+	closeOnce  sync.Once
+
+	// Span assembly state, owned by the run goroutine
+	turns     map[string]*otlpTurn     // requestID -> open turn
+	toolCalls map[string]*otlpToolCall // sessionID + tool_use_id -> open tool call
+	upstreams map[string]string        // sessionID -> upstream host
+
+	// Stats counters (accessed atomically)
+	spansSent      int64
+	spansFailed    int64
+	entriesDropped int64
+	batchesSent    int64
+}
+
+// NewOTLPExporter creates a new OTLPExporter with the given configuration
+func NewOTLPExporter(cfg OTLPExporterConfig) (*OTLPExporter, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("OTLPExporter: endpoint is required")
+	}
+
+	// Apply defaults
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "llm-proxy"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = 5 * time.Second
+	}
+	if cfg.RetryMax <= 0 {
+		cfg.RetryMax = 5
+	}
+	if cfg.RetryWait <= 0 {
+		cfg.RetryWait = 100 * time.Millisecond
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+
+	exporter := &OTLPExporter{
+		config:     cfg,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		entryChan:  make(chan otlpEntry, cfg.BufferSize),
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+		turns:      make(map[string]*otlpTurn),
+		toolCalls:  make(map[string]*otlpToolCall),
+		upstreams:  make(map[string]string),
+	}
+
+	go exporter.run()
+
+	return exporter, nil
+}
+
+// newOTLPExporterFromConfig creates the exporter for cfg, or returns nil
+// when it is disabled or can't be created (graceful degradation, as for Loki).
+func newOTLPExporterFromConfig(cfg OTLPConfig) *OTLPExporter {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Endpoint == "" {
+		log.Printf("WARNING: OTLP enabled but endpoint is empty, continuing without OTLP")
+		return nil
+	}
+
+	exporterCfg := OTLPExporterConfig{
+		Endpoint:    cfg.Endpoint,
+		Headers:     cfg.Headers,
+		ServiceName: cfg.ServiceName,
+		BatchSize:   cfg.BatchSize,
+		RetryMax:    cfg.RetryMax,
+	}
+	if cfg.BatchWaitStr != "" {
+		if batchWait, err := time.ParseDuration(cfg.BatchWaitStr); err == nil {
+			exporterCfg.BatchWait = batchWait
+		}
+	}
+
+	exporter, err := NewOTLPExporter(exporterCfg)
+	if err != nil {
+		log.Printf("WARNING: Failed to create OTLPExporter: %v", err)
+		return nil
+	}
+	return exporter
+}
+
+// Push queues a log entry for conversion to spans.
+// This method is non-blocking - if the channel is full, the entry is dropped.
+func (e *OTLPExporter) Push(entry map[string]interface{}, provider string) {
+	select {
+	case e.entryChan <- otlpEntry{entry: entry, provider: provider}:
+	default:
+		atomic.AddInt64(&e.entriesDropped, 1)
+	}
+}
+
+// run is the background worker that assembles, batches and sends spans
+func (e *OTLPExporter) run() {
+	defer close(e.closedChan)
+
+	batch := make([]otlpSpan, 0, e.config.BatchSize)
+	ticker := time.NewTicker(e.config.BatchWait)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry := <-e.entryChan:
+			batch = append(batch, e.process(entry)...)
+			if len(batch) >= e.config.BatchSize {
+				e.sendBatch(batch)
+				batch = make([]otlpSpan, 0, e.config.BatchSize)
+				ticker.Reset(e.config.BatchWait)
+			}
+
+		case <-ticker.C:
+			e.prune(time.Now())
+			if len(batch) > 0 {
+				e.sendBatch(batch)
+				batch = make([]otlpSpan, 0, e.config.BatchSize)
+			}
+
+		case <-e.closeChan:
+			draining := true
+			for draining {
+				select {
+				case entry := <-e.entryChan:
+					batch = append(batch, e.process(entry)...)
+				default:
+					draining = false
+				}
+			}
+			if len(batch) > 0 {
+				e.sendBatch(batch)
+			}
+			return
+		}
+	}
+}
+
+// prune forgets requests and tool calls that never completed.
+func (e *OTLPExporter) prune(now time.Time) {
+	for id, turn := range e.turns {
+		if now.Sub(turn.start) > otlpPendingTTL {
+			delete(e.turns, id)
+		}
+	}
+	for id, call := range e.toolCalls {
+		if now.Sub(call.start) > otlpPendingTTL {
+			delete(e.toolCalls, id)
+		}
+	}
+}
+
+// process updates the span assembly state with one entry and returns the
+// spans it completes.
+func (e *OTLPExporter) process(qe otlpEntry) []otlpSpan {
+	entry := qe.entry
+	meta, _ := entry["_meta"].(map[string]interface{})
+	sessionID, _ := meta["session"].(string)
+	requestID, _ := meta["request_id"].(string)
+	if sessionID == "" {
+		return nil
+	}
+	ts := time.Now()
+	if s, ok := meta["ts"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			ts = parsed
+		}
+	}
+
+	switch entry["type"] {
+	case "session_start":
+		if upstream, ok := entry["upstream"].(string); ok {
+			e.upstreams[sessionID] = upstream
+		}
+
+	case "request":
+		if requestID == "" {
+			return nil
+		}
+		body, _ := entry["body"].(string)
+		turn := &otlpTurn{
+			sessionID: sessionID,
+			provider:  qe.provider,
+			seq:       entryInt(entry["seq"]),
+			start:     ts,
+		}
+		var req struct {
+			Model       string   `json:"model"`
+			MaxTokens   *int64   `json:"max_tokens"`
+			Temperature *float64 `json:"temperature"`
+			TopP        *float64 `json:"top_p"`
+		}
+		json.Unmarshal([]byte(body), &req)
+		turn.model = req.Model
+		if override, ok := meta["model_override"].(string); ok && override != "" {
+			turn.model = override
+		}
+		if req.MaxTokens != nil {
+			turn.attrs = append(turn.attrs, otlpInt("gen_ai.request.max_tokens", *req.MaxTokens))
+		}
+		if req.Temperature != nil {
+			turn.attrs = append(turn.attrs, otlpDouble("gen_ai.request.temperature", *req.Temperature))
+		}
+		if req.TopP != nil {
+			turn.attrs = append(turn.attrs, otlpDouble("gen_ai.request.top_p", *req.TopP))
+		}
+		if machine, ok := meta["machine"].(string); ok {
+			turn.attrs = append(turn.attrs, otlpString("llm_proxy.machine", machine))
+		}
+		e.turns[requestID] = turn
+
+		// Tool results in this request close the tool calls they answer
+		var spans []otlpSpan
+		for _, result := range extractToolResults([]byte(body)) {
+			key := sessionID + "\x00" + result.ToolUseID
+			call, ok := e.toolCalls[key]
+			if !ok {
+				continue
+			}
+			delete(e.toolCalls, key)
+			spans = append(spans, e.toolSpan(call, result, ts))
+		}
+		return spans
+
+	case "response":
+		turn, ok := e.turns[requestID]
+		if !ok {
+			return nil
+		}
+		delete(e.turns, requestID)
+		return e.turnSpans(turn, requestID, entry, ts)
+	}
+	return nil
+}
+
+// turnSpans builds the chat span for a completed turn and opens a tool call
+// for each tool_use in the response.
+func (e *OTLPExporter) turnSpans(turn *otlpTurn, requestID string, entry map[string]interface{}, end time.Time) []otlpSpan {
+	parsed := otlpParseResponse(entry, turn.provider)
+	status := entryInt(entry["status"])
+	model := responseModel(turn.model, parsed)
+
+	span := otlpSpan{
+		TraceID:           otlpTraceID(turn.sessionID),
+		SpanID:            otlpID(8, "turn", requestID),
+		Name:              "chat",
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: otlpTime(turn.start),
+		EndTimeUnixNano:   otlpTime(end),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if turn.model != "" {
+		span.Name = "chat " + turn.model
+	}
+
+	attrs := []otlpKeyValue{
+		otlpString("gen_ai.operation.name", "chat"),
+		otlpString("gen_ai.system", turn.provider),
+		otlpString("gen_ai.provider.name", turn.provider),
+		otlpString("gen_ai.conversation.id", turn.sessionID),
+		otlpInt("http.response.status_code", int64(status)),
+		otlpInt("llm_proxy.seq", int64(turn.seq)),
+		otlpString("llm_proxy.request_id", requestID),
+	}
+	if turn.model != "" {
+		attrs = append(attrs, otlpString("gen_ai.request.model", turn.model))
+	}
+	if model != "" {
+		attrs = append(attrs, otlpString("gen_ai.response.model", model))
+	}
+	if id, ok := parsed.Raw["id"].(string); ok && id != "" {
+		attrs = append(attrs, otlpString("gen_ai.response.id", id))
+	}
+	if upstream := e.upstreams[turn.sessionID]; upstream != "" {
+		attrs = append(attrs, otlpString("server.address", upstream))
+	}
+	if parsed.StopReason != "" {
+		attrs = append(attrs, otlpStrings("gen_ai.response.finish_reasons", []string{parsed.StopReason}))
+	}
+	usage := parsed.Usage
+	if usage.InputTokens > 0 || usage.OutputTokens > 0 {
+		attrs = append(attrs,
+			otlpInt("gen_ai.usage.input_tokens", int64(usage.InputTokens+usage.CacheReadInputTokens+usage.CacheCreationInputTokens)),
+			otlpInt("gen_ai.usage.output_tokens", int64(usage.OutputTokens)),
+		)
+	}
+	if usage.CacheReadInputTokens > 0 {
+		attrs = append(attrs, otlpInt("gen_ai.usage.cache_read.input_tokens", int64(usage.CacheReadInputTokens)))
+	}
+	if usage.CacheCreationInputTokens > 0 {
+		attrs = append(attrs, otlpInt("gen_ai.usage.cache_creation.input_tokens", int64(usage.CacheCreationInputTokens)))
+	}
+	if cost, ok := entry["cost_usd"].(float64); ok {
+		attrs = append(attrs, otlpDouble("llm_proxy.cost_usd", cost))
+	}
+	if status >= 400 {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: http.StatusText(status)}
+		attrs = append(attrs, otlpString("error.type", strconv.Itoa(status)))
+	}
+	span.Attributes = append(attrs, turn.attrs...)
+
+	spans := []otlpSpan{span}
+	for _, call := range extractToolCalls(parsed.Content) {
+		if call.ToolID == "" {
+			continue
+		}
+		e.toolCalls[turn.sessionID+"\x00"+call.ToolID] = &otlpToolCall{
+			traceID:      span.TraceID,
+			spanID:       otlpID(8, "tool", turn.sessionID, call.ToolID),
+			parentSpanID: span.SpanID,
+			name:         call.ToolName,
+			start:        end,
+		}
+	}
+	return spans
+}
+
+// toolSpan builds the execute_tool span for a tool call once its result arrives.
+func (e *OTLPExporter) toolSpan(call *otlpToolCall, result ToolResultInfo, end time.Time) otlpSpan {
+	span := otlpSpan{
+		TraceID:           call.traceID,
+		SpanID:            call.spanID,
+		ParentSpanID:      call.parentSpanID,
+		Name:              "execute_tool " + call.name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: otlpTime(call.start),
+		EndTimeUnixNano:   otlpTime(end),
+		Attributes: []otlpKeyValue{
+			otlpString("gen_ai.operation.name", "execute_tool"),
+			otlpString("gen_ai.tool.name", call.name),
+			otlpString("gen_ai.tool.call.id", result.ToolUseID),
+		},
+		Status: otlpStatus{Code: otlpStatusOK},
+	}
+	if result.IsError {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: "tool returned an error"}
+		span.Attributes = append(span.Attributes, otlpString("error.type", "tool_error"))
+	}
+	return span
+}
+
+// otlpParseResponse parses a response entry's body or stream chunks.
+func otlpParseResponse(entry map[string]interface{}, provider string) ParsedResponse {
+	p := LookupProvider(provider)
+	if p == nil {
+		p = LookupProvider("anthropic")
+	}
+	if chunks, ok := entry["chunks"].([]StreamChunk); ok && chunks != nil {
+		return p.ParseStream(chunks)
+	}
+	body, _ := entry["body"].(string)
+	var raw map[string]interface{}
+	if json.Unmarshal([]byte(body), &raw) != nil {
+		return ParsedResponse{}
+	}
+	return p.ParseResponse(raw)
+}
+
+// entryInt reads a number from an entry built in-process (int) or decoded
+// from JSON (float64).
+func entryInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// sendBatch exports spans with retries
+func (e *OTLPExporter) sendBatch(spans []otlpSpan) {
+	if len(spans) == 0 {
+		return
+	}
+
+	request := otlpTracesRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpKeyValue{
+				otlpString("service.name", e.config.ServiceName),
+			}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "llm-proxy"},
+				Spans: spans,
+			}},
+		}},
+	}
+
+	for attempt := 0; attempt <= e.config.RetryMax; attempt++ {
+		if attempt > 0 {
+			// Exponential backoff with jitter
+			delay := e.config.RetryWait * time.Duration(1<<(attempt-1))
+			if delay > 10*time.Second {
+				delay = 10 * time.Second
+			}
+			jitter := time.Duration(float64(delay) * 0.25 * rand.Float64())
+			time.Sleep(delay + jitter)
+		}
+
+		if err := e.doSend(request); err == nil {
+			atomic.AddInt64(&e.spansSent, int64(len(spans)))
+			atomic.AddInt64(&e.batchesSent, 1)
+			return
+		}
+	}
+
+	atomic.AddInt64(&e.spansFailed, int64(len(spans)))
+}
+
+// doSend performs the HTTP POST to the OTLP endpoint
+func (e *OTLPExporter) doSend(payload otlpTracesRequest) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", e.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("OTLP endpoint returned status %d", resp.StatusCode)
+}
+
+// Stats returns the current statistics for the exporter
+func (e *OTLPExporter) Stats() OTLPExporterStats {
+	return OTLPExporterStats{
+		SpansSent:      atomic.LoadInt64(&e.spansSent),
+		SpansFailed:    atomic.LoadInt64(&e.spansFailed),
+		EntriesDropped: atomic.LoadInt64(&e.entriesDropped),
+		BatchesSent:    atomic.LoadInt64(&e.batchesSent),
+	}
+}
+
+// Close gracefully shuts down the exporter, draining the channel and flushing
+// remaining spans. Returns an error if the shutdown times out.
+func (e *OTLPExporter) Close() error {
+	var timeoutErr error
+
+	e.closeOnce.Do(func() {
+		close(e.closeChan)
+
+		select {
+		case <-e.closedChan:
+		case <-time.After(e.config.ShutdownTimeout):
+			timeoutErr = fmt.Errorf("shutdown timeout: %v", e.config.ShutdownTimeout)
+		}
+	})
+
+	return timeoutErr
+}
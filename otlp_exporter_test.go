@@ -0,0 +1,191 @@
+// otlp_exporter_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otlpCollector is an httptest stand-in for an OTLP/HTTP traces endpoint.
+type otlpCollector struct {
+	mu      sync.Mutex
+	spans   []otlpSpan
+	headers []http.Header
+}
+
+func newOTLPCollector(t *testing.T) (*otlpCollector, *httptest.Server) {
+	c := &otlpCollector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpTracesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector: bad payload: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = append(c.headers, r.Header.Clone())
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+func (c *otlpCollector) span(name string) *otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.spans {
+		if c.spans[i].Name == name {
+			return &c.spans[i]
+		}
+	}
+	return nil
+}
+
+func otlpAttr(span *otlpSpan, key string) string {
+	for _, kv := range span.Attributes {
+		if kv.Key != key {
+			continue
+		}
+		switch {
+		case kv.Value.StringValue != nil:
+			return *kv.Value.StringValue
+		case kv.Value.IntValue != nil:
+			return *kv.Value.IntValue
+		}
+	}
+	return ""
+}
+
+func otlpTestEntry(typ, session, requestID string, fields map[string]interface{}) map[string]interface{} {
+	entry := map[string]interface{}{
+		"type": typ,
+		"_meta": map[string]interface{}{
+			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
+			"session":    session,
+			"request_id": requestID,
+		},
+	}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	return entry
+}
+
+func TestNewOTLPExporter_RequiresEndpoint(t *testing.T) {
+	if _, err := NewOTLPExporter(OTLPExporterConfig{}); err == nil || !strings.Contains(err.Error(), "endpoint") {
+		t.Errorf("expected an endpoint error, got %v", err)
+	}
+}
+
+func TestOTLPExporter_ExportsChatAndToolSpans(t *testing.T) {
+	collector, srv := newOTLPCollector(t)
+	exporter, err := NewOTLPExporter(OTLPExporterConfig{
+		Endpoint: srv.URL + "/v1/traces",
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+	})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed: %v", err)
+	}
+
+	exporter.Push(otlpTestEntry("session_start", "sess-1", "", map[string]interface{}{"upstream": "api.anthropic.com"}), "anthropic")
+	exporter.Push(otlpTestEntry("request", "sess-1", "req-1", map[string]interface{}{
+		"seq":  1,
+		"body": `{"model":"claude-sonnet-4","max_tokens":1024,"messages":[{"role":"user","content":"list files"}]}`,
+	}), "anthropic")
+	exporter.Push(otlpTestEntry("response", "sess-1", "req-1", map[string]interface{}{
+		"seq":      1,
+		"status":   200,
+		"body":     `{"id":"msg_1","model":"claude-sonnet-4","stop_reason":"tool_use","content":[{"type":"tool_use","id":"toolu_1","name":"Bash","input":{}}],"usage":{"input_tokens":10,"output_tokens":5}}`,
+		"cost_usd": 0.001,
+	}), "anthropic")
+	exporter.Push(otlpTestEntry("request", "sess-1", "req-2", map[string]interface{}{
+		"seq":  2,
+		"body": `{"model":"claude-sonnet-4","messages":[{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"a.go"}]}]}`,
+	}), "anthropic")
+
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	chat := collector.span("chat claude-sonnet-4")
+	if chat == nil {
+		t.Fatalf("expected a chat span, got %+v", collector.spans)
+	}
+	for key, want := range map[string]string{
+		"gen_ai.operation.name":      "chat",
+		"gen_ai.system":              "anthropic",
+		"gen_ai.request.model":       "claude-sonnet-4",
+		"gen_ai.response.id":         "msg_1",
+		"gen_ai.usage.input_tokens":  "10",
+		"gen_ai.usage.output_tokens": "5",
+		"gen_ai.request.max_tokens":  "1024",
+		"gen_ai.conversation.id":     "sess-1",
+		"server.address":             "api.anthropic.com",
+	} {
+		if got := otlpAttr(chat, key); got != want {
+			t.Errorf("chat span %s = %q, want %q", key, got, want)
+		}
+	}
+	if chat.Kind != otlpSpanKindClient || chat.TraceID != otlpTraceID("sess-1") {
+		t.Errorf("unexpected chat span kind %d or trace %s", chat.Kind, chat.TraceID)
+	}
+
+	tool := collector.span("execute_tool Bash")
+	if tool == nil {
+		t.Fatal("expected an execute_tool span once the tool result arrived")
+	}
+	if tool.TraceID != chat.TraceID || tool.ParentSpanID != chat.SpanID {
+		t.Errorf("expected the tool span to be a child of the chat span, got trace %s parent %s", tool.TraceID, tool.ParentSpanID)
+	}
+	if otlpAttr(tool, "gen_ai.tool.call.id") != "toolu_1" {
+		t.Errorf("expected tool call id toolu_1, got %q", otlpAttr(tool, "gen_ai.tool.call.id"))
+	}
+
+	if got := collector.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("expected configured headers to be sent, got %q", got)
+	}
+	if stats := exporter.Stats(); stats.SpansSent != 2 || stats.SpansFailed != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestOTLPExporter_ErrorStatus(t *testing.T) {
+	collector, srv := newOTLPCollector(t)
+	exporter, err := NewOTLPExporter(OTLPExporterConfig{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed: %v", err)
+	}
+
+	exporter.Push(otlpTestEntry("request", "sess-2", "req-1", map[string]interface{}{"body": `{"model":"gpt-4o"}`}), "openai")
+	exporter.Push(otlpTestEntry("response", "sess-2", "req-1", map[string]interface{}{"status": 429, "body": `{}`}), "openai")
+	exporter.Close()
+
+	chat := collector.span("chat gpt-4o")
+	if chat == nil {
+		t.Fatal("expected a chat span")
+	}
+	if chat.Status.Code != otlpStatusError || otlpAttr(chat, "error.type") != "429" {
+		t.Errorf("expected an error status for a 429, got %+v", chat.Status)
+	}
+}
+
+func TestOTLPExporter_DropsWhenBufferFull(t *testing.T) {
+	exporter := &OTLPExporter{entryChan: make(chan otlpEntry, 1)}
+
+	exporter.Push(otlpTestEntry("request", "s", "r1", nil), "anthropic")
+	exporter.Push(otlpTestEntry("request", "s", "r2", nil), "anthropic")
+
+	if stats := exporter.Stats(); stats.EntriesDropped != 1 {
+		t.Errorf("expected 1 dropped entry, got %d", stats.EntriesDropped)
+	}
+}
@@ -45,12 +45,21 @@ type UsageInfo struct {
 	CacheCreationInputTokens int
 }
 
+// ParseRequestBody parses a request body. The provider is chosen by upstream
+// host when known, otherwise by the shape of the body (see providerForBody).
 func ParseRequestBody(body string, host string) ParsedRequest {
 	var raw map[string]interface{}
 	if json.Unmarshal([]byte(body), &raw) != nil {
 		return ParsedRequest{Raw: raw}
 	}
 
+	return providerForBody(raw, host).ParseRequest(raw)
+}
+
+// parseChatRequest parses Anthropic Messages, OpenAI Chat Completions and
+// OpenAI Responses API request bodies, which share enough structure to be
+// handled together.
+func parseChatRequest(raw map[string]interface{}) ParsedRequest {
 	parsed := ParsedRequest{Raw: raw}
 
 	if model, ok := raw["model"].(string); ok {
@@ -59,6 +68,13 @@ func ParseRequestBody(body string, host string) ParsedRequest {
 	if maxTokens, ok := raw["max_tokens"].(float64); ok {
 		parsed.MaxTokens = int(maxTokens)
 	}
+	// OpenAI Chat Completions and Responses API equivalents
+	if maxTokens, ok := raw["max_completion_tokens"].(float64); ok {
+		parsed.MaxTokens = int(maxTokens)
+	}
+	if maxTokens, ok := raw["max_output_tokens"].(float64); ok {
+		parsed.MaxTokens = int(maxTokens)
+	}
 	// Handle system as string
 	if system, ok := raw["system"].(string); ok {
 		parsed.System = system
@@ -76,49 +92,127 @@ func ParseRequestBody(body string, host string) ParsedRequest {
 		parsed.System = strings.Join(systemParts, "\n\n")
 	}
 
+	// OpenAI carries system prompts as system/developer messages and the
+	// Responses API uses a top-level instructions field
+	var extraSystem []string
+	if instructions, ok := raw["instructions"].(string); ok && instructions != "" {
+		extraSystem = append(extraSystem, instructions)
+	}
+
 	if messages, ok := raw["messages"].([]interface{}); ok {
 		for _, m := range messages {
 			if msg, ok := m.(map[string]interface{}); ok {
-				pm := ParsedMessage{Raw: msg}
-
-				if role, ok := msg["role"].(string); ok {
-					pm.Role = role
+				pm := parseMessage(msg)
+				if isSystemRole(pm.Role) {
+					extraSystem = append(extraSystem, messageText(pm))
+					continue
 				}
+				parsed.Messages = append(parsed.Messages, pm)
+			}
+		}
+	}
 
-				// Handle simple string content
-				if content, ok := msg["content"].(string); ok {
-					pm.TextContent = content
-				}
+	// OpenAI Responses API: input is a string or a list of items
+	if input, ok := raw["input"]; ok {
+		extraSystem = append(extraSystem, parseResponsesInput(input, &parsed)...)
+	}
 
-				// Handle array content (tool results, etc)
-				if content, ok := msg["content"].([]interface{}); ok {
-					for _, c := range content {
-						if block, ok := c.(map[string]interface{}); ok {
-							pm.Content = append(pm.Content, parseContentBlock(block))
-						}
-					}
-					// Set TextContent from first text block for convenience
-					for _, cb := range pm.Content {
-						if cb.Type == "text" && pm.TextContent == "" {
-							pm.TextContent = cb.Text
-						}
-					}
-				}
+	if len(extraSystem) > 0 {
+		if parsed.System != "" {
+			extraSystem = append([]string{parsed.System}, extraSystem...)
+		}
+		parsed.System = strings.Join(extraSystem, "\n\n")
+	}
 
-				parsed.Messages = append(parsed.Messages, pm)
+	return parsed
+}
+
+// parseMessage parses a single conversation message. Anthropic content blocks
+// are parsed as-is; OpenAI tool messages and assistant tool_calls are
+// normalized into tool_result and tool_use blocks.
+func parseMessage(msg map[string]interface{}) ParsedMessage {
+	pm := ParsedMessage{Raw: msg}
+
+	if role, ok := msg["role"].(string); ok {
+		pm.Role = role
+	}
+
+	// Handle simple string content
+	if content, ok := msg["content"].(string); ok {
+		pm.TextContent = content
+	}
+
+	// Handle array content (tool results, etc)
+	if content, ok := msg["content"].([]interface{}); ok {
+		for _, c := range content {
+			if block, ok := c.(map[string]interface{}); ok {
+				pm.Content = append(pm.Content, parseContentBlock(block))
+			}
+		}
+		// Set TextContent from first text block for convenience
+		for _, cb := range pm.Content {
+			if cb.Type == "text" && pm.TextContent == "" {
+				pm.TextContent = cb.Text
 			}
 		}
 	}
 
-	return parsed
+	// OpenAI tool output: {"role":"tool","tool_call_id":"...","content":"..."}
+	if pm.Role == "tool" {
+		toolCallID, _ := msg["tool_call_id"].(string)
+		pm.Content = []ContentBlock{{
+			Type:   "tool_result",
+			ToolID: toolCallID,
+			Text:   messageText(pm),
+			Raw:    msg,
+		}}
+	}
+
+	// OpenAI assistant tool calls: {"role":"assistant","tool_calls":[...]}
+	if _, ok := msg["tool_calls"].([]interface{}); ok {
+		toolBlocks := parseChatMessageContent(map[string]interface{}{"tool_calls": msg["tool_calls"]})
+		if pm.Content == nil && pm.TextContent != "" {
+			pm.Content = append(pm.Content, ContentBlock{Type: "text", Text: pm.TextContent})
+		}
+		pm.Content = append(pm.Content, toolBlocks...)
+	}
+
+	return pm
+}
+
+// isSystemRole reports whether a message role carries system instructions.
+func isSystemRole(role string) bool {
+	return role == "system" || role == "developer"
 }
 
+// messageText returns all text in a message, joining text blocks.
+func messageText(pm ParsedMessage) string {
+	if len(pm.Content) == 0 {
+		return pm.TextContent
+	}
+	var parts []string
+	for _, cb := range pm.Content {
+		if cb.Type == "text" && cb.Text != "" {
+			parts = append(parts, cb.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// ParseResponseBody parses a non-streaming response body. The provider is
+// chosen by upstream host when known, otherwise by the shape of the body
+// (see providerForBody); anything unrecognized is treated as Anthropic.
 func ParseResponseBody(body string, host string) ParsedResponse {
 	var raw map[string]interface{}
 	if json.Unmarshal([]byte(body), &raw) != nil {
 		return ParsedResponse{Raw: raw}
 	}
 
+	return providerForBody(raw, host).ParseResponse(raw)
+}
+
+// parseAnthropicResponseBody parses an Anthropic Messages API response body.
+func parseAnthropicResponseBody(raw map[string]interface{}) ParsedResponse {
 	parsed := ParsedResponse{Raw: raw}
 
 	if content, ok := raw["content"].([]interface{}); ok {
@@ -151,10 +245,16 @@ func ParseResponseBody(body string, host string) ParsedResponse {
 	return parsed
 }
 
-// ParseStreamingResponse reconstructs a ParsedResponse from SSE chunks
+// ParseStreamingResponse reconstructs a ParsedResponse from SSE chunks.
+// Anthropic, OpenAI Chat Completions and OpenAI Responses API streams are
+// recognized from the shape of each event.
 func ParseStreamingResponse(chunks []StreamChunk) ParsedResponse {
 	parsed := ParsedResponse{}
 
+	// OpenAI stream state (see parser_openai.go)
+	var chatStream chatStreamBuilder
+	var responsesStream responsesStreamBuilder
+
 	// Track content blocks being built
 	var currentBlocks []ContentBlock
 	blockInputBuilders := make(map[int]string) // For building tool input JSON
@@ -175,6 +275,17 @@ func ParseStreamingResponse(chunks []StreamChunk) ParsedResponse {
 
 			eventType, _ := data["type"].(string)
 
+			// OpenAI Responses API: {"type":"response.output_text.delta",...}
+			if strings.HasPrefix(eventType, "response.") {
+				responsesStream.add(eventType, data)
+				continue
+			}
+			// OpenAI Chat Completions: {"object":"chat.completion.chunk","choices":[...]}
+			if _, ok := data["choices"]; ok && eventType == "" {
+				chatStream.add(data)
+				continue
+			}
+
 			switch eventType {
 			case "message_start":
 				// Extract usage from message_start
@@ -273,6 +384,13 @@ func ParseStreamingResponse(chunks []StreamChunk) ParsedResponse {
 	}
 
 	parsed.Content = currentBlocks
+
+	if chatStream.seen {
+		chatStream.finish(&parsed)
+	}
+	if responsesStream.seen {
+		responsesStream.finish(&parsed)
+	}
 	return parsed
 }
 
@@ -288,6 +406,12 @@ func parseContentBlock(block map[string]interface{}) ContentBlock {
 		if text, ok := block["text"].(string); ok {
 			cb.Text = text
 		}
+	case "input_text", "output_text":
+		// OpenAI Responses API text parts
+		cb.Type = "text"
+		if text, ok := block["text"].(string); ok {
+			cb.Text = text
+		}
 	case "thinking":
 		if thinking, ok := block["thinking"].(string); ok {
 			cb.Thinking = thinking
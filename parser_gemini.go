@@ -0,0 +1,322 @@
+// parser_gemini.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Gemini payloads are normalized into the same ParsedRequest/ParsedResponse
+// model as Anthropic and OpenAI:
+//   - "model" turns become "assistant" messages
+//   - functionCall parts become "tool_use" blocks and functionResponse parts
+//     become "tool_result" blocks. Gemini only sends call IDs on some models,
+//     so ToolID falls back to the function name, which is how Gemini pairs
+//     calls with responses.
+//   - parts marked thought:true become "thinking" blocks
+//
+// Usage follows Anthropic semantics: InputTokens excludes cached prompt tokens
+// and OutputTokens includes thinking tokens, which Gemini bills as output.
+
+// geminiProvider handles the Gemini API (generativelanguage.googleapis.com).
+type geminiProvider struct{}
+
+func (geminiProvider) Name() string { return "gemini" }
+
+func (geminiProvider) DefaultUpstreams() []string {
+	return []string{"generativelanguage.googleapis.com"}
+}
+
+// IsConversationEndpoint matches /v1beta/models/{model}:generateContent and
+// :streamGenerateContent (and the same methods on tunedModels).
+func (geminiProvider) IsConversationEndpoint(path string) bool {
+	return strings.HasSuffix(path, ":generateContent") || strings.HasSuffix(path, ":streamGenerateContent")
+}
+
+// ClientSessionID reads the X-Session-ID header; Gemini request bodies have
+// no session field.
+func (geminiProvider) ClientSessionID(body []byte, headers http.Header, path string) string {
+	if headers == nil {
+		return ""
+	}
+	if sessID := headers.Get("X-Session-ID"); isValidSessionID(sessID) {
+		return sessID
+	}
+	return ""
+}
+
+// DeltaText handles alt=sse events: {"candidates":[{"content":{"parts":[{"text":"..."}]}}]}.
+// Lines of a JSON-array stream carry no complete event and yield nothing.
+func (geminiProvider) DeltaText(line []byte) string {
+	var text strings.Builder
+	for _, part := range geminiCandidateParts(sseData(line)) {
+		if t, ok := part["text"].(string); ok && part["thought"] != true {
+			text.WriteString(t)
+		}
+	}
+	return text.String()
+}
+
+func (geminiProvider) MatchesBody(raw map[string]interface{}) bool {
+	_, hasContents := raw["contents"]
+	_, hasCandidates := raw["candidates"]
+	return hasContents || hasCandidates
+}
+
+func (geminiProvider) ParseRequest(raw map[string]interface{}) ParsedRequest {
+	parsed := ParsedRequest{Raw: raw}
+
+	// The model is part of the URL path, not the body
+	if config, ok := raw["generationConfig"].(map[string]interface{}); ok {
+		parsed.MaxTokens = intField(config, "maxOutputTokens")
+	}
+
+	// The REST API accepts both camelCase and snake_case field names
+	system, ok := raw["systemInstruction"].(map[string]interface{})
+	if !ok {
+		system, _ = raw["system_instruction"].(map[string]interface{})
+	}
+	if system != nil {
+		var parts []string
+		for _, block := range parseGeminiParts(system["parts"]) {
+			if block.Type == "text" {
+				parts = append(parts, block.Text)
+			}
+		}
+		parsed.System = strings.Join(parts, "\n\n")
+	}
+
+	if contents, ok := raw["contents"].([]interface{}); ok {
+		for _, c := range contents {
+			content, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			pm := ParsedMessage{Raw: content, Role: geminiRole(content["role"])}
+			pm.Content = parseGeminiParts(content["parts"])
+			for _, cb := range pm.Content {
+				if cb.Type == "text" {
+					pm.TextContent = cb.Text
+					break
+				}
+			}
+			parsed.Messages = append(parsed.Messages, pm)
+		}
+	}
+
+	return parsed
+}
+
+func (geminiProvider) ParseResponse(raw map[string]interface{}) ParsedResponse {
+	var b geminiStreamBuilder
+	b.add(raw)
+	return b.finish()
+}
+
+// ParseStream handles both streaming formats: SSE events (alt=sse) and the
+// default JSON array, which arrives split across arbitrary lines.
+func (geminiProvider) ParseStream(chunks []StreamChunk) ParsedResponse {
+	var b geminiStreamBuilder
+	var arrayBody strings.Builder
+
+	for _, chunk := range chunks {
+		if strings.HasPrefix(chunk.Raw, "data: ") {
+			if event := sseData([]byte(chunk.Raw)); event != nil {
+				b.add(event)
+			}
+			continue
+		}
+		arrayBody.WriteString(chunk.Raw)
+	}
+
+	if body := strings.TrimSpace(arrayBody.String()); body != "" {
+		var events []map[string]interface{}
+		if json.Unmarshal([]byte(body), &events) == nil {
+			for _, event := range events {
+				b.add(event)
+			}
+		} else {
+			// Errors come back as a single object rather than an array
+			var event map[string]interface{}
+			if json.Unmarshal([]byte(body), &event) == nil {
+				b.add(event)
+			}
+		}
+	}
+
+	return b.finish()
+}
+
+// ErrorResponse reports a 400 FAILED_PRECONDITION, which Gemini clients don't
+// retry (unlike 429 RESOURCE_EXHAUSTED).
+func (geminiProvider) ErrorResponse(err error) (int, map[string]interface{}) {
+	return http.StatusBadRequest, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    http.StatusBadRequest,
+			"message": err.Error(),
+			"status":  "FAILED_PRECONDITION",
+		},
+	}
+}
+
+// geminiRole maps Gemini content roles onto the shared role names.
+func geminiRole(role interface{}) string {
+	switch role {
+	case "model":
+		return "assistant"
+	case "function":
+		// Older clients send functionResponse parts under a "function" role
+		return "user"
+	}
+	r, _ := role.(string)
+	if r == "" {
+		return "user"
+	}
+	return r
+}
+
+// parseGeminiParts converts a Gemini parts array into ContentBlocks.
+func parseGeminiParts(partsRaw interface{}) []ContentBlock {
+	parts, _ := partsRaw.([]interface{})
+	var blocks []ContentBlock
+	for _, p := range parts {
+		part, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		blocks = append(blocks, parseGeminiPart(part))
+	}
+	return blocks
+}
+
+// parseGeminiPart converts one Gemini part into a ContentBlock.
+func parseGeminiPart(part map[string]interface{}) ContentBlock {
+	cb := ContentBlock{Raw: part}
+
+	if call, ok := part["functionCall"].(map[string]interface{}); ok {
+		cb.Type = "tool_use"
+		cb.ToolName, _ = call["name"].(string)
+		cb.ToolID = geminiCallID(call)
+		cb.ToolInput, _ = call["args"].(map[string]interface{})
+		return cb
+	}
+
+	if resp, ok := part["functionResponse"].(map[string]interface{}); ok {
+		cb.Type = "tool_result"
+		cb.ToolName, _ = resp["name"].(string)
+		cb.ToolID = geminiCallID(resp)
+		if response, ok := resp["response"].(map[string]interface{}); ok {
+			if out, err := json.Marshal(response); err == nil {
+				cb.Text = string(out)
+			}
+			_, cb.IsError = response["error"]
+		}
+		return cb
+	}
+
+	text, _ := part["text"].(string)
+	if part["thought"] == true {
+		cb.Type = "thinking"
+		cb.Thinking = text
+		return cb
+	}
+	cb.Type = "text"
+	cb.Text = text
+	return cb
+}
+
+// geminiCallID returns a functionCall/functionResponse ID, or the function
+// name when the model didn't assign one.
+func geminiCallID(call map[string]interface{}) string {
+	if id, ok := call["id"].(string); ok && id != "" {
+		return id
+	}
+	name, _ := call["name"].(string)
+	return name
+}
+
+// geminiCandidateParts returns the parts of the first candidate in a
+// GenerateContentResponse, or nil.
+func geminiCandidateParts(resp map[string]interface{}) []map[string]interface{} {
+	candidates, _ := resp["candidates"].([]interface{})
+	if len(candidates) == 0 {
+		return nil
+	}
+	candidate, _ := candidates[0].(map[string]interface{})
+	content, _ := candidate["content"].(map[string]interface{})
+	partsRaw, _ := content["parts"].([]interface{})
+
+	var parts []map[string]interface{}
+	for _, p := range partsRaw {
+		if part, ok := p.(map[string]interface{}); ok {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// parseGeminiUsage maps a usageMetadata object onto UsageInfo.
+func parseGeminiUsage(usage map[string]interface{}) UsageInfo {
+	input := intField(usage, "promptTokenCount")
+	cached := intField(usage, "cachedContentTokenCount")
+	if cached > input {
+		cached = input
+	}
+	return UsageInfo{
+		InputTokens:          input - cached,
+		OutputTokens:         intField(usage, "candidatesTokenCount") + intField(usage, "thoughtsTokenCount"),
+		CacheReadInputTokens: cached,
+	}
+}
+
+// geminiStreamBuilder merges GenerateContentResponse objects into one
+// ParsedResponse. A non-streaming response is a stream of one.
+type geminiStreamBuilder struct {
+	content    []ContentBlock
+	usage      *UsageInfo
+	stopReason string
+	last       map[string]interface{}
+}
+
+func (b *geminiStreamBuilder) add(resp map[string]interface{}) {
+	b.last = resp
+
+	for _, part := range geminiCandidateParts(resp) {
+		block := parseGeminiPart(part)
+
+		// Streamed text arrives in pieces; extend the previous block of the same kind
+		if n := len(b.content); n > 0 && (block.Type == "text" || block.Type == "thinking") && b.content[n-1].Type == block.Type {
+			b.content[n-1].Text += block.Text
+			b.content[n-1].Thinking += block.Thinking
+			continue
+		}
+		b.content = append(b.content, block)
+	}
+
+	if candidates, ok := resp["candidates"].([]interface{}); ok && len(candidates) > 0 {
+		if candidate, ok := candidates[0].(map[string]interface{}); ok {
+			if finish, ok := candidate["finishReason"].(string); ok && finish != "" {
+				b.stopReason = finish
+			}
+		}
+	}
+
+	// usageMetadata is cumulative; the last one wins
+	if usage, ok := resp["usageMetadata"].(map[string]interface{}); ok {
+		info := parseGeminiUsage(usage)
+		b.usage = &info
+	}
+}
+
+func (b *geminiStreamBuilder) finish() ParsedResponse {
+	parsed := ParsedResponse{
+		Content:    b.content,
+		StopReason: b.stopReason,
+		Raw:        b.last,
+	}
+	if b.usage != nil {
+		parsed.Usage = *b.usage
+	}
+	return parsed
+}
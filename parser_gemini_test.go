@@ -0,0 +1,231 @@
+// parser_gemini_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRequestBody_Gemini(t *testing.T) {
+	body := `{
+		"systemInstruction": {"parts": [{"text": "You are a coding agent."}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "List the files"}]},
+			{"role": "model", "parts": [{"functionCall": {"name": "list_directory", "args": {"path": "."}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"name": "list_directory", "response": {"output": "main.go"}}}]}
+		],
+		"generationConfig": {"maxOutputTokens": 8192}
+	}`
+
+	parsed := ParseRequestBody(body, "generativelanguage.googleapis.com")
+
+	if parsed.System != "You are a coding agent." {
+		t.Errorf("Expected system instruction, got %q", parsed.System)
+	}
+	if parsed.MaxTokens != 8192 {
+		t.Errorf("Expected max tokens 8192, got %d", parsed.MaxTokens)
+	}
+	if len(parsed.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(parsed.Messages))
+	}
+	if parsed.Messages[0].Role != "user" || parsed.Messages[0].TextContent != "List the files" {
+		t.Errorf("Unexpected first message: %+v", parsed.Messages[0])
+	}
+
+	call := parsed.Messages[1]
+	if call.Role != "assistant" {
+		t.Errorf("Expected model role mapped to assistant, got %q", call.Role)
+	}
+	if call.Content[0].Type != "tool_use" || call.Content[0].ToolName != "list_directory" || call.Content[0].ToolInput["path"] != "." {
+		t.Errorf("Unexpected functionCall block: %+v", call.Content[0])
+	}
+
+	result := parsed.Messages[2].Content[0]
+	if result.Type != "tool_result" || result.ToolID != "list_directory" || !strings.Contains(result.Text, "main.go") {
+		t.Errorf("Unexpected functionResponse block: %+v", result)
+	}
+
+	// Body shape decides when the host is unknown
+	if sniffed := ParseRequestBody(body, ""); len(sniffed.Messages) != 3 {
+		t.Errorf("Expected Gemini body recognized without host, got %d messages", len(sniffed.Messages))
+	}
+}
+
+func TestParseResponseBody_Gemini(t *testing.T) {
+	body := `{
+		"candidates": [{
+			"content": {"role": "model", "parts": [
+				{"text": "Checking the tests first.", "thought": true},
+				{"text": "Running them now."},
+				{"functionCall": {"id": "call-1", "name": "run_shell_command", "args": {"command": "go test ./..."}}}
+			]},
+			"finishReason": "STOP"
+		}],
+		"usageMetadata": {"promptTokenCount": 1200, "cachedContentTokenCount": 1000, "candidatesTokenCount": 40, "thoughtsTokenCount": 60},
+		"modelVersion": "gemini-2.5-pro"
+	}`
+
+	parsed := ParseResponseBody(body, "generativelanguage.googleapis.com")
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("Expected 3 content blocks, got %d", len(parsed.Content))
+	}
+	if parsed.Content[0].Type != "thinking" || parsed.Content[0].Thinking != "Checking the tests first." {
+		t.Errorf("Unexpected thinking block: %+v", parsed.Content[0])
+	}
+	if parsed.Content[1].Type != "text" || parsed.Content[1].Text != "Running them now." {
+		t.Errorf("Unexpected text block: %+v", parsed.Content[1])
+	}
+	tool := parsed.Content[2]
+	if tool.Type != "tool_use" || tool.ToolID != "call-1" || tool.ToolName != "run_shell_command" {
+		t.Errorf("Unexpected tool block: %+v", tool)
+	}
+	if parsed.StopReason != "STOP" {
+		t.Errorf("Expected stop reason STOP, got %q", parsed.StopReason)
+	}
+	if parsed.Usage.InputTokens != 200 || parsed.Usage.CacheReadInputTokens != 1000 || parsed.Usage.OutputTokens != 100 {
+		t.Errorf("Unexpected usage: %+v", parsed.Usage)
+	}
+	if model := responseModel("", parsed); model != "gemini-2.5-pro" {
+		t.Errorf("Expected model from modelVersion, got %q", model)
+	}
+}
+
+func TestGeminiParseStream_SSE(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]}}],"modelVersion":"gemini-2.5-flash"}` + "\n"},
+		{Raw: "\n"},
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"text":" world"}]}}]}` + "\n"},
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"a.go"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":50,"candidatesTokenCount":12},"modelVersion":"gemini-2.5-flash"}` + "\n"},
+	}
+
+	parsed := geminiProvider{}.ParseStream(chunks)
+
+	if len(parsed.Content) != 2 {
+		t.Fatalf("Expected 2 content blocks, got %d: %+v", len(parsed.Content), parsed.Content)
+	}
+	if parsed.Content[0].Text != "Hello world" {
+		t.Errorf("Expected merged text 'Hello world', got %q", parsed.Content[0].Text)
+	}
+	if parsed.Content[1].Type != "tool_use" || parsed.Content[1].ToolID != "read_file" {
+		t.Errorf("Expected tool call keyed by name, got %+v", parsed.Content[1])
+	}
+	if parsed.Usage.InputTokens != 50 || parsed.Usage.OutputTokens != 12 {
+		t.Errorf("Unexpected usage: %+v", parsed.Usage)
+	}
+	if parsed.StopReason != "STOP" {
+		t.Errorf("Expected stop reason STOP, got %q", parsed.StopReason)
+	}
+
+	if got := extractDeltaText([]byte(chunks[0].Raw), "gemini"); got != "Hello" {
+		t.Errorf("Expected delta text 'Hello', got %q", got)
+	}
+}
+
+func TestGeminiParseStream_JSONArray(t *testing.T) {
+	// Without alt=sse, Gemini streams a pretty-printed JSON array
+	chunks := []StreamChunk{
+		{Raw: "[{\n"},
+		{Raw: `  "candidates": [{"content": {"role": "model", "parts": [{"text": "Hel"}]}}]` + "\n"},
+		{Raw: "}\n"},
+		{Raw: ",\r\n"},
+		{Raw: "{\n"},
+		{Raw: `  "candidates": [{"content": {"role": "model", "parts": [{"text": "lo"}]}, "finishReason": "STOP"}],` + "\n"},
+		{Raw: `  "usageMetadata": {"promptTokenCount": 8, "candidatesTokenCount": 2}` + "\n"},
+		{Raw: "}\n"},
+		{Raw: "]"},
+	}
+
+	parsed := geminiProvider{}.ParseStream(chunks)
+
+	if len(parsed.Content) != 1 || parsed.Content[0].Text != "Hello" {
+		t.Fatalf("Expected merged text 'Hello', got %+v", parsed.Content)
+	}
+	if parsed.Usage.InputTokens != 8 || parsed.Usage.OutputTokens != 2 {
+		t.Errorf("Unexpected usage: %+v", parsed.Usage)
+	}
+}
+
+func TestGeminiGroupAndParseTurns(t *testing.T) {
+	explorer := NewExplorer(t.TempDir())
+
+	entries := []LogEntry{
+		{Type: "request", Seq: 1, Body: `{"contents":[{"role":"user","parts":[{"text":"Hi Gemini"}]}]}`},
+		{Type: "response", Seq: 1, Chunks: []StreamChunk{
+			{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Hi there"}]},"finishReason":"STOP"}]}` + "\n"},
+		}},
+	}
+
+	// Host unknown (e.g. a regional endpoint): the body shape decides
+	turns := explorer.groupAndParseTurns(entries, "")
+	if len(turns) != 1 {
+		t.Fatalf("Expected 1 turn, got %d", len(turns))
+	}
+	if turns[0].LastUserMessage == nil || turns[0].LastUserMessage.TextContent != "Hi Gemini" {
+		t.Errorf("Expected last user message 'Hi Gemini', got %+v", turns[0].LastUserMessage)
+	}
+	if len(turns[0].RespParsed.Content) != 1 || turns[0].RespParsed.Content[0].Text != "Hi there" {
+		t.Errorf("Expected response text 'Hi there', got %+v", turns[0].RespParsed.Content)
+	}
+}
+
+func TestGeminiProxyStreamEmitsToolEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[{\n"))
+		w.Write([]byte(`  "candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "read_file", "args": {"path": "go.mod"}}}]}, "finishReason": "STOP"}],` + "\n"))
+		w.Write([]byte(`  "usageMetadata": {"promptTokenCount": 30, "candidatesTokenCount": 5},` + "\n"))
+		w.Write([]byte(`  "modelVersion": "gemini-2.5-pro"` + "\n"))
+		w.Write([]byte("}\n]"))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	emitter := &MockEventEmitter{}
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/gemini/"+upstreamHost+"/v1beta/models/gemini-2.5-pro:streamGenerateContent", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Session-ID", "gemini-session")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(`{"contents":[{"role":"user","parts":[{"text":"read go.mod"}]}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Body.String(), "[{") {
+		t.Errorf("Expected JSON array passed through, got %q", w.Body.String())
+	}
+
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "read_file" {
+		t.Fatalf("Expected one read_file tool_call event, got %+v", emitter.ToolCallEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 || emitter.TurnEndEvents[0].Tokens.InputTokens != 30 {
+		t.Errorf("Expected turn_end with Gemini usage, got %+v", emitter.TurnEndEvents)
+	}
+
+	// The functionResponse in the follow-up pairs with the call by name
+	send(`{"contents":[
+		{"role":"user","parts":[{"text":"read go.mod"}]},
+		{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"go.mod"}}}]},
+		{"role":"user","parts":[{"functionResponse":{"name":"read_file","response":{"output":"module example"}}}]}
+	]}`)
+	if len(emitter.ToolResultEvents) != 1 || emitter.ToolResultEvents[0].ToolName != "read_file" {
+		t.Errorf("Expected one read_file tool_result event, got %+v", emitter.ToolResultEvents)
+	}
+}
@@ -0,0 +1,494 @@
+// parser_openai.go
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// OpenAI payloads are normalized into the same ParsedResponse/ContentBlock
+// model used for Anthropic so event emission and the explorer don't need to
+// know which provider produced them:
+//   - function calls become "tool_use" blocks (ToolID is the call_id)
+//   - assistant text becomes "text" blocks
+//   - reasoning (summaries or reasoning_content) becomes "thinking" blocks
+//
+// Usage follows Anthropic semantics: InputTokens excludes cached prompt tokens,
+// which are reported separately as CacheReadInputTokens.
+
+// parseOpenAIUsage maps a Chat Completions or Responses API usage object onto UsageInfo.
+func parseOpenAIUsage(usage map[string]interface{}) UsageInfo {
+	var info UsageInfo
+
+	// Chat Completions: prompt_tokens / completion_tokens / prompt_tokens_details
+	// Responses API:    input_tokens / output_tokens / input_tokens_details
+	input := intField(usage, "prompt_tokens")
+	if _, ok := usage["input_tokens"]; ok {
+		input = intField(usage, "input_tokens")
+	}
+	output := intField(usage, "completion_tokens")
+	if _, ok := usage["output_tokens"]; ok {
+		output = intField(usage, "output_tokens")
+	}
+
+	var cached int
+	if details, ok := usage["prompt_tokens_details"].(map[string]interface{}); ok {
+		cached = intField(details, "cached_tokens")
+	}
+	if details, ok := usage["input_tokens_details"].(map[string]interface{}); ok {
+		cached = intField(details, "cached_tokens")
+	}
+	if cached > input {
+		cached = input
+	}
+
+	info.InputTokens = input - cached
+	info.OutputTokens = output
+	info.CacheReadInputTokens = cached
+	return info
+}
+
+// intField reads a JSON number field as an int, returning 0 if absent.
+func intField(m map[string]interface{}, key string) int {
+	if v, ok := m[key].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// parseToolArguments decodes an OpenAI function-call arguments string.
+// Arguments that aren't a JSON object are kept under an "input" key so
+// nothing the model produced is lost.
+func parseToolArguments(args string) map[string]interface{} {
+	if args == "" {
+		return nil
+	}
+	var input map[string]interface{}
+	if json.Unmarshal([]byte(args), &input) == nil {
+		return input
+	}
+	return map[string]interface{}{"input": args}
+}
+
+// parseResponsesOutputItem converts a Responses API output item into a ContentBlock.
+func parseResponsesOutputItem(item map[string]interface{}) ContentBlock {
+	cb := ContentBlock{Raw: item}
+	itemType, _ := item["type"].(string)
+
+	switch itemType {
+	case "message":
+		cb.Type = "text"
+		var parts []string
+		if content, ok := item["content"].([]interface{}); ok {
+			for _, c := range content {
+				if part, ok := c.(map[string]interface{}); ok {
+					if text, ok := part["text"].(string); ok {
+						parts = append(parts, text)
+					} else if refusal, ok := part["refusal"].(string); ok {
+						parts = append(parts, refusal)
+					}
+				}
+			}
+		}
+		cb.Text = strings.Join(parts, "")
+	case "reasoning":
+		cb.Type = "thinking"
+		var parts []string
+		if summary, ok := item["summary"].([]interface{}); ok {
+			for _, s := range summary {
+				if part, ok := s.(map[string]interface{}); ok {
+					if text, ok := part["text"].(string); ok {
+						parts = append(parts, text)
+					}
+				}
+			}
+		}
+		cb.Thinking = strings.Join(parts, "\n\n")
+	case "function_call":
+		cb.Type = "tool_use"
+		cb.ToolID, _ = item["call_id"].(string)
+		cb.ToolName, _ = item["name"].(string)
+		if args, ok := item["arguments"].(string); ok {
+			cb.ToolInput = parseToolArguments(args)
+		}
+	case "custom_tool_call":
+		cb.Type = "tool_use"
+		cb.ToolID, _ = item["call_id"].(string)
+		cb.ToolName, _ = item["name"].(string)
+		if input, ok := item["input"].(string); ok {
+			cb.ToolInput = map[string]interface{}{"input": input}
+		}
+	default:
+		cb.Type = itemType
+	}
+
+	return cb
+}
+
+// responsesStopReason derives a stop reason from a Responses API response object.
+// The Responses API has no finish_reason, so the status is used, refined by
+// incomplete_details.reason (e.g. "max_output_tokens") when present.
+func responsesStopReason(resp map[string]interface{}) string {
+	if details, ok := resp["incomplete_details"].(map[string]interface{}); ok {
+		if reason, ok := details["reason"].(string); ok && reason != "" {
+			return reason
+		}
+	}
+	status, _ := resp["status"].(string)
+	return status
+}
+
+// chatStreamBuilder rebuilds a response from Chat Completions stream chunks
+// (choices[].delta with content, reasoning_content and tool_calls).
+type chatStreamBuilder struct {
+	seen       bool
+	text       strings.Builder
+	reasoning  strings.Builder
+	toolCalls  map[int]*ContentBlock
+	toolArgs   map[int]*strings.Builder
+	usage      *UsageInfo
+	stopReason string
+}
+
+func (b *chatStreamBuilder) add(data map[string]interface{}) {
+	b.seen = true
+	if b.toolCalls == nil {
+		b.toolCalls = make(map[int]*ContentBlock)
+		b.toolArgs = make(map[int]*strings.Builder)
+	}
+
+	if usage, ok := data["usage"].(map[string]interface{}); ok {
+		u := parseOpenAIUsage(usage)
+		b.usage = &u
+	}
+
+	choices, _ := data["choices"].([]interface{})
+	for _, c := range choices {
+		choice, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Only the first choice is tracked; n>1 is not used by agents
+		if intField(choice, "index") != 0 {
+			continue
+		}
+		if finish, ok := choice["finish_reason"].(string); ok && finish != "" {
+			b.stopReason = finish
+		}
+		delta, ok := choice["delta"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if content, ok := delta["content"].(string); ok {
+			b.text.WriteString(content)
+		}
+		if reasoning, ok := delta["reasoning_content"].(string); ok {
+			b.reasoning.WriteString(reasoning)
+		}
+		toolCalls, _ := delta["tool_calls"].([]interface{})
+		for _, tc := range toolCalls {
+			call, ok := tc.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			idx := intField(call, "index")
+			block, ok := b.toolCalls[idx]
+			if !ok {
+				block = &ContentBlock{Type: "tool_use"}
+				b.toolCalls[idx] = block
+				b.toolArgs[idx] = &strings.Builder{}
+			}
+			if id, ok := call["id"].(string); ok && id != "" {
+				block.ToolID = id
+			}
+			if fn, ok := call["function"].(map[string]interface{}); ok {
+				if name, ok := fn["name"].(string); ok && name != "" {
+					block.ToolName = name
+				}
+				if args, ok := fn["arguments"].(string); ok {
+					b.toolArgs[idx].WriteString(args)
+				}
+			}
+		}
+	}
+}
+
+func (b *chatStreamBuilder) finish(parsed *ParsedResponse) {
+	if b.reasoning.Len() > 0 {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "thinking", Thinking: b.reasoning.String()})
+	}
+	if b.text.Len() > 0 {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "text", Text: b.text.String()})
+	}
+
+	indices := make([]int, 0, len(b.toolCalls))
+	for idx := range b.toolCalls {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+	for _, idx := range indices {
+		block := *b.toolCalls[idx]
+		block.ToolInput = parseToolArguments(b.toolArgs[idx].String())
+		parsed.Content = append(parsed.Content, block)
+	}
+
+	if b.usage != nil {
+		parsed.Usage = *b.usage
+	}
+	parsed.StopReason = b.stopReason
+}
+
+// responsesStreamBuilder rebuilds a response from Responses API stream events
+// (response.output_item.*, response.*.delta, response.completed).
+type responsesStreamBuilder struct {
+	seen       bool
+	blocks     map[int]*ContentBlock
+	builders   map[int]*strings.Builder
+	done       map[int]bool
+	usage      *UsageInfo
+	stopReason string
+}
+
+func (b *responsesStreamBuilder) add(eventType string, data map[string]interface{}) {
+	b.seen = true
+	if b.blocks == nil {
+		b.blocks = make(map[int]*ContentBlock)
+		b.builders = make(map[int]*strings.Builder)
+		b.done = make(map[int]bool)
+	}
+
+	idx := intField(data, "output_index")
+
+	switch eventType {
+	case "response.output_item.added":
+		if item, ok := data["item"].(map[string]interface{}); ok {
+			block := parseResponsesOutputItem(item)
+			b.blocks[idx] = &block
+			b.builders[idx] = &strings.Builder{}
+		}
+
+	case "response.output_text.delta", "response.refusal.delta",
+		"response.function_call_arguments.delta", "response.custom_tool_call_input.delta",
+		"response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+		if delta, ok := data["delta"].(string); ok {
+			if _, ok := b.builders[idx]; !ok {
+				b.builders[idx] = &strings.Builder{}
+			}
+			b.builders[idx].WriteString(delta)
+		}
+
+	case "response.output_item.done":
+		// The done event carries the complete item and supersedes accumulated deltas
+		if item, ok := data["item"].(map[string]interface{}); ok {
+			block := parseResponsesOutputItem(item)
+			b.blocks[idx] = &block
+			b.done[idx] = true
+		}
+
+	case "response.completed", "response.incomplete", "response.failed":
+		if resp, ok := data["response"].(map[string]interface{}); ok {
+			if usage, ok := resp["usage"].(map[string]interface{}); ok {
+				u := parseOpenAIUsage(usage)
+				b.usage = &u
+			}
+			b.stopReason = responsesStopReason(resp)
+		}
+	}
+}
+
+func (b *responsesStreamBuilder) finish(parsed *ParsedResponse) {
+	indices := make([]int, 0, len(b.blocks))
+	for idx := range b.blocks {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+
+	for _, idx := range indices {
+		block := *b.blocks[idx]
+		if !b.done[idx] {
+			// Stream ended before output_item.done - fall back to accumulated deltas
+			if sb, ok := b.builders[idx]; ok && sb.Len() > 0 {
+				switch block.Type {
+				case "text":
+					block.Text = sb.String()
+				case "thinking":
+					block.Thinking = sb.String()
+				case "tool_use":
+					block.ToolInput = parseToolArguments(sb.String())
+				}
+			}
+		}
+		parsed.Content = append(parsed.Content, block)
+	}
+
+	if b.usage != nil {
+		parsed.Usage = *b.usage
+	}
+	parsed.StopReason = b.stopReason
+}
+
+// isOpenAIResponseBody reports whether a response body is shaped like a Chat
+// Completions or Responses API object, which covers OpenAI-compatible servers
+// on hosts no provider claims.
+func isOpenAIResponseBody(raw map[string]interface{}) bool {
+	if _, ok := raw["choices"]; ok {
+		return true
+	}
+	object, _ := raw["object"].(string)
+	return object == "response" || object == "chat.completion"
+}
+
+// parseOpenAIResponseBody parses a Chat Completions or Responses API response body.
+func parseOpenAIResponseBody(raw map[string]interface{}) ParsedResponse {
+	parsed := ParsedResponse{Raw: raw}
+
+	if usage, ok := raw["usage"].(map[string]interface{}); ok {
+		parsed.Usage = parseOpenAIUsage(usage)
+	}
+
+	// Responses API: {"object":"response","output":[...],"status":"completed"}
+	if output, ok := raw["output"].([]interface{}); ok {
+		for _, o := range output {
+			if item, ok := o.(map[string]interface{}); ok {
+				parsed.Content = append(parsed.Content, parseResponsesOutputItem(item))
+			}
+		}
+		parsed.StopReason = responsesStopReason(raw)
+		return parsed
+	}
+
+	// Chat Completions: {"choices":[{"message":{...},"finish_reason":"..."}]}
+	choices, _ := raw["choices"].([]interface{})
+	if len(choices) == 0 {
+		return parsed
+	}
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return parsed
+	}
+	if finish, ok := choice["finish_reason"].(string); ok {
+		parsed.StopReason = finish
+	}
+	if message, ok := choice["message"].(map[string]interface{}); ok {
+		parsed.Content = parseChatMessageContent(message)
+	}
+
+	return parsed
+}
+
+// parseChatMessageContent converts a Chat Completions assistant message into content blocks.
+func parseChatMessageContent(message map[string]interface{}) []ContentBlock {
+	var blocks []ContentBlock
+
+	if reasoning, ok := message["reasoning_content"].(string); ok && reasoning != "" {
+		blocks = append(blocks, ContentBlock{Type: "thinking", Thinking: reasoning})
+	}
+	if content, ok := message["content"].(string); ok && content != "" {
+		blocks = append(blocks, ContentBlock{Type: "text", Text: content})
+	}
+	if refusal, ok := message["refusal"].(string); ok && refusal != "" {
+		blocks = append(blocks, ContentBlock{Type: "text", Text: refusal})
+	}
+
+	toolCalls, _ := message["tool_calls"].([]interface{})
+	for _, tc := range toolCalls {
+		call, ok := tc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cb := ContentBlock{Type: "tool_use", Raw: call}
+		cb.ToolID, _ = call["id"].(string)
+		if fn, ok := call["function"].(map[string]interface{}); ok {
+			cb.ToolName, _ = fn["name"].(string)
+			if args, ok := fn["arguments"].(string); ok {
+				cb.ToolInput = parseToolArguments(args)
+			}
+		}
+		blocks = append(blocks, cb)
+	}
+
+	return blocks
+}
+
+// parseResponsesInput normalizes a Responses API request input into messages.
+// Consecutive function_call items are grouped into one assistant message and
+// consecutive function_call_output items into one "tool" message, mirroring how
+// Anthropic groups tool_use and tool_result blocks. Returns any system/developer
+// instructions found in the input.
+func parseResponsesInput(input interface{}, parsed *ParsedRequest) []string {
+	if text, ok := input.(string); ok {
+		parsed.Messages = append(parsed.Messages, ParsedMessage{Role: "user", TextContent: text})
+		return nil
+	}
+
+	items, ok := input.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var system []string
+	// appendBlock adds a block to the last message if it has the given role,
+	// otherwise starts a new message.
+	appendBlock := func(role string, block ContentBlock) {
+		if n := len(parsed.Messages); n > 0 && parsed.Messages[n-1].Role == role {
+			last := &parsed.Messages[n-1]
+			if last.Content == nil && last.TextContent != "" {
+				last.Content = []ContentBlock{{Type: "text", Text: last.TextContent}}
+			}
+			last.Content = append(last.Content, block)
+			return
+		}
+		parsed.Messages = append(parsed.Messages, ParsedMessage{Role: role, Content: []ContentBlock{block}})
+	}
+
+	for _, i := range items {
+		item, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		itemType, _ := item["type"].(string)
+
+		switch itemType {
+		case "", "message":
+			pm := parseMessage(item)
+			if isSystemRole(pm.Role) {
+				system = append(system, messageText(pm))
+				continue
+			}
+			parsed.Messages = append(parsed.Messages, pm)
+
+		case "function_call", "custom_tool_call", "reasoning":
+			appendBlock("assistant", parseResponsesOutputItem(item))
+
+		case "function_call_output", "custom_tool_call_output":
+			cb := ContentBlock{Type: "tool_result", Raw: item}
+			cb.ToolID, _ = item["call_id"].(string)
+			cb.Text = outputText(item["output"])
+			appendBlock("tool", cb)
+		}
+	}
+
+	return system
+}
+
+// outputText flattens a function_call_output payload, which is either a string
+// or a list of content parts, into plain text.
+func outputText(output interface{}) string {
+	if text, ok := output.(string); ok {
+		return text
+	}
+	parts, ok := output.([]interface{})
+	if !ok {
+		return ""
+	}
+	var texts []string
+	for _, p := range parts {
+		if part, ok := p.(map[string]interface{}); ok {
+			if text, ok := part["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,389 @@
+// parser_openai_test.go
+package main
+
+import (
+	"testing"
+)
+
+func TestParseStreamingResponse_ChatCompletionsText(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`},
+		{Raw: `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`},
+		{Raw: `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":" world"},"finish_reason":null}]}`},
+		{Raw: `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`},
+		{Raw: `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":120,"completion_tokens":7,"prompt_tokens_details":{"cached_tokens":100}}}`},
+		{Raw: `data: [DONE]`},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 1 {
+		t.Fatalf("Expected 1 content block, got %d", len(parsed.Content))
+	}
+	if parsed.Content[0].Type != "text" || parsed.Content[0].Text != "Hello world" {
+		t.Errorf("Expected text 'Hello world', got %+v", parsed.Content[0])
+	}
+	if parsed.StopReason != "stop" {
+		t.Errorf("Expected stop_reason 'stop', got %q", parsed.StopReason)
+	}
+	if parsed.Usage.InputTokens != 20 {
+		t.Errorf("Expected uncached input_tokens 20, got %d", parsed.Usage.InputTokens)
+	}
+	if parsed.Usage.CacheReadInputTokens != 100 {
+		t.Errorf("Expected cache_read_input_tokens 100, got %d", parsed.Usage.CacheReadInputTokens)
+	}
+	if parsed.Usage.OutputTokens != 7 {
+		t.Errorf("Expected output_tokens 7, got %d", parsed.Usage.OutputTokens)
+	}
+}
+
+func TestParseStreamingResponse_ChatCompletionsToolCalls(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: `data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"read_file","arguments":""}}]}}]}`},
+		{Raw: `data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}}]}`},
+		{Raw: `data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"/tmp/a\"}"}}]}}]}`},
+		{Raw: `data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"list_dir","arguments":"{}"}}]}}]}`},
+		{Raw: `data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`},
+		{Raw: `data: [DONE]`},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 2 {
+		t.Fatalf("Expected 2 tool_use blocks, got %d", len(parsed.Content))
+	}
+	first := parsed.Content[0]
+	if first.Type != "tool_use" || first.ToolID != "call_a" || first.ToolName != "read_file" {
+		t.Errorf("Unexpected first tool block: %+v", first)
+	}
+	if first.ToolInput["path"] != "/tmp/a" {
+		t.Errorf("Expected tool input path '/tmp/a', got %v", first.ToolInput)
+	}
+	if parsed.Content[1].ToolID != "call_b" || parsed.Content[1].ToolName != "list_dir" {
+		t.Errorf("Unexpected second tool block: %+v", parsed.Content[1])
+	}
+	if parsed.StopReason != "tool_calls" {
+		t.Errorf("Expected stop_reason 'tool_calls', got %q", parsed.StopReason)
+	}
+
+	calls := extractToolCalls(parsed.Content)
+	if len(calls) != 2 || calls[1].ToolIndex != 1 {
+		t.Errorf("Expected 2 extracted tool calls, got %+v", calls)
+	}
+}
+
+func TestParseStreamingResponse_ResponsesAPI(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: "event: response.created\n"},
+		{Raw: `data: {"type":"response.created","response":{"id":"resp_1","status":"in_progress"}}`},
+		{Raw: `data: {"type":"response.output_item.added","output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[]}}`},
+		{Raw: `data: {"type":"response.reasoning_summary_text.delta","output_index":0,"delta":"Thinking about it"}`},
+		{Raw: `data: {"type":"response.output_item.done","output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"Thinking about it"}]}}`},
+		{Raw: `data: {"type":"response.output_item.added","output_index":1,"item":{"type":"message","id":"msg_1","role":"assistant","content":[]}}`},
+		{Raw: `data: {"type":"response.output_text.delta","output_index":1,"content_index":0,"delta":"Let me "}`},
+		{Raw: `data: {"type":"response.output_text.delta","output_index":1,"content_index":0,"delta":"check."}`},
+		{Raw: `data: {"type":"response.output_item.added","output_index":2,"item":{"type":"function_call","id":"fc_1","call_id":"call_xyz","name":"shell","arguments":""}}`},
+		{Raw: `data: {"type":"response.function_call_arguments.delta","output_index":2,"item_id":"fc_1","delta":"{\"command\":[\"ls\"]}"}`},
+		{Raw: `data: {"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":500,"output_tokens":40,"input_tokens_details":{"cached_tokens":300}}}}`},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("Expected 3 content blocks, got %d: %+v", len(parsed.Content), parsed.Content)
+	}
+	if parsed.Content[0].Type != "thinking" || parsed.Content[0].Thinking != "Thinking about it" {
+		t.Errorf("Unexpected reasoning block: %+v", parsed.Content[0])
+	}
+	// Message item never got output_item.done - text falls back to deltas
+	if parsed.Content[1].Type != "text" || parsed.Content[1].Text != "Let me check." {
+		t.Errorf("Unexpected text block: %+v", parsed.Content[1])
+	}
+	tool := parsed.Content[2]
+	if tool.Type != "tool_use" || tool.ToolID != "call_xyz" || tool.ToolName != "shell" {
+		t.Errorf("Unexpected tool block: %+v", tool)
+	}
+	if cmd, ok := tool.ToolInput["command"].([]interface{}); !ok || len(cmd) != 1 || cmd[0] != "ls" {
+		t.Errorf("Expected command [ls], got %v", tool.ToolInput)
+	}
+	if parsed.StopReason != "completed" {
+		t.Errorf("Expected stop_reason 'completed', got %q", parsed.StopReason)
+	}
+	if parsed.Usage.InputTokens != 200 || parsed.Usage.CacheReadInputTokens != 300 || parsed.Usage.OutputTokens != 40 {
+		t.Errorf("Unexpected usage: %+v", parsed.Usage)
+	}
+}
+
+func TestParseStreamingResponse_ResponsesAPIIncomplete(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: `data: {"type":"response.output_item.added","output_index":0,"item":{"type":"message","content":[]}}`},
+		{Raw: `data: {"type":"response.output_text.delta","output_index":0,"delta":"Partial"}`},
+		{Raw: `data: {"type":"response.incomplete","response":{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},"usage":{"input_tokens":10,"output_tokens":5}}}`},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if parsed.StopReason != "max_output_tokens" {
+		t.Errorf("Expected stop_reason 'max_output_tokens', got %q", parsed.StopReason)
+	}
+	if len(parsed.Content) != 1 || parsed.Content[0].Text != "Partial" {
+		t.Errorf("Unexpected content: %+v", parsed.Content)
+	}
+}
+
+func TestParseOpenAIUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		usage  map[string]interface{}
+		expect UsageInfo
+	}{
+		{
+			name:   "chat completions without cache",
+			usage:  map[string]interface{}{"prompt_tokens": float64(50), "completion_tokens": float64(10)},
+			expect: UsageInfo{InputTokens: 50, OutputTokens: 10},
+		},
+		{
+			name: "chat completions with cache",
+			usage: map[string]interface{}{
+				"prompt_tokens":         float64(50),
+				"completion_tokens":     float64(10),
+				"prompt_tokens_details": map[string]interface{}{"cached_tokens": float64(30)},
+			},
+			expect: UsageInfo{InputTokens: 20, OutputTokens: 10, CacheReadInputTokens: 30},
+		},
+		{
+			name: "responses api with cache",
+			usage: map[string]interface{}{
+				"input_tokens":         float64(80),
+				"output_tokens":        float64(4),
+				"input_tokens_details": map[string]interface{}{"cached_tokens": float64(64)},
+			},
+			expect: UsageInfo{InputTokens: 16, OutputTokens: 4, CacheReadInputTokens: 64},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseOpenAIUsage(tt.usage)
+			if got != tt.expect {
+				t.Errorf("Expected %+v, got %+v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestParseResponseBody_ChatCompletionsToolCalls(t *testing.T) {
+	body := `{
+		"id": "chatcmpl-1",
+		"object": "chat.completion",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"content": "Reading it now.",
+				"tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "read_file", "arguments": "{\"path\":\"/etc/hosts\"}"}}
+				]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 300, "completion_tokens": 20, "prompt_tokens_details": {"cached_tokens": 256}}
+	}`
+
+	parsed := ParseResponseBody(body, "api.openai.com")
+
+	if len(parsed.Content) != 2 {
+		t.Fatalf("Expected 2 content blocks, got %d", len(parsed.Content))
+	}
+	if parsed.Content[0].Type != "text" || parsed.Content[0].Text != "Reading it now." {
+		t.Errorf("Unexpected text block: %+v", parsed.Content[0])
+	}
+	tool := parsed.Content[1]
+	if tool.Type != "tool_use" || tool.ToolID != "call_1" || tool.ToolName != "read_file" {
+		t.Errorf("Unexpected tool block: %+v", tool)
+	}
+	if tool.ToolInput["path"] != "/etc/hosts" {
+		t.Errorf("Expected path /etc/hosts, got %v", tool.ToolInput)
+	}
+	if parsed.StopReason != "tool_calls" {
+		t.Errorf("Expected stop_reason 'tool_calls', got %q", parsed.StopReason)
+	}
+	if parsed.Usage.InputTokens != 44 || parsed.Usage.CacheReadInputTokens != 256 || parsed.Usage.OutputTokens != 20 {
+		t.Errorf("Unexpected usage: %+v", parsed.Usage)
+	}
+}
+
+func TestParseResponseBody_ResponsesAPI(t *testing.T) {
+	body := `{
+		"id": "resp_1",
+		"object": "response",
+		"status": "completed",
+		"output": [
+			{"type": "reasoning", "id": "rs_1", "summary": [{"type": "summary_text", "text": "Need to list files"}]},
+			{"type": "message", "id": "msg_1", "role": "assistant", "content": [{"type": "output_text", "text": "Listing."}]},
+			{"type": "function_call", "id": "fc_1", "call_id": "call_9", "name": "shell", "arguments": "{\"command\":[\"ls\",\"-la\"]}"}
+		],
+		"usage": {"input_tokens": 1000, "output_tokens": 50, "input_tokens_details": {"cached_tokens": 900}}
+	}`
+
+	parsed := ParseResponseBody(body, "chatgpt.com")
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("Expected 3 content blocks, got %d", len(parsed.Content))
+	}
+	if parsed.Content[0].Type != "thinking" || parsed.Content[0].Thinking != "Need to list files" {
+		t.Errorf("Unexpected reasoning block: %+v", parsed.Content[0])
+	}
+	if parsed.Content[1].Type != "text" || parsed.Content[1].Text != "Listing." {
+		t.Errorf("Unexpected text block: %+v", parsed.Content[1])
+	}
+	if parsed.Content[2].Type != "tool_use" || parsed.Content[2].ToolID != "call_9" || parsed.Content[2].ToolName != "shell" {
+		t.Errorf("Unexpected tool block: %+v", parsed.Content[2])
+	}
+	if parsed.StopReason != "completed" {
+		t.Errorf("Expected stop_reason 'completed', got %q", parsed.StopReason)
+	}
+	if parsed.Usage.InputTokens != 100 || parsed.Usage.CacheReadInputTokens != 900 || parsed.Usage.OutputTokens != 50 {
+		t.Errorf("Unexpected usage: %+v", parsed.Usage)
+	}
+}
+
+func TestParseResponseBody_OpenAICompatibleUnknownHost(t *testing.T) {
+	// Local OpenAI-compatible servers are detected from the body shape
+	body := `{"object":"chat.completion","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`
+
+	parsed := ParseResponseBody(body, "localhost:8000")
+
+	if len(parsed.Content) != 1 || parsed.Content[0].Text != "hi" {
+		t.Errorf("Unexpected content: %+v", parsed.Content)
+	}
+	if parsed.StopReason != "stop" {
+		t.Errorf("Expected stop_reason 'stop', got %q", parsed.StopReason)
+	}
+	if parsed.Usage.InputTokens != 3 || parsed.Usage.OutputTokens != 1 {
+		t.Errorf("Unexpected usage: %+v", parsed.Usage)
+	}
+}
+
+func TestParseResponseBody_AnthropicUnaffected(t *testing.T) {
+	body := `{"type":"message","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":2}}`
+
+	parsed := ParseResponseBody(body, "api.anthropic.com")
+
+	if len(parsed.Content) != 1 || parsed.StopReason != "end_turn" || parsed.Usage.InputTokens != 5 {
+		t.Errorf("Anthropic parsing changed: %+v", parsed)
+	}
+}
+
+func TestParseRequestBody_ChatCompletionsToolMessages(t *testing.T) {
+	body := `{
+		"model": "gpt-4o",
+		"max_completion_tokens": 2048,
+		"messages": [
+			{"role": "system", "content": "You are a coding agent."},
+			{"role": "developer", "content": "Prefer small diffs."},
+			{"role": "user", "content": "Read /etc/hosts"},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "read_file", "arguments": "{\"path\":\"/etc/hosts\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "127.0.0.1 localhost"}
+		]
+	}`
+
+	parsed := ParseRequestBody(body, "api.openai.com")
+
+	if parsed.System != "You are a coding agent.\n\nPrefer small diffs." {
+		t.Errorf("Unexpected system prompt: %q", parsed.System)
+	}
+	if parsed.MaxTokens != 2048 {
+		t.Errorf("Expected max tokens 2048, got %d", parsed.MaxTokens)
+	}
+	if len(parsed.Messages) != 3 {
+		t.Fatalf("Expected 3 non-system messages, got %d", len(parsed.Messages))
+	}
+
+	assistant := parsed.Messages[1]
+	if len(assistant.Content) != 1 || assistant.Content[0].Type != "tool_use" || assistant.Content[0].ToolName != "read_file" {
+		t.Errorf("Unexpected assistant content: %+v", assistant.Content)
+	}
+
+	tool := parsed.Messages[2]
+	if tool.Role != "tool" || len(tool.Content) != 1 {
+		t.Fatalf("Unexpected tool message: %+v", tool)
+	}
+	if tool.Content[0].Type != "tool_result" || tool.Content[0].ToolID != "call_1" || tool.Content[0].Text != "127.0.0.1 localhost" {
+		t.Errorf("Unexpected tool_result block: %+v", tool.Content[0])
+	}
+}
+
+func TestParseRequestBody_ResponsesAPIInput(t *testing.T) {
+	body := `{
+		"model": "gpt-5-codex",
+		"instructions": "You are Codex.",
+		"max_output_tokens": 1000,
+		"input": [
+			{"type": "message", "role": "developer", "content": [{"type": "input_text", "text": "Sandbox: read-only"}]},
+			{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "List files"}]},
+			{"type": "reasoning", "summary": [{"type": "summary_text", "text": "Use ls"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "shell", "arguments": "{\"command\":[\"ls\"]}"},
+			{"type": "function_call", "call_id": "call_2", "name": "shell", "arguments": "{\"command\":[\"pwd\"]}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "a.go\nb.go"},
+			{"type": "function_call_output", "call_id": "call_2", "output": "/repo"}
+		]
+	}`
+
+	parsed := ParseRequestBody(body, "chatgpt.com")
+
+	if parsed.System != "You are Codex.\n\nSandbox: read-only" {
+		t.Errorf("Unexpected system prompt: %q", parsed.System)
+	}
+	if parsed.MaxTokens != 1000 {
+		t.Errorf("Expected max tokens 1000, got %d", parsed.MaxTokens)
+	}
+	if len(parsed.Messages) != 3 {
+		t.Fatalf("Expected user, assistant and tool messages, got %d: %+v", len(parsed.Messages), parsed.Messages)
+	}
+
+	user := parsed.Messages[0]
+	if user.Role != "user" || user.TextContent != "List files" {
+		t.Errorf("Unexpected user message: %+v", user)
+	}
+
+	assistant := parsed.Messages[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 3 {
+		t.Fatalf("Expected assistant with thinking + 2 tool_use blocks, got %+v", assistant)
+	}
+	if assistant.Content[1].ToolID != "call_1" || assistant.Content[2].ToolID != "call_2" {
+		t.Errorf("Unexpected tool_use blocks: %+v", assistant.Content)
+	}
+
+	tool := parsed.Messages[2]
+	if tool.Role != "tool" || len(tool.Content) != 2 {
+		t.Fatalf("Expected tool message with 2 results, got %+v", tool)
+	}
+	if tool.Content[0].ToolID != "call_1" || tool.Content[0].Text != "a.go\nb.go" {
+		t.Errorf("Unexpected first tool_result: %+v", tool.Content[0])
+	}
+}
+
+func TestParseRequestBody_ResponsesAPIStringInput(t *testing.T) {
+	parsed := ParseRequestBody(`{"model":"gpt-5","input":"hello"}`, "api.openai.com")
+
+	if len(parsed.Messages) != 1 || parsed.Messages[0].Role != "user" || parsed.Messages[0].TextContent != "hello" {
+		t.Errorf("Unexpected messages: %+v", parsed.Messages)
+	}
+}
+
+func TestExtractToolResults_OpenAI(t *testing.T) {
+	chat := []byte(`{"messages":[{"role":"tool","tool_call_id":"call_a","content":"ok"}]}`)
+	results := extractToolResults(chat)
+	if len(results) != 1 || results[0].ToolUseID != "call_a" {
+		t.Errorf("Expected call_a from chat tool message, got %+v", results)
+	}
+
+	responses := []byte(`{"input":[{"type":"function_call_output","call_id":"call_b","output":"ok"}]}`)
+	results = extractToolResults(responses)
+	if len(results) != 1 || results[0].ToolUseID != "call_b" {
+		t.Errorf("Expected call_b from function_call_output, got %+v", results)
+	}
+}
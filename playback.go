@@ -0,0 +1,341 @@
+// playback.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Playback mode answers requests from recorded session logs instead of the
+// upstream, for hermetic agent tests. A request matches a recording when its
+// method, path and canonical body (see canonicalizeMap) are the same;
+// streamed responses are replayed chunk by chunk, optionally paced by their
+// recorded timing. Played back exchanges are logged like any other, so a test
+// run leaves a normal session log behind.
+//
+// Recordings are keyed on the logged request body, so exchanges logged with
+// redactions can't be played back: the logged body is not what the client
+// sends, and the response is not what it expects. They are skipped, and so
+// are deduplicated bodies whose blobs are missing.
+
+// ErrPlaybackMiss is returned for a request with no recording when on_miss is "fail".
+var ErrPlaybackMiss = errors.New("playback: no recording matches request")
+
+var playbackMissModes = []string{"fail", "passthrough", "record"}
+
+// playbackExcludeKeys are top-level request fields left out of the match.
+// They identify the client session rather than the conversation, and change
+// on every test run.
+var playbackExcludeKeys = []string{"metadata", "user"}
+
+// PlaybackConfig configures record/playback mode.
+type PlaybackConfig struct {
+	Enabled bool    `toml:"enabled"`
+	Dir     string  `toml:"dir"`     // Log directory to play back from (default: log_dir)
+	OnMiss  string  `toml:"on_miss"` // "fail" (default), "passthrough" or "record"
+	Speed   float64 `toml:"speed"`   // Chunk timing: 0 = no delays (default), 1 = as recorded, 2 = twice as fast
+}
+
+// Validate checks on_miss and speed.
+func (c PlaybackConfig) Validate() error {
+	if c.OnMiss != "" && !slices.Contains(playbackMissModes, c.OnMiss) {
+		return fmt.Errorf("playback: invalid on_miss %q (valid: %s)", c.OnMiss, strings.Join(playbackMissModes, ", "))
+	}
+	if c.Speed < 0 {
+		return fmt.Errorf("playback: speed must not be negative")
+	}
+	return nil
+}
+
+// recordedResponse is one response to play back.
+type recordedResponse struct {
+	Status  int
+	Headers http.Header
+	Body    string
+	Chunks  []StreamChunk // Set for streamed responses instead of Body
+}
+
+// PlaybackTransport is an http.RoundTripper that serves recorded responses,
+// falling back to next according to on_miss.
+type PlaybackTransport struct {
+	next   http.RoundTripper
+	onMiss string
+	speed  float64
+
+	mu         sync.RWMutex
+	recordings map[string]*recordedResponse // playbackKey -> response
+	skipped    int                          // Redacted or unresolved exchanges left out at load
+}
+
+// NewPlaybackTransport loads the recordings under dir. next handles misses
+// for the passthrough and record modes.
+func NewPlaybackTransport(cfg PlaybackConfig, dir string, c *LogCipher, next http.RoundTripper) (*PlaybackTransport, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Dir != "" {
+		dir = expandHome(cfg.Dir)
+	}
+	t := &PlaybackTransport{
+		next:       next,
+		onMiss:     cfg.OnMiss,
+		speed:      cfg.Speed,
+		recordings: make(map[string]*recordedResponse),
+	}
+	if t.onMiss == "" {
+		t.onMiss = "fail"
+	}
+	if err := t.load(dir, c); err != nil {
+		return nil, fmt.Errorf("playback: loading recordings: %w", err)
+	}
+	return t, nil
+}
+
+// Skipped returns the number of logged exchanges that can't be played back
+// because they were redacted or their deduplicated body is missing.
+func (t *PlaybackTransport) Skipped() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.skipped
+}
+
+// Len returns the number of distinct recorded requests.
+func (t *PlaybackTransport) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.recordings)
+}
+
+// load indexes every logged request/response pair under dir. The first
+// recording of a request wins. Redacted or unresolved exchanges are skipped.
+func (t *PlaybackTransport) load(dir string, c *LogCipher) error {
+	explorer := &Explorer{logDir: dir, cipher: c}
+	return walkSessionLogs(dir, func(session sessionLog) error {
+		entries, err := explorer.parseSessionFiles(session.Paths)
+		if err != nil {
+			return nil // Skip unreadable sessions
+		}
+		for _, turn := range explorer.groupIntoTurns(entries) {
+			if turn.Request == nil || turn.Response == nil || turn.Response.Status == 0 {
+				continue
+			}
+			if !playableEntry(turn.Request.Raw) || !playableEntry(turn.Response.Raw) {
+				t.skipped++
+				continue
+			}
+			var logged struct {
+				Method string `json:"method"`
+			}
+			json.Unmarshal([]byte(turn.Request.Raw), &logged)
+			key := playbackKey(logged.Method, turn.Request.Path, []byte(turn.Request.Body))
+
+			var respMeta struct {
+				Headers http.Header `json:"headers"`
+			}
+			json.Unmarshal([]byte(turn.Response.Raw), &respMeta)
+			if _, ok := t.recordings[key]; !ok {
+				t.recordings[key] = &recordedResponse{
+					Status:  turn.Response.Status,
+					Headers: respMeta.Headers,
+					Body:    turn.Response.Body,
+					Chunks:  turn.Response.Chunks,
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// playableEntry reports whether a logged entry holds what was actually sent:
+// nothing redacted, and no body_segments left unresolved by missing blobs.
+func playableEntry(raw string) bool {
+	var entry struct {
+		BodySegments json.RawMessage `json:"body_segments"`
+		Meta         struct {
+			Redactions map[string]int `json:"redactions"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal([]byte(raw), &entry) != nil {
+		return false
+	}
+	return entry.BodySegments == nil && len(entry.Meta.Redactions) == 0
+}
+
+// playbackKey identifies a request by method, path and canonical body.
+func playbackKey(method, path string, body []byte) string {
+	if method == "" {
+		method = http.MethodPost
+	}
+	canonical := body
+	var raw map[string]interface{}
+	if json.Unmarshal(body, &raw) == nil {
+		for _, k := range playbackExcludeKeys {
+			delete(raw, k)
+		}
+		canonical, _ = json.Marshal(canonicalizeMap(raw))
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, path)
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *PlaybackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := playbackKey(req.Method, req.URL.Path, body)
+
+	t.mu.RLock()
+	recorded := t.recordings[key]
+	t.mu.RUnlock()
+	if recorded != nil {
+		return t.playResponse(req, recorded), nil
+	}
+
+	switch t.onMiss {
+	case "passthrough":
+		return t.next.RoundTrip(req)
+	case "record":
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = &recordingBody{
+			ReadCloser: resp.Body,
+			start:      time.Now(),
+			streaming:  isStreamingResponse(resp),
+			done: func(r *recordedResponse) {
+				r.Status, r.Headers = resp.StatusCode, resp.Header.Clone()
+				t.mu.Lock()
+				if _, ok := t.recordings[key]; !ok {
+					t.recordings[key] = r
+				}
+				t.mu.Unlock()
+			},
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrPlaybackMiss, req.Method, req.URL.Path)
+}
+
+// requestBody reads a copy of the request body, leaving req.Body unread
+// when the request can supply another copy.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// playResponse builds the response for a recording. Streamed chunks are
+// written as the body is read, paced by their recorded delta_ms.
+func (t *PlaybackTransport) playResponse(req *http.Request, recorded *recordedResponse) *http.Response {
+	headers := recorded.Headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Del("Content-Length")
+	headers.Del("Content-Encoding")
+	headers.Del("Transfer-Encoding")
+
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode: recorded.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     headers,
+		Request:    req,
+	}
+
+	if recorded.Chunks == nil {
+		resp.Body = io.NopCloser(strings.NewReader(recorded.Body))
+		resp.ContentLength = int64(len(recorded.Body))
+		return resp
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		var last int64
+		for _, chunk := range recorded.Chunks {
+			if t.speed > 0 && chunk.DeltaMs > last {
+				delay := time.Duration(float64(chunk.DeltaMs-last)/t.speed) * time.Millisecond
+				select {
+				case <-time.After(delay):
+				case <-req.Context().Done():
+					pw.CloseWithError(req.Context().Err())
+					return
+				}
+			}
+			last = chunk.DeltaMs
+			if _, err := pw.Write([]byte(chunk.Raw)); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	resp.Body = pr
+	resp.ContentLength = -1
+	return resp
+}
+
+// recordingBody captures a response as it is read and hands the recording
+// to done at EOF.
+type recordingBody struct {
+	io.ReadCloser
+	start     time.Time
+	streaming bool
+	body      bytes.Buffer
+	chunks    []StreamChunk
+	done      func(*recordedResponse)
+	once      sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if b.streaming {
+			now := time.Now()
+			b.chunks = append(b.chunks, StreamChunk{
+				Timestamp: now,
+				DeltaMs:   now.Sub(b.start).Milliseconds(),
+				Raw:       string(p[:n]),
+			})
+		} else {
+			b.body.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.once.Do(func() {
+			b.done(&recordedResponse{Body: b.body.String(), Chunks: b.chunks})
+		})
+	}
+	return n, err
+}
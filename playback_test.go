@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writePlaybackRecording writes a session log holding one exchange.
+func writePlaybackRecording(t *testing.T, dir, host, reqBody string, response map[string]interface{}) {
+	t.Helper()
+	response["type"] = "response"
+	response["seq"] = 1
+	lines := []map[string]interface{}{
+		{"type": "session_start", "provider": "anthropic", "upstream": host},
+		{"type": "request", "seq": 1, "method": "POST", "path": "/v1/messages", "body": reqBody},
+		response,
+	}
+	var content strings.Builder
+	for _, line := range lines {
+		data, _ := json.Marshal(line)
+		content.Write(data)
+		content.WriteString("\n")
+	}
+	writeTestLog(t, dir, host, "2026-01-15", "recorded.jsonl", content.String())
+}
+
+func TestPlaybackServesRecordedResponse(t *testing.T) {
+	recDir := t.TempDir()
+	writePlaybackRecording(t, recDir, "api.anthropic.com",
+		`{"model":"claude-x","metadata":{"user_id":"user_a_session_111"},"messages":[{"role":"user","content":"hi"}]}`,
+		map[string]interface{}{
+			"status":  200,
+			"headers": map[string][]string{"Content-Type": {"application/json"}, "Content-Length": {"999"}},
+			"body":    `{"content":[{"type":"text","text":"recorded"}]}`,
+		})
+
+	srv, err := NewServer(Config{LogDir: t.TempDir(), Playback: PlaybackConfig{Enabled: true, Dir: recDir}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	// Key order, the client session and cache_control don't affect the match
+	body := `{"messages":[{"content":"hi","role":"user","cache_control":{"type":"ephemeral"}}],"metadata":{"user_id":"user_a_session_222"},"model":"claude-x"}`
+	req := httptest.NewRequest("POST", "/anthropic/api.anthropic.com/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != `{"content":[{"type":"text","text":"recorded"}]}` {
+		t.Errorf("expected the recorded body, got %s", w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected recorded headers, got %v", w.Header())
+	}
+}
+
+func TestPlaybackSkipsRedactedAndUnresolvedExchanges(t *testing.T) {
+	recDir := t.TempDir()
+	lines := []string{
+		`{"type":"session_start","provider":"anthropic","upstream":"api.anthropic.com"}`,
+		`{"type":"request","seq":1,"method":"POST","path":"/v1/messages","body":"{\"messages\":[\"[REDACTED:email]\"]}","_meta":{"redactions":{"email":1}}}`,
+		`{"type":"response","seq":1,"status":200,"body":"{}"}`,
+		`{"type":"request","seq":2,"method":"POST","path":"/v1/messages","body":"{\"messages\":[2]}"}`,
+		`{"type":"response","seq":2,"status":200,"body":"[REDACTED:aws_key]","_meta":{"redactions":{"aws_key":1}}}`,
+		`{"type":"request","seq":3,"method":"POST","path":"/v1/messages","body_segments":[{"blob":"missing"}]}`,
+		`{"type":"response","seq":3,"status":200,"body":"{}"}`,
+		`{"type":"request","seq":4,"method":"POST","path":"/v1/messages","body":"{\"messages\":[4]}"}`,
+		`{"type":"response","seq":4,"status":200,"body":"{}"}`,
+	}
+	writeTestLog(t, recDir, "api.anthropic.com", "2026-01-15", "redacted.jsonl", strings.Join(lines, "\n")+"\n")
+
+	transport, err := NewPlaybackTransport(PlaybackConfig{}, recDir, nil, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("NewPlaybackTransport failed: %v", err)
+	}
+	if transport.Len() != 1 || transport.Skipped() != 3 {
+		t.Errorf("Expected 1 recording and 3 skipped exchanges, got %d and %d", transport.Len(), transport.Skipped())
+	}
+}
+
+func TestPlaybackMissFails(t *testing.T) {
+	srv, err := NewServer(Config{LogDir: t.TempDir(), Playback: PlaybackConfig{Enabled: true}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	req := httptest.NewRequest("POST", "/anthropic/api.anthropic.com/v1/messages", strings.NewReader(`{"messages":[]}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "no recording matches") {
+		t.Errorf("expected 502 for an unrecorded request, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPlaybackStreamsChunksWithTiming(t *testing.T) {
+	recDir := t.TempDir()
+	writePlaybackRecording(t, recDir, "api.anthropic.com", `{"stream":true,"messages":[]}`, map[string]interface{}{
+		"status":  200,
+		"headers": map[string][]string{"Content-Type": {"text/event-stream"}},
+		"chunks": []map[string]interface{}{
+			{"delta_ms": 0, "raw": "data: one\n\n"},
+			{"delta_ms": 200, "raw": "data: two\n\n"},
+		},
+	})
+
+	transport, err := NewPlaybackTransport(PlaybackConfig{Speed: 2}, recDir, nil, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("NewPlaybackTransport failed: %v", err)
+	}
+	req, _ := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", strings.NewReader(`{"messages":[],"stream":true}`))
+
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	elapsed := time.Since(start)
+
+	if string(data) != "data: one\n\ndata: two\n\n" {
+		t.Errorf("expected the recorded chunks, got %q", data)
+	}
+	if !isStreamingResponse(resp) {
+		t.Error("expected a streaming response")
+	}
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("expected chunks paced at half their recorded timing, took %v", elapsed)
+	}
+}
+
+func TestPlaybackPassthroughAndRecord(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(`{"content":[]}`))
+	}))
+	defer upstream.Close()
+
+	send := func(transport http.RoundTripper) string {
+		req, _ := http.NewRequest("POST", upstream.URL+"/v1/messages", strings.NewReader(`{"messages":[]}`))
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+
+	passthrough, err := NewPlaybackTransport(PlaybackConfig{OnMiss: "passthrough"}, t.TempDir(), nil, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(passthrough)
+	send(passthrough)
+	if atomic.LoadInt32(&hits) != 2 || passthrough.Len() != 0 {
+		t.Errorf("expected passthrough to reach the upstream every time without recording, got %d hits and %d recordings", hits, passthrough.Len())
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	record, err := NewPlaybackTransport(PlaybackConfig{OnMiss: "record"}, t.TempDir(), nil, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := send(record), send(record)
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("expected the second request to be played back, got %d upstream hits", hits)
+	}
+	if first != second {
+		t.Errorf("expected the recorded body to be played back, got %q then %q", first, second)
+	}
+
+	fail, err := NewPlaybackTransport(PlaybackConfig{}, t.TempDir(), nil, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", upstream.URL+"/v1/messages", strings.NewReader(`{}`))
+	if _, err := fail.RoundTrip(req); !errors.Is(err, ErrPlaybackMiss) {
+		t.Errorf("expected ErrPlaybackMiss, got %v", err)
+	}
+}
+
+func TestPlaybackConfigValidate(t *testing.T) {
+	if err := (PlaybackConfig{OnMiss: "ignore"}).Validate(); err == nil {
+		t.Error("expected an unknown on_miss to be rejected")
+	}
+	if err := (PlaybackConfig{Speed: -1}).Validate(); err == nil {
+		t.Error("expected a negative speed to be rejected")
+	}
+	if err := (PlaybackConfig{OnMiss: "record", Speed: 1}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
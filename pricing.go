@@ -0,0 +1,205 @@
+// pricing.go
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// ModelPrice holds per-million-token rates in USD for a model.
+// Unset cache rates fall back to the input rate.
+type ModelPrice struct {
+	Input      float64 `toml:"input"`       // Uncached input tokens
+	Output     float64 `toml:"output"`      // Output tokens (including reasoning)
+	CacheRead  float64 `toml:"cache_read"`  // Cache hit input tokens
+	CacheWrite float64 `toml:"cache_write"` // Cache creation input tokens (Anthropic)
+}
+
+// defaultModelPrices is the built-in price table, keyed by model glob.
+// Globs are matched case-insensitively; Bedrock model IDs such as
+// "us.anthropic.claude-sonnet-4-20250514-v1:0" are covered by the leading "*".
+var defaultModelPrices = map[string]ModelPrice{
+	// Anthropic
+	"*claude-opus-4-5*":   {Input: 5, Output: 25, CacheRead: 0.50, CacheWrite: 6.25},
+	"*claude-opus-4*":     {Input: 15, Output: 75, CacheRead: 1.50, CacheWrite: 18.75},
+	"*claude-3-opus*":     {Input: 15, Output: 75, CacheRead: 1.50, CacheWrite: 18.75},
+	"*claude-sonnet-4*":   {Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75},
+	"*claude-3-7-sonnet*": {Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75},
+	"*claude-3-5-sonnet*": {Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75},
+	"*claude-haiku-4-5*":  {Input: 1, Output: 5, CacheRead: 0.10, CacheWrite: 1.25},
+	"*claude-3-5-haiku*":  {Input: 0.80, Output: 4, CacheRead: 0.08, CacheWrite: 1},
+	"*claude-3-haiku*":    {Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.30},
+
+	// OpenAI
+	"gpt-5*":        {Input: 1.25, Output: 10, CacheRead: 0.125},
+	"gpt-5-mini*":   {Input: 0.25, Output: 2, CacheRead: 0.025},
+	"gpt-5-nano*":   {Input: 0.05, Output: 0.40, CacheRead: 0.005},
+	"gpt-4.1*":      {Input: 2, Output: 8, CacheRead: 0.50},
+	"gpt-4.1-mini*": {Input: 0.40, Output: 1.60, CacheRead: 0.10},
+	"gpt-4.1-nano*": {Input: 0.10, Output: 0.40, CacheRead: 0.025},
+	"gpt-4o*":       {Input: 2.50, Output: 10, CacheRead: 1.25},
+	"gpt-4o-mini*":  {Input: 0.15, Output: 0.60, CacheRead: 0.075},
+	"o3*":           {Input: 2, Output: 8, CacheRead: 0.50},
+	"o3-mini*":      {Input: 1.10, Output: 4.40, CacheRead: 0.55},
+	"o4-mini*":      {Input: 1.10, Output: 4.40, CacheRead: 0.275},
+
+	// Gemini (prompts up to 200k tokens)
+	"gemini-2.5-pro*":        {Input: 1.25, Output: 10, CacheRead: 0.125},
+	"gemini-2.5-flash*":      {Input: 0.30, Output: 2.50, CacheRead: 0.03},
+	"gemini-2.5-flash-lite*": {Input: 0.10, Output: 0.40, CacheRead: 0.01},
+	"gemini-2.0-flash*":      {Input: 0.10, Output: 0.40, CacheRead: 0.025},
+}
+
+// PriceTable resolves model names to prices. Configured prices take
+// precedence over the built-in defaults; within a table the most specific
+// matching glob (most literal characters) wins.
+type PriceTable struct {
+	configured map[string]ModelPrice
+	defaults   map[string]ModelPrice
+}
+
+// NewPriceTable creates a price table from configured overrides layered on
+// top of the built-in defaults.
+func NewPriceTable(configured map[string]ModelPrice) *PriceTable {
+	return &PriceTable{
+		configured: lowerKeys(configured),
+		defaults:   lowerKeys(defaultModelPrices),
+	}
+}
+
+func lowerKeys(prices map[string]ModelPrice) map[string]ModelPrice {
+	result := make(map[string]ModelPrice, len(prices))
+	for k, v := range prices {
+		result[strings.ToLower(k)] = v
+	}
+	return result
+}
+
+// Lookup returns the price for a model and whether one was found.
+func (t *PriceTable) Lookup(model string) (ModelPrice, bool) {
+	if model == "" {
+		return ModelPrice{}, false
+	}
+	model = strings.ToLower(model)
+	if price, ok := matchPrice(t.configured, model); ok {
+		return price, true
+	}
+	return matchPrice(t.defaults, model)
+}
+
+// MetricLabel returns a bounded model label for metrics: the price table
+// entry model matches, without leading and trailing wildcards, or "other".
+// Model names come from clients, so using them as labels directly would let
+// any client create unlimited series.
+func (t *PriceTable) MetricLabel(model string) string {
+	if t == nil || model == "" {
+		return "other"
+	}
+	model = strings.ToLower(model)
+	pattern, ok := matchPattern(t.configured, model)
+	if !ok {
+		pattern, ok = matchPattern(t.defaults, model)
+	}
+	if !ok {
+		return "other"
+	}
+	return strings.Trim(pattern, "*")
+}
+
+// matchPrice finds the price of the most specific glob in prices that matches model.
+func matchPrice(prices map[string]ModelPrice, model string) (ModelPrice, bool) {
+	pattern, ok := matchPattern(prices, model)
+	return prices[pattern], ok
+}
+
+// matchPattern finds the most specific glob in prices that matches model.
+func matchPattern(prices map[string]ModelPrice, model string) (string, bool) {
+	if _, ok := prices[model]; ok {
+		return model, true
+	}
+
+	var bestPattern string
+	bestScore := -1
+	for pattern := range prices {
+		if matched, err := path.Match(pattern, model); err != nil || !matched {
+			continue
+		}
+		score := len(pattern) - strings.Count(pattern, "*") - strings.Count(pattern, "?")
+		// Ties are broken alphabetically so lookups are deterministic
+		if score > bestScore || (score == bestScore && pattern < bestPattern) {
+			bestPattern = pattern
+			bestScore = score
+		}
+	}
+	return bestPattern, bestScore >= 0
+}
+
+// Cost computes the USD cost of a response's token usage.
+// Returns false if no price is known for the model.
+func (t *PriceTable) Cost(model string, usage UsageInfo) (float64, bool) {
+	price, ok := t.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+
+	cacheRead := price.CacheRead
+	if cacheRead == 0 {
+		cacheRead = price.Input
+	}
+	cacheWrite := price.CacheWrite
+	if cacheWrite == 0 {
+		cacheWrite = price.Input
+	}
+
+	cost := float64(usage.InputTokens)*price.Input +
+		float64(usage.OutputTokens)*price.Output +
+		float64(usage.CacheReadInputTokens)*cacheRead +
+		float64(usage.CacheCreationInputTokens)*cacheWrite
+
+	return cost / 1_000_000, true
+}
+
+// extractRequestModel returns the model named in a request body, or "".
+func extractRequestModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return req.Model
+}
+
+// responseModel picks the model to price a response with: the request's model,
+// falling back to the model reported in the response body (Gemini reports it
+// as modelVersion and never sends it in the request body).
+func responseModel(requestModel string, parsed ParsedResponse) string {
+	if requestModel != "" {
+		return requestModel
+	}
+	if model, ok := parsed.Raw["model"].(string); ok {
+		return model
+	}
+	if model, ok := parsed.Raw["modelVersion"].(string); ok {
+		return model
+	}
+	return ""
+}
+
+// priceResponse computes the cost of a response and adds it to the session's
+// running total. Returns nil if pricing is disabled or the model is unknown.
+func priceResponse(pricing *PriceTable, sm *SessionManager, sessionID, model string, usage UsageInfo) *float64 {
+	if pricing == nil {
+		return nil
+	}
+	cost, ok := pricing.Cost(model, usage)
+	if !ok {
+		return nil
+	}
+	if sm != nil {
+		// Graceful degradation - the cost is still logged with the response
+		sm.AddSessionCost(sessionID, cost)
+	}
+	return &cost
+}
@@ -0,0 +1,116 @@
+// pricing_test.go
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPriceTableLookup(t *testing.T) {
+	table := NewPriceTable(nil)
+
+	tests := []struct {
+		model     string
+		wantInput float64
+		wantFound bool
+	}{
+		{"claude-sonnet-4-20250514", 3, true},
+		{"claude-opus-4-5-20251101", 5, true},
+		{"claude-opus-4-1-20250805", 15, true},
+		{"us.anthropic.claude-sonnet-4-20250514-v1:0", 3, true},
+		{"Claude-Sonnet-4-20250514", 3, true},
+		{"gpt-5", 1.25, true},
+		{"gpt-5-mini-2025-08-07", 0.25, true},
+		{"gpt-4o-mini", 0.15, true},
+		{"o3-mini", 1.10, true},
+		{"llama-3-70b", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			price, found := table.Lookup(tt.model)
+			if found != tt.wantFound {
+				t.Fatalf("Lookup(%q) found = %v, want %v", tt.model, found, tt.wantFound)
+			}
+			if price.Input != tt.wantInput {
+				t.Errorf("Lookup(%q) input = %v, want %v", tt.model, price.Input, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestPriceTableConfiguredOverridesDefaults(t *testing.T) {
+	table := NewPriceTable(map[string]ModelPrice{
+		"*sonnet*":      {Input: 1, Output: 2},
+		"my-finetune-*": {Input: 0.5, Output: 1},
+	})
+
+	// A broad configured glob beats a more specific built-in one
+	price, ok := table.Lookup("claude-sonnet-4-20250514")
+	if !ok || price.Input != 1 {
+		t.Errorf("expected configured price (input=1), got %+v found=%v", price, ok)
+	}
+
+	price, ok = table.Lookup("my-finetune-v2")
+	if !ok || price.Input != 0.5 {
+		t.Errorf("expected configured price (input=0.5), got %+v found=%v", price, ok)
+	}
+
+	// Models not covered by the configured table fall through to defaults
+	price, ok = table.Lookup("gpt-4o")
+	if !ok || price.Input != 2.50 {
+		t.Errorf("expected default price (input=2.50), got %+v found=%v", price, ok)
+	}
+}
+
+func TestPriceTableCost(t *testing.T) {
+	table := NewPriceTable(map[string]ModelPrice{
+		"full-model":  {Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75},
+		"plain-model": {Input: 2, Output: 8},
+	})
+
+	usage := UsageInfo{
+		InputTokens:              1000,
+		OutputTokens:             500,
+		CacheReadInputTokens:     10000,
+		CacheCreationInputTokens: 2000,
+	}
+
+	cost, ok := table.Cost("full-model", usage)
+	if !ok {
+		t.Fatal("expected cost for full-model")
+	}
+	// 1000*3 + 500*15 + 10000*0.30 + 2000*3.75 = 21000 per million
+	if math.Abs(cost-0.021) > 1e-12 {
+		t.Errorf("expected cost 0.021, got %v", cost)
+	}
+
+	// Cache rates fall back to the input rate when unset
+	cost, ok = table.Cost("plain-model", usage)
+	if !ok {
+		t.Fatal("expected cost for plain-model")
+	}
+	// 1000*2 + 500*8 + 10000*2 + 2000*2 = 30000 per million
+	if math.Abs(cost-0.030) > 1e-12 {
+		t.Errorf("expected cost 0.030, got %v", cost)
+	}
+
+	if _, ok := table.Cost("unknown-model", usage); ok {
+		t.Error("expected no cost for unknown model")
+	}
+}
+
+func TestResponseModel(t *testing.T) {
+	parsed := ParsedResponse{Raw: map[string]interface{}{"model": "gpt-4o-2024-08-06"}}
+
+	if got := responseModel("gpt-4o", parsed); got != "gpt-4o" {
+		t.Errorf("expected request model to win, got %q", got)
+	}
+	if got := responseModel("", parsed); got != "gpt-4o-2024-08-06" {
+		t.Errorf("expected response model fallback, got %q", got)
+	}
+	if got := responseModel("", ParsedResponse{}); got != "" {
+		t.Errorf("expected empty model, got %q", got)
+	}
+}
@@ -0,0 +1,338 @@
+// providers.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Provider describes one upstream API family routed as /{name}/{upstream}/{path}.
+// Everything that differs between wire formats lives behind this interface, so
+// adding a provider means adding one implementation to builtinProviders.
+type Provider interface {
+	// Name is the first segment of proxy paths and the provider label in logs.
+	Name() string
+
+	// DefaultUpstreams lists the hosts (or path.Match patterns) the proxy may
+	// forward to for this provider unless the config overrides them.
+	DefaultUpstreams() []string
+
+	// IsConversationEndpoint reports whether path carries conversation turns
+	// that should be logged and tracked for session continuity.
+	IsConversationEndpoint(path string) bool
+
+	// ClientSessionID extracts a client-provided session ID from the request,
+	// or returns "" if there is none.
+	ClientSessionID(body []byte, headers http.Header, path string) string
+
+	// DeltaText extracts the text carried by one raw streamed line.
+	DeltaText(line []byte) string
+
+	// MatchesBody reports whether a decoded request or response body has
+	// this provider's shape. Used for logged bodies whose upstream host is
+	// unknown.
+	MatchesBody(raw map[string]interface{}) bool
+
+	// ParseRequest, ParseResponse and ParseStream normalize bodies into the
+	// shared Parsed* types.
+	ParseRequest(raw map[string]interface{}) ParsedRequest
+	ParseResponse(raw map[string]interface{}) ParsedResponse
+	ParseStream(chunks []StreamChunk) ParsedResponse
+
+	// ErrorResponse returns the status and body of an error in the shape the
+	// provider's clients expect, using a non-retryable status.
+	ErrorResponse(err error) (int, map[string]interface{})
+}
+
+// builtinProviders are the providers the proxy routes. The order matters only
+// when sniffing the shape of a logged response; anthropic is the fallback.
+var builtinProviders = []Provider{
+	anthropicProvider{},
+	openAIProvider{openAICompatibleProvider{
+		name:      "openai",
+		upstreams: []string{"api.openai.com", "chatgpt.com"},
+		endpoints: []string{"/v1/chat/completions", "/v1/completions", "/v1/responses"},
+	}},
+	geminiProvider{},
+	openAICompatibleProvider{
+		name:      "mistral",
+		upstreams: []string{"api.mistral.ai"},
+		endpoints: []string{"/v1/chat/completions", "/v1/fim/completions", "/v1/agents/completions"},
+	},
+	openAICompatibleProvider{
+		name:      "vllm",
+		upstreams: localUpstreams,
+		endpoints: []string{"/v1/chat/completions", "/v1/completions", "/v1/responses"},
+	},
+	openAICompatibleProvider{
+		name:      "ollama",
+		upstreams: localUpstreams,
+		endpoints: []string{"/v1/chat/completions", "/v1/completions", "/v1/responses"},
+	},
+}
+
+// localUpstreams is the default allowlist for self-hosted servers, which
+// usually listen on loopback. Remote deployments must be added in the config.
+var localUpstreams = []string{"localhost", "localhost:*", "127.0.0.1", "127.0.0.1:*"}
+
+var providerRegistry = func() map[string]Provider {
+	registry := make(map[string]Provider, len(builtinProviders))
+	for _, p := range builtinProviders {
+		registry[p.Name()] = p
+	}
+	return registry
+}()
+
+// LookupProvider returns the named provider, or nil if it isn't registered.
+func LookupProvider(name string) Provider {
+	return providerRegistry[name]
+}
+
+// ProviderNames returns the registered provider names, sorted.
+func ProviderNames() []string {
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// providerForHost returns the provider whose default upstreams list host
+// exactly. Patterns are ignored: several providers share loopback hosts.
+func providerForHost(host string) Provider {
+	host = strings.ToLower(host)
+	for _, p := range builtinProviders {
+		for _, upstream := range p.DefaultUpstreams() {
+			if upstream == host {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// providerForBody picks the parser for a logged body: the upstream host when
+// it is known, otherwise the shape of the body.
+func providerForBody(raw map[string]interface{}, host string) Provider {
+	if p := providerForHost(host); p != nil {
+		return p
+	}
+	for _, p := range builtinProviders {
+		if p.MatchesBody(raw) {
+			return p
+		}
+	}
+	return anthropicProvider{}
+}
+
+// providerForStream picks the parser for logged stream chunks: the upstream
+// host when it is known, otherwise the shape of the first SSE event. Returns
+// nil if neither decides, in which case ParseStreamingResponse applies.
+func providerForStream(chunks []StreamChunk, host string) Provider {
+	if p := providerForHost(host); p != nil {
+		return p
+	}
+	for _, chunk := range chunks {
+		event := sseData([]byte(chunk.Raw))
+		if event == nil {
+			continue
+		}
+		for _, p := range builtinProviders {
+			if p.MatchesBody(event) {
+				return p
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// sseData decodes the JSON payload of an SSE "data: " line. Returns nil for
+// other lines, the [DONE] sentinel and malformed JSON.
+func sseData(line []byte) map[string]interface{} {
+	s := string(line)
+	if !strings.HasPrefix(s, "data: ") {
+		return nil
+	}
+
+	jsonStr := strings.TrimSpace(strings.TrimPrefix(s, "data: "))
+	if jsonStr == "[DONE]" || jsonStr == "" {
+		return nil
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &event); err != nil {
+		return nil
+	}
+	return event
+}
+
+// anthropicProvider handles the Anthropic Messages API.
+type anthropicProvider struct{}
+
+func (anthropicProvider) Name() string { return "anthropic" }
+
+func (anthropicProvider) DefaultUpstreams() []string { return []string{"api.anthropic.com"} }
+
+func (anthropicProvider) IsConversationEndpoint(path string) bool {
+	return path == "/v1/messages"
+}
+
+// ClientSessionID reads the session from metadata.user_id
+// (user_<hash>_account_<uuid>_session_<session-uuid>).
+func (anthropicProvider) ClientSessionID(body []byte, headers http.Header, path string) string {
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	return extractAnthropicSessionID(request)
+}
+
+// DeltaText handles {"type":"content_block_delta","delta":{"type":"text_delta","text":"..."}}
+func (anthropicProvider) DeltaText(line []byte) string {
+	event := sseData(line)
+	if event == nil || event["type"] != "content_block_delta" {
+		return ""
+	}
+	if delta, ok := event["delta"].(map[string]interface{}); ok {
+		if text, ok := delta["text"].(string); ok {
+			return text
+		}
+	}
+	return ""
+}
+
+// MatchesBody is false: anthropic is the fallback when nothing else matches.
+func (anthropicProvider) MatchesBody(raw map[string]interface{}) bool { return false }
+
+func (anthropicProvider) ParseRequest(raw map[string]interface{}) ParsedRequest {
+	return parseChatRequest(raw)
+}
+
+func (anthropicProvider) ParseResponse(raw map[string]interface{}) ParsedResponse {
+	return parseAnthropicResponseBody(raw)
+}
+
+func (anthropicProvider) ParseStream(chunks []StreamChunk) ParsedResponse {
+	return ParseStreamingResponse(chunks)
+}
+
+// ErrorResponse reports a 400 invalid_request_error, as Anthropic does for
+// exhausted credit.
+func (anthropicProvider) ErrorResponse(err error) (int, map[string]interface{}) {
+	return http.StatusBadRequest, map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    "invalid_request_error",
+			"message": err.Error(),
+		},
+	}
+}
+
+// openAICompatibleProvider handles servers that speak OpenAI's Chat
+// Completions wire format (Mistral, vLLM, Ollama's /v1 endpoints).
+type openAICompatibleProvider struct {
+	name      string
+	upstreams []string
+	endpoints []string
+}
+
+func (p openAICompatibleProvider) Name() string { return p.name }
+
+func (p openAICompatibleProvider) DefaultUpstreams() []string { return p.upstreams }
+
+func (p openAICompatibleProvider) IsConversationEndpoint(path string) bool {
+	for _, endpoint := range p.endpoints {
+		if path == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func (openAICompatibleProvider) ClientSessionID(body []byte, headers http.Header, path string) string {
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	return extractOpenAISessionID(request, headers)
+}
+
+// DeltaText handles {"choices":[{"delta":{"content":"..."}}]}
+func (openAICompatibleProvider) DeltaText(line []byte) string {
+	event := sseData(line)
+	if choices, ok := event["choices"].([]interface{}); ok && len(choices) > 0 {
+		if choice, ok := choices[0].(map[string]interface{}); ok {
+			if delta, ok := choice["delta"].(map[string]interface{}); ok {
+				if content, ok := delta["content"].(string); ok {
+					return content
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (openAICompatibleProvider) MatchesBody(raw map[string]interface{}) bool {
+	return isOpenAIResponseBody(raw)
+}
+
+func (openAICompatibleProvider) ParseRequest(raw map[string]interface{}) ParsedRequest {
+	return parseChatRequest(raw)
+}
+
+func (openAICompatibleProvider) ParseResponse(raw map[string]interface{}) ParsedResponse {
+	return parseOpenAIResponseBody(raw)
+}
+
+func (openAICompatibleProvider) ParseStream(chunks []StreamChunk) ParsedResponse {
+	return ParseStreamingResponse(chunks)
+}
+
+// ErrorResponse reports insufficient_quota with a 403, which OpenAI clients
+// don't retry (they retry every 429, even insufficient_quota).
+func (openAICompatibleProvider) ErrorResponse(err error) (int, map[string]interface{}) {
+	return http.StatusForbidden, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": err.Error(),
+			"type":    "insufficient_quota",
+			"param":   nil,
+			"code":    "insufficient_quota",
+		},
+	}
+}
+
+// openAIProvider adds the OpenAI-only endpoints (Threads API, ChatGPT backend)
+// to the compatible wire format.
+type openAIProvider struct {
+	openAICompatibleProvider
+}
+
+func (p openAIProvider) IsConversationEndpoint(path string) bool {
+	if p.openAICompatibleProvider.IsConversationEndpoint(path) {
+		return true
+	}
+
+	// Threads API - matches /v1/threads/{id}/messages or /v1/threads/{id}/runs[/...]
+	if strings.HasPrefix(path, "/v1/threads/") {
+		parts := strings.Split(path, "/")
+		if len(parts) >= 5 && (parts[4] == "messages" || parts[4] == "runs") {
+			return true
+		}
+	}
+
+	// ChatGPT backend API (used with OAuth authentication)
+	// Paths like /backend-api/codex/v1/responses
+	return strings.HasPrefix(path, "/backend-api/") && strings.HasSuffix(path, "/responses")
+}
+
+// ClientSessionID prefers a Threads API thread ID in the path over body fields.
+func (p openAIProvider) ClientSessionID(body []byte, headers http.Header, path string) string {
+	if threadID := ExtractThreadIDFromPath(path); threadID != "" {
+		return threadID
+	}
+	return p.openAICompatibleProvider.ClientSessionID(body, headers, path)
+}
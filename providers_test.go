@@ -0,0 +1,205 @@
+// providers_test.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProviderRegistry(t *testing.T) {
+	want := []string{"anthropic", "gemini", "mistral", "ollama", "openai", "vllm"}
+	if got := ProviderNames(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ProviderNames() = %v, want %v", got, want)
+	}
+
+	for _, name := range want {
+		p := LookupProvider(name)
+		if p == nil {
+			t.Fatalf("LookupProvider(%q) = nil", name)
+		}
+		if p.Name() != name {
+			t.Errorf("LookupProvider(%q).Name() = %q", name, p.Name())
+		}
+	}
+
+	if LookupProvider("gopher") != nil {
+		t.Error("expected nil for unregistered provider")
+	}
+}
+
+func TestParseProxyURLRegisteredProviders(t *testing.T) {
+	for _, path := range []string{
+		"/mistral/api.mistral.ai/v1/chat/completions",
+		"/vllm/localhost:8000/v1/chat/completions",
+		"/ollama/localhost:11434/v1/chat/completions",
+	} {
+		if _, _, _, err := ParseProxyURL(path); err != nil {
+			t.Errorf("ParseProxyURL(%q) failed: %v", path, err)
+		}
+	}
+
+	_, _, _, err := ParseProxyURL("/gopher/example.com/v1/chat")
+	if !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("expected ErrUnknownProvider, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "anthropic, gemini, mistral, ollama, openai, vllm") {
+		t.Errorf("expected error to list providers, got %q", err.Error())
+	}
+}
+
+func TestProviderConversationEndpoints(t *testing.T) {
+	tests := []struct {
+		provider string
+		path     string
+		expected bool
+	}{
+		{"anthropic", "/v1/messages", true},
+		{"anthropic", "/v1/chat/completions", false},
+		{"openai", "/v1/threads/thread_abc/runs", true},
+		{"openai", "/backend-api/codex/v1/responses", true},
+		{"openai", "/v1/messages", false},
+		{"mistral", "/v1/chat/completions", true},
+		{"mistral", "/v1/fim/completions", true},
+		{"mistral", "/v1/embeddings", false},
+		{"mistral", "/v1/threads/thread_abc/runs", false},
+		{"vllm", "/v1/completions", true},
+		{"ollama", "/v1/chat/completions", true},
+		{"ollama", "/api/tags", false},
+	}
+
+	for _, tt := range tests {
+		if got := LookupProvider(tt.provider).IsConversationEndpoint(tt.path); got != tt.expected {
+			t.Errorf("%s.IsConversationEndpoint(%q) = %v, want %v", tt.provider, tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestProviderClientSessionIDCompatible(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("X-Session-ID", "local-session-1")
+
+	for _, provider := range []string{"mistral", "vllm", "ollama"} {
+		if got := ExtractClientSessionID([]byte(`{"messages":[]}`), provider, headers, "/v1/chat/completions"); got != "local-session-1" {
+			t.Errorf("%s: expected header session ID, got %q", provider, got)
+		}
+	}
+
+	// Thread IDs in the path are an OpenAI Threads API feature only
+	if got := ExtractClientSessionID([]byte(`{}`), "mistral", nil, "/v1/threads/thread_abc/messages"); got != "" {
+		t.Errorf("expected no thread ID for mistral, got %q", got)
+	}
+
+	if got := ExtractClientSessionID([]byte(`{"user":"someone"}`), "gopher", nil, ""); got != "" {
+		t.Errorf("expected no session ID for unknown provider, got %q", got)
+	}
+}
+
+func TestProviderDeltaTextCompatible(t *testing.T) {
+	data := []byte(`data: {"id":"cmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Bonjour"}}]}` + "\n")
+	for _, provider := range []string{"mistral", "vllm", "ollama"} {
+		if got := extractDeltaText(data, provider); got != "Bonjour" {
+			t.Errorf("%s: expected delta text, got %q", provider, got)
+		}
+	}
+	if got := extractDeltaText(data, "gopher"); got != "" {
+		t.Errorf("expected no delta text for unknown provider, got %q", got)
+	}
+}
+
+func TestParseResponseBodyByHost(t *testing.T) {
+	body := `{"id":"cmpl-1","object":"chat.completion","choices":[{"message":{"role":"assistant","content":"Salut"},"finish_reason":"stop"}],"usage":{"prompt_tokens":7,"completion_tokens":2}}`
+
+	parsed := ParseResponseBody(body, "api.mistral.ai")
+	if len(parsed.Content) != 1 || parsed.Content[0].Text != "Salut" {
+		t.Errorf("expected Mistral body parsed as OpenAI-compatible, got %+v", parsed.Content)
+	}
+	if parsed.Usage.InputTokens != 7 || parsed.Usage.OutputTokens != 2 {
+		t.Errorf("unexpected usage: %+v", parsed.Usage)
+	}
+
+	// Unknown hosts fall back to the body shape
+	anthropic := ParseResponseBody(`{"content":[{"type":"text","text":"Hi"}],"usage":{"input_tokens":3,"output_tokens":1}}`, "127.0.0.1:9999")
+	if len(anthropic.Content) != 1 || anthropic.Content[0].Text != "Hi" || anthropic.Usage.InputTokens != 3 {
+		t.Errorf("expected Anthropic body on unknown host parsed as Anthropic, got %+v", anthropic)
+	}
+}
+
+func TestDefaultAllowedUpstreamsCoversProviders(t *testing.T) {
+	allowlist := NewUpstreamAllowlist(DefaultAllowedUpstreams())
+
+	for _, tt := range []struct{ provider, upstream string }{
+		{"mistral", "api.mistral.ai"},
+		{"vllm", "localhost:8000"},
+		{"ollama", "127.0.0.1:11434"},
+		{"bedrock", "bedrock-runtime.us-east-1.amazonaws.com"},
+	} {
+		if err := allowlist.Check(tt.provider, tt.upstream); err != nil {
+			t.Errorf("expected %s upstream %s allowed by default: %v", tt.provider, tt.upstream, err)
+		}
+	}
+
+	if err := allowlist.Check("vllm", "vllm.example.com"); err == nil {
+		t.Error("expected remote vllm host to need explicit configuration")
+	}
+}
+
+func TestWriteRejectionCompatible(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeRejection(w, "mistral", errors.New("out of budget"))
+
+	if w.Code != http.StatusForbidden || w.Header().Get("X-Should-Retry") != "false" {
+		t.Errorf("expected a non-retryable 403 for OpenAI-compatible provider, got %d", w.Code)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Error.Code != "insufficient_quota" {
+		t.Errorf("expected OpenAI-shaped error, got %s", w.Body.String())
+	}
+}
+
+func TestProxyLogsCompatibleProvider(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"chat.completion","model":"llama-3.1-8b","choices":[{"message":{"role":"assistant","content":"hello"}}],"usage":{"prompt_tokens":4,"completion_tokens":1}}`))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+	proxy := NewProxyWithSessionManagerAndLogger(logger, sm)
+
+	req := httptest.NewRequest("POST", "/vllm/"+upstreamHost+"/v1/chat/completions",
+		strings.NewReader(`{"model":"llama-3.1-8b","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Session-ID", "vllm-session")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	logger.Close()
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, upstreamHost, "*", "*.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("expected one session log, got %v", matches)
+	}
+	data, _ := os.ReadFile(matches[0])
+	if !strings.Contains(string(data), `"provider":"vllm"`) {
+		t.Errorf("expected provider vllm in session log, got:\n%s", data)
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,9 +22,10 @@ import (
 // Both *Logger (file-based) and *MultiWriter (fan-out) implement this interface.
 type ProxyLogger interface {
 	RegisterUpstream(sessionID, upstream string)
+	RegisterIdentity(sessionID, identity string)
 	LogSessionStart(sessionID, provider, upstream string) error
 	LogRequest(sessionID, provider string, seq int, method, path string, headers http.Header, body []byte, requestID string) error
-	LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string) error
+	LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string, costUSD *float64) error
 	LogFork(sessionID, provider string, fromSeq int, parentSession string) error
 	Close() error
 }
@@ -32,6 +37,13 @@ type Proxy struct {
 	eventEmitter   AgentEventEmitter
 	machineID      string
 	bedrock        *bedrockState
+	pricing        *PriceTable
+	budget         *BudgetEnforcer
+	metrics        *Metrics // nil = no /metrics collection
+	anomaly        AnomalyConfig
+	upstreams      *UpstreamAllowlist // nil allows any upstream
+	auth           *ProxyAuth         // nil = no proxy authentication
+	identities     sync.Map           // sessionID -> authenticated user
 }
 
 // createPassthroughClient creates an HTTP client configured for true passthrough proxying
@@ -51,6 +63,16 @@ func createPassthroughClient() *http.Client {
 	}
 }
 
+// createUpstreamClient creates a passthrough client with cfg's CA bundle,
+// client certificate and egress proxy applied.
+func createUpstreamClient(cfg TransportConfig) (*http.Client, error) {
+	client := createPassthroughClient()
+	if err := cfg.Apply(client.Transport.(*http.Transport)); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
 func NewProxy() *Proxy {
 	return &Proxy{
 		client: createPassthroughClient(),
@@ -93,6 +115,70 @@ func NewProxyWithEventEmitter(logger ProxyLogger, sm *SessionManager, emitter Ag
 	}
 }
 
+// emitBudgetExceeded reports a request rejected by a budget to emitters that
+// accept budget events.
+func (p *Proxy) emitBudgetExceeded(sessionID, provider, machine string, err error) {
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) {
+		return
+	}
+	if emitter, ok := p.eventEmitter.(budgetEventEmitter); ok {
+		emitter.EmitBudgetExceeded(sessionID, provider, machine, exceeded)
+	}
+}
+
+// admitRequest applies the budget and circuit breaker before the request's
+// session is created or advanced, so a rejected request leaves no trace in
+// the session. existing is the session the request continues, or "" for a
+// new one. On rejection it writes the error in errorShape's format and
+// returns false; otherwise it returns the pattern state it loaded, if any,
+// for the caller to reuse.
+func (p *Proxy) admitRequest(w http.ResponseWriter, r *http.Request, existing, provider, errorShape string) (*PatternState, bool) {
+	machine := proxyUserFromContext(r.Context())
+	if machine == "" {
+		machine = p.machineID
+	}
+
+	if err := p.budget.Check(existing, machine); err != nil {
+		log.Printf("Budget: rejecting %s request (session=%s): %v", errorShape, existing, err)
+		p.emitBudgetExceeded(existing, provider, machine, err)
+		writeRejection(w, errorShape, err)
+		return nil, false
+	}
+
+	if existing == "" || p.eventEmitter == nil {
+		return nil, true
+	}
+	patternState, _ := p.sessionManager.LoadPatternState(existing)
+	// Fail this request if the last turn tripped the circuit breaker
+	if err := checkCircuitBreaker(patternState, p.anomaly); err != nil {
+		log.Printf("Circuit breaker: rejecting %s request (session=%s): %v", errorShape, existing, err)
+		writeRejection(w, errorShape, err)
+		return nil, false
+	}
+	return patternState, true
+}
+
+// registerIdentity records the request's authenticated user, if any, as the
+// machine for the session's log entries and events.
+func (p *Proxy) registerIdentity(sessionID string, r *http.Request) {
+	user := proxyUserFromContext(r.Context())
+	if user == "" {
+		return
+	}
+	p.identities.Store(sessionID, user)
+	p.logger.RegisterIdentity(sessionID, user)
+}
+
+// machineFor returns the machine reported in a session's events: the
+// authenticated user, or user@host when proxy auth is off.
+func (p *Proxy) machineFor(sessionID string) string {
+	if user, ok := p.identities.Load(sessionID); ok {
+		return user.(string)
+	}
+	return p.machineID
+}
+
 func (p *Proxy) generateSessionID() string {
 	return time.Now().UTC().Format("20060102-150405") + "-" + randomHex(4)
 }
@@ -166,7 +252,7 @@ func (p *Proxy) processToolResultsAndEmitEvents(reqBody []byte, sessionID, provi
 			delete(state.PendingToolIDs, tr.ToolUseID)
 		}
 
-		p.eventEmitter.EmitToolResult(sessionID, provider, p.machineID, toolName, tr.ToolUseID, tr.IsError)
+		p.eventEmitter.EmitToolResult(sessionID, provider, p.machineFor(sessionID), toolName, tr.ToolUseID, tr.IsError)
 
 		if tr.IsError {
 			hadError = true
@@ -177,17 +263,17 @@ func (p *Proxy) processToolResultsAndEmitEvents(reqBody []byte, sessionID, provi
 }
 
 // processResponseAndEmitEvents processes response, emits tool_call events, computes patterns, emits turn_end.
-func (p *Proxy) processResponseAndEmitEvents(parsed ParsedResponse, sessionID, provider string, state *PatternState, statusCode int, respBody string) {
+func (p *Proxy) processResponseAndEmitEvents(parsed ParsedResponse, sessionID, provider string, state *PatternState, statusCode int, respBody string, costUSD *float64) {
 	if p.eventEmitter == nil || p.sessionManager == nil {
 		return
 	}
 
-	emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineID, state, parsed.Content, parsed.Usage, parsed.StopReason, statusCode, respBody)
+	emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineFor(sessionID), state, parsed.Content, parsed.Usage, costUSD, parsed.StopReason, statusCode, respBody, p.anomaly)
 }
 
 // emitResponseEvents is the shared implementation for emitting response events.
 // Used by both non-streaming (processResponseAndEmitEvents) and streaming (streamResponse) paths.
-func emitResponseEvents(emitter AgentEventEmitter, sm *SessionManager, sessionID, provider, machineID string, state *PatternState, content []ContentBlock, usage UsageInfo, stopReason string, statusCode int, respBody string) {
+func emitResponseEvents(emitter AgentEventEmitter, sm *SessionManager, sessionID, provider, machineID string, state *PatternState, content []ContentBlock, usage UsageInfo, costUSD *float64, stopReason string, statusCode int, respBody string, anomaly AnomalyConfig) {
 	// Extract tool calls
 	toolCalls := extractToolCalls(content)
 
@@ -221,11 +307,15 @@ func emitResponseEvents(emitter AgentEventEmitter, sm *SessionManager, sessionID
 		OutputTokens:             usage.OutputTokens,
 		CacheReadInputTokens:     usage.CacheReadInputTokens,
 		CacheCreationInputTokens: usage.CacheCreationInputTokens,
+		CostUSD:                  costUSD,
 	}
 
 	// Emit turn_end
 	emitter.EmitTurnEnd(sessionID, provider, machineID, stopReason, isRetry, errorType, patterns, tokens)
 
+	// Detect runaway loops (may trip the circuit breaker for the next request)
+	detectAndEmitAnomalies(emitter, sessionID, provider, machineID, state, content, anomaly)
+
 	// Persist state
 	if err := sm.UpdatePatternState(sessionID, state); err != nil {
 		// Log but don't fail - graceful degradation
@@ -244,6 +334,32 @@ func randomHex(n int) string {
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, ok := p.authenticate(w, r)
+	if !ok {
+		return
+	}
+	p.serveProxy(w, r)
+}
+
+// authenticate checks proxy auth first, which strips the token from the path
+// and headers before anything is routed, forwarded or logged. It returns the
+// request carrying the user, or false after writing a 407.
+func (p *Proxy) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	user, err := p.auth.Authenticate(r)
+	if err != nil {
+		log.Printf("Proxy auth: rejecting %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		w.Header().Set("Proxy-Authenticate", `Bearer realm="llm-proxy"`)
+		http.Error(w, err.Error(), http.StatusProxyAuthRequired)
+		return r, false
+	}
+	if user != "" {
+		r = r.WithContext(withProxyUser(r.Context(), user))
+	}
+	return r, true
+}
+
+// serveProxy routes an authenticated request to its upstream and logs it.
+func (p *Proxy) serveProxy(w http.ResponseWriter, r *http.Request) {
 	// Route Bedrock requests before ParseProxyURL — Bedrock paths don't follow
 	// the /{provider}/{upstream}/{path} format
 	if strings.HasPrefix(r.URL.Path, "/model/") {
@@ -270,17 +386,15 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Determine scheme (use http for tests, https for real)
-	scheme := "https"
-	if isLocalhost(upstream) {
-		scheme = "http"
+	// Refuse to relay to hosts outside the allowlist
+	if err := p.upstreams.Check(provider, upstream); err != nil {
+		log.Printf("Upstream rejected: %v (path=%s)", err, r.URL.Path)
+		http.Error(w, "upstream not allowed: "+err.Error(), http.StatusForbidden)
+		return
 	}
 
 	// Build upstream URL
-	upstreamURL := scheme + "://" + upstream + path
-	if r.URL.RawQuery != "" {
-		upstreamURL += "?" + r.URL.RawQuery
-	}
+	upstreamURL := buildUpstreamURL(upstream, path, r.URL.RawQuery)
 
 	// Buffer request body for logging
 	var reqBody []byte
@@ -312,25 +426,49 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var isNewSession bool
 	var requestID string
 	var patternState *PatternState
-	shouldLog := p.logger != nil && isConversationEndpoint(path)
+	shouldLog := p.logger != nil && LookupProvider(provider).IsConversationEndpoint(path)
 
 	if shouldLog {
 		// Generate unique request ID for this API call
 		requestID = uuid.New().String()
 
 		if p.sessionManager != nil {
-			var err error
-			sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(reqBody, provider, upstream, r.Header, path)
+			owner := proxyUserFromContext(r.Context())
+			existing, err := p.sessionManager.FindSession(reqBody, provider, r.Header, path, owner)
+			if errors.Is(err, ErrSessionOwner) {
+				log.Printf("Session: rejecting request from %q: %v", owner, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+
+			// Reject the request if a budget is exhausted or the breaker is
+			// open, before it creates or advances a session
+			admittedState, ok := p.admitRequest(w, r, existing, provider, provider)
+			if !ok {
+				return
+			}
+
+			sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(reqBody, provider, upstream, r.Header, path, owner)
+			if errors.Is(err, ErrSessionOwner) {
+				log.Printf("Session: rejecting request from %q: %v", owner, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 			if err != nil {
 				// Fallback to generating a new session
 				sessionID = p.generateSessionID()
 				seq = 1
 				isNewSession = true
 			}
+			p.registerIdentity(sessionID, r)
 
 			// Load pattern state for event emission
 			if p.eventEmitter != nil {
-				patternState, _ = p.sessionManager.LoadPatternState(sessionID)
+				if sessionID == existing {
+					patternState = admittedState
+				} else {
+					patternState, _ = p.sessionManager.LoadPatternState(sessionID)
+				}
 				if patternState == nil {
 					patternState = &PatternState{
 						PendingToolIDs: make(map[string]string),
@@ -351,13 +489,14 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 				// Increment turn count and emit turn_start
 				patternState.TurnCount++
-				p.eventEmitter.EmitTurnStart(sessionID, provider, p.machineID, patternState.TurnCount, errorRecovered)
+				p.eventEmitter.EmitTurnStart(sessionID, provider, p.machineFor(sessionID), patternState.TurnCount, errorRecovered)
 			}
 		} else {
 			// No session manager - generate new session for each request
 			sessionID = p.generateSessionID()
 			seq = 1
 			isNewSession = true
+			p.registerIdentity(sessionID, r)
 		}
 
 		// Only log session_start on new sessions (seq == 1)
@@ -370,6 +509,7 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Make request to upstream
 	resp, err := p.client.Do(proxyReq)
 	if err != nil {
+		p.metrics.ObserveFailure(provider, extractRequestModel(reqBody))
 		http.Error(w, "upstream request failed: "+err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -383,7 +523,7 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			loggerForStream = p.logger
 			smForStream = p.sessionManager
 		}
-		streamResponse(w, resp, loggerForStream, smForStream, sessionID, provider, seq, startTime, reqBody, requestID, p.eventEmitter, p.machineID, patternState)
+		streamResponse(w, resp, loggerForStream, smForStream, sessionID, provider, seq, startTime, reqBody, requestID, p.eventEmitter, p.machineFor(sessionID), patternState, p.pricing, p.budget, p.metrics, p.anomaly)
 		return
 	}
 
@@ -394,26 +534,34 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Buffer response body for logging
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
+		p.metrics.ObserveFailure(provider, extractRequestModel(reqBody))
 		http.Error(w, "failed to read response body: "+err.Error(), http.StatusBadGateway)
 		return
 	}
 
 	// Record total time
 	totalTime := time.Since(startTime)
+	timing := ResponseTiming{
+		TTFBMs:  ttfb.Milliseconds(),
+		TotalMs: totalTime.Milliseconds(),
+	}
 
 	// Log response and record fingerprint for session tracking (conversation endpoints only)
 	if shouldLog {
-		timing := ResponseTiming{
-			TTFBMs:  ttfb.Milliseconds(),
-			TotalMs: totalTime.Milliseconds(),
-		}
-		p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, respBody, nil, timing, requestID)
+		parsed := ParseResponseBody(string(respBody), upstream)
+		model := responseModel(extractRequestModel(reqBody), parsed)
+		cost := priceResponse(p.pricing, p.sessionManager, sessionID, model, parsed.Usage)
+		p.budget.Record(sessionID, p.machineFor(sessionID), resp.StatusCode, parsed.Usage, cost)
+		p.metrics.ObserveResponse(provider, model, resp.StatusCode, timing, parsed.Usage)
+		p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, respBody, nil, timing, requestID, cost)
 
 		// Emit agent observability events
 		if p.eventEmitter != nil && patternState != nil {
-			parsed := ParseResponseBody(string(respBody), upstream)
-			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody))
+			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody), cost)
 		}
+	} else {
+		// Other endpoints aren't logged or priced, but still counted
+		p.metrics.ObserveResponse(provider, extractRequestModel(reqBody), resp.StatusCode, timing, UsageInfo{})
 	}
 
 	// Copy response headers
@@ -434,11 +582,24 @@ func copyHeaders(dst, src http.Header) {
 	}
 }
 
+// buildUpstreamURL builds the URL for a request to upstream, which must have
+// passed splitUpstream. The scheme is http for localhost (tests, local
+// servers) and https otherwise.
+func buildUpstreamURL(upstream, path, rawQuery string) string {
+	scheme := "https"
+	if isLocalhost(upstream) {
+		scheme = "http"
+	}
+	u := url.URL{Scheme: scheme, Host: upstream, Path: path, RawQuery: rawQuery}
+	return u.String()
+}
+
 // isLocalhost checks if the host is localhost for determining http vs https scheme.
+// Upstreams that aren't a plain host[:port] are never localhost.
 func isLocalhost(host string) bool {
-	hostname := host
-	if h, _, err := net.SplitHostPort(host); err == nil {
-		hostname = h
+	hostname, _, err := splitUpstream(host)
+	if err != nil {
+		return false
 	}
 	if ip := net.ParseIP(hostname); ip != nil {
 		return ip.IsLoopback()
@@ -484,37 +645,18 @@ func isJWTAuth(headers http.Header) bool {
 }
 
 // isConversationEndpoint returns true for API endpoints that represent conversations
-// (i.e., have messages that can be tracked for session continuity)
+// (i.e., have messages that can be tracked for session continuity) for Bedrock
+// or any registered provider. Proxied requests ask their own provider instead.
 func isConversationEndpoint(path string) bool {
 	// Bedrock
 	if strings.HasPrefix(path, "/model/") {
 		return true
 	}
 
-	// Anthropic
-	if path == "/v1/messages" {
-		return true
-	}
-
-	// OpenAI Chat/Completions
-	if path == "/v1/chat/completions" || path == "/v1/completions" || path == "/v1/responses" {
-		return true
-	}
-
-	// OpenAI Threads API - matches /v1/threads/{id}/messages or /v1/threads/{id}/runs[/...]
-	if strings.HasPrefix(path, "/v1/threads/") {
-		parts := strings.Split(path, "/")
-		// /v1/threads/{id}/messages or /v1/threads/{id}/runs
-		if len(parts) >= 5 && (parts[4] == "messages" || parts[4] == "runs") {
+	for _, p := range builtinProviders {
+		if p.IsConversationEndpoint(path) {
 			return true
 		}
 	}
-
-	// ChatGPT backend API (used with OAuth authentication)
-	// Paths like /backend-api/codex/v1/responses
-	if strings.HasPrefix(path, "/backend-api/") && strings.HasSuffix(path, "/responses") {
-		return true
-	}
-
 	return false
 }
@@ -0,0 +1,516 @@
+// redact.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redaction rewrites request/response bodies and stream chunks before they
+// are persisted. Regex rules (custom patterns and built-in detectors) run
+// over every decoded JSON string value, so replacements never break the
+// JSON; path rules replace or drop whole fields. The bytes forwarded to the
+// upstream and back to the client are never touched.
+
+// RedactionRule is one [[redaction.rules]] entry. Exactly one of Detector,
+// Pattern or Path must be set.
+type RedactionRule struct {
+	Name     string `toml:"name"`     // Label used in replacements and _meta counts
+	Detector string `toml:"detector"` // Built-in detector (see builtinDetectors)
+	Pattern  string `toml:"pattern"`  // Go regular expression
+	Path     string `toml:"path"`     // JSON field path, e.g. "messages[*].content[*].source.data"
+	Strategy string `toml:"strategy"` // mask (default), hash or remove
+}
+
+// RedactionConfig configures the redaction pipeline.
+type RedactionConfig struct {
+	Enabled   bool            `toml:"enabled"`
+	Detectors []string        `toml:"detectors"` // Built-in detectors to run with the mask strategy (all if no rules)
+	Rules     []RedactionRule `toml:"rules"`
+}
+
+// builtinDetectors maps detector names to their patterns.
+var builtinDetectors = map[string]string{
+	"aws_key":      `\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[A-Z0-9]{16}\b|(?i:aws_?secret_?access_?key)["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`,
+	"github_token": `\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`,
+	"private_key":  `-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`,
+	"email":        `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`,
+	"jwt":          `\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`,
+}
+
+// BuiltinDetectorNames returns the built-in detector names, sorted.
+func BuiltinDetectorNames() []string {
+	names := make([]string, 0, len(builtinDetectors))
+	for name := range builtinDetectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type redactionStrategy string
+
+const (
+	redactMask   redactionStrategy = "mask"
+	redactHash   redactionStrategy = "hash"
+	redactRemove redactionStrategy = "remove"
+)
+
+type compiledRule struct {
+	name     string
+	re       *regexp.Regexp
+	path     []string
+	strategy redactionStrategy
+}
+
+// Redactor applies the configured rules. A nil *Redactor redacts nothing.
+type Redactor struct {
+	textRules []compiledRule
+	pathRules []compiledRule
+}
+
+// NewRedactor compiles cfg. Returns nil (and no error) when redaction is disabled.
+func NewRedactor(cfg RedactionConfig) (*Redactor, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	detectors := cfg.Detectors
+	if detectors == nil && len(cfg.Rules) == 0 {
+		// Enabled with nothing configured: run every built-in detector
+		detectors = BuiltinDetectorNames()
+	}
+
+	rules := make([]RedactionRule, 0, len(detectors)+len(cfg.Rules))
+	for _, name := range detectors {
+		rules = append(rules, RedactionRule{Detector: name})
+	}
+	rules = append(rules, cfg.Rules...)
+
+	r := &Redactor{}
+	for i, rule := range rules {
+		compiled, err := compileRedactionRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %d: %w", i+1, err)
+		}
+		if compiled.path != nil {
+			r.pathRules = append(r.pathRules, compiled)
+		} else {
+			r.textRules = append(r.textRules, compiled)
+		}
+	}
+	return r, nil
+}
+
+func compileRedactionRule(rule RedactionRule) (compiledRule, error) {
+	set := 0
+	for _, v := range []string{rule.Detector, rule.Pattern, rule.Path} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return compiledRule{}, fmt.Errorf("exactly one of detector, pattern or path must be set")
+	}
+
+	c := compiledRule{name: rule.Name, strategy: redactionStrategy(rule.Strategy)}
+	switch c.strategy {
+	case "":
+		c.strategy = redactMask
+	case redactMask, redactHash, redactRemove:
+	default:
+		return compiledRule{}, fmt.Errorf("unknown strategy %q (valid: mask, hash, remove)", rule.Strategy)
+	}
+
+	switch {
+	case rule.Detector != "":
+		pattern, ok := builtinDetectors[rule.Detector]
+		if !ok {
+			return compiledRule{}, fmt.Errorf("unknown detector %q (valid: %s)", rule.Detector, strings.Join(BuiltinDetectorNames(), ", "))
+		}
+		c.re = regexp.MustCompile(pattern)
+		if c.name == "" {
+			c.name = rule.Detector
+		}
+	case rule.Pattern != "":
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return compiledRule{}, fmt.Errorf("invalid pattern: %w", err)
+		}
+		c.re = re
+		if c.name == "" {
+			c.name = "pattern"
+		}
+	default:
+		c.path = parseRedactionPath(rule.Path)
+		if len(c.path) == 0 {
+			return compiledRule{}, fmt.Errorf("invalid path %q", rule.Path)
+		}
+		if c.name == "" {
+			c.name = "field"
+		}
+	}
+	return c, nil
+}
+
+// parseRedactionPath splits "messages[*].content[*].source.data" into
+// ["messages", "*", "content", "*", "source", "data"]. A "*" step matches
+// every array element or object key.
+func parseRedactionPath(path string) []string {
+	var steps []string
+	for _, part := range strings.Split(path, ".") {
+		wildcards := 0
+		for strings.HasSuffix(part, "[*]") {
+			part = strings.TrimSuffix(part, "[*]")
+			wildcards++
+		}
+		if part != "" {
+			steps = append(steps, part)
+		}
+		for ; wildcards > 0; wildcards-- {
+			steps = append(steps, "*")
+		}
+	}
+	return steps
+}
+
+// replacement returns what a matched value becomes under the rule's strategy.
+func (c compiledRule) replacement(value string) string {
+	switch c.strategy {
+	case redactHash:
+		sum := sha256.Sum256([]byte(value))
+		return "[REDACTED:" + c.name + ":" + hex.EncodeToString(sum[:8]) + "]"
+	case redactRemove:
+		return ""
+	}
+	return "[REDACTED:" + c.name + "]"
+}
+
+// RedactBody returns a redacted copy of body and the number of redactions
+// per rule. body itself is never modified; it is returned as is when nothing
+// matched.
+func (r *Redactor) RedactBody(body []byte) ([]byte, map[string]int) {
+	if r == nil || len(body) == 0 {
+		return body, nil
+	}
+
+	counts := make(map[string]int)
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		// Not JSON: redact the raw text
+		redacted := r.redactText(string(body), counts)
+		if len(counts) == 0 {
+			return body, nil
+		}
+		return []byte(redacted), counts
+	}
+
+	for _, rule := range r.pathRules {
+		doc = rule.applyPath(doc, rule.path, counts)
+	}
+	doc = r.redactValue(doc, counts)
+	if len(counts) == 0 {
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return body, nil
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), counts
+}
+
+// RedactChunks returns redacted copies of stream chunks. SSE data lines are
+// redacted as JSON; anything else as text. Upstreams split text and tool
+// argument deltas at arbitrary points, so the text rules first run over each
+// reassembled delta stream (see redactStreamDeltas) before every chunk is
+// redacted on its own.
+func (r *Redactor) RedactChunks(chunks []StreamChunk) ([]StreamChunk, map[string]int) {
+	if r == nil || chunks == nil {
+		return chunks, nil
+	}
+
+	total := make(map[string]int)
+	out := make([]StreamChunk, len(chunks))
+	copy(out, chunks)
+	r.redactStreamDeltas(out, total)
+
+	for i := range out {
+		raw := out[i].Raw
+		prefix := ""
+		if strings.HasPrefix(raw, "data: ") {
+			prefix, raw = "data: ", raw[len("data: "):]
+		}
+		suffix := raw[len(strings.TrimRight(raw, "\r\n")):]
+		redacted, counts := r.RedactBody([]byte(strings.TrimRight(raw, "\r\n")))
+		if len(counts) == 0 {
+			continue
+		}
+		out[i].Raw = prefix + string(redacted) + suffix
+		for name, n := range counts {
+			total[name] += n
+		}
+	}
+	if len(total) == 0 {
+		return chunks, nil
+	}
+	return out, total
+}
+
+// streamDeltaKeys are the chunk fields that carry a fragment of a longer
+// value: text and thinking deltas (Anthropic, OpenAI, Gemini, Responses API)
+// and tool call arguments.
+var streamDeltaKeys = map[string]bool{
+	"text":              true,
+	"thinking":          true,
+	"partial_json":      true,
+	"content":           true,
+	"reasoning_content": true,
+	"arguments":         true,
+	"delta":             true,
+}
+
+// streamIndexKeys identify which block, choice or tool call a delta belongs
+// to, so interleaved streams are reassembled separately.
+var streamIndexKeys = []string{"index", "output_index", "content_index"}
+
+// deltaFragment is one delta string inside a decoded chunk.
+type deltaFragment struct {
+	chunk int
+	value string
+	set   func(string)
+}
+
+// redactStreamDeltas runs the text rules over the concatenation of every
+// delta stream in chunks and rewrites the fragments a match spans: the
+// replacement goes into the fragment where the match starts and the rest of
+// the match is cut from the following fragments. Chunks are rewritten in
+// place; chunks without a match keep their original bytes.
+func (r *Redactor) redactStreamDeltas(chunks []StreamChunk, counts map[string]int) {
+	if len(r.textRules) == 0 {
+		return
+	}
+
+	docs := make(map[int]interface{})
+	streams := make(map[string][]*deltaFragment)
+	var order []string
+	for i, chunk := range chunks {
+		if !strings.HasPrefix(chunk.Raw, "data: ") {
+			continue
+		}
+		var doc interface{}
+		dec := json.NewDecoder(strings.NewReader(strings.TrimRight(chunk.Raw[len("data: "):], "\r\n")))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil || dec.More() {
+			continue
+		}
+		docs[i] = doc
+		collectDeltas(doc, "", func(key, value string, set func(string)) {
+			if _, ok := streams[key]; !ok {
+				order = append(order, key)
+			}
+			streams[key] = append(streams[key], &deltaFragment{chunk: i, value: value, set: set})
+		})
+	}
+
+	changed := make(map[int]bool)
+	for _, key := range order {
+		fragments := streams[key]
+		if len(fragments) < 2 {
+			// A lone fragment is covered by the per-chunk pass
+			continue
+		}
+		values := make([]string, len(fragments))
+		for i, f := range fragments {
+			values[i] = f.value
+		}
+		for _, rule := range r.textRules {
+			values = rule.redactAcross(values, counts)
+		}
+		for i, f := range fragments {
+			if values[i] != f.value {
+				f.set(values[i])
+				changed[f.chunk] = true
+			}
+		}
+	}
+
+	for i := range changed {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(docs[i]); err != nil {
+			continue
+		}
+		raw := chunks[i].Raw
+		suffix := raw[len(strings.TrimRight(raw, "\r\n")):]
+		chunks[i].Raw = "data: " + strings.TrimSuffix(buf.String(), "\n") + suffix
+	}
+}
+
+// collectDeltas calls visit for every delta string in a decoded chunk. key
+// names the stream the fragment belongs to: its path, with array positions
+// replaced by the element's own index field where it has one.
+func collectDeltas(v interface{}, key string, visit func(key, value string, set func(string))) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range streamIndexKeys {
+			if n, ok := val[name].(json.Number); ok {
+				key += "#" + name + "=" + n.String()
+			}
+		}
+		for k, child := range val {
+			if s, ok := child.(string); ok {
+				if streamDeltaKeys[k] {
+					k := k
+					visit(key+"."+k, s, func(ns string) { val[k] = ns })
+				}
+				continue
+			}
+			collectDeltas(child, key+"."+k, visit)
+		}
+	case []interface{}:
+		for i, child := range val {
+			if m, ok := child.(map[string]interface{}); ok {
+				if _, ok := m["index"].(json.Number); ok {
+					// The element's index field already names it
+					collectDeltas(child, key+"[]", visit)
+					continue
+				}
+			}
+			collectDeltas(child, fmt.Sprintf("%s[%d]", key, i), visit)
+		}
+	}
+}
+
+// redactAcross applies the rule to the concatenation of fragments and
+// returns the rewritten fragments, one per input.
+func (c compiledRule) redactAcross(fragments []string, counts map[string]int) []string {
+	joined := strings.Join(fragments, "")
+	matches := c.re.FindAllStringIndex(joined, -1)
+	if len(matches) == 0 {
+		return fragments
+	}
+
+	out := make([]strings.Builder, len(fragments))
+	current, end := 0, len(fragments[0])
+	// seek moves current to the fragment holding byte pos of joined
+	seek := func(pos int) {
+		for current < len(fragments)-1 && pos >= end {
+			current++
+			end += len(fragments[current])
+		}
+	}
+	pos := 0
+	copyTo := func(limit int) {
+		for pos < limit {
+			seek(pos)
+			n := min(end, limit)
+			out[current].WriteString(joined[pos:n])
+			pos = n
+		}
+	}
+	for _, m := range matches {
+		if m[0] == m[1] {
+			continue
+		}
+		copyTo(m[0])
+		seek(pos)
+		counts[c.name]++
+		out[current].WriteString(c.replacement(joined[m[0]:m[1]]))
+		pos = m[1]
+	}
+	copyTo(len(joined))
+
+	result := make([]string, len(fragments))
+	for i := range out {
+		result[i] = out[i].String()
+	}
+	return result
+}
+
+func (r *Redactor) redactText(s string, counts map[string]int) string {
+	for _, rule := range r.textRules {
+		s = rule.re.ReplaceAllStringFunc(s, func(match string) string {
+			counts[rule.name]++
+			return rule.replacement(match)
+		})
+	}
+	return s
+}
+
+// redactValue applies the text rules to every string in a decoded JSON value.
+func (r *Redactor) redactValue(v interface{}, counts map[string]int) interface{} {
+	switch val := v.(type) {
+	case string:
+		return r.redactText(val, counts)
+	case map[string]interface{}:
+		for k, child := range val {
+			val[k] = r.redactValue(child, counts)
+		}
+	case []interface{}:
+		for i, child := range val {
+			val[i] = r.redactValue(child, counts)
+		}
+	}
+	return v
+}
+
+// applyPath replaces (or with remove, deletes) every field matching path.
+func (c compiledRule) applyPath(v interface{}, path []string, counts map[string]int) interface{} {
+	if len(path) == 0 {
+		return v
+	}
+	step, rest := path[0], path[1:]
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if step != "*" && step != k {
+				continue
+			}
+			if len(rest) > 0 {
+				val[k] = c.applyPath(child, rest, counts)
+				continue
+			}
+			counts[c.name]++
+			if c.strategy == redactRemove {
+				delete(val, k)
+				continue
+			}
+			val[k] = c.replacement(redactedValueText(child))
+		}
+	case []interface{}:
+		if step != "*" {
+			return v
+		}
+		for i, child := range val {
+			if len(rest) > 0 {
+				val[i] = c.applyPath(child, rest, counts)
+				continue
+			}
+			counts[c.name]++
+			val[i] = c.replacement(redactedValueText(child))
+		}
+	}
+	return v
+}
+
+// redactedValueText is the text hashed for a replaced field.
+func redactedValueText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,515 @@
+// replay.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replay re-issues a logged request and records the result as a new session
+// linked to the original in sessions.db. Logged credentials are obfuscated,
+// so they are taken from the environment instead. The new session is logged
+// with the same header obfuscation, redaction and encryption as the proxy's.
+
+// ReplayOptions configures `llm-proxy replay`.
+type ReplayOptions struct {
+	LogDir     string
+	ConfigPath string
+	SessionID  string
+	Seq        int    // Request to replay; 0 means the session's last request
+	Model      string // Replaces the request's model
+	Upstream   string // Base URL to send to instead of the logged upstream
+	Diff       bool   // Print a diff of the old and new responses
+}
+
+// ReplayResult describes a completed replay.
+type ReplayResult struct {
+	SessionID   string // The new session
+	Seq         int    // The replayed request's seq in the original session
+	Status      int
+	OldResponse string // Text of the original response
+	NewResponse string // Text of the replayed response
+}
+
+// replayCredential is an environment variable whose value authenticates a
+// replayed request, and the header it is sent in.
+type replayCredential struct {
+	env    string
+	header string
+	bearer bool
+}
+
+// replayCredentials lists the credentials tried for each provider, in order.
+// Providers without an entry (self-hosted servers) need none.
+var replayCredentials = map[string][]replayCredential{
+	"anthropic": {
+		{env: "ANTHROPIC_API_KEY", header: "x-api-key"},
+		{env: "ANTHROPIC_AUTH_TOKEN", header: "Authorization", bearer: true},
+	},
+	"openai": {
+		{env: "OPENAI_API_KEY", header: "Authorization", bearer: true},
+	},
+	"gemini": {
+		{env: "GEMINI_API_KEY", header: "x-goog-api-key"},
+		{env: "GOOGLE_API_KEY", header: "x-goog-api-key"},
+	},
+	"mistral": {
+		{env: "MISTRAL_API_KEY", header: "Authorization", bearer: true},
+	},
+}
+
+// replaySkippedHeaders are logged request headers that are not re-sent. The
+// transport sets them for the new request.
+var replaySkippedHeaders = []string{
+	"Accept-Encoding",
+	"Connection",
+	"Content-Length",
+	"Host",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// geminiModelPath matches the model segment of a Gemini request path.
+var geminiModelPath = regexp.MustCompile(`/models/[^/:]+:`)
+
+// ParseReplayFlags parses arguments for the replay subcommand. The session
+// ID may come before or after the flags.
+func ParseReplayFlags(args []string) (ReplayOptions, error) {
+	fs := flag.NewFlagSet("llm-proxy replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: llm-proxy replay <session-id> [flags]\n\nRe-issues a logged request and logs the result as a new session.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var opts ReplayOptions
+	fs.StringVar(&opts.LogDir, "log-dir", "", "Directory of log files (default ~/.llm-provider-logs)")
+	fs.StringVar(&opts.ConfigPath, "config", "", "Path to config file")
+	fs.IntVar(&opts.Seq, "seq", 0, "Sequence number of the request to replay (default: the last request)")
+	fs.StringVar(&opts.Model, "model", "", "Replay with this model instead of the logged one")
+	fs.StringVar(&opts.Upstream, "upstream", "", "Send to this base URL (e.g. http://localhost:8000) instead of the logged upstream")
+	fs.BoolVar(&opts.Diff, "diff", false, "Print a diff of the original and replayed responses")
+
+	if err := fs.Parse(args); err != nil {
+		return ReplayOptions{}, err
+	}
+	if fs.NArg() > 0 {
+		opts.SessionID = fs.Arg(0)
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return ReplayOptions{}, err
+		}
+	}
+	if fs.NArg() > 0 {
+		return ReplayOptions{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if opts.SessionID == "" {
+		fs.Usage()
+		return ReplayOptions{}, fmt.Errorf("no session ID given")
+	}
+	if opts.Seq < 0 {
+		return ReplayOptions{}, fmt.Errorf("invalid --seq %d", opts.Seq)
+	}
+	if opts.Upstream != "" {
+		u, err := url.Parse(opts.Upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ReplayOptions{}, fmt.Errorf("invalid --upstream %q: must be an http or https URL", opts.Upstream)
+		}
+	}
+	return opts, nil
+}
+
+// RunReplay implements `llm-proxy replay`.
+func RunReplay(args []string, out io.Writer) error {
+	opts, err := ParseReplayFlags(args)
+	if err != nil {
+		return err
+	}
+
+	if opts.LogDir == "" {
+		home, _ := os.UserHomeDir()
+		opts.LogDir = filepath.Join(home, ".llm-provider-logs")
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	result, err := Replay(opts, cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Replayed %s seq %d as session %s (status %d)\n", opts.SessionID, result.Seq, result.SessionID, result.Status)
+	if opts.Diff {
+		fmt.Fprintf(out, "\n--- %s seq %d\n+++ %s\n", opts.SessionID, result.Seq, result.SessionID)
+		writeLineDiff(out, result.OldResponse, result.NewResponse)
+	}
+	return nil
+}
+
+// Replay re-issues one request from a logged session and logs the exchange
+// as a new session under opts.LogDir.
+func Replay(opts ReplayOptions, cfg Config) (*ReplayResult, error) {
+	cipher, err := LoadLogCipher(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	redactor, err := NewRedactor(cfg.Redaction)
+	if err != nil {
+		return nil, err
+	}
+
+	explorer := &Explorer{logDir: opts.LogDir, cipher: cipher}
+	paths := explorer.findSessionFiles(opts.SessionID)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("session %s not found in %s", opts.SessionID, opts.LogDir)
+	}
+	entries, err := explorer.parseSessionFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	fileLogger, err := NewLogger(opts.LogDir)
+	if err != nil {
+		return nil, err
+	}
+	defer fileLogger.Close()
+	fileLogger.SetMaxFileSize(cfg.Retention.MaxSessionFileMB << 20)
+	fileLogger.SetDedup(cfg.Storage.Dedup)
+	fileLogger.SetCipher(cipher)
+	headerObfuscator := NewHeaderObfuscator(cfg.SensitiveHeaders)
+	fileLogger.SetHeaderObfuscator(headerObfuscator)
+
+	sm, err := NewSessionManager(opts.LogDir, fileLogger)
+	if err != nil {
+		return nil, err
+	}
+	defer sm.Close()
+
+	// The session_start entry records where the session was sent; older
+	// logs may only have it in sessions.db
+	provider, upstream := sessionStartInfo(entries)
+	if provider == "" {
+		if provider, upstream, err = sm.GetSession(opts.SessionID); err != nil {
+			return nil, fmt.Errorf("session %s has no session_start entry or sessions.db record", opts.SessionID)
+		}
+	}
+	if LookupProvider(provider) == nil {
+		return nil, fmt.Errorf("session %s has unknown provider %q", opts.SessionID, provider)
+	}
+	if strings.HasPrefix(upstream, "bedrock-runtime.") {
+		return nil, fmt.Errorf("session %s was sent to Bedrock, which can't be replayed", opts.SessionID)
+	}
+
+	turn, err := replayTurn(explorer.groupAndParseTurns(entries, upstream), opts.Seq)
+	if err != nil {
+		return nil, fmt.Errorf("session %s: %w", opts.SessionID, err)
+	}
+
+	var logged struct {
+		Method  string      `json:"method"`
+		Headers http.Header `json:"headers"`
+	}
+	if err := json.Unmarshal([]byte(turn.Request.Raw), &logged); err != nil {
+		return nil, fmt.Errorf("session %s seq %d: reading the logged request: %w", opts.SessionID, turn.Seq, err)
+	}
+	if logged.Method == "" {
+		return nil, fmt.Errorf("session %s seq %d: the logged request has no method", opts.SessionID, turn.Seq)
+	}
+
+	body, path := []byte(turn.Request.Body), turn.Request.Path
+	if opts.Model != "" {
+		if body, path, err = replaceRequestModel(body, path, opts.Model); err != nil {
+			return nil, err
+		}
+	}
+
+	// Resolve where to send the replay
+	var targetURL string
+	if opts.Upstream != "" {
+		u, _ := url.Parse(opts.Upstream)
+		upstream = u.Host
+		targetURL = strings.TrimSuffix(opts.Upstream, "/") + path
+	} else {
+		var allowlist *UpstreamAllowlist
+		if len(cfg.Upstreams) > 0 {
+			allowlist = NewUpstreamAllowlist(cfg.Upstreams)
+		}
+		if err := allowlist.Check(provider, upstream); err != nil {
+			return nil, fmt.Errorf("upstream not allowed: %w", err)
+		}
+		targetURL = buildUpstreamURL(upstream, path, "")
+	}
+
+	headers := replayHeaders(logged.Headers, headerObfuscator)
+	if err := addReplayCredentials(provider, headers); err != nil {
+		return nil, err
+	}
+
+	client, err := createUpstreamClient(cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(logged.Method, targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+
+	sessionID, err := sm.CreateReplaySession(opts.SessionID, provider, upstream)
+	if err != nil {
+		return nil, fmt.Errorf("creating replay session: %w", err)
+	}
+	meta := map[string]interface{}{
+		"replay_of":  opts.SessionID,
+		"replay_seq": turn.Seq,
+	}
+	requestID := uuid.New().String()
+	fileLogger.LogSessionStart(sessionID, provider, upstream)
+	loggedBody, counts := redactor.RedactBody(body)
+	fileLogger.LogRequestWithMeta(sessionID, provider, 1, logged.Method, path, headers, loggedBody, requestID, replayMeta(meta, counts))
+
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var respBody []byte
+	var chunks []StreamChunk
+	var parsed ParsedResponse
+	var timing ResponseTiming
+	if isStreamingResponse(resp) {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				now := time.Now()
+				chunks = append(chunks, StreamChunk{
+					Timestamp: now,
+					DeltaMs:   now.Sub(startTime).Milliseconds(),
+					Raw:       string(line),
+				})
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading replayed response: %w", err)
+			}
+		}
+		if len(chunks) > 0 {
+			timing.TTFBMs = chunks[0].DeltaMs
+		}
+		parsed = LookupProvider(provider).ParseStream(chunks)
+	} else {
+		timing.TTFBMs = time.Since(startTime).Milliseconds()
+		if respBody, err = io.ReadAll(resp.Body); err != nil {
+			return nil, fmt.Errorf("reading replayed response: %w", err)
+		}
+		parsed = ParseResponseBody(string(respBody), upstream)
+	}
+	timing.TotalMs = time.Since(startTime).Milliseconds()
+
+	cost := priceResponse(NewPriceTable(cfg.Pricing), sm, sessionID, responseModel(extractRequestModel(body), parsed), parsed.Usage)
+	loggedRespBody, loggedChunks := respBody, chunks
+	if chunks != nil {
+		loggedChunks, counts = redactor.RedactChunks(chunks)
+	} else {
+		loggedRespBody, counts = redactor.RedactBody(respBody)
+	}
+	fileLogger.LogResponseWithMeta(sessionID, provider, 1, resp.StatusCode, resp.Header, loggedRespBody, loggedChunks, timing, requestID, cost, replayMeta(meta, counts))
+
+	result := &ReplayResult{
+		SessionID:   sessionID,
+		Seq:         turn.Seq,
+		Status:      resp.StatusCode,
+		NewResponse: responseText(parsed, string(respBody)),
+	}
+	if turn.Response != nil {
+		result.OldResponse = responseText(turn.RespParsed, turn.Response.Body)
+	}
+	return result, nil
+}
+
+// replayMeta adds redaction counts, if any, to a copy of the replay _meta.
+func replayMeta(meta map[string]interface{}, counts map[string]int) map[string]interface{} {
+	out := make(map[string]interface{}, len(meta)+1)
+	for k, v := range meta {
+		out[k] = v
+	}
+	for k, v := range redactionMeta(counts) {
+		out[k] = v
+	}
+	return out
+}
+
+// sessionStartInfo returns the provider and upstream from a session's
+// session_start entry.
+func sessionStartInfo(entries []LogEntry) (provider, upstream string) {
+	for _, entry := range entries {
+		if entry.Type != "session_start" {
+			continue
+		}
+		var start struct {
+			Provider string `json:"provider"`
+			Upstream string `json:"upstream"`
+		}
+		if json.Unmarshal([]byte(entry.Raw), &start) == nil && start.Provider != "" {
+			return start.Provider, start.Upstream
+		}
+	}
+	return "", ""
+}
+
+// replayTurn picks the turn with the given seq, or the last turn with a
+// request when seq is 0.
+func replayTurn(turns []ParsedTurn, seq int) (ParsedTurn, error) {
+	for i := len(turns) - 1; i >= 0; i-- {
+		if turns[i].Request == nil {
+			continue
+		}
+		if seq == 0 || turns[i].Seq == seq {
+			return turns[i], nil
+		}
+	}
+	if seq == 0 {
+		return ParsedTurn{}, fmt.Errorf("no logged requests")
+	}
+	return ParsedTurn{}, fmt.Errorf("no request with seq %d", seq)
+}
+
+// replaceRequestModel swaps the model in a request body, or in the path for
+// Gemini, which names the model there.
+func replaceRequestModel(body []byte, path, model string) ([]byte, string, error) {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(body, &raw) == nil {
+		if _, ok := raw["model"]; ok {
+			raw["model"], _ = json.Marshal(model)
+			newBody, err := json.Marshal(raw)
+			return newBody, path, err
+		}
+	}
+	if geminiModelPath.MatchString(path) {
+		return body, geminiModelPath.ReplaceAllLiteralString(path, "/models/"+model+":"), nil
+	}
+	return nil, "", fmt.Errorf("can't set the model: the request has no model field")
+}
+
+// replayHeaders copies the logged request headers that can be re-sent.
+// Sensitive headers were obfuscated when logged, so they are dropped.
+func replayHeaders(logged http.Header, obfuscator *HeaderObfuscator) http.Header {
+	headers := make(http.Header)
+	for name, values := range logged {
+		if obfuscator.Sensitive(name) {
+			continue
+		}
+		headers[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+	for _, name := range replaySkippedHeaders {
+		headers.Del(name)
+	}
+	return headers
+}
+
+// addReplayCredentials sets the provider's credentials from the environment.
+func addReplayCredentials(provider string, headers http.Header) error {
+	credentials, ok := replayCredentials[provider]
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, c := range credentials {
+		value := os.Getenv(c.env)
+		if value == "" {
+			names = append(names, c.env)
+			continue
+		}
+		if c.bearer {
+			value = "Bearer " + value
+		}
+		headers.Set(c.header, value)
+		return nil
+	}
+	return fmt.Errorf("replaying %s requests needs credentials: set %s", provider, strings.Join(names, " or "))
+}
+
+// responseText renders a response's content for diffing, falling back to the
+// raw body when it didn't parse (e.g. error responses).
+func responseText(parsed ParsedResponse, rawBody string) string {
+	var parts []string
+	for _, block := range parsed.Content {
+		switch {
+		case block.Text != "":
+			parts = append(parts, block.Text)
+		case block.ToolName != "":
+			input, _ := json.Marshal(block.ToolInput)
+			parts = append(parts, fmt.Sprintf("[tool_use %s] %s", block.ToolName, input))
+		}
+	}
+	if len(parts) == 0 {
+		return rawBody
+	}
+	return strings.Join(parts, "\n")
+}
+
+// writeLineDiff writes a line diff of a and b, prefixing removed lines with
+// "-", added lines with "+" and unchanged lines with a space.
+func writeLineDiff(w io.Writer, a, b string) {
+	old, upd := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of old[i:] and upd[j:]
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(upd)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(upd) - 1; j >= 0; j-- {
+			if old[i] == upd[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(old) && j < len(upd) {
+		switch {
+		case old[i] == upd[j]:
+			fmt.Fprintf(w, " %s\n", old[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(w, "-%s\n", old[i])
+			i++
+		default:
+			fmt.Fprintf(w, "+%s\n", upd[j])
+			j++
+		}
+	}
+	for ; i < len(old); i++ {
+		fmt.Fprintf(w, "-%s\n", old[i])
+	}
+	for ; j < len(upd); j++ {
+		fmt.Fprintf(w, "+%s\n", upd[j])
+	}
+}
@@ -0,0 +1,303 @@
+// report.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ReportOptions configures an offline usage report.
+type ReportOptions struct {
+	LogDir     string
+	ConfigPath string
+	GroupBy    string     // day, model, upstream, machine or session
+	Format     string     // table, csv or json
+	Since      time.Time  // Inclusive; zero means unbounded
+	Until      time.Time  // Exclusive; zero means unbounded
+	Cipher     *LogCipher // Decrypts encrypted logs (from the config's encryption key)
+}
+
+// ReportRow aggregates usage for one group.
+type ReportRow struct {
+	Key                      string  `json:"key"`
+	Turns                    int     `json:"turns"`
+	InputTokens              int     `json:"input_tokens"`
+	OutputTokens             int     `json:"output_tokens"`
+	CacheReadInputTokens     int     `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int     `json:"cache_creation_input_tokens"`
+	CostUSD                  float64 `json:"cost_usd"`
+	UnpricedTurns            int     `json:"unpriced_turns"` // Turns with usage but no known price
+}
+
+var reportGroupings = []string{"day", "model", "upstream", "machine", "session"}
+
+var reportFormats = []string{"table", "csv", "json"}
+
+// ParseReportFlags parses arguments for the report subcommand.
+func ParseReportFlags(args []string) (ReportOptions, error) {
+	fs := flag.NewFlagSet("llm-proxy report", flag.ContinueOnError)
+
+	var opts ReportOptions
+	var since, until string
+	fs.StringVar(&opts.LogDir, "log-dir", "", "Directory of log files (default ~/.llm-provider-logs)")
+	fs.StringVar(&opts.ConfigPath, "config", "", "Path to config file (for [pricing] overrides)")
+	fs.StringVar(&opts.GroupBy, "group-by", "day", "Group totals by: "+strings.Join(reportGroupings, ", "))
+	fs.StringVar(&opts.Format, "format", "table", "Output format: "+strings.Join(reportFormats, ", "))
+	fs.StringVar(&since, "since", "", "Only include turns at or after this date (YYYY-MM-DD or RFC3339)")
+	fs.StringVar(&until, "until", "", "Only include turns before the end of this date (YYYY-MM-DD or RFC3339)")
+
+	if err := fs.Parse(args); err != nil {
+		return ReportOptions{}, err
+	}
+
+	if !slices.Contains(reportGroupings, opts.GroupBy) {
+		return ReportOptions{}, fmt.Errorf("invalid --group-by %q (valid: %s)", opts.GroupBy, strings.Join(reportGroupings, ", "))
+	}
+	if !slices.Contains(reportFormats, opts.Format) {
+		return ReportOptions{}, fmt.Errorf("invalid --format %q (valid: %s)", opts.Format, strings.Join(reportFormats, ", "))
+	}
+
+	var err error
+	if since != "" {
+		if opts.Since, _, err = parseReportTime(since); err != nil {
+			return ReportOptions{}, fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+	if until != "" {
+		var dateOnly bool
+		if opts.Until, dateOnly, err = parseReportTime(until); err != nil {
+			return ReportOptions{}, fmt.Errorf("invalid --until: %w", err)
+		}
+		// A bare date includes the whole day
+		if dateOnly {
+			opts.Until = opts.Until.AddDate(0, 0, 1)
+		}
+	}
+
+	return opts, nil
+}
+
+// parseReportTime accepts a YYYY-MM-DD date (UTC) or an RFC3339 timestamp.
+func parseReportTime(s string) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+// RunReport implements `llm-proxy report`.
+func RunReport(args []string, out io.Writer) error {
+	opts, err := ParseReportFlags(args)
+	if err != nil {
+		return err
+	}
+
+	if opts.LogDir == "" {
+		home, _ := os.UserHomeDir()
+		opts.LogDir = filepath.Join(home, ".llm-provider-logs")
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	if opts.Cipher, err = LoadLogCipher(cfg.Encryption); err != nil {
+		return err
+	}
+
+	rows, err := BuildReport(opts, NewPriceTable(cfg.Pricing))
+	if err != nil {
+		return err
+	}
+
+	return WriteReport(out, opts, rows)
+}
+
+// BuildReport walks the session logs under logDir and totals
+// usage per group. Responses logged with cost_usd keep that cost; older
+// entries are priced with pricing.
+func BuildReport(opts ReportOptions, pricing *PriceTable) ([]ReportRow, error) {
+	if _, err := os.Stat(opts.LogDir); err != nil {
+		return nil, err
+	}
+
+	explorer := &Explorer{logDir: opts.LogDir, cipher: opts.Cipher}
+	groups := make(map[string]*ReportRow)
+
+	err := walkSessionLogs(opts.LogDir, func(session sessionLog) error {
+		host, date, sessionID := session.Host, session.Date, session.SessionID
+
+		// Session files live under the date the session started, so only
+		// sessions starting after the window can be skipped outright
+		dirDate, dateErr := time.Parse("2006-01-02", date)
+		if dateErr == nil && !opts.Until.IsZero() && !dirDate.Before(opts.Until) {
+			return nil
+		}
+
+		// A session that can't be read (a missing or wrong encryption key, a
+		// damaged part) fails the report rather than silently leaving its
+		// usage out of the totals
+		entries, err := explorer.parseSessionFiles(session.Paths)
+		if err != nil {
+			return fmt.Errorf("reading session %s/%s/%s: %w", host, date, sessionID, err)
+		}
+
+		for _, turn := range explorer.groupAndParseTurns(entries, host) {
+			if turn.Request == nil {
+				continue
+			}
+
+			ts := turn.Request.Meta.Timestamp
+			if ts.IsZero() {
+				ts = dirDate
+			}
+			if !opts.Since.IsZero() && ts.Before(opts.Since) {
+				continue
+			}
+			if !opts.Until.IsZero() && !ts.Before(opts.Until) {
+				continue
+			}
+
+			model := turnModel(turn)
+
+			var key string
+			switch opts.GroupBy {
+			case "day":
+				key = ts.UTC().Format("2006-01-02")
+			case "model":
+				key = model
+			case "upstream":
+				key = host
+			case "machine":
+				key = turn.Request.Meta.Machine
+			case "session":
+				key = sessionID
+			}
+			if key == "" {
+				key = "unknown"
+			}
+
+			row, ok := groups[key]
+			if !ok {
+				row = &ReportRow{Key: key}
+				groups[key] = row
+			}
+
+			usage := turn.RespParsed.Usage
+			row.Turns++
+			row.InputTokens += usage.InputTokens
+			row.OutputTokens += usage.OutputTokens
+			row.CacheReadInputTokens += usage.CacheReadInputTokens
+			row.CacheCreationInputTokens += usage.CacheCreationInputTokens
+
+			if turn.Response == nil {
+				continue
+			}
+			if turn.Response.CostUSD != nil {
+				row.CostUSD += *turn.Response.CostUSD
+			} else if cost, ok := pricing.Cost(model, usage); ok {
+				row.CostUSD += cost
+			} else if usage != (UsageInfo{}) {
+				row.UnpricedTurns++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]ReportRow, 0, len(groups))
+	for _, row := range groups {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Key < rows[j].Key
+	})
+
+	return rows, nil
+}
+
+// turnModel returns the model a turn was sent to: the request body's model,
+// the Bedrock model ID from the request path, or the model the response reports.
+func turnModel(turn ParsedTurn) string {
+	if turn.ReqParsed.Model != "" {
+		return turn.ReqParsed.Model
+	}
+	if turn.Request != nil && strings.HasPrefix(turn.Request.Path, "/model/") {
+		if modelID, err := extractModelID(turn.Request.Path); err == nil {
+			return modelID
+		}
+	}
+	return responseModel("", turn.RespParsed)
+}
+
+// reportTotal sums all rows into a single TOTAL row.
+func reportTotal(rows []ReportRow) ReportRow {
+	total := ReportRow{Key: "TOTAL"}
+	for _, row := range rows {
+		total.Turns += row.Turns
+		total.InputTokens += row.InputTokens
+		total.OutputTokens += row.OutputTokens
+		total.CacheReadInputTokens += row.CacheReadInputTokens
+		total.CacheCreationInputTokens += row.CacheCreationInputTokens
+		total.CostUSD += row.CostUSD
+		total.UnpricedTurns += row.UnpricedTurns
+	}
+	return total
+}
+
+// WriteReport renders rows in the requested format.
+func WriteReport(out io.Writer, opts ReportOptions, rows []ReportRow) error {
+	switch opts.Format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"group_by": opts.GroupBy,
+			"rows":     rows,
+			"total":    reportTotal(rows),
+		})
+
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{opts.GroupBy, "turns", "input_tokens", "output_tokens", "cache_read_input_tokens", "cache_creation_input_tokens", "cost_usd", "unpriced_turns"})
+		for _, row := range rows {
+			w.Write([]string{
+				row.Key,
+				strconv.Itoa(row.Turns),
+				strconv.Itoa(row.InputTokens),
+				strconv.Itoa(row.OutputTokens),
+				strconv.Itoa(row.CacheReadInputTokens),
+				strconv.Itoa(row.CacheCreationInputTokens),
+				strconv.FormatFloat(row.CostUSD, 'f', 6, 64),
+				strconv.Itoa(row.UnpricedTurns),
+			})
+		}
+		w.Flush()
+		return w.Error()
+
+	default:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tTURNS\tINPUT\tOUTPUT\tCACHE READ\tCACHE WRITE\tCOST (USD)\tUNPRICED\n", strings.ToUpper(opts.GroupBy))
+		for _, row := range append(rows, reportTotal(rows)) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.4f\t%d\n",
+				row.Key, row.Turns, row.InputTokens, row.OutputTokens,
+				row.CacheReadInputTokens, row.CacheCreationInputTokens, row.CostUSD, row.UnpricedTurns)
+		}
+		return w.Flush()
+	}
+}
@@ -0,0 +1,264 @@
+// report_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeReportSession writes a session file with one request/response pair per turn.
+func writeReportSession(t *testing.T, logDir, host, date, sessionID string, turns []map[string]interface{}) {
+	t.Helper()
+	dir := filepath.Join(logDir, host, date)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i, turn := range turns {
+		seq := i + 1
+		requestID := sessionID + "-" + string(rune('a'+i))
+		meta := map[string]interface{}{
+			"ts":         turn["ts"],
+			"machine":    turn["machine"],
+			"host":       host,
+			"session":    sessionID,
+			"request_id": requestID,
+		}
+		path := "/v1/messages"
+		if p, ok := turn["path"].(string); ok {
+			path = p
+		}
+		enc.Encode(map[string]interface{}{
+			"type": "request", "seq": seq, "path": path, "body": turn["request"], "_meta": meta,
+		})
+		resp := map[string]interface{}{
+			"type": "response", "seq": seq, "status": 200, "body": turn["response"], "_meta": meta,
+		}
+		if cost, ok := turn["cost_usd"]; ok {
+			resp["cost_usd"] = cost
+		}
+		enc.Encode(resp)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, sessionID+".jsonl"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func reportFixture(t *testing.T) string {
+	logDir := t.TempDir()
+
+	anthropicResp := `{"content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":1000,"output_tokens":100}}`
+	openaiResp := `{"object":"chat.completion","choices":[{"message":{"role":"assistant","content":"hi"}}],"usage":{"prompt_tokens":2000,"completion_tokens":200}}`
+
+	writeReportSession(t, logDir, "api.anthropic.com", "2026-01-10", "s1", []map[string]interface{}{
+		{"ts": "2026-01-10T10:00:00Z", "machine": "laptop", "request": `{"model":"claude-sonnet-4-20250514"}`, "response": anthropicResp},
+		// Logged cost wins over the price table
+		{"ts": "2026-01-11T09:00:00Z", "machine": "laptop", "request": `{"model":"claude-sonnet-4-20250514"}`, "response": anthropicResp, "cost_usd": 1.5},
+	})
+	writeReportSession(t, logDir, "api.openai.com", "2026-01-11", "s2", []map[string]interface{}{
+		{"ts": "2026-01-11T12:00:00Z", "machine": "ci", "request": `{"model":"gpt-4o"}`, "response": openaiResp},
+	})
+	writeReportSession(t, logDir, "bedrock-runtime.us-east-1.amazonaws.com", "2026-01-12", "s3", []map[string]interface{}{
+		{"ts": "2026-01-12T08:00:00Z", "machine": "ci", "path": "/model/us.anthropic.claude-sonnet-4-20250514-v1:0/invoke", "request": `{"messages":[]}`, "response": anthropicResp},
+		{"ts": "2026-01-12T08:05:00Z", "machine": "ci", "request": `{"model":"mystery-model"}`, "response": anthropicResp},
+	})
+
+	return logDir
+}
+
+func findRow(rows []ReportRow, key string) *ReportRow {
+	for i := range rows {
+		if rows[i].Key == key {
+			return &rows[i]
+		}
+	}
+	return nil
+}
+
+func TestBuildReportGroupByModel(t *testing.T) {
+	logDir := reportFixture(t)
+
+	rows, err := BuildReport(ReportOptions{LogDir: logDir, GroupBy: "model"}, NewPriceTable(nil))
+	if err != nil {
+		t.Fatalf("BuildReport failed: %v", err)
+	}
+
+	sonnet := findRow(rows, "claude-sonnet-4-20250514")
+	if sonnet == nil || sonnet.Turns != 2 {
+		t.Fatalf("expected 2 sonnet turns, got %+v", sonnet)
+	}
+	// 1000*3 + 100*15 = 0.0045, plus the logged 1.5
+	if math.Abs(sonnet.CostUSD-1.5045) > 1e-9 {
+		t.Errorf("expected sonnet cost 1.5045, got %v", sonnet.CostUSD)
+	}
+
+	// Bedrock model comes from the request path
+	bedrock := findRow(rows, "us.anthropic.claude-sonnet-4-20250514-v1:0")
+	if bedrock == nil || math.Abs(bedrock.CostUSD-0.0045) > 1e-9 {
+		t.Errorf("expected Bedrock row priced at 0.0045, got %+v", bedrock)
+	}
+
+	gpt := findRow(rows, "gpt-4o")
+	if gpt == nil || gpt.InputTokens != 2000 || gpt.OutputTokens != 200 {
+		t.Errorf("expected gpt-4o usage 2000/200, got %+v", gpt)
+	}
+
+	mystery := findRow(rows, "mystery-model")
+	if mystery == nil || mystery.UnpricedTurns != 1 || mystery.CostUSD != 0 {
+		t.Errorf("expected mystery-model to be unpriced, got %+v", mystery)
+	}
+}
+
+func TestBuildReportGroupings(t *testing.T) {
+	logDir := reportFixture(t)
+	pricing := NewPriceTable(nil)
+
+	tests := []struct {
+		groupBy  string
+		wantKeys []string
+	}{
+		{"day", []string{"2026-01-10", "2026-01-11", "2026-01-12"}},
+		{"upstream", []string{"api.anthropic.com", "api.openai.com", "bedrock-runtime.us-east-1.amazonaws.com"}},
+		{"machine", []string{"ci", "laptop"}},
+		{"session", []string{"s1", "s2", "s3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			rows, err := BuildReport(ReportOptions{LogDir: logDir, GroupBy: tt.groupBy}, pricing)
+			if err != nil {
+				t.Fatalf("BuildReport failed: %v", err)
+			}
+			var keys []string
+			for _, row := range rows {
+				keys = append(keys, row.Key)
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.wantKeys, ",") {
+				t.Errorf("expected keys %v, got %v", tt.wantKeys, keys)
+			}
+		})
+	}
+}
+
+func TestBuildReportSinceUntil(t *testing.T) {
+	logDir := reportFixture(t)
+
+	opts, err := ParseReportFlags([]string{"--log-dir", logDir, "--since", "2026-01-11", "--until", "2026-01-11"})
+	if err != nil {
+		t.Fatalf("ParseReportFlags failed: %v", err)
+	}
+
+	rows, err := BuildReport(opts, NewPriceTable(nil))
+	if err != nil {
+		t.Fatalf("BuildReport failed: %v", err)
+	}
+
+	// The second s1 turn (started in the 2026-01-10 session) and the s2 turn
+	if len(rows) != 1 || rows[0].Key != "2026-01-11" || rows[0].Turns != 2 {
+		t.Errorf("expected only 2026-01-11 with 2 turns, got %+v", rows)
+	}
+}
+
+func TestParseReportFlagsValidation(t *testing.T) {
+	opts, err := ParseReportFlags(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.GroupBy != "day" || opts.Format != "table" {
+		t.Errorf("expected defaults day/table, got %s/%s", opts.GroupBy, opts.Format)
+	}
+
+	opts, err = ParseReportFlags([]string{"--until", "2026-01-11T12:00:00Z"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.Until.Equal(time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected RFC3339 --until to be used as-is, got %v", opts.Until)
+	}
+
+	for _, args := range [][]string{
+		{"--group-by", "week"},
+		{"--format", "xml"},
+		{"--since", "yesterday"},
+	} {
+		if _, err := ParseReportFlags(args); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+func TestWriteReportFormats(t *testing.T) {
+	rows := []ReportRow{
+		{Key: "a", Turns: 1, InputTokens: 10, OutputTokens: 5, CostUSD: 0.25},
+		{Key: "b", Turns: 2, InputTokens: 20, OutputTokens: 10, CostUSD: 0.5, UnpricedTurns: 1},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, ReportOptions{GroupBy: "model", Format: "csv"}, rows); err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "model" || records[2][6] != "0.500000" {
+		t.Errorf("unexpected csv output: %v", records)
+	}
+
+	buf.Reset()
+	if err := WriteReport(&buf, ReportOptions{GroupBy: "model", Format: "json"}, rows); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var out struct {
+		GroupBy string      `json:"group_by"`
+		Rows    []ReportRow `json:"rows"`
+		Total   ReportRow   `json:"total"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if len(out.Rows) != 2 || out.Total.Turns != 3 || out.Total.CostUSD != 0.75 || out.Total.UnpricedTurns != 1 {
+		t.Errorf("unexpected json output: %+v", out)
+	}
+
+	buf.Reset()
+	if err := WriteReport(&buf, ReportOptions{GroupBy: "model", Format: "table"}, rows); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	table := buf.String()
+	if !strings.HasPrefix(table, "MODEL") || !strings.Contains(table, "TOTAL") || !strings.Contains(table, "0.7500") {
+		t.Errorf("unexpected table output:\n%s", table)
+	}
+}
+
+func TestBuildReportFailsOnUnreadableSession(t *testing.T) {
+	logDir := reportFixture(t)
+	c := testLogCipher(t, 5)
+	dir := filepath.Join(logDir, "api.anthropic.com", "2026-01-13")
+	os.MkdirAll(dir, 0755)
+	line := c.Seal([]byte(`{"type":"request","seq":1,"body":"{\"model\":\"claude-sonnet-4-20250514\"}","_meta":{"ts":"2026-01-13T10:00:00Z"}}`))
+	if err := os.WriteFile(filepath.Join(dir, "sealed.jsonl"), append(line, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, cipher := range map[string]*LogCipher{"no key": nil, "wrong key": testLogCipher(t, 6)} {
+		_, err := BuildReport(ReportOptions{LogDir: logDir, GroupBy: "model", Cipher: cipher}, NewPriceTable(nil))
+		if err == nil || !strings.Contains(err.Error(), "sealed") {
+			t.Errorf("%s: expected the undecryptable session to fail the report, got %v", name, err)
+		}
+	}
+
+	if _, err := BuildReport(ReportOptions{LogDir: logDir, GroupBy: "model", Cipher: c}, NewPriceTable(nil)); err != nil {
+		t.Errorf("expected the report to succeed with the right key, got %v", err)
+	}
+}
@@ -0,0 +1,404 @@
+// retention.go
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionConfig controls how long session logs are kept. Zero values
+// disable each policy.
+type RetentionConfig struct {
+	CompressAfterDays int    `toml:"compress_after_days"` // Compress date directories older than this
+	Compression       string `toml:"compression"`         // Compression format (only "gzip" is supported)
+	MaxAgeDays        int    `toml:"max_age_days"`        // Delete date directories older than this
+	MaxTotalMB        int64  `toml:"max_total_mb"`        // Delete the oldest logs until the total fits
+	MaxSessionFileMB  int64  `toml:"max_session_file_mb"` // Split a session file once it passes this size
+	SweepInterval     string `toml:"sweep_interval"`      // Duration between retention sweeps
+}
+
+// Enabled reports whether any sweep policy (compression or deletion) is configured.
+func (c RetentionConfig) Enabled() bool {
+	return c.CompressAfterDays > 0 || c.MaxAgeDays > 0 || c.MaxTotalMB > 0
+}
+
+// Validate returns an error for settings the proxy can't honor.
+func (c RetentionConfig) Validate() error {
+	switch c.Compression {
+	case "", "gzip":
+	case "zstd":
+		return fmt.Errorf("retention: zstd compression is not supported in this build; use gzip")
+	default:
+		return fmt.Errorf("retention: unknown compression %q (valid: gzip)", c.Compression)
+	}
+	if c.SweepInterval != "" {
+		if d, err := time.ParseDuration(c.SweepInterval); err != nil || d <= 0 {
+			return fmt.Errorf("retention: invalid sweep_interval %q", c.SweepInterval)
+		}
+	}
+	if c.CompressAfterDays < 0 || c.MaxAgeDays < 0 || c.MaxTotalMB < 0 || c.MaxSessionFileMB < 0 {
+		return fmt.Errorf("retention: limits must not be negative")
+	}
+	return nil
+}
+
+// retentionIdleTimeout is how long a session file must go unwritten before a
+// sweep may close, compress or delete it.
+const retentionIdleTimeout = time.Hour
+
+// RetentionStats summarizes one sweep.
+type RetentionStats struct {
+	Compressed   int
+	Deleted      int
+	BytesFreed   int64
+	SkippedInUse int
+}
+
+// RetentionManager periodically compresses and deletes old session logs.
+type RetentionManager struct {
+	config     RetentionConfig
+	logDir     string
+	logger     *Logger // May be nil; used to release idle open files
+	interval   time.Duration
+	now        func() time.Time
+	closeChan  chan struct{}
+	closedChan chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewRetentionManager creates a manager for logDir. Call Start to begin sweeping.
+func NewRetentionManager(cfg RetentionConfig, logDir string, logger *Logger) *RetentionManager {
+	interval := time.Hour
+	if d, err := time.ParseDuration(cfg.SweepInterval); err == nil && d > 0 {
+		interval = d
+	}
+	return &RetentionManager{
+		config:     cfg,
+		logDir:     logDir,
+		logger:     logger,
+		interval:   interval,
+		now:        time.Now,
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+}
+
+// Start runs a sweep immediately and then on every interval until Close.
+func (r *RetentionManager) Start() {
+	go func() {
+		defer close(r.closedChan)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			r.sweepAndLog()
+			select {
+			case <-ticker.C:
+			case <-r.closeChan:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background sweeper and waits for a running sweep to finish.
+func (r *RetentionManager) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closeChan)
+		<-r.closedChan
+	})
+}
+
+func (r *RetentionManager) sweepAndLog() {
+	stats, err := r.Sweep()
+	if err != nil {
+		log.Printf("WARNING: retention sweep failed: %v", err)
+		return
+	}
+	if stats.Compressed > 0 || stats.Deleted > 0 {
+		log.Printf("Retention: compressed %d files, deleted %d files (%.1f MB freed, %d in use skipped)",
+			stats.Compressed, stats.Deleted, float64(stats.BytesFreed)/(1<<20), stats.SkippedInUse)
+	}
+}
+
+// retentionFile is one session log file considered by a sweep.
+type retentionFile struct {
+	path  string
+	date  time.Time // From the date directory
+	size  int64
+	blobs bool // A date directory's blob store, removed with its last session log
+}
+
+// Sweep applies the retention policies once: delete by age, compress old
+// directories, then delete the oldest files until under the size quota.
+func (r *RetentionManager) Sweep() (RetentionStats, error) {
+	var stats RetentionStats
+
+	files, err := r.collect()
+	if err != nil {
+		return stats, err
+	}
+
+	today := r.now().UTC().Truncate(24 * time.Hour)
+	var kept []retentionFile
+
+	for _, f := range files {
+		if f.blobs {
+			kept = append(kept, f)
+			continue
+		}
+		age := int(today.Sub(f.date).Hours() / 24)
+
+		if r.config.MaxAgeDays > 0 && age > r.config.MaxAgeDays {
+			if r.remove(f, &stats) {
+				continue
+			}
+		}
+
+		if r.config.CompressAfterDays > 0 && age > r.config.CompressAfterDays && !strings.HasSuffix(f.path, compressedLogExt) {
+			if compressed, ok := r.compress(f, &stats); ok {
+				f = compressed
+			}
+		}
+
+		kept = append(kept, f)
+	}
+
+	if r.config.MaxTotalMB > 0 {
+		quota := r.config.MaxTotalMB << 20
+		var total int64
+		for _, f := range kept {
+			total += f.size
+		}
+		// Oldest first; kept is already sorted by date
+		for _, f := range kept {
+			if total <= quota {
+				break
+			}
+			if f.blobs {
+				if r.removeBlobs(f, &stats) {
+					total -= f.size
+				}
+				continue
+			}
+			if r.remove(f, &stats) {
+				total -= f.size
+			}
+		}
+	}
+
+	r.removeEmptyDirs()
+	return stats, nil
+}
+
+// collect lists all session log files, oldest date directory first.
+// Directories whose names aren't dates are left alone.
+func (r *RetentionManager) collect() ([]retentionFile, error) {
+	var files []retentionFile
+	err := walkSessionLogs(r.logDir, func(session sessionLog) error {
+		date, err := time.Parse("2006-01-02", session.Date)
+		if err != nil {
+			return nil
+		}
+		for _, path := range session.Paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			files = append(files, retentionFile{path: path, date: date, size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Blob stores sort after the session logs of the same day
+	blobDirs, _ := filepath.Glob(filepath.Join(r.logDir, "*", "*", blobDirName))
+	for _, dir := range blobDirs {
+		date, err := time.Parse("2006-01-02", filepath.Base(filepath.Dir(dir)))
+		if err != nil {
+			continue
+		}
+		files = append(files, retentionFile{path: dir, date: date, size: dirSize(dir), blobs: true})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].date.Before(files[j].date)
+	})
+	return files, nil
+}
+
+// claim makes sure the logger isn't writing to path and won't reopen it
+// until unclaim.
+func (r *RetentionManager) claim(path string) bool {
+	if r.logger == nil {
+		return true
+	}
+	return r.logger.claimIdleFile(path, retentionIdleTimeout)
+}
+
+func (r *RetentionManager) unclaim(path string) {
+	if r.logger != nil {
+		r.logger.unclaimFile(path)
+	}
+}
+
+func (r *RetentionManager) remove(f retentionFile, stats *RetentionStats) bool {
+	if !r.claim(f.path) {
+		stats.SkippedInUse++
+		return false
+	}
+	defer r.unclaim(f.path)
+	if err := os.Remove(f.path); err != nil {
+		log.Printf("WARNING: retention: failed to delete %s: %v", f.path, err)
+		return false
+	}
+	stats.Deleted++
+	stats.BytesFreed += f.size
+	return true
+}
+
+// removeBlobs deletes a blob store once no session logs in its date
+// directory can reference it.
+func (r *RetentionManager) removeBlobs(f retentionFile, stats *RetentionStats) bool {
+	if len(sessionLogsInDir(filepath.Dir(f.path))) > 0 {
+		return false
+	}
+	if err := os.RemoveAll(f.path); err != nil {
+		log.Printf("WARNING: retention: failed to delete %s: %v", f.path, err)
+		return false
+	}
+	if r.logger != nil {
+		r.logger.forgetBlobs(f.path)
+	}
+	stats.BytesFreed += f.size
+	return true
+}
+
+// dirSize totals the sizes of the files directly in dir.
+func dirSize(dir string) int64 {
+	var size int64
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// compress gzips f next to the original and removes the original. The
+// compressed file keeps the original's modification time. The claim lasts
+// until the original is gone, so writes meanwhile go to the session's next
+// part instead of a file about to be removed.
+func (r *RetentionManager) compress(f retentionFile, stats *RetentionStats) (retentionFile, bool) {
+	if !r.claim(f.path) {
+		stats.SkippedInUse++
+		return f, false
+	}
+	defer r.unclaim(f.path)
+
+	dst := f.path + compressedLogExt
+	size, err := compressLog(f.path, dst)
+	if err != nil {
+		log.Printf("WARNING: retention: failed to compress %s: %v", f.path, err)
+		return f, false
+	}
+	if info, err := os.Stat(f.path); err == nil {
+		os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	if err := os.Remove(f.path); err != nil {
+		log.Printf("WARNING: retention: failed to remove %s after compressing: %v", f.path, err)
+	}
+
+	stats.Compressed++
+	stats.BytesFreed += f.size - size
+	return retentionFile{path: dst, date: f.date, size: size}, true
+}
+
+// compressLog compresses a session log for retention. Tests replace it.
+var compressLog = gzipFile
+
+// gzipFile writes a gzip copy of src to dst via a temporary file, so readers
+// never see a partial archive. Returns the compressed size.
+func gzipFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// removeEmptyDirs deletes date and upstream directories left empty by a
+// sweep, along with blob stores no remaining session log can reference.
+// Dot-directories (the Loki spool, the webhook queue) and directories
+// without date subdirectories are left alone.
+func (r *RetentionManager) removeEmptyDirs() {
+	hosts, err := os.ReadDir(r.logDir)
+	if err != nil {
+		return
+	}
+	for _, host := range hosts {
+		if !host.IsDir() || strings.HasPrefix(host.Name(), ".") {
+			continue
+		}
+		hostDir := filepath.Join(r.logDir, host.Name())
+		dates, _ := os.ReadDir(hostDir)
+		hadDates := false
+		for _, date := range dates {
+			if _, err := time.Parse("2006-01-02", date.Name()); err != nil || !date.IsDir() {
+				continue
+			}
+			hadDates = true
+			dateDir := filepath.Join(hostDir, date.Name())
+			if len(sessionLogsInDir(dateDir)) == 0 {
+				os.RemoveAll(filepath.Join(dateDir, blobDirName))
+			}
+			// os.Remove only succeeds on empty directories
+			os.Remove(dateDir)
+		}
+		if hadDates {
+			os.Remove(hostDir)
+		}
+	}
+}
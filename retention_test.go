@@ -0,0 +1,402 @@
+// retention_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeTestLog(t *testing.T, logDir, host, date, name, content string) string {
+	t.Helper()
+	dir := filepath.Join(logDir, host, date)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestRetention(cfg RetentionConfig, logDir string, logger *Logger) *RetentionManager {
+	r := NewRetentionManager(cfg, logDir, logger)
+	r.now = func() time.Time { return time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC) }
+	return r
+}
+
+func TestParseLogFileName(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessionID string
+		part      int
+		ok        bool
+	}{
+		{"20260320-100000-abcd.jsonl", "20260320-100000-abcd", 1, true},
+		{"20260320-100000-abcd.part3.jsonl", "20260320-100000-abcd", 3, true},
+		{"20260320-100000-abcd.part2.jsonl.gz", "20260320-100000-abcd", 2, true},
+		{"20260320-100000-abcd.jsonl.gz", "20260320-100000-abcd", 1, true},
+		{"20260320-100000-abcd.jsonl.gz.tmp", "", 0, false},
+		{"notes.txt", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		sessionID, part, ok := parseLogFileName(tt.name)
+		if sessionID != tt.sessionID || part != tt.part || ok != tt.ok {
+			t.Errorf("parseLogFileName(%q) = (%q, %d, %v), want (%q, %d, %v)",
+				tt.name, sessionID, part, ok, tt.sessionID, tt.part, tt.ok)
+		}
+	}
+
+	if name := sessionLogFileName("abc", 2); name != "abc.part2.jsonl" {
+		t.Errorf("sessionLogFileName(abc, 2) = %q", name)
+	}
+}
+
+func TestRetentionCompressesOldDirectories(t *testing.T) {
+	logDir := t.TempDir()
+	old := writeTestLog(t, logDir, "api.anthropic.com", "2026-03-10", "old-session.jsonl", `{"type":"session_start"}`+"\n")
+	recent := writeTestLog(t, logDir, "api.anthropic.com", "2026-03-19", "new-session.jsonl", `{"type":"session_start"}`+"\n")
+
+	r := newTestRetention(RetentionConfig{CompressAfterDays: 7, Compression: "gzip"}, logDir, nil)
+	stats, err := r.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	if stats.Compressed != 1 {
+		t.Errorf("Expected 1 compressed file, got %d", stats.Compressed)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Expected uncompressed original to be removed")
+	}
+	data, err := readLogFile(old + ".gz")
+	if err != nil || string(data) != `{"type":"session_start"}`+"\n" {
+		t.Errorf("Expected compressed file to round-trip, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("Expected recent log to stay uncompressed")
+	}
+
+	// A second sweep leaves compressed files alone
+	if stats, _ := r.Sweep(); stats.Compressed != 0 {
+		t.Errorf("Expected nothing to compress on second sweep, got %d", stats.Compressed)
+	}
+}
+
+func TestRetentionDeletesByAge(t *testing.T) {
+	logDir := t.TempDir()
+	writeTestLog(t, logDir, "api.openai.com", "2026-01-01", "ancient.jsonl.gz", "x")
+	keep := writeTestLog(t, logDir, "api.openai.com", "2026-03-15", "keep.jsonl", "x")
+	other := writeTestLog(t, logDir, "api.openai.com", "not-a-date", "other.jsonl", "x")
+
+	r := newTestRetention(RetentionConfig{MaxAgeDays: 30}, logDir, nil)
+	stats, err := r.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	if stats.Deleted != 1 {
+		t.Errorf("Expected 1 deleted file, got %d", stats.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "api.openai.com", "2026-01-01")); !os.IsNotExist(err) {
+		t.Error("Expected emptied date directory to be removed")
+	}
+	for _, path := range []string{keep, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept", path)
+		}
+	}
+}
+
+func TestRetentionEnforcesTotalQuota(t *testing.T) {
+	logDir := t.TempDir()
+	mb := strings.Repeat("x", 1<<20)
+	oldest := writeTestLog(t, logDir, "api.anthropic.com", "2026-03-17", "a.jsonl", mb)
+	middle := writeTestLog(t, logDir, "api.anthropic.com", "2026-03-18", "b.jsonl", mb)
+	newest := writeTestLog(t, logDir, "api.anthropic.com", "2026-03-19", "c.jsonl", mb)
+
+	r := newTestRetention(RetentionConfig{MaxTotalMB: 2}, logDir, nil)
+	stats, err := r.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	if stats.Deleted != 1 || stats.BytesFreed != 1<<20 {
+		t.Errorf("Expected one 1MB file deleted, got %+v", stats)
+	}
+	if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+		t.Error("Expected oldest log to be deleted first")
+	}
+	for _, path := range []string{middle, newest} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept", path)
+		}
+	}
+}
+
+func TestRetentionSkipsFilesInUse(t *testing.T) {
+	logDir := t.TempDir()
+	logger, _ := NewLogger(logDir)
+	defer logger.Close()
+
+	logger.LogSessionStart("active-session", "anthropic", "api.anthropic.com")
+	matches, _ := filepath.Glob(filepath.Join(logDir, "api.anthropic.com", "*", "active-session.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one session log, got %v", matches)
+	}
+
+	// Pretend the log is ancient; the logger still holds it open
+	r := NewRetentionManager(RetentionConfig{MaxAgeDays: 1}, logDir, logger)
+	r.now = func() time.Time { return time.Now().AddDate(0, 0, 10) }
+	stats, err := r.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	if stats.Deleted != 0 || stats.SkippedInUse != 1 {
+		t.Errorf("Expected the open file to be skipped, got %+v", stats)
+	}
+	if _, err := os.Stat(matches[0]); err != nil {
+		t.Error("Expected in-use log to be kept")
+	}
+}
+
+func TestLoggerSplitsLargeSessionFiles(t *testing.T) {
+	logDir := t.TempDir()
+	logger, _ := NewLogger(logDir)
+	defer logger.Close()
+	logger.SetMaxFileSize(200)
+
+	logger.LogSessionStart("big-session", "anthropic", "api.anthropic.com")
+	for seq := 1; seq <= 3; seq++ {
+		logger.LogRequest("big-session", "anthropic", seq, "POST", "/v1/messages", nil, []byte(`{"messages":[]}`), "req")
+	}
+	logger.Close()
+
+	var paths []string
+	walkSessionLogs(logDir, func(session sessionLog) error {
+		if session.SessionID == "big-session" {
+			paths = session.Paths
+		}
+		return nil
+	})
+	if len(paths) < 2 {
+		t.Fatalf("Expected the session to be split into parts, got %v", paths)
+	}
+	if !strings.HasSuffix(paths[1], "big-session.part2.jsonl") {
+		t.Errorf("Expected second part named big-session.part2.jsonl, got %s", paths[1])
+	}
+
+	explorer := NewExplorer(logDir)
+	entries, err := explorer.parseSessionFiles(explorer.findSessionFiles("big-session"))
+	if err != nil {
+		t.Fatalf("parseSessionFiles failed: %v", err)
+	}
+	if len(entries) != 4 || entries[0].Type != "session_start" || entries[3].Seq != 3 {
+		t.Errorf("Expected all 4 entries in order across parts, got %+v", entries)
+	}
+}
+
+func TestLoggerContinuesPartsAfterRelease(t *testing.T) {
+	logDir := t.TempDir()
+	logger, _ := NewLogger(logDir)
+	defer logger.Close()
+
+	logger.LogSessionStart("long-session", "anthropic", "api.anthropic.com")
+	matches, _ := filepath.Glob(filepath.Join(logDir, "api.anthropic.com", "*", "long-session.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one session log, got %v", matches)
+	}
+	dir := filepath.Dir(matches[0])
+
+	// Retention claims and compresses the idle first part
+	if !logger.claimIdleFile(matches[0], 0) {
+		t.Fatal("Expected the idle file to be claimed")
+	}
+	if _, err := gzipFile(matches[0], matches[0]+compressedLogExt); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(matches[0])
+	logger.unclaimFile(matches[0])
+
+	logger.LogRequest("long-session", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{}`), "req")
+	if _, err := os.Stat(filepath.Join(dir, "long-session.part2.jsonl")); err != nil {
+		t.Errorf("Expected the session to continue in part 2 of its own directory: %v", err)
+	}
+	if _, err := os.Stat(matches[0]); err == nil {
+		t.Error("Expected part 1 not to be recreated next to its compressed copy")
+	}
+}
+
+func TestRetentionKeepsWritesDuringCompression(t *testing.T) {
+	logDir := t.TempDir()
+	logger, _ := NewLogger(logDir)
+	defer logger.Close()
+
+	logger.LogSessionStart("busy-session", "anthropic", "api.anthropic.com")
+	logger.mu.Lock()
+	logger.lastWrite["busy-session"] = time.Now().Add(-2 * retentionIdleTimeout)
+	logger.mu.Unlock()
+
+	// The session writes again while its first part is being compressed
+	orig := compressLog
+	compressLog = func(src, dst string) (int64, error) {
+		if err := logger.LogRequest("busy-session", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{}`), "req"); err != nil {
+			t.Errorf("LogRequest during compression failed: %v", err)
+		}
+		return orig(src, dst)
+	}
+	defer func() { compressLog = orig }()
+
+	r := NewRetentionManager(RetentionConfig{CompressAfterDays: 1, Compression: "gzip"}, logDir, logger)
+	r.now = func() time.Time { return time.Now().AddDate(0, 0, 10) }
+	stats, err := r.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if stats.Compressed != 1 {
+		t.Fatalf("Expected the first part to be compressed, got %+v", stats)
+	}
+	logger.LogRequest("busy-session", "anthropic", 2, "POST", "/v1/messages", nil, []byte(`{}`), "req")
+
+	explorer := NewExplorer(logDir)
+	entries, err := explorer.parseSessionFiles(explorer.findSessionFiles("busy-session"))
+	if err != nil {
+		t.Fatalf("parseSessionFiles failed: %v", err)
+	}
+	if len(entries) != 3 || entries[0].Type != "session_start" || entries[1].Seq != 1 || entries[2].Seq != 2 {
+		t.Errorf("Expected all 3 entries to survive compression, got %+v", entries)
+	}
+}
+
+func TestLoggerPrunesReleasedSessions(t *testing.T) {
+	logDir := t.TempDir()
+	logger, _ := NewLogger(logDir)
+	defer logger.Close()
+
+	logger.RegisterIdentity("idle-session", "alice")
+	logger.LogSessionStart("idle-session", "anthropic", "api.anthropic.com")
+	matches, _ := filepath.Glob(filepath.Join(logDir, "api.anthropic.com", "*", "idle-session.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one session log, got %v", matches)
+	}
+	logger.mu.Lock()
+	logger.lastWrite["idle-session"] = time.Now().Add(-2 * retentionIdleTimeout)
+	logger.mu.Unlock()
+
+	if !logger.claimIdleFile(matches[0], retentionIdleTimeout) {
+		t.Fatal("Expected the idle file to be claimed")
+	}
+	logger.unclaimFile(matches[0])
+	logger.mu.Lock()
+	_, hasUpstream := logger.upstreams["idle-session"]
+	_, hasIdentity := logger.identities["idle-session"]
+	_, hasDir := logger.sessionDirs["idle-session"]
+	_, hasPart := logger.parts["idle-session"]
+	logger.mu.Unlock()
+	if hasUpstream || hasIdentity || hasDir || hasPart {
+		t.Errorf("Expected the released session's state to be pruned")
+	}
+
+	// The session picks up its own file again on the next write
+	logger.RegisterIdentity("idle-session", "alice")
+	if err := logger.LogRequest("idle-session", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{}`), "req"); err != nil {
+		t.Fatalf("LogRequest after pruning failed: %v", err)
+	}
+	data, _ := os.ReadFile(matches[0])
+	if n := strings.Count(string(data), "\n"); n != 2 || !strings.Contains(string(data), `"machine":"alice"`) {
+		t.Errorf("Expected both entries in the original file, got %s", data)
+	}
+
+	// Blobs removed by retention are forgotten
+	blobDir := filepath.Join(filepath.Dir(matches[0]), blobDirName)
+	os.MkdirAll(blobDir, 0755)
+	logger.mu.Lock()
+	logger.knownBlobs[blobPath(filepath.Dir(matches[0]), "abc")] = true
+	logger.mu.Unlock()
+	os.Remove(matches[0])
+	r := NewRetentionManager(RetentionConfig{}, logDir, logger)
+	var stats RetentionStats
+	if !r.removeBlobs(retentionFile{path: blobDir, blobs: true}, &stats) {
+		t.Fatal("Expected the blob store to be removed")
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.knownBlobs) != 0 {
+		t.Errorf("Expected removed blobs to be forgotten, got %v", logger.knownBlobs)
+	}
+}
+
+func TestLoggerConcurrentSplitsLoseNoEntries(t *testing.T) {
+	logDir := t.TempDir()
+	logger, _ := NewLogger(logDir)
+	logger.SetMaxFileSize(300)
+
+	logger.LogSessionStart("busy-session", "anthropic", "api.anthropic.com")
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				logger.LogRequest("busy-session", "anthropic", w*100+i, "POST", "/v1/messages", nil, []byte(`{"messages":[]}`), "req")
+			}
+		}(w)
+	}
+	wg.Wait()
+	logger.Close()
+
+	explorer := NewExplorer(logDir)
+	entries, err := explorer.parseSessionFiles(explorer.findSessionFiles("busy-session"))
+	if err != nil {
+		t.Fatalf("parseSessionFiles failed: %v", err)
+	}
+	if len(entries) != 201 {
+		t.Errorf("Expected 201 entries across parts, got %d", len(entries))
+	}
+}
+
+func TestRetentionKeepsDotDirectories(t *testing.T) {
+	logDir := t.TempDir()
+	for _, name := range []string{".loki-spool", ".webhook-queue", "notes"} {
+		os.MkdirAll(filepath.Join(logDir, name), 0700)
+	}
+	writeTestLog(t, logDir, "api.anthropic.com", "2026-01-01", "old.jsonl", "{}\n")
+
+	r := newTestRetention(RetentionConfig{MaxAgeDays: 30}, logDir, nil)
+	if _, err := r.Sweep(); err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	for _, name := range []string{".loki-spool", ".webhook-queue", "notes"} {
+		if _, err := os.Stat(filepath.Join(logDir, name)); err != nil {
+			t.Errorf("Expected empty %s to be kept: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "api.anthropic.com")); !os.IsNotExist(err) {
+		t.Error("Expected the emptied upstream directory to be removed")
+	}
+}
+
+func TestRetentionConfigValidate(t *testing.T) {
+	if err := (RetentionConfig{Compression: "gzip", MaxAgeDays: 30}).Validate(); err != nil {
+		t.Errorf("Expected gzip config to be valid: %v", err)
+	}
+	if err := (RetentionConfig{Compression: "zstd"}).Validate(); err == nil || !strings.Contains(err.Error(), "zstd") {
+		t.Errorf("Expected zstd to be rejected with a clear error, got %v", err)
+	}
+	if err := (RetentionConfig{Compression: "lz4"}).Validate(); err == nil {
+		t.Error("Expected unknown compression to be rejected")
+	}
+	if err := (RetentionConfig{SweepInterval: "soon"}).Validate(); err == nil {
+		t.Error("Expected bad sweep_interval to be rejected")
+	}
+	if (RetentionConfig{MaxSessionFileMB: 64}).Enabled() {
+		t.Error("Expected file splitting alone not to start the sweeper")
+	}
+}
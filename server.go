@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"path/filepath"
+	"slices"
 	"sync/atomic"
 	"time"
 )
@@ -14,16 +16,91 @@ type Server struct {
 	proxy          *Proxy
 	fileLogger     *Logger
 	lokiExporter   *LokiExporter
+	otlpExporter   *OTLPExporter
 	multiWriter    *MultiWriter
 	sessionManager *SessionManager
+	retention      *RetentionManager
+	forward        *ForwardProxy // nil unless forward-proxy mode is enabled
+}
+
+// enabledBuiltinSinks lists the built-in sinks whose sections are enabled.
+func enabledBuiltinSinks(cfg Config) []string {
+	enabled := []string{"file"}
+	if cfg.Loki.Enabled {
+		enabled = append(enabled, "loki")
+	}
+	if cfg.OTLP.Enabled {
+		enabled = append(enabled, "otlp")
+	}
+	return enabled
 }
 
 func NewServer(cfg Config) (*Server, error) {
+	if err := cfg.Retention.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Webhook.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Budgets.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Anomaly.Validate(); err != nil {
+		return nil, err
+	}
+	redactor, err := NewRedactor(cfg.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	logCipher, err := LoadLogCipher(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateSinks(cfg.Sinks, enabledBuiltinSinks(cfg), logCipher != nil); err != nil {
+		return nil, err
+	}
+	proxyAuth, err := NewProxyAuth(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	upstreamClient, err := createUpstreamClient(cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Playback.Enabled {
+		playback, err := NewPlaybackTransport(cfg.Playback, cfg.LogDir, logCipher, upstreamClient.Transport)
+		if err != nil {
+			return nil, err
+		}
+		upstreamClient.Transport = playback
+		log.Printf("Playback: enabled (%d recordings, on_miss=%s)", playback.Len(), playback.onMiss)
+		if n := playback.Skipped(); n > 0 {
+			log.Printf("Playback: skipped %d redacted or unresolved exchanges", n)
+		}
+		if cfg.Redaction.Enabled && playback.onMiss == "record" {
+			log.Printf("Playback: warning: [redaction] is enabled, so exchanges recorded now are logged redacted and won't play back on later runs")
+		}
+	}
+	var localCA *LocalCA
+	if cfg.ForwardProxy.Enabled {
+		if localCA, err = cfg.ForwardProxy.LoadCA(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create file logger (primary)
 	fileLogger, err := NewLogger(cfg.LogDir)
 	if err != nil {
 		return nil, err
 	}
+	fileLogger.SetMaxFileSize(cfg.Retention.MaxSessionFileMB << 20)
+	fileLogger.SetDedup(cfg.Storage.Dedup)
+	fileLogger.SetCipher(logCipher)
+	if logCipher != nil {
+		log.Printf("Encryption at rest: enabled")
+	}
+	headerObfuscator := NewHeaderObfuscator(cfg.SensitiveHeaders)
+	fileLogger.SetHeaderObfuscator(headerObfuscator)
 
 	// Create LokiExporter if enabled and URL is set
 	var lokiExporter *LokiExporter
@@ -36,6 +113,14 @@ func NewServer(cfg Config) (*Server, error) {
 			UseGzip:     cfg.Loki.UseGzip,
 			Environment: cfg.Loki.Environment,
 		}
+		if cfg.Loki.Spool {
+			lokiCfg.SpoolDir = filepath.Join(cfg.LogDir, ".loki-spool")
+			if cfg.Loki.SpoolDir != "" {
+				lokiCfg.SpoolDir = expandHome(cfg.Loki.SpoolDir)
+			}
+			lokiCfg.SpoolMaxBytes = int64(cfg.Loki.SpoolMaxMB) << 20
+			lokiCfg.SpoolCipher = logCipher
+		}
 
 		// Parse batch wait duration
 		if cfg.Loki.BatchWaitStr != "" {
@@ -56,13 +141,41 @@ func NewServer(cfg Config) (*Server, error) {
 		log.Printf("WARNING: Loki enabled but URL is empty, continuing without Loki")
 	}
 
-	// Create MultiWriter wrapping both file logger and Loki exporter
-	// Pass nil interface (not typed nil) when Loki is not enabled
-	var lokiPusher LokiPusher
+	// Loki and OTLP are sinks; [[sinks]] may set their filters and policies
+	multiWriter := NewMultiWriter(fileLogger, nil)
+	builtins := make(map[string]Sink)
 	if lokiExporter != nil {
-		lokiPusher = lokiExporter
+		builtins["loki"] = pusherAdapter{lokiExporter}
+	}
+	otlpExporter := newOTLPExporterFromConfig(cfg.OTLP)
+	if otlpExporter != nil {
+		builtins["otlp"] = pusherAdapter{otlpExporter}
+		log.Printf("OTLP traces: enabled (%s)", cfg.OTLP.Endpoint)
 	}
-	multiWriter := NewMultiWriter(fileLogger, lokiPusher)
+	sinks, fileSink, err := OpenSinks(cfg.Sinks, builtins, logCipher)
+	if err != nil {
+		for _, sink := range builtins {
+			sink.Close()
+		}
+		multiWriter.Close()
+		return nil, err
+	}
+	for _, sink := range sinks {
+		multiWriter.AddSink(sink)
+		if _, builtin := sink.Sink.(pusherAdapter); !builtin {
+			log.Printf("Sink %s: enabled", sink.Name)
+		}
+	}
+	multiWriter.SetFileSink(fileSink)
+	if webhook := newWebhookNotifierFromConfig(cfg.Webhook, cfg.LogDir); webhook != nil {
+		// Session starts reach the notifier as log entries, the rest as events
+		webhookSink := pusherSink("webhook", webhook)
+		webhookSink.types = stringSet([]string{"session_start"})
+		multiWriter.AddSink(webhookSink)
+		log.Printf("Webhooks: enabled (%d urls)", len(cfg.Webhook.URLs))
+	}
+	multiWriter.SetRedactor(redactor)
+	multiWriter.SetHeaderObfuscator(headerObfuscator)
 
 	sessionManager, err := NewSessionManager(cfg.LogDir, fileLogger)
 	if err != nil {
@@ -77,11 +190,31 @@ func NewServer(cfg Config) (*Server, error) {
 	eventEmitter := multiWriter.EventEmitter()
 	machineID := multiWriter.MachineID()
 
+	// Pattern tracking runs on the event path, so the circuit breaker needs
+	// an emitter even when Loki is disabled
+	if eventEmitter == nil && cfg.Anomaly.CircuitBreaker {
+		eventEmitter = discardEventEmitter{}
+	}
+
 	proxy := NewProxyWithEventEmitter(multiWriter, sessionManager, eventEmitter, machineID)
+	proxy.client = upstreamClient
+	proxy.pricing = NewPriceTable(cfg.Pricing)
+	proxy.metrics = NewMetrics(proxy.pricing)
+	proxy.anomaly = cfg.Anomaly
+	proxy.auth = proxyAuth
+	if proxyAuth != nil {
+		log.Printf("Proxy auth: enabled (%d users)", len(cfg.Auth.Users))
+	}
+	if len(cfg.Upstreams) > 0 {
+		proxy.upstreams = NewUpstreamAllowlist(cfg.Upstreams)
+	}
+	if cfg.Budgets.Enabled() {
+		proxy.budget = NewBudgetEnforcer(cfg.Budgets, sessionManager)
+	}
 
 	// Initialize Bedrock if region is configured
 	if cfg.BedrockRegion != "" {
-		bedrock, bedrockErr := initBedrock(cfg.BedrockRegion)
+		bedrock, bedrockErr := initBedrock(cfg.BedrockRegion, cfg.Transport)
 		if bedrockErr != nil {
 			if lokiExporter != nil {
 				lokiExporter.Close()
@@ -100,32 +233,70 @@ func NewServer(cfg Config) (*Server, error) {
 		proxy:          proxy,
 		fileLogger:     fileLogger,
 		lokiExporter:   lokiExporter,
+		otlpExporter:   otlpExporter,
 		multiWriter:    multiWriter,
 		sessionManager: sessionManager,
 	}
+	if localCA != nil {
+		s.forward = NewForwardProxy(proxy, localCA)
+		certPath, _ := cfg.ForwardProxy.CAPaths()
+		log.Printf("Forward proxy: enabled (CA %s)", certPath)
+	}
+	if cfg.Retention.Enabled() {
+		s.retention = NewRetentionManager(cfg.Retention, cfg.LogDir, fileLogger)
+		s.retention.Start()
+	}
 	s.mux.HandleFunc("/health", s.handleHealth)
 	s.mux.HandleFunc("/health/loki", s.handleHealthLoki)
 	s.mux.HandleFunc("/health/bedrock", s.handleHealthBedrock)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
 	return s, nil
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Check if it's a known endpoint
-	if r.URL.Path == "/health" {
-		s.handleHealth(w, r)
+	if r.Method == http.MethodConnect {
+		if s.forward == nil {
+			http.Error(w, "forward proxy mode is not enabled", http.StatusMethodNotAllowed)
+			return
+		}
+		s.forward.ServeHTTP(w, r)
 		return
 	}
-	if r.URL.Path == "/health/loki" {
-		s.handleHealthLoki(w, r)
+
+	// Status endpoints left open answer before authentication; the rest need
+	// a token like any proxied request
+	if isStatusEndpoint(r.URL.Path) && s.statusEndpointPublic(r.URL.Path) {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+	r, ok := s.proxy.authenticate(w, r)
+	if !ok {
 		return
 	}
-	if r.URL.Path == "/health/bedrock" {
-		s.handleHealthBedrock(w, r)
+	if isStatusEndpoint(r.URL.Path) {
+		s.mux.ServeHTTP(w, r)
 		return
 	}
 
 	// Otherwise, proxy the request
-	s.proxy.ServeHTTP(w, r)
+	s.proxy.serveProxy(w, r)
+}
+
+// statusEndpoints are served by the proxy itself instead of being relayed.
+var statusEndpoints = []string{"/health", "/health/loki", "/health/bedrock", "/metrics"}
+
+func isStatusEndpoint(path string) bool {
+	return slices.Contains(statusEndpoints, path)
+}
+
+// statusEndpointPublic reports whether path may be served without a proxy
+// token: always without proxy auth or on a loopback listener, otherwise only
+// when listed in auth.public_endpoints.
+func (s *Server) statusEndpointPublic(path string) bool {
+	if s.proxy.auth == nil || isLoopbackHost(s.config.Listen) {
+		return true
+	}
+	return slices.Contains(s.config.Auth.PublicEndpoints, path)
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -138,6 +309,9 @@ type LokiHealthResponse struct {
 	Status         string  `json:"status"`
 	EntriesSent    *int64  `json:"entries_sent,omitempty"`
 	EntriesDropped *int64  `json:"entries_dropped,omitempty"`
+	SpoolBatches   *int64  `json:"spool_batches,omitempty"`
+	SpoolEntries   *int64  `json:"spool_entries,omitempty"`
+	SpoolBytes     *int64  `json:"spool_bytes,omitempty"`
 	LastError      *string `json:"last_error"`
 	LastErrorTime  *string `json:"last_error_time"`
 }
@@ -164,6 +338,15 @@ func (s *Server) handleHealthLoki(w http.ResponseWriter, r *http.Request) {
 			LastError:      nil,
 			LastErrorTime:  nil,
 		}
+		if s.lokiExporter.config.SpoolDir != "" {
+			response.SpoolBatches = &stats.SpoolBatches
+			response.SpoolEntries = &stats.SpoolEntries
+			response.SpoolBytes = &stats.SpoolBytes
+			if stats.SpoolEntries > 0 {
+				// Loki is or was unreachable; entries are waiting on disk
+				response.Status = "spooling"
+			}
+		}
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -193,8 +376,43 @@ func (s *Server) handleHealthBedrock(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleMetrics serves proxy, Bedrock and exporter metrics in the Prometheus
+// text exposition format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	s.proxy.metrics.WritePrometheus(w)
+
+	if s.proxy.bedrock != nil {
+		writeMetric(w, "llm_proxy_bedrock_requests_in_flight", "gauge", "Bedrock concurrency slots in use.", int64(len(s.proxy.bedrock.semaphore)))
+		writeMetric(w, "llm_proxy_bedrock_requests_max", "gauge", "Bedrock concurrency limit.", int64(cap(s.proxy.bedrock.semaphore)))
+		writeMetric(w, "llm_proxy_bedrock_decode_errors_total", "counter", "Bedrock responses that could not be decoded for logging.", atomic.LoadInt64(&s.proxy.bedrock.decodeErrors))
+	}
+
+	if s.lokiExporter != nil {
+		stats := s.lokiExporter.Stats()
+		writeMetric(w, "llm_proxy_loki_entries_sent_total", "counter", "Entries pushed to Loki.", stats.EntriesSent)
+		writeMetric(w, "llm_proxy_loki_entries_failed_total", "counter", "Entries that failed to push to Loki after retries.", stats.EntriesFailed)
+		writeMetric(w, "llm_proxy_loki_entries_dropped_total", "counter", "Entries dropped because the Loki buffer was full.", stats.EntriesDropped)
+		writeMetric(w, "llm_proxy_loki_batches_sent_total", "counter", "Batches pushed to Loki.", stats.BatchesSent)
+		writeMetric(w, "llm_proxy_loki_buffer_depth", "gauge", "Entries waiting in the Loki buffer.", int64(len(s.lokiExporter.entryChan)))
+		writeMetric(w, "llm_proxy_loki_spool_entries", "gauge", "Entries waiting in the Loki spool.", stats.SpoolEntries)
+		writeMetric(w, "llm_proxy_loki_spool_bytes", "gauge", "Size of the Loki spool.", stats.SpoolBytes)
+	}
+
+	if s.otlpExporter != nil {
+		stats := s.otlpExporter.Stats()
+		writeMetric(w, "llm_proxy_otlp_spans_sent_total", "counter", "Spans exported over OTLP.", stats.SpansSent)
+		writeMetric(w, "llm_proxy_otlp_spans_failed_total", "counter", "Spans that failed to export after retries.", stats.SpansFailed)
+		writeMetric(w, "llm_proxy_otlp_entries_dropped_total", "counter", "Entries dropped because the OTLP buffer was full.", stats.EntriesDropped)
+	}
+}
+
 func (s *Server) Close() error {
 	var err error
+	if s.retention != nil {
+		s.retention.Close()
+	}
 	if s.sessionManager != nil {
 		err = s.sessionManager.Close()
 	}
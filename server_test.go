@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -128,7 +131,7 @@ func TestNewServer_LokiInvalidURL(t *testing.T) {
 	// Capture log output
 	var logBuf bytes.Buffer
 	log.SetOutput(&logBuf)
-	defer log.SetOutput(nil)
+	defer log.SetOutput(os.Stderr)
 
 	cfg := Config{
 		Port:   12071,
@@ -330,3 +333,169 @@ func TestHealthBedrock_Disabled(t *testing.T) {
 		t.Errorf("expected status 'disabled', got %q", response["status"])
 	}
 }
+
+func TestNoRawTokensReachLogFiles(t *testing.T) {
+	secrets := map[string]string{
+		"X-Api-Key":            "sk-ant-REDACTED",
+		"Authorization":        "Bearer sk-proj-requestsecret0002",
+		"Openai-Organization":  "org-requestsecret0003",
+		"Chatgpt-Account-Id":   "acct-requestsecret0004",
+		"X-Amz-Security-Token": "FwoGZXIvYXdzEBYaDrequestsecret0005",
+		"Cookie":               "session=requestsecret0006",
+		"X-Gateway-Token":      "gw-requestsecret0007",
+	}
+	responseSecrets := map[string]string{
+		"Set-Cookie":          "__cf_bm=responsesecret0008; path=/",
+		"Openai-Organization": "org-responsesecret0009",
+		"X-Gateway-Token":     "gw-responsesecret0010",
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range responseSecrets {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	tmpDir := t.TempDir()
+	srv, err := NewServer(Config{Port: 12071, LogDir: tmpDir, SensitiveHeaders: []string{"X-Gateway-Token"}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(`{"messages":[{"role":"user","content":"hi"}]}`))
+	for name, value := range secrets {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	srv.Close()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var logged bytes.Buffer
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, "*", "*", "*.jsonl"))
+	if len(matches) == 0 {
+		t.Fatal("expected session logs to be written")
+	}
+	for _, path := range matches {
+		data, _ := os.ReadFile(path)
+		logged.Write(data)
+	}
+
+	for _, set := range []map[string]string{secrets, responseSecrets} {
+		for name, value := range set {
+			// Every secret embeds a "secretNNNN" marker
+			marker := value[strings.Index(value, "secret"):]
+			if idx := strings.IndexAny(marker, "; "); idx != -1 {
+				marker = marker[:idx]
+			}
+			if strings.Contains(logged.String(), marker) {
+				t.Errorf("raw %s value %q reached a .jsonl file", name, value)
+			}
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_123","model":"claude-sonnet-4","usage":{"input_tokens":12,"output_tokens":3}}`))
+	}))
+	defer upstream.Close()
+
+	srv, err := NewServer(Config{
+		LogDir: t.TempDir(),
+		Loki:   LokiConfig{Enabled: true, URL: "http://loki:3100/loki/api/v1/push"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	reqPath := "/anthropic/" + strings.TrimPrefix(upstream.URL, "http://") + "/v1/messages"
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", reqPath, strings.NewReader(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hi"}]}`)))
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected text exposition format, got %q", w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`llm_proxy_requests_total{provider="anthropic",model="claude-sonnet-4",status="200"} 1`,
+		`llm_proxy_tokens_total{provider="anthropic",model="claude-sonnet-4",type="input"} 12`,
+		"llm_proxy_loki_entries_dropped_total 0",
+		"# TYPE llm_proxy_loki_buffer_depth gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in /metrics output:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "llm_proxy_bedrock_") {
+		t.Error("expected no Bedrock metrics when Bedrock is disabled")
+	}
+}
+
+func TestMetricsCountFailuresAndOtherEndpoints(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	srv, err := NewServer(Config{LogDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	// A non-conversation endpoint, then an upstream that is gone
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/anthropic/"+upstreamHost+"/v1/models", nil))
+	upstream.Close()
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4","messages":[]}`)))
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 from a closed upstream, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`llm_proxy_requests_total{provider="anthropic",model="other",status="404"} 1`,
+		`llm_proxy_requests_total{provider="anthropic",model="claude-sonnet-4",status="502"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in /metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestNewServer_InvalidSink(t *testing.T) {
+	_, err := NewServer(Config{LogDir: t.TempDir(), Sinks: []SinkConfig{{Name: "k", Type: "kafka"}}})
+	if err == nil || !strings.Contains(err.Error(), "unknown type") {
+		t.Errorf("expected an unknown sink type error, got %v", err)
+	}
+}
+
+func TestNewServer_SinksNeedTheirDestination(t *testing.T) {
+	_, err := NewServer(Config{LogDir: t.TempDir(), Sinks: []SinkConfig{{Name: "loki", Type: "loki"}}})
+	if err == nil || !strings.Contains(err.Error(), "[loki] is not enabled") {
+		t.Errorf("expected a disabled loki error, got %v", err)
+	}
+
+	t.Setenv(EncryptionKeyEnv, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	_, err = NewServer(Config{LogDir: t.TempDir(), Sinks: []SinkConfig{{Name: "console", Type: "stdout"}}})
+	if err == nil || !strings.Contains(err.Error(), "can't be encrypted") {
+		t.Errorf("expected stdout to be refused with encryption, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -9,6 +10,10 @@ import (
 	"time"
 )
 
+// ErrSessionOwner is returned when a request names a client session ID that
+// belongs to another authenticated user.
+var ErrSessionOwner = errors.New("session belongs to another user")
+
 type SessionManager struct {
 	baseDir string
 	db      *SessionDB
@@ -36,15 +41,17 @@ func (sm *SessionManager) Close() error {
 }
 
 // GetOrCreateSession determines if this request continues an existing session or starts a new one.
+// owner is the authenticated user ("" with proxy auth off); a client session ID already owned by
+// someone else returns ErrSessionOwner instead of joining their session.
 // Returns: sessionID, sequence number, isNewSession, error
-func (sm *SessionManager) GetOrCreateSession(body []byte, provider, upstream string, headers http.Header, path string) (string, int, bool, error) {
+func (sm *SessionManager) GetOrCreateSession(body []byte, provider, upstream string, headers http.Header, path, owner string) (string, int, bool, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	// Check if the client provided a session ID (e.g., Claude Code via metadata.user_id)
 	clientSessionID := ExtractClientSessionID(body, provider, headers, path)
 	if clientSessionID != "" {
-		return sm.getOrCreateByClientSessionID(clientSessionID, provider, upstream)
+		return sm.getOrCreateByClientSessionID(clientSessionID, owner, provider, upstream)
 	}
 
 	// No client session ID - create a new session for this request.
@@ -53,13 +60,37 @@ func (sm *SessionManager) GetOrCreateSession(body []byte, provider, upstream str
 	return sm.createNewSession(provider, upstream)
 }
 
+// FindSession returns the existing session a request would continue, or ""
+// if it would start a new one. Unlike GetOrCreateSession it neither creates
+// a session nor advances its sequence, so a request can be vetted first.
+func (sm *SessionManager) FindSession(body []byte, provider string, headers http.Header, path, owner string) (string, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	clientSessionID := ExtractClientSessionID(body, provider, headers, path)
+	if clientSessionID == "" {
+		return "", nil
+	}
+	existingSession, existingOwner, err := sm.db.FindByClientSessionID(clientSessionID)
+	if err != nil {
+		return "", err
+	}
+	if existingSession != "" && existingOwner != owner {
+		return "", ErrSessionOwner
+	}
+	return existingSession, nil
+}
+
 // getOrCreateByClientSessionID handles session tracking when the client provides a session ID
-func (sm *SessionManager) getOrCreateByClientSessionID(clientSessionID, provider, upstream string) (string, int, bool, error) {
+func (sm *SessionManager) getOrCreateByClientSessionID(clientSessionID, owner, provider, upstream string) (string, int, bool, error) {
 	// Check if we've seen this client session ID before
-	existingSession, err := sm.db.FindByClientSessionID(clientSessionID)
+	existingSession, existingOwner, err := sm.db.FindByClientSessionID(clientSessionID)
 	if err != nil {
 		return "", 0, false, err
 	}
+	if existingSession != "" && existingOwner != owner {
+		return "", 0, false, ErrSessionOwner
+	}
 
 	if existingSession != "" {
 		// Continue existing session
@@ -76,7 +107,7 @@ func (sm *SessionManager) getOrCreateByClientSessionID(clientSessionID, provider
 	}
 
 	// New client session - create our own session ID but track the client's ID
-	return sm.createNewSessionWithClientID(clientSessionID, provider, upstream)
+	return sm.createNewSessionWithClientID(clientSessionID, owner, provider, upstream)
 }
 
 func (sm *SessionManager) createNewSession(provider, upstream string) (string, int, bool, error) {
@@ -99,7 +130,7 @@ func (sm *SessionManager) createNewSession(provider, upstream string) (string, i
 	return sessionID, 1, true, nil
 }
 
-func (sm *SessionManager) createNewSessionWithClientID(clientSessionID, provider, upstream string) (string, int, bool, error) {
+func (sm *SessionManager) createNewSessionWithClientID(clientSessionID, owner, provider, upstream string) (string, int, bool, error) {
 	sessionID := generateSessionID()
 	// New path structure: <upstream>/<YYYY-MM-DD>/<sessionID>.jsonl
 	dateStr := time.Now().Format("2006-01-02")
@@ -112,13 +143,43 @@ func (sm *SessionManager) createNewSessionWithClientID(clientSessionID, provider
 	}
 
 	// Create session in DB with client session ID
-	if err := sm.db.CreateSessionWithClientID(sessionID, clientSessionID, provider, upstream, filePath); err != nil {
+	if err := sm.db.CreateSessionWithClientID(sessionID, clientSessionID, owner, provider, upstream, filePath); err != nil {
 		return "", 0, false, err
 	}
 
 	return sessionID, 1, true, nil
 }
 
+// CreateReplaySession starts a new session for a request replayed from the
+// session replayOf. Replays never continue a session, even when the replayed
+// body carries a client session ID.
+func (sm *SessionManager) CreateReplaySession(replayOf, provider, upstream string) (string, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sessionID := generateSessionID()
+	dateStr := time.Now().Format("2006-01-02")
+	filePath := filepath.Join(upstream, dateStr, sessionID+".jsonl")
+
+	logDir := filepath.Join(sm.baseDir, upstream, dateStr)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return "", err
+	}
+
+	if err := sm.db.CreateReplaySession(sessionID, replayOf, provider, upstream, filePath); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// GetSession returns the provider and upstream a session was recorded against.
+func (sm *SessionManager) GetSession(sessionID string) (provider, upstream string, err error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	provider, upstream, _, err = sm.db.GetSession(sessionID)
+	return provider, upstream, err
+}
+
 func generateSessionID() string {
 	now := time.Now()
 	return now.Format("20060102-150405") + "-" + randomHex(4)
@@ -150,6 +211,34 @@ func (sm *SessionManager) UpdatePatternState(sessionID string, state *PatternSta
 	return sm.db.UpdatePatternState(sessionID, state)
 }
 
+// AddSessionCost adds cost (USD) to a session's running total.
+func (sm *SessionManager) AddSessionCost(sessionID string, cost float64) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.db.AddSessionCost(sessionID, cost)
+}
+
+// GetSessionCost returns a session's running cost total in USD.
+func (sm *SessionManager) GetSessionCost(sessionID string) (float64, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.db.GetSessionCost(sessionID)
+}
+
+// AddUsage adds one turn's usage to the running totals for a budget scope.
+func (sm *SessionManager) AddUsage(scope, key string, tokens int, cost float64) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.db.AddUsage(scope, key, tokens, cost)
+}
+
+// GetUsage returns the running totals for a budget scope.
+func (sm *SessionManager) GetUsage(scope, key string) (UsageTotals, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.db.GetUsage(scope, key)
+}
+
 // ComputePatterns updates pattern state based on response data.
 // firstToolName is the first tool_use in the response (empty if no tools).
 // Returns isRetry for use in turn_end event.
@@ -17,7 +17,7 @@ func TestSessionManagerNewSession(t *testing.T) {
 	// First message = new session
 	body := []byte(`{"messages":[{"role":"user","content":"hello"}]}`)
 
-	sessionID, seq, isNew, err := sm.GetOrCreateSession(body, "anthropic", "api.anthropic.com", nil, "/v1/messages")
+	sessionID, seq, isNew, err := sm.GetOrCreateSession(body, "anthropic", "api.anthropic.com", nil, "/v1/messages", "")
 	if err != nil {
 		t.Fatalf("Failed to get session: %v", err)
 	}
@@ -41,7 +41,7 @@ func TestSessionManagerContinuationWithClientSessionID(t *testing.T) {
 
 	// First request with client session ID (Anthropic format)
 	body1 := []byte(`{"messages":[{"role":"user","content":"hello"}],"metadata":{"user_id":"user_abc_session_test-session-123"}}`)
-	sessionID1, seq1, isNew1, _ := sm.GetOrCreateSession(body1, "anthropic", "api.anthropic.com", nil, "/v1/messages")
+	sessionID1, seq1, isNew1, _ := sm.GetOrCreateSession(body1, "anthropic", "api.anthropic.com", nil, "/v1/messages", "")
 
 	if !isNew1 {
 		t.Error("First request should create new session")
@@ -52,7 +52,7 @@ func TestSessionManagerContinuationWithClientSessionID(t *testing.T) {
 
 	// Second request with same client session ID continues the conversation
 	body2 := []byte(`{"messages":[{"role":"user","content":"hello"},{"role":"assistant","content":[{"type":"text","text":"hi"}]},{"role":"user","content":"how are you"}],"metadata":{"user_id":"user_abc_session_test-session-123"}}`)
-	sessionID2, seq2, isNew2, _ := sm.GetOrCreateSession(body2, "anthropic", "api.anthropic.com", nil, "/v1/messages")
+	sessionID2, seq2, isNew2, _ := sm.GetOrCreateSession(body2, "anthropic", "api.anthropic.com", nil, "/v1/messages", "")
 
 	if isNew2 {
 		t.Error("Continuation should not create new session")
@@ -73,7 +73,7 @@ func TestSessionManagerNoClientSessionIDCreatesNewSession(t *testing.T) {
 
 	// First request without client session ID
 	body1 := []byte(`{"messages":[{"role":"user","content":"hello"}]}`)
-	sessionID1, _, isNew1, _ := sm.GetOrCreateSession(body1, "anthropic", "api.anthropic.com", nil, "/v1/messages")
+	sessionID1, _, isNew1, _ := sm.GetOrCreateSession(body1, "anthropic", "api.anthropic.com", nil, "/v1/messages", "")
 
 	if !isNew1 {
 		t.Error("First request should create new session")
@@ -81,7 +81,7 @@ func TestSessionManagerNoClientSessionIDCreatesNewSession(t *testing.T) {
 
 	// Second request also without client session ID - should create NEW session (not merge)
 	body2 := []byte(`{"messages":[{"role":"user","content":"hello"},{"role":"assistant","content":"hi"},{"role":"user","content":"how are you"}]}`)
-	sessionID2, _, isNew2, _ := sm.GetOrCreateSession(body2, "anthropic", "api.anthropic.com", nil, "/v1/messages")
+	sessionID2, _, isNew2, _ := sm.GetOrCreateSession(body2, "anthropic", "api.anthropic.com", nil, "/v1/messages", "")
 
 	// Without client session ID, each request should get its own session
 	if !isNew2 {
@@ -100,11 +100,11 @@ func TestSessionManagerDifferentClientSessionIDs(t *testing.T) {
 
 	// Request with client session ID "session-A"
 	body1 := []byte(`{"messages":[{"role":"user","content":"hello"}],"metadata":{"user_id":"user_abc_session_session-A"}}`)
-	sessionID1, _, _, _ := sm.GetOrCreateSession(body1, "anthropic", "api.anthropic.com", nil, "/v1/messages")
+	sessionID1, _, _, _ := sm.GetOrCreateSession(body1, "anthropic", "api.anthropic.com", nil, "/v1/messages", "")
 
 	// Request with different client session ID "session-B"
 	body2 := []byte(`{"messages":[{"role":"user","content":"hello"}],"metadata":{"user_id":"user_abc_session_session-B"}}`)
-	sessionID2, _, isNew2, _ := sm.GetOrCreateSession(body2, "anthropic", "api.anthropic.com", nil, "/v1/messages")
+	sessionID2, _, isNew2, _ := sm.GetOrCreateSession(body2, "anthropic", "api.anthropic.com", nil, "/v1/messages", "")
 
 	// Different client session IDs should create different sessions
 	if !isNew2 {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -201,6 +202,64 @@ func FullSetup() error {
 	return nil
 }
 
+// SetupLocalCA creates the forward-proxy CA (if it doesn't exist yet) and
+// adds it to the system trust store. That needs root, so the trust commands
+// run through sudo (which asks for the password) unless we are root already.
+func SetupLocalCA(cfg ForwardProxyConfig) error {
+	if _, err := cfg.LoadCA(); err != nil {
+		return err
+	}
+	certPath, _ := cfg.CAPaths()
+	fmt.Printf("Forward proxy CA: %s\n", certPath)
+
+	cmds, err := caTrustCommands(runtime.GOOS, certPath, exec.LookPath)
+	if err != nil {
+		return err
+	}
+	for _, args := range cmds {
+		if os.Geteuid() != 0 {
+			args = append([]string{"sudo"}, args...)
+		}
+		fmt.Printf("  %s\n", strings.Join(args, " "))
+		if err := runTrustCommand(args); err != nil {
+			return fmt.Errorf("trusting the CA failed (%s): %w", strings.Join(args, " "), err)
+		}
+	}
+	fmt.Println("The CA was added to the system trust store.")
+	fmt.Printf("Node-based agents don't use it; set NODE_EXTRA_CA_CERTS=%s for them.\n", certPath)
+	return nil
+}
+
+// caTrustCommands returns the commands that add certPath to the system trust
+// store: update-ca-certificates on Debian-like systems, trust anchor on
+// Fedora-like ones, and the System keychain on macOS.
+func caTrustCommands(goos, certPath string, lookPath func(string) (string, error)) ([][]string, error) {
+	switch goos {
+	case "darwin":
+		return [][]string{{"security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", certPath}}, nil
+	case "linux":
+		if _, err := lookPath("update-ca-certificates"); err == nil {
+			return [][]string{
+				{"install", "-m", "0644", certPath, "/usr/local/share/ca-certificates/llm-proxy.crt"},
+				{"update-ca-certificates"},
+			}, nil
+		}
+		if _, err := lookPath("trust"); err == nil {
+			return [][]string{{"trust", "anchor", "--store", certPath}}, nil
+		}
+		return nil, fmt.Errorf("no update-ca-certificates or trust command found; add %s to the system trust store by hand", certPath)
+	}
+	return nil, fmt.Errorf("adding the CA to the trust store is not supported on %s; add %s by hand", goos, certPath)
+}
+
+// runTrustCommand runs one trust store command attached to the terminal, so
+// sudo can prompt. Tests replace it.
+var runTrustCommand = func(args []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
 // Status prints the current status of the LLM Proxy.
 // It checks if the proxy is running by reading the portfile and making a health check.
 func Status() {
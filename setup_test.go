@@ -2,8 +2,11 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -392,3 +395,66 @@ func TestFullSetup(t *testing.T) {
 		t.Error("zshrc not patched")
 	}
 }
+
+func TestCATrustCommands(t *testing.T) {
+	found := func(names ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			for _, n := range names {
+				if n == name {
+					return "/usr/sbin/" + name, nil
+				}
+			}
+			return "", errors.New("not found")
+		}
+	}
+
+	cmds, err := caTrustCommands("linux", "/ca.crt", found("update-ca-certificates", "trust"))
+	if err != nil || len(cmds) != 2 || cmds[0][3] != "/ca.crt" || cmds[1][0] != "update-ca-certificates" {
+		t.Errorf("unexpected Debian commands %v (%v)", cmds, err)
+	}
+	cmds, err = caTrustCommands("linux", "/ca.crt", found("trust"))
+	if err != nil || len(cmds) != 1 || strings.Join(cmds[0], " ") != "trust anchor --store /ca.crt" {
+		t.Errorf("unexpected Fedora commands %v (%v)", cmds, err)
+	}
+	cmds, err = caTrustCommands("darwin", "/ca.crt", found())
+	if err != nil || len(cmds) != 1 || cmds[0][0] != "security" || cmds[0][len(cmds[0])-1] != "/ca.crt" {
+		t.Errorf("unexpected macOS commands %v (%v)", cmds, err)
+	}
+	if _, err := caTrustCommands("linux", "/ca.crt", found()); err == nil {
+		t.Error("expected an error without a trust tool")
+	}
+	if _, err := caTrustCommands("windows", "/ca.crt", found()); err == nil {
+		t.Error("expected an error on unsupported platforms")
+	}
+}
+
+func TestSetupLocalCA_InstallsCA(t *testing.T) {
+	dir := t.TempDir()
+	cfg := ForwardProxyConfig{CACertFile: filepath.Join(dir, "ca.crt"), CAKeyFile: filepath.Join(dir, "ca.key")}
+
+	var ran [][]string
+	orig := runTrustCommand
+	runTrustCommand = func(args []string) error {
+		ran = append(ran, args)
+		return nil
+	}
+	defer func() { runTrustCommand = orig }()
+
+	if _, err := caTrustCommands(runtime.GOOS, cfg.CACertFile, exec.LookPath); err != nil {
+		t.Skipf("no trust store tool here: %v", err)
+	}
+	if err := SetupLocalCA(cfg); err != nil {
+		t.Fatalf("SetupLocalCA failed: %v", err)
+	}
+	if _, err := os.Stat(cfg.CACertFile); err != nil {
+		t.Fatalf("CA not created: %v", err)
+	}
+	if len(ran) == 0 || !strings.Contains(strings.Join(ran[0], " "), cfg.CACertFile) {
+		t.Errorf("expected the CA to be passed to the trust store, ran %v", ran)
+	}
+
+	runTrustCommand = func(args []string) error { return errors.New("sudo: a password is required") }
+	if err := SetupLocalCA(cfg); err == nil || !strings.Contains(err.Error(), "trusting the CA failed") {
+		t.Errorf("expected a trust failure to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+// sinks.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MultiWriter fans log entries out to named sinks. The session files, Loki
+// and OTLP are built-in sinks, configured in their own sections and always
+// registered when enabled; further sinks are added as [[sinks]] tables. A
+// [[sinks]] table whose type is "file", "loki" or "otlp" doesn't add a
+// destination but sets the filter and policy of that built-in one. Each sink
+// has a filter on provider and entry type, and a failure policy: errors from
+// a primary sink are returned to the caller, while secondary sinks are best
+// effort. The session files default to primary, everything else to
+// secondary.
+
+// Sink receives log entries. Write must not modify the entry, which is
+// shared between sinks.
+type Sink interface {
+	Write(entry map[string]interface{}, provider string) error
+	Close() error
+}
+
+// sinkEntryTypes are the entry types a sink can filter on.
+var sinkEntryTypes = []string{"session_start", "request", "response", "fork"}
+
+// builtinSinkTypes are the destinations configured in their own sections.
+var builtinSinkTypes = []string{"file", "loki", "otlp"}
+
+// sinkTypes maps a [[sinks]] type to its constructor. Constructors of sinks
+// that write files get the log cipher (nil without [encryption]) and must
+// seal what they write. New sink types register here.
+var sinkTypes = map[string]func(SinkConfig, *LogCipher) (Sink, error){
+	"stdout": func(SinkConfig, *LogCipher) (Sink, error) { return &jsonlSink{w: os.Stdout}, nil },
+	"jsonl":  newJSONLFileSink,
+}
+
+// SinkConfig configures one [[sinks]] entry.
+type SinkConfig struct {
+	Name      string   `toml:"name"`
+	Type      string   `toml:"type"`      // "stdout", "jsonl", or a built-in destination: "file", "loki", "otlp"
+	Policy    string   `toml:"policy"`    // "secondary" (default, best effort) or "primary" (errors fail request logging); "file" defaults to primary
+	Providers []string `toml:"providers"` // Only entries from these providers (default: all)
+	Types     []string `toml:"types"`     // Only these entry types: session_start, request, response, fork (default: all)
+	Path      string   `toml:"path"`      // jsonl: file to append entries to
+}
+
+// ValidateSinks checks sink names, types, policies and filters. enabled lists
+// the built-in destinations that are turned on, and encrypted reports whether
+// [encryption] is configured: stdout can't be encrypted, so it's refused.
+func ValidateSinks(cfgs []SinkConfig, enabled []string, encrypted bool) error {
+	seen := make(map[string]bool)
+	builtins := make(map[string]bool)
+	for i, c := range cfgs {
+		if c.Name == "" {
+			return fmt.Errorf("sinks[%d]: name is required", i)
+		}
+		reserved := c.Name == "webhook" || slices.Contains(builtinSinkTypes, c.Name)
+		if seen[c.Name] || (reserved && c.Name != c.Type) {
+			return fmt.Errorf("sinks: duplicate or reserved name %q", c.Name)
+		}
+		seen[c.Name] = true
+		if slices.Contains(builtinSinkTypes, c.Type) {
+			if builtins[c.Type] {
+				return fmt.Errorf("sink %q: only one %s sink is allowed", c.Name, c.Type)
+			}
+			builtins[c.Type] = true
+			if !slices.Contains(enabled, c.Type) {
+				return fmt.Errorf("sink %q: [%s] is not enabled", c.Name, c.Type)
+			}
+			if c.Path != "" {
+				return fmt.Errorf("sink %q: path only applies to jsonl sinks", c.Name)
+			}
+		} else if _, ok := sinkTypes[c.Type]; !ok {
+			return fmt.Errorf("sink %q: unknown type %q", c.Name, c.Type)
+		}
+		if c.Policy != "" && c.Policy != "primary" && c.Policy != "secondary" {
+			return fmt.Errorf("sink %q: invalid policy %q (valid: primary, secondary)", c.Name, c.Policy)
+		}
+		for _, t := range c.Types {
+			if !slices.Contains(sinkEntryTypes, t) {
+				return fmt.Errorf("sink %q: invalid type filter %q (valid: %s)", c.Name, t, strings.Join(sinkEntryTypes, ", "))
+			}
+		}
+		if c.Type == "jsonl" && c.Path == "" {
+			return fmt.Errorf("sink %q: path is required for jsonl sinks", c.Name)
+		}
+		if c.Type == "stdout" && encrypted {
+			return fmt.Errorf("sink %q: stdout sinks can't be encrypted; remove it or disable [encryption]", c.Name)
+		}
+	}
+	return nil
+}
+
+// ConfiguredSink is a sink with its name, filter and failure policy. The
+// session files have no Sink: MultiWriter writes them through its file
+// logger and only uses the filter and policy.
+type ConfiguredSink struct {
+	Name      string
+	Sink      Sink
+	Primary   bool
+	providers map[string]bool // nil = all
+	types     map[string]bool // nil = all
+}
+
+// OpenSinks validates and creates the configured sinks. builtins holds the
+// Loki and OTLP destinations that are running; each is returned with its
+// [[sinks]] filter and policy, or as a secondary sink receiving every entry
+// when it isn't listed. A listed built-in that isn't running (its exporter
+// failed to start) is skipped. The second result carries the filter and
+// policy for the session files, nil when they aren't listed. On error, sinks
+// already opened are closed.
+func OpenSinks(cfgs []SinkConfig, builtins map[string]Sink, c *LogCipher) ([]*ConfiguredSink, *ConfiguredSink, error) {
+	if err := ValidateSinks(cfgs, builtinSinkTypes, c != nil); err != nil {
+		return nil, nil, err
+	}
+	var sinks []*ConfiguredSink
+	var file *ConfiguredSink
+	var opened []Sink // closed on error; built-ins belong to the caller
+	configured := make(map[string]bool)
+	for _, sc := range cfgs {
+		configured[sc.Type] = true
+	}
+	for _, name := range builtinSinkTypes {
+		if sink, ok := builtins[name]; ok && !configured[name] {
+			sinks = append(sinks, &ConfiguredSink{Name: name, Sink: sink})
+		}
+	}
+	for _, sc := range cfgs {
+		cs := &ConfiguredSink{
+			Name:      sc.Name,
+			Primary:   sc.Policy == "primary" || (sc.Policy == "" && sc.Type == "file"),
+			providers: stringSet(sc.Providers),
+			types:     stringSet(sc.Types),
+		}
+		switch sc.Type {
+		case "file":
+			file = cs
+			continue
+		case "loki", "otlp":
+			sink, ok := builtins[sc.Type]
+			if !ok {
+				log.Printf("WARNING: sink %s: %s is not running, skipping", sc.Name, sc.Type)
+				continue
+			}
+			cs.Sink = sink
+		default:
+			sink, err := sinkTypes[sc.Type](sc, c)
+			if err != nil {
+				for _, s := range opened {
+					s.Close()
+				}
+				return nil, nil, fmt.Errorf("sink %q: %w", sc.Name, err)
+			}
+			cs.Sink = sink
+			opened = append(opened, sink)
+		}
+		sinks = append(sinks, cs)
+	}
+	return sinks, file, nil
+}
+
+// pusherSink registers a LokiPusher (Loki, OTLP) as a secondary sink that
+// receives every entry.
+func pusherSink(name string, p LokiPusher) *ConfiguredSink {
+	return &ConfiguredSink{Name: name, Sink: pusherAdapter{p}}
+}
+
+type pusherAdapter struct {
+	LokiPusher
+}
+
+func (a pusherAdapter) Write(entry map[string]interface{}, provider string) error {
+	a.Push(entry, provider)
+	return nil
+}
+
+func stringSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// accepts reports whether the sink's filter passes an entry.
+func (s *ConfiguredSink) accepts(entryType, provider string) bool {
+	if s.providers != nil && !s.providers[provider] {
+		return false
+	}
+	return s.types == nil || s.types[entryType]
+}
+
+// jsonlSink writes each entry as a JSON line, with the provider added.
+type jsonlSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer  // nil for stdout
+	cipher *LogCipher // nil = plaintext
+}
+
+func newJSONLFileSink(c SinkConfig, cipher *LogCipher) (Sink, error) {
+	path := expandHome(c.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{w: f, closer: f, cipher: cipher}, nil
+}
+
+func (s *jsonlSink) Write(entry map[string]interface{}, provider string) error {
+	line := make(map[string]interface{}, len(entry)+1)
+	for k, v := range entry {
+		line[k] = v
+	}
+	line["provider"] = provider
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = s.cipher.Seal(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *jsonlSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
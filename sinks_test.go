@@ -0,0 +1,206 @@
+// sinks_test.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingSink is a Sink whose writes always fail.
+type failingSink struct {
+	writes int
+}
+
+func (s *failingSink) Write(entry map[string]interface{}, provider string) error {
+	s.writes++
+	return errors.New("sink unavailable")
+}
+
+func (s *failingSink) Close() error { return nil }
+
+func TestValidateSinks(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  SinkConfig
+		want string
+	}{
+		{"missing name", SinkConfig{Type: "stdout"}, "name is required"},
+		{"reserved name", SinkConfig{Name: "loki", Type: "stdout"}, "reserved"},
+		{"unknown type", SinkConfig{Name: "k", Type: "kafka"}, "unknown type"},
+		{"bad policy", SinkConfig{Name: "s", Type: "stdout", Policy: "sometimes"}, "invalid policy"},
+		{"bad type filter", SinkConfig{Name: "s", Type: "stdout", Types: []string{"turn_end"}}, "invalid type filter"},
+		{"jsonl without path", SinkConfig{Name: "f", Type: "jsonl"}, "path is required"},
+		{"reserved name of another built-in", SinkConfig{Name: "otlp", Type: "file"}, "reserved"},
+		{"built-in not enabled", SinkConfig{Name: "loki", Type: "loki"}, "not enabled"},
+		{"path on built-in", SinkConfig{Name: "file", Type: "file", Path: "/tmp/x"}, "path only applies"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSinks([]SinkConfig{tt.cfg}, []string{"file"}, false)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if err := ValidateSinks([]SinkConfig{{Name: "a", Type: "stdout"}, {Name: "a", Type: "stdout"}}, nil, false); err == nil {
+		t.Error("expected duplicate names to be rejected")
+	}
+	if err := ValidateSinks([]SinkConfig{{Name: "file", Type: "file"}, {Name: "local", Type: "file"}}, builtinSinkTypes, false); err == nil || !strings.Contains(err.Error(), "only one") {
+		t.Errorf("expected a second file sink to be rejected, got %v", err)
+	}
+	if err := ValidateSinks([]SinkConfig{{Name: "console", Type: "stdout"}}, nil, true); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("expected stdout to be refused with encryption, got %v", err)
+	}
+	if err := ValidateSinks([]SinkConfig{{Name: "out", Type: "stdout", Policy: "primary", Types: []string{"request"}}}, nil, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestJSONLSink_FiltersAndWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sinks", "anthropic.jsonl")
+	sinks, _, err := OpenSinks([]SinkConfig{{
+		Name:      "audit",
+		Type:      "jsonl",
+		Path:      path,
+		Providers: []string{"anthropic"},
+		Types:     []string{"request"},
+	}}, nil, nil)
+	if err != nil {
+		t.Fatalf("OpenSinks failed: %v", err)
+	}
+
+	mw := NewMultiWriter(newMockFileLogger(), nil)
+	mw.AddSink(sinks[0])
+	mw.LogSessionStart("s1", "anthropic", "api.anthropic.com")
+	mw.LogRequest("s1", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{"model":"claude"}`), "req-1")
+	mw.LogRequest("s2", "openai", 1, "POST", "/v1/chat/completions", nil, []byte(`{}`), "req-2")
+	mw.LogResponse("s1", "anthropic", 1, 200, nil, []byte(`{}`), nil, ResponseTiming{}, "req-1", nil)
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading sink file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the anthropic request, got %d lines:\n%s", len(lines), data)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	if entry["type"] != "request" || entry["provider"] != "anthropic" || entry["body"] != `{"model":"claude"}` {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+}
+
+func TestMultiWriter_SinkFailurePolicy(t *testing.T) {
+	// Secondary sinks are best effort
+	secondary := &failingSink{}
+	mw := NewMultiWriter(newMockFileLogger(), nil)
+	mw.AddSink(&ConfiguredSink{Name: "secondary", Sink: secondary})
+	if err := mw.LogRequest("s1", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{}`), "req-1"); err != nil {
+		t.Errorf("expected secondary sink errors to be swallowed, got %v", err)
+	}
+	if secondary.writes != 1 {
+		t.Errorf("expected 1 write, got %d", secondary.writes)
+	}
+
+	// Primary sink errors are returned like file errors
+	mw = NewMultiWriter(newMockFileLogger(), nil)
+	mw.AddSink(&ConfiguredSink{Name: "primary", Sink: &failingSink{}, Primary: true})
+	err := mw.LogRequest("s1", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{}`), "req-1")
+	if err == nil || !strings.Contains(err.Error(), "sink primary") {
+		t.Errorf("expected the primary sink error, got %v", err)
+	}
+}
+
+func TestJSONLSink_SealsLinesWhenEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cipher := testLogCipher(t, 7)
+	sinks, _, err := OpenSinks([]SinkConfig{{Name: "audit", Type: "jsonl", Path: path}}, nil, cipher)
+	if err != nil {
+		t.Fatalf("OpenSinks failed: %v", err)
+	}
+	if err := sinks[0].Sink.Write(map[string]interface{}{"type": "request", "body": "secret prompt"}, "anthropic"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	sinks[0].Sink.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading sink file: %v", err)
+	}
+	if strings.Contains(string(data), "secret prompt") {
+		t.Fatalf("sink file holds plaintext: %s", data)
+	}
+	line, err := cipher.Open([]byte(strings.TrimSpace(string(data))))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(line, &entry); err != nil || entry["body"] != "secret prompt" {
+		t.Errorf("unexpected entry %s (%v)", line, err)
+	}
+}
+
+func TestOpenSinks_ConfiguresBuiltins(t *testing.T) {
+	var closeOrder []string
+	loki := pusherAdapter{newMockLokiExporter(&closeOrder)}
+	otlp := pusherAdapter{newMockLokiExporter(&closeOrder)}
+
+	// Unlisted built-ins are secondary sinks receiving every entry
+	sinks, file, err := OpenSinks(nil, map[string]Sink{"loki": loki, "otlp": otlp}, nil)
+	if err != nil {
+		t.Fatalf("OpenSinks failed: %v", err)
+	}
+	if file != nil || len(sinks) != 2 || sinks[0].Name != "loki" || sinks[0].Primary || sinks[0].types != nil {
+		t.Fatalf("unexpected default sinks %+v (file %+v)", sinks, file)
+	}
+
+	sinks, file, err = OpenSinks([]SinkConfig{
+		{Name: "central", Type: "loki", Types: []string{"response"}, Policy: "primary"},
+		{Name: "file", Type: "file", Providers: []string{"anthropic"}},
+		{Name: "traces", Type: "otlp"},
+	}, map[string]Sink{"loki": loki}, nil)
+	if err != nil {
+		t.Fatalf("OpenSinks failed: %v", err)
+	}
+	if len(sinks) != 1 || sinks[0].Name != "central" || sinks[0].Sink != loki || !sinks[0].Primary {
+		t.Fatalf("expected only the configured loki sink (otlp isn't running), got %+v", sinks)
+	}
+	if sinks[0].accepts("request", "anthropic") || !sinks[0].accepts("response", "anthropic") {
+		t.Error("expected the loki sink to take responses only")
+	}
+	if file == nil || !file.Primary || file.accepts("request", "openai") || !file.accepts("request", "anthropic") {
+		t.Errorf("unexpected file sink %+v", file)
+	}
+}
+
+func TestMultiWriter_FileSinkFilterAndPolicy(t *testing.T) {
+	fileLogger := newMockFileLogger()
+	mw := NewMultiWriter(fileLogger, nil)
+	mw.SetFileSink(&ConfiguredSink{Name: "file", types: stringSet([]string{"request"})})
+
+	mw.LogSessionStart("s1", "anthropic", "api.anthropic.com")
+	fileLogger.requestError = errors.New("disk full")
+	if err := mw.LogRequest("s1", "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{}`), "req-1"); err != nil {
+		t.Errorf("expected a secondary file sink to swallow errors, got %v", err)
+	}
+	if len(fileLogger.sessionStartCalls) != 0 || len(fileLogger.requestCalls) != 1 {
+		t.Errorf("expected only the request in the file, got %d session starts and %d requests",
+			len(fileLogger.sessionStartCalls), len(fileLogger.requestCalls))
+	}
+
+	mw.SetFileSink(&ConfiguredSink{Name: "file", Primary: true})
+	if err := mw.LogRequest("s1", "anthropic", 2, "POST", "/v1/messages", nil, []byte(`{}`), "req-2"); err == nil {
+		t.Error("expected a primary file sink to return errors")
+	}
+}
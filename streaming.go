@@ -21,10 +21,14 @@ func isStreamingRequest(body []byte) bool {
 	return req.Stream
 }
 
-// isStreamingResponse checks if the response is SSE
+// isStreamingResponse checks if the response is SSE, or a Gemini
+// streamGenerateContent response, which streams a JSON array unless alt=sse is set
 func isStreamingResponse(resp *http.Response) bool {
 	contentType := resp.Header.Get("Content-Type")
-	return strings.HasPrefix(contentType, "text/event-stream")
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return true
+	}
+	return resp.Request != nil && strings.HasSuffix(resp.Request.URL.Path, ":streamGenerateContent")
 }
 
 // StreamingResponseWriter wraps http.ResponseWriter to capture chunks and accumulate text
@@ -81,56 +85,20 @@ func (s *StreamingResponseWriter) AccumulatedText() string {
 	return s.accumulatedText.String()
 }
 
-// extractDeltaText extracts text content from SSE delta events (provider-aware)
+// extractDeltaText extracts text content from streamed delta events (provider-aware)
 func extractDeltaText(data []byte, provider string) string {
-	line := string(data)
-
-	// SSE format: "data: {...}\n"
-	if !strings.HasPrefix(line, "data: ") {
+	p := LookupProvider(provider)
+	if p == nil {
 		return ""
 	}
-
-	jsonStr := strings.TrimPrefix(line, "data: ")
-	jsonStr = strings.TrimSpace(jsonStr)
-
-	if jsonStr == "[DONE]" || jsonStr == "" {
-		return ""
-	}
-
-	var event map[string]interface{}
-	if err := json.Unmarshal([]byte(jsonStr), &event); err != nil {
-		return ""
-	}
-
-	if provider == "anthropic" {
-		// Anthropic: {"type":"content_block_delta","delta":{"type":"text_delta","text":"..."}}
-		if event["type"] != "content_block_delta" {
-			return ""
-		}
-		if delta, ok := event["delta"].(map[string]interface{}); ok {
-			if text, ok := delta["text"].(string); ok {
-				return text
-			}
-		}
-	} else if provider == "openai" {
-		// OpenAI: {"choices":[{"delta":{"content":"..."}}]}
-		if choices, ok := event["choices"].([]interface{}); ok && len(choices) > 0 {
-			if choice, ok := choices[0].(map[string]interface{}); ok {
-				if delta, ok := choice["delta"].(map[string]interface{}); ok {
-					if content, ok := delta["content"].(string); ok {
-						return content
-					}
-				}
-			}
-		}
-	}
-
-	return ""
+	return p.DeltaText(data)
 }
 
 // streamResponse handles streaming responses from upstream
-func streamResponse(w http.ResponseWriter, resp *http.Response, logger ProxyLogger, sm *SessionManager, sessionID, provider string, seq int, startTime time.Time, reqBody []byte, requestID string, emitter AgentEventEmitter, machineID string, patternState *PatternState) error {
+func streamResponse(w http.ResponseWriter, resp *http.Response, logger ProxyLogger, sm *SessionManager, sessionID, provider string, seq int, startTime time.Time, reqBody []byte, requestID string, emitter AgentEventEmitter, machineID string, patternState *PatternState, pricing *PriceTable, budget *BudgetEnforcer, metrics *Metrics, anomaly AnomalyConfig) error {
 	sw := NewStreamingResponseWriter(w, provider)
+	metrics.StreamStarted()
+	defer metrics.StreamEnded()
 
 	// Copy headers
 	copyHeaders(w.Header(), resp.Header)
@@ -152,26 +120,31 @@ func streamResponse(w http.ResponseWriter, resp *http.Response, logger ProxyLogg
 		}
 	}
 
-	// Log the complete streaming response
-	if logger != nil {
-		ttfb := int64(0)
-		if len(sw.chunks) > 0 {
-			ttfb = sw.chunks[0].DeltaMs
-		}
-		timing := ResponseTiming{
-			TTFBMs:  ttfb,
-			TotalMs: time.Since(startTime).Milliseconds(),
-		}
-		logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, nil, sw.chunks, timing, requestID)
-	}
+	// Parse the accumulated streaming response
+	parsed := LookupProvider(provider).ParseStream(sw.chunks)
 
-	// Emit agent observability events for streaming responses
-	if emitter != nil && patternState != nil && sm != nil {
-		// Parse the accumulated streaming response
-		parsed := ParseStreamingResponse(sw.chunks)
+	ttfb := int64(0)
+	if len(sw.chunks) > 0 {
+		ttfb = sw.chunks[0].DeltaMs
+	}
+	timing := ResponseTiming{
+		TTFBMs:  ttfb,
+		TotalMs: time.Since(startTime).Milliseconds(),
+	}
+	model := responseModel(extractRequestModel(reqBody), parsed)
+	metrics.ObserveResponse(provider, model, resp.StatusCode, timing, parsed.Usage)
 
-		// Use shared event emission logic
-		emitResponseEvents(emitter, sm, sessionID, provider, machineID, patternState, parsed.Content, parsed.Usage, parsed.StopReason, resp.StatusCode, "")
+	// Log the complete streaming response (conversation endpoints only)
+	if logger != nil {
+		cost := priceResponse(pricing, sm, sessionID, model, parsed.Usage)
+		budget.Record(sessionID, machineID, resp.StatusCode, parsed.Usage, cost)
+		logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, nil, sw.chunks, timing, requestID, cost)
+
+		// Emit agent observability events for streaming responses
+		if emitter != nil && patternState != nil && sm != nil {
+			// Use shared event emission logic
+			emitResponseEvents(emitter, sm, sessionID, provider, machineID, patternState, parsed.Content, parsed.Usage, cost, parsed.StopReason, resp.StatusCode, "", anomaly)
+		}
 	}
 
 	return nil
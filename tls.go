@@ -0,0 +1,158 @@
+// tls.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// TLSConfig configures TLS on the proxy's listener. The certificate is
+// re-read on SIGHUP, so it can be rotated without dropping connections.
+type TLSConfig struct {
+	CertFile string `toml:"cert_file"` // PEM certificate (chain)
+	KeyFile  string `toml:"key_file"`  // PEM private key
+}
+
+// Enabled reports whether the listener serves TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validate returns an error unless both or neither of the files are set.
+func (c TLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("tls: cert_file and key_file must be set together")
+	}
+	return nil
+}
+
+// certReloader serves the listener certificate and swaps it on Reload.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newCertReloader loads the certificate, failing if it can't be read.
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	r := &certReloader{
+		certFile: expandHome(cfg.CertFile),
+		keyFile:  expandHome(cfg.KeyFile),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the certificate and key. On error the previous
+// certificate stays in use.
+func (r *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ListenerTLSConfig returns the tls.Config for the listener and the reloader
+// that backs it. Returns nil, nil when TLS is not configured.
+func ListenerTLSConfig(cfg TLSConfig) (*tls.Config, *certReloader, error) {
+	if !cfg.Enabled() {
+		return nil, nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	reloader, err := newCertReloader(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, reloader, nil
+}
+
+// TransportConfig configures how the proxy connects to upstreams. It applies
+// to both the passthrough client and the Bedrock client.
+type TransportConfig struct {
+	CAFile         string `toml:"ca_file"`          // PEM bundle trusted in addition to the system roots
+	ClientCertFile string `toml:"client_cert_file"` // PEM client certificate for mTLS (upstreams and the egress proxy)
+	ClientKeyFile  string `toml:"client_key_file"`  // PEM client key
+	Proxy          string `toml:"proxy"`            // Egress proxy URL, or "env" for HTTPS_PROXY/HTTP_PROXY/NO_PROXY (default: direct)
+}
+
+// Apply configures t for the custom CA, client certificate and egress proxy.
+func (c TransportConfig) Apply(t *http.Transport) error {
+	if c.CAFile != "" || c.ClientCertFile != "" || c.ClientKeyFile != "" {
+		tlsCfg, err := c.tlsClientConfig()
+		if err != nil {
+			return err
+		}
+		t.TLSClientConfig = tlsCfg
+	}
+
+	switch c.Proxy {
+	case "":
+	case "env":
+		t.Proxy = http.ProxyFromEnvironment
+	default:
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("transport: invalid proxy URL %q", c.Proxy)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	return nil
+}
+
+func (c TransportConfig) tlsClientConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			log.Printf("WARNING: system CA pool unavailable, trusting only %s: %v", c.CAFile, err)
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(expandHome(c.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("transport: reading CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("transport: no certificates found in %s", c.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return nil, fmt.Errorf("transport: client_cert_file and client_key_file must be set together")
+	}
+	if c.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(expandHome(c.ClientCertFile), expandHome(c.ClientKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("transport: loading client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
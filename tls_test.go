@@ -0,0 +1,178 @@
+// tls_test.go
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for localhost and returns
+// the cert and key paths.
+func writeTestCert(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPath, keyPath
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "first")
+
+	tlsCfg, reloader, err := ListenerTLSConfig(TLSConfig{CertFile: certPath, KeyFile: keyPath})
+	if err != nil {
+		t.Fatalf("ListenerTLSConfig failed: %v", err)
+	}
+	cert, _ := tlsCfg.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "first" {
+		t.Fatalf("Expected first certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	// Rotate the files in place, as a cert manager would, then reload
+	newCert, newKey := writeTestCert(t, dir, "second")
+	os.Rename(newCert, certPath)
+	os.Rename(newKey, keyPath)
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	cert, _ = tlsCfg.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Errorf("Expected reloaded certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	// A broken file keeps the current certificate
+	os.WriteFile(certPath, []byte("garbage"), 0644)
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reload of a broken certificate to fail")
+	}
+	if current, _ := tlsCfg.GetCertificate(nil); current != cert {
+		t.Error("Expected the previous certificate to stay in use")
+	}
+}
+
+func TestListenerTLSConfigDisabledAndInvalid(t *testing.T) {
+	if tlsCfg, reloader, err := ListenerTLSConfig(TLSConfig{}); tlsCfg != nil || reloader != nil || err != nil {
+		t.Error("Expected no TLS without a certificate")
+	}
+	if _, _, err := ListenerTLSConfig(TLSConfig{CertFile: "cert.pem"}); err == nil {
+		t.Error("Expected cert_file without key_file to be rejected")
+	}
+	if _, _, err := ListenerTLSConfig(TLSConfig{CertFile: "missing.pem", KeyFile: "missing.key"}); err == nil {
+		t.Error("Expected missing files to be rejected")
+	}
+}
+
+func TestUpstreamClientCustomCAAndClientCert(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeTestCert(t, dir, "upstream")
+	clientCert, clientKey := writeTestCert(t, dir, "client")
+
+	serverPair, _ := tls.LoadX509KeyPair(serverCert, serverKey)
+	clientPEM, _ := os.ReadFile(clientCert)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientPEM)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	// System roots alone don't trust the upstream
+	plain, _ := createUpstreamClient(TransportConfig{})
+	if resp, err := plain.Get(upstream.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected the default client to reject the private CA")
+	}
+
+	client, err := createUpstreamClient(TransportConfig{CAFile: serverCert, ClientCertFile: clientCert, ClientKeyFile: clientKey})
+	if err != nil {
+		t.Fatalf("createUpstreamClient failed: %v", err)
+	}
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Expected the CA bundle and client cert to be used: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestTransportConfigProxy(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://api.anthropic.com/v1/messages", nil)
+
+	transport := &http.Transport{}
+	if err := (TransportConfig{Proxy: "http://egress.internal:3128"}).Apply(transport); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	proxyURL, err := transport.Proxy(req)
+	if err != nil || proxyURL == nil || proxyURL.Host != "egress.internal:3128" {
+		t.Errorf("Expected the egress proxy, got %v, %v", proxyURL, err)
+	}
+
+	transport = &http.Transport{}
+	(TransportConfig{}).Apply(transport)
+	if transport.Proxy != nil {
+		t.Error("Expected direct connections by default")
+	}
+
+	transport = &http.Transport{}
+	if err := (TransportConfig{Proxy: "env"}).Apply(transport); err != nil || transport.Proxy == nil {
+		t.Errorf("Expected the environment proxy to be used, got %v", err)
+	}
+
+	for _, cfg := range []TransportConfig{
+		{Proxy: "not a url"},
+		{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{ClientCertFile: "client.crt"},
+	} {
+		if err := cfg.Apply(&http.Transport{}); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// upstreams.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultAllowedUpstreams returns the built-in upstream allowlist, keyed by
+// provider: each registered provider's default upstreams plus Bedrock. Entries
+// are exact hosts or path.Match-style patterns ("*" matches any run of
+// characters, including dots), optionally with a port or ":*" for any port.
+// A port must be listed explicitly to match.
+func DefaultAllowedUpstreams() map[string][]string {
+	result := make(map[string][]string, len(builtinProviders)+1)
+	for _, p := range builtinProviders {
+		result[p.Name()] = append([]string(nil), p.DefaultUpstreams()...)
+	}
+	result["bedrock"] = []string{"bedrock-runtime.*.amazonaws.com"}
+	return result
+}
+
+// UpstreamAllowlist restricts which hosts the proxy forwards to, so it can't
+// be used as an open relay to arbitrary hosts.
+type UpstreamAllowlist struct {
+	patterns map[string][]upstreamPattern // provider -> host patterns
+}
+
+// upstreamPattern is an allowlist entry split into lowercased host and port
+// patterns. An empty port only matches upstreams without a port.
+type upstreamPattern struct {
+	host string
+	port string
+}
+
+func (p upstreamPattern) matches(host, port string) bool {
+	return globMatch(p.host, host) && (p.port == port || port != "" && globMatch(p.port, port))
+}
+
+func globMatch(pattern, s string) bool {
+	if pattern == s {
+		return true
+	}
+	matched, err := path.Match(pattern, s)
+	return err == nil && matched
+}
+
+// NewUpstreamAllowlist creates an allowlist from provider -> host patterns.
+func NewUpstreamAllowlist(allowed map[string][]string) *UpstreamAllowlist {
+	patterns := make(map[string][]upstreamPattern, len(allowed))
+	for provider, hosts := range allowed {
+		for _, host := range hosts {
+			patterns[provider] = append(patterns[provider], parseUpstreamPattern(host))
+		}
+	}
+	return &UpstreamAllowlist{patterns: patterns}
+}
+
+func parseUpstreamPattern(pattern string) upstreamPattern {
+	pattern = strings.ToLower(pattern)
+	var p upstreamPattern
+	if i := strings.LastIndex(pattern, ":"); i >= 0 && !strings.Contains(pattern[i:], "]") {
+		p.host, p.port = pattern[:i], pattern[i+1:]
+	} else {
+		p.host = pattern
+	}
+	if strings.HasPrefix(p.host, "[") && strings.HasSuffix(p.host, "]") {
+		p.host = p.host[1 : len(p.host)-1]
+	}
+	return p
+}
+
+// splitUpstream parses an upstream strictly as host[:port], with a numeric
+// port and IPv6 addresses in brackets. Anything else (userinfo, paths,
+// queries, escapes) is rejected, so the host that's checked against the
+// allowlist is the host that's dialed. The host is lowercased.
+func splitUpstream(upstream string) (host, port string, err error) {
+	invalid := fmt.Errorf("invalid upstream %q: expected host[:port]", upstream)
+
+	host = upstream
+	if i := strings.LastIndex(upstream, ":"); i >= 0 && !strings.Contains(upstream[i:], "]") {
+		host, port = upstream[:i], upstream[i+1:]
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || strconv.Itoa(n) != port {
+			return "", "", invalid
+		}
+	}
+
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return "", "", invalid
+		}
+		return strings.ToLower(host), port, nil
+	}
+	if host == "" {
+		return "", "", invalid
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return "", "", invalid
+		}
+	}
+	return strings.ToLower(host), port, nil
+}
+
+// Check returns an error describing why upstream is not allowed for provider,
+// or nil if it is. Upstreams that aren't a plain host[:port] are always
+// rejected; otherwise a nil allowlist allows everything.
+func (a *UpstreamAllowlist) Check(provider, upstream string) error {
+	host, port, err := splitUpstream(upstream)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return nil
+	}
+
+	for _, pattern := range a.patterns[provider] {
+		if pattern.matches(host, port) {
+			return nil
+		}
+	}
+
+	if len(a.patterns[provider]) == 0 {
+		return fmt.Errorf("no upstreams are allowed for provider %q", provider)
+	}
+	return fmt.Errorf("upstream %q is not in the %s allowlist", upstream, provider)
+}
+
+// ProviderFor returns the registered provider whose patterns allow upstream,
+// or "" if none does. Providers are tried in builtinProviders order, and
+// Bedrock is never returned. A nil allowlist falls back to the providers'
+// default upstreams.
+func (a *UpstreamAllowlist) ProviderFor(upstream string) string {
+	if a == nil {
+		if p := providerForHost(upstream); p != nil {
+			return p.Name()
+		}
+		return ""
+	}
+	for _, p := range builtinProviders {
+		if a.Check(p.Name(), upstream) == nil {
+			return p.Name()
+		}
+	}
+	return ""
+}
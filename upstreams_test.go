@@ -0,0 +1,203 @@
+// upstreams_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamAllowlistDefaults(t *testing.T) {
+	allowlist := NewUpstreamAllowlist(DefaultAllowedUpstreams())
+
+	tests := []struct {
+		provider string
+		upstream string
+		allowed  bool
+	}{
+		{"anthropic", "api.anthropic.com", true},
+		{"anthropic", "API.Anthropic.com", true},
+		{"openai", "api.openai.com", true},
+		{"openai", "chatgpt.com", true},
+		{"bedrock", "bedrock-runtime.us-east-1.amazonaws.com", true},
+		{"anthropic", "api.openai.com", false},
+		{"anthropic", "evil.example.com", false},
+		{"anthropic", "api.anthropic.com:8443", false},
+		{"anthropic", "api.anthropic.com.evil.com", false},
+		{"openai", "169.254.169.254", false},
+		{"bedrock", "bedrock-runtime.us-east-1.evil.com", false},
+		{"cohere", "api.cohere.com", false},
+		{"vllm", "localhost", true},
+		{"vllm", "localhost:8000", true},
+		{"vllm", "[::1]:8000", false},
+		{"vllm", "localhost:1@evil.example", false},
+		{"vllm", "localhost:1/@evil.example", false},
+		{"vllm", "localhost:1%40evil.example", false},
+		{"ollama", "127.0.0.1:11434#@evil.example", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.upstream, func(t *testing.T) {
+			err := allowlist.Check(tt.provider, tt.upstream)
+			if tt.allowed && err != nil {
+				t.Errorf("expected %s to be allowed, got %v", tt.upstream, err)
+			}
+			if !tt.allowed && err == nil {
+				t.Errorf("expected %s to be rejected", tt.upstream)
+			}
+		})
+	}
+}
+
+func TestUpstreamAllowlistPatterns(t *testing.T) {
+	allowlist := NewUpstreamAllowlist(map[string][]string{
+		"openai": {"*.openai.azure.com", "localhost:11434"},
+	})
+
+	if err := allowlist.Check("openai", "myorg.openai.azure.com"); err != nil {
+		t.Errorf("expected wildcard match, got %v", err)
+	}
+	if err := allowlist.Check("openai", "localhost:11434"); err != nil {
+		t.Errorf("expected host:port match, got %v", err)
+	}
+	if err := allowlist.Check("openai", "localhost:8080"); err == nil {
+		t.Error("expected other port to be rejected")
+	}
+
+	var nilAllowlist *UpstreamAllowlist
+	if err := nilAllowlist.Check("anthropic", "anything.example.com"); err != nil {
+		t.Errorf("expected nil allowlist to allow everything, got %v", err)
+	}
+}
+
+func TestLoadConfigFromTOML_UpstreamsSection(t *testing.T) {
+	tomlContent := `
+[upstreams]
+openai = ["api.openai.com", "chatgpt.com", "localhost:8000"]
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := strings.Join(cfg.Upstreams["openai"], ","); got != "api.openai.com,chatgpt.com,localhost:8000" {
+		t.Errorf("expected configured openai upstreams, got %q", got)
+	}
+	// Providers not mentioned keep their defaults
+	if got := strings.Join(cfg.Upstreams["anthropic"], ","); got != "api.anthropic.com" {
+		t.Errorf("expected default anthropic upstreams, got %q", got)
+	}
+}
+
+func TestProxyRejectsDisallowedUpstream(t *testing.T) {
+	upstreamCalled := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalled = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	proxy := NewProxy()
+	proxy.upstreams = NewUpstreamAllowlist(DefaultAllowedUpstreams())
+
+	req := httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+	if upstreamCalled {
+		t.Error("expected disallowed upstream not to be contacted")
+	}
+
+	// Allowing the host lets the request through
+	proxy.upstreams = NewUpstreamAllowlist(map[string][]string{"anthropic": {upstreamHost}})
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(`{}`)))
+	if w.Code != http.StatusOK || !upstreamCalled {
+		t.Errorf("expected allowed upstream to be proxied, got %d", w.Code)
+	}
+}
+
+func TestUpstreamAllowlistProviderFor(t *testing.T) {
+	allowlist := NewUpstreamAllowlist(map[string][]string{
+		"anthropic": {"api.anthropic.com"},
+		"openai":    {"api.openai.com", "*.openai.azure.com"},
+		"bedrock":   {"bedrock-runtime.*.amazonaws.com"},
+	})
+
+	tests := map[string]string{
+		"api.anthropic.com":                       "anthropic",
+		"myorg.openai.azure.com":                  "openai",
+		"bedrock-runtime.us-west-2.amazonaws.com": "",
+		"example.com":                             "",
+	}
+	for host, want := range tests {
+		if got := allowlist.ProviderFor(host); got != want {
+			t.Errorf("ProviderFor(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	var none *UpstreamAllowlist
+	if got := none.ProviderFor("generativelanguage.googleapis.com"); got != "gemini" {
+		t.Errorf("Expected nil allowlist to fall back to default upstreams, got %q", got)
+	}
+}
+
+func TestSplitUpstream(t *testing.T) {
+	valid := map[string][2]string{
+		"api.openai.com":   {"api.openai.com", ""},
+		"API.OpenAI.com":   {"api.openai.com", ""},
+		"localhost:8080":   {"localhost", "8080"},
+		"[::1]:8080":       {"::1", "8080"},
+		"[::1]":            {"::1", ""},
+		"my_host.internal": {"my_host.internal", ""},
+		"127.0.0.1:65535":  {"127.0.0.1", "65535"},
+	}
+	for upstream, want := range valid {
+		host, port, err := splitUpstream(upstream)
+		if err != nil || host != want[0] || port != want[1] {
+			t.Errorf("splitUpstream(%q) = %q, %q, %v; want %q, %q", upstream, host, port, err, want[0], want[1])
+		}
+	}
+
+	for _, upstream := range []string{
+		"", "localhost:1@evil.example", "user@evil.example", "evil.example/x", "evil.example?x",
+		"evil.example#x", "evil%2eexample", `evil.example\x`, "evil example", "::1", "[1.2.3.4]",
+		"localhost:", "localhost:0", "localhost:65536", "localhost:+80", "localhost:080", "localhost:http",
+	} {
+		if _, _, err := splitUpstream(upstream); err == nil {
+			t.Errorf("expected %q to be rejected", upstream)
+		}
+	}
+}
+
+func TestProxyRejectsUserinfoUpstream(t *testing.T) {
+	attackerCalled := false
+	attacker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attackerCalled = true
+	}))
+	defer attacker.Close()
+	attackerHost := strings.TrimPrefix(attacker.URL, "http://")
+
+	proxy := NewProxy()
+	proxy.upstreams = NewUpstreamAllowlist(DefaultAllowedUpstreams())
+
+	req := httptest.NewRequest("GET", "/vllm/localhost:1@"+attackerHost+"/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+	if attackerCalled {
+		t.Error("expected the host after userinfo not to be contacted")
+	}
+	if isLocalhost("localhost:1@" + attackerHost) {
+		t.Error("expected a userinfo upstream not to count as localhost")
+	}
+}
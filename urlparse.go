@@ -3,19 +3,15 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 )
 
 var (
 	ErrInvalidProxyPath = errors.New("invalid proxy path: expected /{provider}/{upstream}/{path}")
-	ErrUnknownProvider  = errors.New("unknown provider: must be 'anthropic' or 'openai'")
+	ErrUnknownProvider  = errors.New("unknown provider")
 )
 
-var validProviders = map[string]bool{
-	"anthropic": true,
-	"openai":    true,
-}
-
 // ParseProxyURL extracts provider, upstream host, and remaining path from a proxy URL.
 // Expected format: /{provider}/{upstream}/{remaining_path}
 func ParseProxyURL(urlPath string) (provider, upstream, path string, err error) {
@@ -31,13 +27,16 @@ func ParseProxyURL(urlPath string) (provider, upstream, path string, err error)
 	upstream = parts[1]
 	path = "/" + parts[2]
 
-	if !validProviders[provider] {
-		return "", "", "", ErrUnknownProvider
+	if LookupProvider(provider) == nil {
+		return "", "", "", fmt.Errorf("%w %q: must be one of %s", ErrUnknownProvider, provider, strings.Join(ProviderNames(), ", "))
 	}
 
 	if upstream == "" {
 		return "", "", "", ErrInvalidProxyPath
 	}
+	if _, _, err := splitUpstream(upstream); err != nil {
+		return "", "", "", fmt.Errorf("%w: %v", ErrInvalidProxyPath, err)
+	}
 
 	return provider, upstream, path, nil
 }
@@ -50,6 +50,16 @@ func TestParseProxyURL(t *testing.T) {
 			path:    "/health",
 			wantErr: true,
 		},
+		{
+			name:    "userinfo in upstream",
+			path:    "/vllm/localhost:1@evil.example/v1/models",
+			wantErr: true,
+		},
+		{
+			name:    "invalid upstream port",
+			path:    "/ollama/localhost:x/api/chat",
+			wantErr: true,
+		},
 		{
 			name:     "chatgpt backend api",
 			path:     "/openai/chatgpt.com/backend-api/codex/v1/responses",
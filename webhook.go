@@ -0,0 +1,579 @@
+// webhook.go
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The webhook notifier POSTs selected events to your own services:
+// session_start, turn_end (only turns that ended in an error), agent_anomaly
+// and budget_exceeded. Each delivery is written to an on-disk queue before
+// it is sent and removed once the endpoint accepts it, so notifications
+// survive endpoint outages and restarts.
+
+// Webhook event types.
+const (
+	WebhookEventSessionStart   = "session_start"
+	WebhookEventTurnError      = "turn_end"
+	WebhookEventAnomaly        = "agent_anomaly"
+	WebhookEventBudgetExceeded = "budget_exceeded"
+)
+
+var webhookEventTypes = []string{WebhookEventSessionStart, WebhookEventTurnError, WebhookEventAnomaly, WebhookEventBudgetExceeded}
+
+// WebhookSignatureHeader carries "sha256=" + hex HMAC-SHA256 of the
+// timestamp header value, a ".", and the request body, keyed with the
+// configured secret. Signing the timestamp lets receivers reject replays of
+// old deliveries.
+const WebhookSignatureHeader = "X-LLM-Proxy-Signature"
+
+// WebhookTimestampHeader carries the Unix time (seconds) of the attempt.
+const WebhookTimestampHeader = "X-LLM-Proxy-Timestamp"
+
+// WebhookConfig configures webhook notifications.
+type WebhookConfig struct {
+	Enabled      bool     `toml:"enabled"`
+	URLs         []string `toml:"urls"`       // Every event is delivered to each URL
+	Secret       string   `toml:"secret"`     // HMAC-SHA256 key for the signature header (optional)
+	Events       []string `toml:"events"`     // Event types to send (default: all)
+	BatchSize    int      `toml:"batch_size"` // Events per delivery (default: 1, per-event delivery)
+	BatchWaitStr string   `toml:"batch_wait"` // Duration string for batch timeout
+	RetryMax     int      `toml:"retry_max"`  // Maximum retry attempts per delivery
+	QueueDir     string   `toml:"queue_dir"`  // Undelivered notifications (default: <log_dir>/.webhook-queue)
+}
+
+// Validate checks URLs and event types.
+func (c WebhookConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.URLs) == 0 {
+		return fmt.Errorf("webhook: at least one url is required")
+	}
+	for _, e := range c.Events {
+		if !slices.Contains(webhookEventTypes, e) {
+			return fmt.Errorf("webhook: invalid event %q (valid: %s)", e, strings.Join(webhookEventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// WebhookNotifierConfig holds configuration for the webhook notifier
+type WebhookNotifierConfig struct {
+	URLs            []string
+	Secret          string
+	Events          []string      // Empty = all
+	BatchSize       int           // Number of events per delivery
+	BatchWait       time.Duration // Duration to wait before flushing a partial batch
+	RetryMax        int           // Maximum retry attempts
+	RetryWait       time.Duration // Base delay between retries
+	RedeliverWait   time.Duration // How often queued deliveries are retried
+	QueueDir        string
+	QueueMax        int // Maximum queued deliveries; further ones are dropped
+	BufferSize      int // Channel buffer size
+	ShutdownTimeout time.Duration
+}
+
+// WebhookNotifierStats holds statistics about the notifier's operation
+type WebhookNotifierStats struct {
+	EventsSent         int64
+	EventsDropped      int64
+	DeliveriesSent     int64
+	DeliveryRetries    int64
+	DeliveriesRejected int64 // Dropped because the endpoint answered with a permanent 4xx
+	Queued             int64
+}
+
+// WebhookEvent is one notification. Fields holds the event-specific data.
+type WebhookEvent struct {
+	Type     string                 `json:"type"`
+	Time     time.Time              `json:"ts"`
+	Session  string                 `json:"session"`
+	Provider string                 `json:"provider"`
+	Machine  string                 `json:"machine,omitempty"`
+	Fields   map[string]interface{} `json:"data,omitempty"`
+}
+
+// webhookPayload is the body of every delivery.
+type webhookPayload struct {
+	Events []WebhookEvent `json:"events"`
+}
+
+// webhookDelivery is a queued payload for one URL.
+type webhookDelivery struct {
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body"`
+}
+
+// WebhookNotifier batches events and delivers them through the on-disk queue.
+// It is a log sink (for session_start entries) and an AgentEventEmitter.
+type WebhookNotifier struct {
+	config     WebhookNotifierConfig
+	client     *http.Client
+	events     map[string]bool
+	eventChan  chan WebhookEvent
+	closeChan  chan struct{}
+	closedChan chan struct{}
+	closeOnce  sync.Once
+	seq        int64           // atomic, for unique queue file names
+	down       map[string]bool // URLs whose last delivery failed, owned by run
+
+	// Stats counters (accessed atomically)
+	eventsSent         int64
+	eventsDropped      int64
+	deliveriesSent     int64
+	deliveryRetries    int64
+	deliveriesRejected int64
+}
+
+// NewWebhookNotifier creates the notifier and starts delivering, beginning
+// with anything left in the queue by a previous run.
+func NewWebhookNotifier(cfg WebhookNotifierConfig) (*WebhookNotifier, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("WebhookNotifier: at least one URL is required")
+	}
+	if cfg.QueueDir == "" {
+		return nil, fmt.Errorf("WebhookNotifier: queue directory is required")
+	}
+	if err := os.MkdirAll(cfg.QueueDir, 0700); err != nil {
+		return nil, fmt.Errorf("WebhookNotifier: creating queue directory: %w", err)
+	}
+
+	// Apply defaults
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = 5 * time.Second
+	}
+	if cfg.RetryMax <= 0 {
+		cfg.RetryMax = 5
+	}
+	if cfg.RetryWait <= 0 {
+		cfg.RetryWait = 100 * time.Millisecond
+	}
+	if cfg.RedeliverWait <= 0 {
+		cfg.RedeliverWait = time.Minute
+	}
+	if cfg.QueueMax <= 0 {
+		cfg.QueueMax = 10000
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+
+	n := &WebhookNotifier{
+		config:     cfg,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		events:     stringSet(cfg.Events),
+		eventChan:  make(chan WebhookEvent, cfg.BufferSize),
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+		down:       make(map[string]bool),
+	}
+
+	go n.run()
+
+	return n, nil
+}
+
+// newWebhookNotifierFromConfig creates the notifier for cfg, or returns nil
+// when it is disabled or can't be created.
+func newWebhookNotifierFromConfig(cfg WebhookConfig, logDir string) *WebhookNotifier {
+	if !cfg.Enabled {
+		return nil
+	}
+	notifierCfg := WebhookNotifierConfig{
+		URLs:      cfg.URLs,
+		Secret:    cfg.Secret,
+		Events:    cfg.Events,
+		BatchSize: cfg.BatchSize,
+		RetryMax:  cfg.RetryMax,
+		QueueDir:  filepath.Join(logDir, ".webhook-queue"),
+	}
+	if cfg.QueueDir != "" {
+		notifierCfg.QueueDir = expandHome(cfg.QueueDir)
+	}
+	if cfg.BatchWaitStr != "" {
+		if batchWait, err := time.ParseDuration(cfg.BatchWaitStr); err == nil {
+			notifierCfg.BatchWait = batchWait
+		}
+	}
+
+	notifier, err := NewWebhookNotifier(notifierCfg)
+	if err != nil {
+		log.Printf("WARNING: Failed to create WebhookNotifier: %v", err)
+		return nil
+	}
+	return notifier
+}
+
+// Notify queues an event if its type is selected. Non-blocking: if the
+// channel is full, the event is dropped.
+func (n *WebhookNotifier) Notify(event WebhookEvent) {
+	if n.events != nil && !n.events[event.Type] {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	select {
+	case n.eventChan <- event:
+	default:
+		atomic.AddInt64(&n.eventsDropped, 1)
+	}
+}
+
+// Push implements LokiPusher, turning session_start entries into events.
+func (n *WebhookNotifier) Push(entry map[string]interface{}, provider string) {
+	if entry["type"] != "session_start" {
+		return
+	}
+	meta, _ := entry["_meta"].(map[string]interface{})
+	session, _ := meta["session"].(string)
+	machine, _ := meta["machine"].(string)
+	n.Notify(WebhookEvent{
+		Type:     WebhookEventSessionStart,
+		Session:  session,
+		Provider: provider,
+		Machine:  machine,
+		Fields:   map[string]interface{}{"upstream": entry["upstream"]},
+	})
+}
+
+// EmitTurnEnd sends a turn_end event for turns that ended in an error.
+func (n *WebhookNotifier) EmitTurnEnd(sessionID, provider, machine, stopReason string, isRetry bool, errorType string, patterns PatternData, tokens TokenData) {
+	if errorType == "" {
+		return
+	}
+	fields := map[string]interface{}{
+		"error_type":  errorType,
+		"stop_reason": stopReason,
+		"is_retry":    isRetry,
+		"turn_depth":  patterns.TurnDepth,
+	}
+	if tokens.CostUSD != nil {
+		fields["cost_usd"] = *tokens.CostUSD
+	}
+	n.Notify(WebhookEvent{Type: WebhookEventTurnError, Session: sessionID, Provider: provider, Machine: machine, Fields: fields})
+}
+
+// EmitAnomaly sends an agent_anomaly event.
+func (n *WebhookNotifier) EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData) {
+	n.Notify(WebhookEvent{
+		Type:     WebhookEventAnomaly,
+		Session:  sessionID,
+		Provider: provider,
+		Machine:  machine,
+		Fields: map[string]interface{}{
+			"anomaly_type":    anomaly.Type,
+			"tool_name":       anomaly.ToolName,
+			"count":           anomaly.Count,
+			"threshold":       anomaly.Threshold,
+			"breaker_tripped": anomaly.BreakerTripped,
+			"message":         anomaly.String(),
+		},
+	})
+}
+
+// EmitBudgetExceeded sends a budget_exceeded event for a rejected request.
+func (n *WebhookNotifier) EmitBudgetExceeded(sessionID, provider, machine string, exceeded *BudgetExceededError) {
+	n.Notify(WebhookEvent{
+		Type:     WebhookEventBudgetExceeded,
+		Session:  sessionID,
+		Provider: provider,
+		Machine:  machine,
+		Fields: map[string]interface{}{
+			"scope":   exceeded.Scope,
+			"metric":  exceeded.Metric,
+			"used":    exceeded.Used,
+			"limit":   exceeded.Limit,
+			"message": exceeded.Error(),
+		},
+	})
+}
+
+// Turn starts, tool calls and tool results aren't sent.
+func (n *WebhookNotifier) EmitTurnStart(sessionID, provider, machine string, turnDepth int, errorRecovered bool) {
+}
+func (n *WebhookNotifier) EmitToolCall(sessionID, provider, machine, toolName string, toolIndex int, toolUseID string) {
+}
+func (n *WebhookNotifier) EmitToolResult(sessionID, provider, machine, toolName, toolUseID string, isError bool) {
+}
+
+// run is the background worker that batches events and works the queue
+func (n *WebhookNotifier) run() {
+	defer close(n.closedChan)
+
+	n.redeliver(true)
+
+	batch := make([]WebhookEvent, 0, n.config.BatchSize)
+	ticker := time.NewTicker(n.config.BatchWait)
+	defer ticker.Stop()
+	redeliverTicker := time.NewTicker(n.config.RedeliverWait)
+	defer redeliverTicker.Stop()
+
+	for {
+		select {
+		case event := <-n.eventChan:
+			batch = append(batch, event)
+			if len(batch) >= n.config.BatchSize {
+				n.deliver(batch)
+				batch = make([]WebhookEvent, 0, n.config.BatchSize)
+				ticker.Reset(n.config.BatchWait)
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				n.deliver(batch)
+				batch = make([]WebhookEvent, 0, n.config.BatchSize)
+			}
+
+		case <-redeliverTicker.C:
+			n.redeliver(true)
+
+		case <-n.closeChan:
+			draining := true
+			for draining {
+				select {
+				case event := <-n.eventChan:
+					batch = append(batch, event)
+				default:
+					draining = false
+				}
+			}
+			// Queue what's left and make one attempt to send it; whatever
+			// isn't delivered stays queued for the next run
+			for len(batch) > 0 {
+				size := min(len(batch), n.config.BatchSize)
+				n.enqueue(batch[:size])
+				batch = batch[size:]
+			}
+			n.redeliver(false)
+			return
+		}
+	}
+}
+
+// deliver queues a batch for every URL, then sends the queue to the URLs
+// that are up. Deliveries for a URL that is down wait for the next retry.
+func (n *WebhookNotifier) deliver(events []WebhookEvent) {
+	n.enqueue(events)
+	n.redeliver(false)
+}
+
+// enqueue writes one delivery per URL to the queue.
+func (n *WebhookNotifier) enqueue(events []WebhookEvent) {
+	body, err := json.Marshal(webhookPayload{Events: events})
+	if err != nil {
+		atomic.AddInt64(&n.eventsDropped, int64(len(events)))
+		return
+	}
+
+	for _, url := range n.config.URLs {
+		if n.queued() >= n.config.QueueMax {
+			log.Printf("WARNING: webhook queue full (%d deliveries), dropping %d events for %s", n.config.QueueMax, len(events), url)
+			atomic.AddInt64(&n.eventsDropped, int64(len(events)))
+			continue
+		}
+		data, _ := json.Marshal(webhookDelivery{URL: url, Body: body})
+		name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), atomic.AddInt64(&n.seq, 1)%1000000)
+		path := filepath.Join(n.config.QueueDir, name)
+		if err := writeFileAtomic(path, data); err != nil {
+			log.Printf("WARNING: webhook queue write failed: %v", err)
+			atomic.AddInt64(&n.eventsDropped, int64(len(events)))
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// so the queue never holds a partial delivery.
+func writeFileAtomic(path string, data []byte) error {
+	// Recreate the directory in case a retention sweep removed it while empty
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// queueFiles returns the queued deliveries, oldest first.
+func (n *WebhookNotifier) queueFiles() []string {
+	paths, _ := filepath.Glob(filepath.Join(n.config.QueueDir, "*.json"))
+	sort.Strings(paths)
+	return paths
+}
+
+func (n *WebhookNotifier) queued() int {
+	return len(n.queueFiles())
+}
+
+// redeliver sends queued deliveries oldest first, so each URL receives
+// events in order. Once a URL fails, its later deliveries wait for the next
+// pass; URLs already down are only retried when retryDown is set.
+func (n *WebhookNotifier) redeliver(retryDown bool) {
+	failed := make(map[string]bool)
+	if !retryDown {
+		for url := range n.down {
+			failed[url] = true
+		}
+	}
+	for _, path := range n.queueFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var d webhookDelivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			log.Printf("WARNING: discarding unreadable webhook delivery %s: %v", filepath.Base(path), err)
+			os.Remove(path)
+			continue
+		}
+		if failed[d.URL] {
+			continue
+		}
+		if !n.send(path, d) {
+			failed[d.URL] = true
+		}
+	}
+	n.down = failed
+}
+
+// send delivers one queued delivery with retries and removes it on success.
+// A delivery the endpoint rejects is removed and counted without retrying.
+// Returns false if the delivery stays queued.
+func (n *WebhookNotifier) send(path string, d webhookDelivery) bool {
+	for attempt := 0; attempt <= n.config.RetryMax; attempt++ {
+		if attempt > 0 {
+			atomic.AddInt64(&n.deliveryRetries, 1)
+			// Exponential backoff with jitter
+			delay := n.config.RetryWait * time.Duration(1<<(attempt-1))
+			if delay > 10*time.Second {
+				delay = 10 * time.Second
+			}
+			jitter := time.Duration(float64(delay) * 0.25 * rand.Float64())
+			time.Sleep(delay + jitter)
+		}
+
+		err := n.doSend(d.URL, filepath.Base(path), d.Body)
+		if err == nil {
+			var payload webhookPayload
+			json.Unmarshal(d.Body, &payload)
+			atomic.AddInt64(&n.eventsSent, int64(len(payload.Events)))
+			atomic.AddInt64(&n.deliveriesSent, 1)
+			os.Remove(path)
+			return true
+		}
+		var rejected *webhookRejectedError
+		if errors.As(err, &rejected) {
+			log.Printf("WARNING: dropping webhook delivery %s to %s: %v", filepath.Base(path), d.URL, err)
+			atomic.AddInt64(&n.deliveriesRejected, 1)
+			os.Remove(path)
+			return true
+		}
+	}
+	return false
+}
+
+// webhookRejectedError is a response that retrying won't change: a 4xx
+// other than 408 Request Timeout and 429 Too Many Requests.
+type webhookRejectedError struct {
+	status int
+}
+
+func (e *webhookRejectedError) Error() string {
+	return fmt.Sprintf("webhook rejected the delivery with status %d", e.status)
+}
+
+// doSend performs the HTTP POST of one delivery
+func (n *WebhookNotifier) doSend(url, deliveryID string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-LLM-Proxy-Delivery", strings.TrimSuffix(deliveryID, ".json"))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if n.config.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, webhookSignature(n.config.Secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &webhookRejectedError{status: resp.StatusCode}
+	}
+	return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+}
+
+// webhookSignature returns the signature header value for a body sent with
+// the given timestamp header.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Stats returns the current statistics for the notifier
+func (n *WebhookNotifier) Stats() WebhookNotifierStats {
+	return WebhookNotifierStats{
+		EventsSent:         atomic.LoadInt64(&n.eventsSent),
+		EventsDropped:      atomic.LoadInt64(&n.eventsDropped),
+		DeliveriesSent:     atomic.LoadInt64(&n.deliveriesSent),
+		DeliveryRetries:    atomic.LoadInt64(&n.deliveryRetries),
+		DeliveriesRejected: atomic.LoadInt64(&n.deliveriesRejected),
+		Queued:             int64(n.queued()),
+	}
+}
+
+// Close stops the notifier, flushing pending events. Events that can't be
+// delivered stay in the queue for the next run. Returns an error if the shutdown times out.
+func (n *WebhookNotifier) Close() error {
+	var timeoutErr error
+
+	n.closeOnce.Do(func() {
+		close(n.closeChan)
+
+		select {
+		case <-n.closedChan:
+		case <-time.After(n.config.ShutdownTimeout):
+			timeoutErr = fmt.Errorf("shutdown timeout: %v", n.config.ShutdownTimeout)
+		}
+	})
+
+	return timeoutErr
+}
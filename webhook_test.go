@@ -0,0 +1,295 @@
+// webhook_test.go
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is an httptest endpoint that records deliveries.
+type webhookReceiver struct {
+	mu         sync.Mutex
+	events     []WebhookEvent
+	signatures []string
+	timestamps []string
+	bodies     [][]byte
+	fail       int32 // atomic; non-zero = respond with this status
+}
+
+func newWebhookReceiver(t *testing.T) (*webhookReceiver, *httptest.Server) {
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := atomic.LoadInt32(&rcv.fail); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("receiver: bad payload: %v", err)
+		}
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.events = append(rcv.events, payload.Events...)
+		rcv.signatures = append(rcv.signatures, r.Header.Get(WebhookSignatureHeader))
+		rcv.timestamps = append(rcv.timestamps, r.Header.Get(WebhookTimestampHeader))
+		rcv.bodies = append(rcv.bodies, body)
+	}))
+	t.Cleanup(srv.Close)
+	return rcv, srv
+}
+
+func (r *webhookReceiver) eventTypes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestWebhookNotifier_DeliversSignedEvents(t *testing.T) {
+	rcv, srv := newWebhookReceiver(t)
+	n, err := NewWebhookNotifier(WebhookNotifierConfig{
+		URLs:     []string{srv.URL},
+		Secret:   "s3cret",
+		QueueDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier failed: %v", err)
+	}
+
+	n.Push(map[string]interface{}{
+		"type":     "session_start",
+		"upstream": "api.anthropic.com",
+		"_meta":    map[string]interface{}{"session": "sess-1", "machine": "dev@host"},
+	}, "anthropic")
+	n.Push(map[string]interface{}{"type": "request"}, "anthropic")
+	n.EmitTurnEnd("sess-1", "anthropic", "dev@host", "end_turn", false, "", PatternData{}, TokenData{})
+	n.EmitTurnEnd("sess-1", "anthropic", "dev@host", "", false, "rate_limit_error", PatternData{TurnDepth: 3}, TokenData{})
+	n.EmitAnomaly("sess-1", "anthropic", "dev@host", AnomalyData{Type: AnomalyRetryStorm, ToolName: "Bash", Count: 5, Threshold: 5})
+	n.EmitBudgetExceeded("sess-1", "anthropic", "dev@host", &BudgetExceededError{Scope: "session", Metric: "turns", Used: 10, Limit: 10})
+	n.Close()
+
+	got := strings.Join(rcv.eventTypes(), ",")
+	if got != "session_start,turn_end,agent_anomaly,budget_exceeded" {
+		t.Errorf("unexpected events delivered: %s", got)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.bodies) != 4 {
+		t.Fatalf("expected per-event delivery, got %d deliveries", len(rcv.bodies))
+	}
+	for i, body := range rcv.bodies {
+		if rcv.signatures[i] != webhookSignature("s3cret", rcv.timestamps[i], body) {
+			t.Errorf("delivery %d: signature %q doesn't match timestamp and body", i, rcv.signatures[i])
+		}
+		if rcv.signatures[i] == webhookSignature("s3cret", "0", body) {
+			t.Errorf("delivery %d: signature doesn't cover the timestamp", i)
+		}
+		if ts, err := strconv.ParseInt(rcv.timestamps[i], 10, 64); err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+			t.Errorf("delivery %d: unexpected timestamp %q", i, rcv.timestamps[i])
+		}
+	}
+	if rcv.events[0].Session != "sess-1" || rcv.events[0].Machine != "dev@host" || rcv.events[0].Fields["upstream"] != "api.anthropic.com" {
+		t.Errorf("unexpected session_start event: %+v", rcv.events[0])
+	}
+	if rcv.events[1].Fields["error_type"] != "rate_limit_error" {
+		t.Errorf("unexpected turn_end event: %+v", rcv.events[1])
+	}
+}
+
+func TestWebhookNotifier_EventFilterAndBatching(t *testing.T) {
+	rcv, srv := newWebhookReceiver(t)
+	n, err := NewWebhookNotifier(WebhookNotifierConfig{
+		URLs:      []string{srv.URL},
+		Events:    []string{WebhookEventAnomaly},
+		BatchSize: 2,
+		QueueDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier failed: %v", err)
+	}
+
+	n.EmitBudgetExceeded("s", "anthropic", "", &BudgetExceededError{Scope: "day", Metric: "tokens"})
+	n.EmitAnomaly("s", "anthropic", "", AnomalyData{Type: AnomalyTurnDepth})
+	n.EmitAnomaly("s", "anthropic", "", AnomalyData{Type: AnomalyRetryStorm})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(rcv.eventTypes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.Close()
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.bodies) != 1 || len(rcv.events) != 2 {
+		t.Errorf("expected one batch of 2 anomalies, got %d deliveries of %d events", len(rcv.bodies), len(rcv.events))
+	}
+}
+
+func TestWebhookNotifier_QueueSurvivesOutageAndRestart(t *testing.T) {
+	rcv, srv := newWebhookReceiver(t)
+	atomic.StoreInt32(&rcv.fail, http.StatusServiceUnavailable)
+	queueDir := t.TempDir()
+	cfg := WebhookNotifierConfig{
+		URLs:      []string{srv.URL},
+		QueueDir:  queueDir,
+		RetryMax:  1,
+		RetryWait: time.Millisecond,
+	}
+
+	n, err := NewWebhookNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier failed: %v", err)
+	}
+	n.EmitAnomaly("s", "anthropic", "", AnomalyData{Type: AnomalyTurnDepth})
+	n.EmitAnomaly("s", "anthropic", "", AnomalyData{Type: AnomalyRetryStorm})
+	n.Close()
+
+	if stats := n.Stats(); stats.Queued != 2 || stats.EventsSent != 0 {
+		t.Fatalf("expected 2 queued deliveries after the outage, got %+v", stats)
+	}
+
+	// A new notifier (as after a restart) delivers the queue in order
+	atomic.StoreInt32(&rcv.fail, 0)
+	n, err = NewWebhookNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier failed: %v", err)
+	}
+	n.Close()
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.events) != 2 || rcv.events[0].Fields["anomaly_type"] != AnomalyTurnDepth {
+		t.Errorf("expected both queued events in order, got %+v", rcv.events)
+	}
+	if files, _ := filepath.Glob(filepath.Join(queueDir, "*.json")); len(files) != 0 {
+		t.Errorf("expected an empty queue, got %v", files)
+	}
+}
+
+func TestWebhookNotifier_DropsRejectedDeliveries(t *testing.T) {
+	tests := []struct {
+		status   int32
+		rejected int64
+		queued   int64
+	}{
+		{http.StatusBadRequest, 1, 0},
+		{http.StatusGone, 1, 0},
+		{http.StatusRequestTimeout, 0, 1},
+		{http.StatusTooManyRequests, 0, 1},
+	}
+	for _, tt := range tests {
+		rcv, srv := newWebhookReceiver(t)
+		atomic.StoreInt32(&rcv.fail, tt.status)
+		n, err := NewWebhookNotifier(WebhookNotifierConfig{
+			URLs:      []string{srv.URL},
+			QueueDir:  t.TempDir(),
+			RetryMax:  2,
+			RetryWait: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("NewWebhookNotifier failed: %v", err)
+		}
+		n.EmitAnomaly("s", "anthropic", "", AnomalyData{Type: AnomalyTurnDepth})
+		n.Close()
+
+		stats := n.Stats()
+		if stats.DeliveriesRejected != tt.rejected || stats.Queued != tt.queued {
+			t.Errorf("status %d: expected %d rejected and %d queued, got %+v", tt.status, tt.rejected, tt.queued, stats)
+		}
+		if tt.rejected > 0 && stats.DeliveryRetries != 0 {
+			t.Errorf("status %d: expected no retries, got %d", tt.status, stats.DeliveryRetries)
+		}
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	if err := (WebhookConfig{Enabled: true}).Validate(); err == nil {
+		t.Error("expected an error without urls")
+	}
+	if err := (WebhookConfig{Enabled: true, URLs: []string{"http://x"}, Events: []string{"tool_call"}}).Validate(); err == nil {
+		t.Error("expected an unknown event to be rejected")
+	}
+	if err := (WebhookConfig{Enabled: true, URLs: []string{"http://x"}, Events: []string{"budget_exceeded"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMultiWriter_EventEmitterFansOut(t *testing.T) {
+	rcv, srv := newWebhookReceiver(t)
+	n, err := NewWebhookNotifier(WebhookNotifierConfig{URLs: []string{srv.URL}, QueueDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier failed: %v", err)
+	}
+	loki := &recordingEmitter{}
+
+	mw := NewMultiWriter(newMockFileLogger(), nil)
+	mw.AddSink(pusherSink("loki", struct {
+		LokiPusher
+		AgentEventEmitter
+	}{newMockLokiExporter(nil), loki}))
+	mw.AddSink(pusherSink("webhook", n))
+
+	emitter := mw.EventEmitter()
+	emitter.EmitAnomaly("s", "anthropic", "", AnomalyData{Type: AnomalyTurnDepth})
+	emitter.(budgetEventEmitter).EmitBudgetExceeded("s", "anthropic", "", &BudgetExceededError{Scope: "session", Metric: "turns"})
+	n.Close()
+
+	if loki.anomalies != 1 {
+		t.Errorf("expected the anomaly to reach both emitters, Loki got %d", loki.anomalies)
+	}
+	if got := strings.Join(rcv.eventTypes(), ","); got != "agent_anomaly,budget_exceeded" {
+		t.Errorf("unexpected webhook events: %s", got)
+	}
+}
+
+func TestMultiWriter_EventEmitterAppliesProviderFilter(t *testing.T) {
+	openaiOnly := &recordingEmitter{}
+	unfiltered := &recordingEmitter{}
+
+	mw := NewMultiWriter(newMockFileLogger(), nil)
+	sink := pusherSink("loki", struct {
+		LokiPusher
+		AgentEventEmitter
+	}{newMockLokiExporter(nil), openaiOnly})
+	sink.providers = stringSet([]string{"openai"})
+	mw.AddSink(sink)
+	mw.AddSink(pusherSink("otlp", struct {
+		LokiPusher
+		AgentEventEmitter
+	}{newMockLokiExporter(nil), unfiltered}))
+
+	emitter := mw.EventEmitter()
+	emitter.EmitAnomaly("s1", "anthropic", "", AnomalyData{Type: AnomalyTurnDepth})
+	emitter.EmitAnomaly("s2", "openai", "", AnomalyData{Type: AnomalyTurnDepth})
+
+	if openaiOnly.anomalies != 1 {
+		t.Errorf("expected the filtered sink to get only the openai event, got %d", openaiOnly.anomalies)
+	}
+	if unfiltered.anomalies != 2 {
+		t.Errorf("expected the unfiltered sink to get both events, got %d", unfiltered.anomalies)
+	}
+}
+
+// recordingEmitter counts anomaly events.
+type recordingEmitter struct {
+	discardEventEmitter
+	anomalies int
+}
+
+func (e *recordingEmitter) EmitAnomaly(sessionID, provider, machine string, anomaly AnomalyData) {
+	e.anomalies++
+}